@@ -0,0 +1,170 @@
+// Package apikey makes API keys for service-to-service access, and checks
+// their scopes and rate limits.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math"
+	"strings"
+	"time"
+
+	"ecommerce-backend/models"
+)
+
+// Prefix starts every key, so keys are easy to spot in configuration and
+// can be told from JWTs.
+const Prefix = "ek_"
+
+// DefaultRateLimit is the requests per minute allowed to a key that does
+// not set its own limit.
+const DefaultRateLimit = 60
+
+// Scope actions. Write allows read as well.
+const (
+	Read  = "read"
+	Write = "write"
+)
+
+// Resources are the first path segments keys can be scoped to. API key
+// management, two-factor settings, sessions and the profile are left out,
+// and deniedRoutes covers what lies under users, so a key can never reach
+// them.
+var Resources = []string{
+	"addresses", "audit", "carts", "invoices", "items", "orders", "payments",
+	"promotions", "returns", "shipping", "store-credit", "tax", "users", "webhooks",
+}
+
+// deniedRoutes are routes no key may use whatever its scopes: the user's
+// own account settings, and the admin controls over other users' roles,
+// sign-in and sessions.
+var deniedRoutes = map[string]bool{
+	"/users/email":              true,
+	"/users/email/verification": true,
+	"/users/identities":         true,
+	"/users/identities/oidc":    true,
+	"/users/identities/:id":     true,
+	"/users/logout":             true,
+	"/users/:id/role":           true,
+	"/users/:id/unlock":         true,
+	"/users/:id/sessions":       true,
+	"/users/:id/logout":         true,
+	"/users/:id/mfa":            true,
+}
+
+// New returns a new key, "ek_<lookup id>_<secret>", and its public prefix,
+// "ek_<lookup id>".
+func New() (key, prefix string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	encoded := hex.EncodeToString(b)
+	prefix = Prefix + encoded[:8]
+	return prefix + "_" + encoded[8:], prefix, nil
+}
+
+// IsKey reports whether a credential looks like an API key rather than a
+// JWT.
+func IsKey(credential string) bool {
+	return strings.HasPrefix(credential, Prefix)
+}
+
+// PrefixOf returns the public prefix of a key, or false if it is not shaped
+// like one.
+func PrefixOf(key string) (string, bool) {
+	if !IsKey(key) {
+		return "", false
+	}
+	i := strings.Index(key[len(Prefix):], "_")
+	if i <= 0 {
+		return "", false
+	}
+	return key[:len(Prefix)+i], true
+}
+
+// Hash returns the hash of a key that is stored in place of it. Keys are
+// long and random, so a fast hash is enough.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports whether key is the one k was made from.
+func Matches(k *models.APIKey, key string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.Hash), []byte(Hash(key))) == 1
+}
+
+// Usable reports whether k may be used at now: not revoked and not
+// expired.
+func Usable(k *models.APIKey, now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// ValidScope reports whether scope names a known resource and action.
+func ValidScope(scope string) bool {
+	resource, action, ok := strings.Cut(scope, ":")
+	if !ok || action != Read && action != Write {
+		return false
+	}
+	for _, r := range Resources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// Required returns the scope a request to a route needs: its first path
+// segment, and read for GET and HEAD or write otherwise. Routes no key may
+// use give false.
+func Required(method, route string) (string, bool) {
+	if deniedRoutes[route] {
+		return "", false
+	}
+	resource, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+	action := Write
+	if method == "GET" || method == "HEAD" {
+		action = Read
+	}
+	scope := resource + ":" + action
+	return scope, ValidScope(scope)
+}
+
+// Allows reports whether scopes include the required scope.
+func Allows(scopes []string, required string) bool {
+	resource, action, _ := strings.Cut(required, ":")
+	for _, scope := range scopes {
+		if scope == required || action == Read && scope == resource+":"+Write {
+			return true
+		}
+	}
+	return false
+}
+
+// Take spends one request from k's rate limit at now. The limit refills
+// evenly over a minute and up to a minute's worth can be spent at once. It
+// returns the requests left, or how long to wait when there are none.
+func Take(k *models.APIKey, now time.Time) (int, time.Duration, bool) {
+	limit := k.RateLimit
+	if limit <= 0 {
+		limit = DefaultRateLimit
+	}
+	perSecond := float64(limit) / 60
+
+	if k.RefilledAt.IsZero() {
+		k.Tokens = float64(limit)
+	} else if elapsed := now.Sub(k.RefilledAt).Seconds(); elapsed > 0 {
+		k.Tokens = math.Min(float64(limit), k.Tokens+elapsed*perSecond)
+	}
+	k.RefilledAt = now
+
+	if k.Tokens < 1 {
+		wait := time.Duration((1 - k.Tokens) / perSecond * float64(time.Second))
+		return 0, wait, false
+	}
+	k.Tokens--
+	return int(k.Tokens), 0, true
+}
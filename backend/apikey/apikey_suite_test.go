@@ -0,0 +1,13 @@
+package apikey_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAPIKey(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Key Suite")
+}
@@ -0,0 +1,136 @@
+package apikey_test
+
+import (
+	"strings"
+	"time"
+
+	"ecommerce-backend/apikey"
+	"ecommerce-backend/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API keys", func() {
+	It("makes random keys that start with their prefix", func() {
+		key, prefix, err := apikey.New()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.HasPrefix(key, prefix+"_")).To(BeTrue())
+		Expect(apikey.IsKey(key)).To(BeTrue())
+
+		found, ok := apikey.PrefixOf(key)
+		Expect(ok).To(BeTrue())
+		Expect(found).To(Equal(prefix))
+
+		other, _, _ := apikey.New()
+		Expect(other).ToNot(Equal(key))
+	})
+
+	It("rejects credentials not shaped like a key", func() {
+		for _, credential := range []string{"eyJhbGciOi.x.y", "ek_", "ek_nosecret", "ek__secret"} {
+			_, ok := apikey.PrefixOf(credential)
+			Expect(ok).To(BeFalse(), credential)
+		}
+	})
+
+	It("matches a key against its stored hash only", func() {
+		key, _, _ := apikey.New()
+		k := &models.APIKey{Hash: apikey.Hash(key)}
+		Expect(k.Hash).ToNot(ContainSubstring(key))
+		Expect(apikey.Matches(k, key)).To(BeTrue())
+		Expect(apikey.Matches(k, key+"x")).To(BeFalse())
+	})
+
+	It("is unusable once revoked or expired", func() {
+		now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		expires := now.Add(time.Hour)
+		k := &models.APIKey{ExpiresAt: &expires}
+		Expect(apikey.Usable(k, now)).To(BeTrue())
+		Expect(apikey.Usable(k, expires)).To(BeFalse())
+
+		k.ExpiresAt = nil
+		k.RevokedAt = &now
+		Expect(apikey.Usable(k, now)).To(BeFalse())
+	})
+})
+
+var _ = Describe("Scopes", func() {
+	It("knows the valid scopes", func() {
+		Expect(apikey.ValidScope("items:write")).To(BeTrue())
+		Expect(apikey.ValidScope("store-credit:read")).To(BeTrue())
+		Expect(apikey.ValidScope("items:delete")).To(BeFalse())
+		Expect(apikey.ValidScope("items")).To(BeFalse())
+		Expect(apikey.ValidScope("mfa:read")).To(BeFalse())
+		Expect(apikey.ValidScope("api-keys:write")).To(BeFalse())
+	})
+
+	It("derives the scope a route needs", func() {
+		scope, ok := apikey.Required("POST", "/items")
+		Expect(ok).To(BeTrue())
+		Expect(scope).To(Equal("items:write"))
+
+		scope, ok = apikey.Required("GET", "/orders/:id/invoice")
+		Expect(ok).To(BeTrue())
+		Expect(scope).To(Equal("orders:read"))
+	})
+
+	It("keeps keys away from key management and account settings", func() {
+		for _, route := range []string{"/api-keys", "/mfa", "/users/email", "/users/:id/role", "/users/:id/mfa"} {
+			_, ok := apikey.Required("POST", route)
+			Expect(ok).To(BeFalse(), route)
+		}
+		_, ok := apikey.Required("PUT", "/users/:id/tax-exempt")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("lets write scopes read", func() {
+		Expect(apikey.Allows([]string{"items:write"}, "items:read")).To(BeTrue())
+		Expect(apikey.Allows([]string{"items:read"}, "items:write")).To(BeFalse())
+		Expect(apikey.Allows([]string{"orders:write"}, "items:read")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Take", func() {
+	var (
+		k   *models.APIKey
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		k = &models.APIKey{RateLimit: 3}
+		now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It("allows a minute's worth at once, then makes callers wait", func() {
+		for left := 2; left >= 0; left-- {
+			remaining, _, ok := apikey.Take(k, now)
+			Expect(ok).To(BeTrue())
+			Expect(remaining).To(Equal(left))
+		}
+		_, wait, ok := apikey.Take(k, now)
+		Expect(ok).To(BeFalse())
+		Expect(wait).To(Equal(20 * time.Second))
+	})
+
+	It("refills evenly over the minute", func() {
+		for i := 0; i < 3; i++ {
+			apikey.Take(k, now)
+		}
+		_, wait, ok := apikey.Take(k, now.Add(15*time.Second))
+		Expect(ok).To(BeFalse())
+		Expect(wait).To(Equal(5 * time.Second))
+
+		_, _, ok = apikey.Take(k, now.Add(20*time.Second))
+		Expect(ok).To(BeTrue())
+
+		remaining, _, _ := apikey.Take(k, now.Add(time.Hour))
+		Expect(remaining).To(Equal(2))
+	})
+
+	It("uses the default limit when the key has none", func() {
+		k.RateLimit = 0
+		remaining, _, ok := apikey.Take(k, now)
+		Expect(ok).To(BeTrue())
+		Expect(remaining).To(Equal(apikey.DefaultRateLimit - 1))
+	})
+})
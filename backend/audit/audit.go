@@ -0,0 +1,137 @@
+// Package audit builds the field diffs and hash chain of the audit log.
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ecommerce-backend/models"
+)
+
+// ErrChainBroken is returned by Verify for a log that has been changed.
+var ErrChainBroken = errors.New("audit chain broken")
+
+// redacted stands in for the value of a sensitive field in a diff.
+var redacted = json.RawMessage(`"[redacted]"`)
+
+// sensitive reports whether a field's values must not be logged.
+func sensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, word := range []string{"password", "token", "secret"} {
+		if strings.Contains(field, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns the top-level JSON fields of v. A nil v has none.
+func fields(v interface{}) (map[string]json.RawMessage, error) {
+	out := map[string]json.RawMessage{}
+	if v == nil {
+		return out, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(raw, []byte("null")) {
+		return out, nil
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Diff lists the top-level fields that differ between the JSON forms of
+// before and after, in field order. Either may be nil, for a creation or a
+// deletion.
+func Diff(before, after interface{}) ([]models.AuditChange, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range updated {
+		if _, exists := old[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.AuditChange{}
+	for _, name := range names {
+		a, b := old[name], updated[name]
+		if bytes.Equal(a, b) {
+			continue
+		}
+		change := models.AuditChange{Field: name, Before: a, After: b}
+		if sensitive(name) {
+			change.Before, change.After = nil, nil
+			if a != nil {
+				change.Before = redacted
+			}
+			if b != nil {
+				change.After = redacted
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// hash returns the hex SHA-256 of an entry's JSON with its hash left out.
+func hash(entry models.AuditEntry) (string, error) {
+	entry.Hash = ""
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Seal links an entry to the one before it, whose hash is prevHash (empty
+// for the first entry), and sets its hash.
+func Seal(entry *models.AuditEntry, prevHash string) error {
+	entry.PrevHash = prevHash
+	h, err := hash(*entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = h
+	return nil
+}
+
+// Verify checks that every entry links to the one before it and still has
+// the hash it was sealed with. It returns ErrChainBroken naming the first
+// entry that does not.
+func Verify(entries []models.AuditEntry) error {
+	prev := ""
+	for _, entry := range entries {
+		h, err := hash(entry)
+		if err != nil {
+			return err
+		}
+		if entry.PrevHash != prev || entry.Hash != h {
+			return fmt.Errorf("%w at entry %d", ErrChainBroken, entry.ID)
+		}
+		prev = entry.Hash
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package audit_test
+
+import (
+	"ecommerce-backend/audit"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit", func() {
+	Describe("Diff", func() {
+		type record struct {
+			Name     string `json:"name"`
+			Price    int64  `json:"price"`
+			Password string `json:"password,omitempty"`
+		}
+
+		It("lists the fields that changed", func() {
+			changes, err := audit.Diff(record{Name: "Laptop", Price: 100}, record{Name: "Laptop", Price: 90})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(Equal([]models.AuditChange{
+				{Field: "price", Before: json.RawMessage("100"), After: json.RawMessage("90")},
+			}))
+		})
+
+		It("treats a missing side as a creation or deletion", func() {
+			changes, _ := audit.Diff(nil, record{Name: "Laptop"})
+			Expect(changes).To(HaveLen(2))
+			Expect(changes[0].Field).To(Equal("name"))
+			Expect(changes[0].Before).To(BeNil())
+
+			changes, _ = audit.Diff(&record{Name: "Laptop"}, nil)
+			Expect(changes[0].After).To(BeNil())
+		})
+
+		It("redacts sensitive fields", func() {
+			changes, _ := audit.Diff(record{Password: "old"}, record{Password: "new"})
+			Expect(changes).To(Equal([]models.AuditChange{
+				{Field: "password", Before: json.RawMessage(`"[redacted]"`), After: json.RawMessage(`"[redacted]"`)},
+			}))
+		})
+	})
+
+	Describe("hash chain", func() {
+		var entries []models.AuditEntry
+
+		BeforeEach(func() {
+			entries = nil
+			prev := ""
+			for i, action := range []string{"user.login", "item.updated", "user.role_changed"} {
+				entry := models.AuditEntry{
+					ID:        uint(i + 1),
+					Action:    action,
+					Outcome:   models.AuditSuccess,
+					Metadata:  map[string]string{"n": string(rune('a' + i))},
+					CreatedAt: time.Date(2024, 6, 1, 12, i, 0, 0, time.UTC),
+				}
+				Expect(audit.Seal(&entry, prev)).To(Succeed())
+				prev = entry.Hash
+				entries = append(entries, entry)
+			}
+		})
+
+		It("links each entry to the one before", func() {
+			Expect(entries[0].PrevHash).To(BeEmpty())
+			Expect(entries[1].PrevHash).To(Equal(entries[0].Hash))
+			Expect(audit.Verify(entries)).To(Succeed())
+		})
+
+		It("detects an edited entry", func() {
+			entries[1].Outcome = models.AuditFailure
+			Expect(audit.Verify(entries)).To(MatchError(ContainSubstring("at entry 2")))
+		})
+
+		It("detects a removed or reordered entry", func() {
+			Expect(audit.Verify([]models.AuditEntry{entries[0], entries[2]})).To(MatchError(audit.ErrChainBroken))
+			Expect(audit.Verify([]models.AuditEntry{entries[1], entries[0], entries[2]})).To(MatchError(audit.ErrChainBroken))
+		})
+
+		It("detects a rehashed entry that no longer links", func() {
+			entries[1].Action = "item.created"
+			Expect(audit.Seal(&entries[1], entries[0].Hash)).To(Succeed())
+			Expect(audit.Verify(entries)).To(MatchError(ContainSubstring("at entry 3")))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+// Package carriers defines the interface fulfillment uses to buy shipping
+// labels and follow parcels, and a local stub carrier.
+package carriers
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Status is where a parcel is according to its carrier.
+type Status string
+
+const (
+	StatusLabelCreated   Status = "label_created"
+	StatusInTransit      Status = "in_transit"
+	StatusOutForDelivery Status = "out_for_delivery"
+	StatusDelivered      Status = "delivered"
+	StatusException      Status = "exception" // lost, damaged or undeliverable
+)
+
+var (
+	ErrUnknownParcel = errors.New("unknown tracking number")
+	ErrInvalidParcel = errors.New("invalid parcel")
+)
+
+// Parcel describes what is being shipped. Reference identifies the
+// shipment; Weight is in grams.
+type Parcel struct {
+	Reference string
+	Weight    int
+	Country   string
+	Region    string
+}
+
+// Label is a purchased shipping label.
+type Label struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"tracking_number"`
+}
+
+// TrackingEvent is one update on a parcel.
+type TrackingEvent struct {
+	TrackingNumber string    `json:"tracking_number"`
+	Status         Status    `json:"status"`
+	Description    string    `json:"description"`
+	Location       string    `json:"location,omitempty"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// Carrier is a shipping carrier. Tracking updates after the label is
+// created are pushed to whoever the adapter was configured to notify;
+// Track returns everything known so far.
+type Carrier interface {
+	Name() string
+	CreateLabel(ctx context.Context, parcel Parcel) (Label, error)
+	Track(ctx context.Context, trackingNumber string) ([]TrackingEvent, error)
+}
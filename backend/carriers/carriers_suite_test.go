@@ -0,0 +1,13 @@
+package carriers_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCarriers(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Carriers Suite")
+}
@@ -0,0 +1,100 @@
+package carriers
+
+import (
+	"context"
+	"ecommerce-backend/clock"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultStubStep = 12 * time.Hour
+
+// stubRoute is the journey every stub parcel takes after its label is made.
+var stubRoute = []TrackingEvent{
+	{Status: StatusInTransit, Description: "Departed origin facility", Location: "Origin hub"},
+	{Status: StatusOutForDelivery, Description: "Out for delivery", Location: "Local depot"},
+	{Status: StatusDelivered, Description: "Delivered", Location: "Front door"},
+}
+
+// StubConfig configures a stub carrier. A parcel moves one step along its
+// route every Step on Clock, and each update is handed to Sink. Without a
+// Sink parcels stay at label_created.
+type StubConfig struct {
+	Clock clock.Clock
+	Step  time.Duration
+	Sink  func(event TrackingEvent)
+}
+
+// Stub is an in-memory Carrier for local development and tests.
+type Stub struct {
+	config StubConfig
+
+	mu     sync.Mutex
+	events map[string][]TrackingEvent
+	nextID int
+}
+
+// NewStub returns a stub carrier.
+func NewStub(config StubConfig) *Stub {
+	if config.Clock == nil {
+		config.Clock = clock.Real{}
+	}
+	if config.Step <= 0 {
+		config.Step = defaultStubStep
+	}
+	return &Stub{config: config, events: map[string][]TrackingEvent{}}
+}
+
+func (s *Stub) Name() string { return "stub" }
+
+func (s *Stub) CreateLabel(ctx context.Context, parcel Parcel) (Label, error) {
+	if parcel.Weight < 0 {
+		return Label{}, fmt.Errorf("%w: weight must not be negative", ErrInvalidParcel)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	label := Label{Carrier: s.Name(), TrackingNumber: fmt.Sprintf("STUB%08d", s.nextID)}
+	s.events[label.TrackingNumber] = []TrackingEvent{{
+		TrackingNumber: label.TrackingNumber,
+		Status:         StatusLabelCreated,
+		Description:    "Shipping label created",
+		OccurredAt:     s.config.Clock.Now(),
+	}}
+
+	if s.config.Sink != nil {
+		go s.follow(label.TrackingNumber)
+	}
+	return label, nil
+}
+
+func (s *Stub) Track(ctx context.Context, trackingNumber string) ([]TrackingEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, exists := s.events[trackingNumber]
+	if !exists {
+		return nil, ErrUnknownParcel
+	}
+	return append([]TrackingEvent(nil), events...), nil
+}
+
+// follow moves a parcel along the stub route, one step at a time.
+func (s *Stub) follow(trackingNumber string) {
+	for _, step := range stubRoute {
+		now := <-s.config.Clock.After(s.config.Step)
+
+		event := step
+		event.TrackingNumber = trackingNumber
+		event.OccurredAt = now
+
+		s.mu.Lock()
+		s.events[trackingNumber] = append(s.events[trackingNumber], event)
+		s.mu.Unlock()
+
+		s.config.Sink(event)
+	}
+}
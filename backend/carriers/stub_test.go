@@ -0,0 +1,89 @@
+package carriers_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ecommerce-backend/carriers"
+	"ecommerce-backend/clock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stub", func() {
+	var (
+		ctx      context.Context
+		fakeTime *clock.Fake
+		stub     *carriers.Stub
+
+		mu      sync.Mutex
+		updates []carriers.TrackingEvent
+	)
+
+	statuses := func() []carriers.Status {
+		mu.Lock()
+		defer mu.Unlock()
+		result := []carriers.Status{}
+		for _, event := range updates {
+			result = append(result, event.Status)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fakeTime = clock.NewFake(time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC))
+		updates = nil
+		stub = carriers.NewStub(carriers.StubConfig{
+			Clock: fakeTime,
+			Step:  time.Hour,
+			Sink: func(event carriers.TrackingEvent) {
+				mu.Lock()
+				updates = append(updates, event)
+				mu.Unlock()
+			},
+		})
+	})
+
+	It("creates a label with a tracking number", func() {
+		label, err := stub.CreateLabel(ctx, carriers.Parcel{Reference: "shipment-1", Weight: 500})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(label.Carrier).To(Equal("stub"))
+		Expect(label.TrackingNumber).To(HavePrefix("STUB"))
+
+		events, err := stub.Track(ctx, label.TrackingNumber)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Status).To(Equal(carriers.StatusLabelCreated))
+	})
+
+	It("moves the parcel one step along its route each interval", func() {
+		label, _ := stub.CreateLabel(ctx, carriers.Parcel{Reference: "shipment-1"})
+
+		Eventually(fakeTime.Waiters).Should(Equal(1))
+		fakeTime.Advance(time.Hour)
+		Eventually(statuses).Should(Equal([]carriers.Status{carriers.StatusInTransit}))
+
+		Eventually(fakeTime.Waiters).Should(Equal(1))
+		fakeTime.Advance(time.Hour)
+		Eventually(fakeTime.Waiters).Should(Equal(1))
+		fakeTime.Advance(time.Hour)
+		Eventually(statuses).Should(Equal([]carriers.Status{carriers.StatusInTransit, carriers.StatusOutForDelivery, carriers.StatusDelivered}))
+
+		events, _ := stub.Track(ctx, label.TrackingNumber)
+		Expect(events).To(HaveLen(4))
+		Expect(events[3].OccurredAt).To(Equal(time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)))
+	})
+
+	It("reports unknown tracking numbers", func() {
+		_, err := stub.Track(ctx, "NOPE")
+		Expect(err).To(MatchError(carriers.ErrUnknownParcel))
+	})
+
+	It("rejects negative weights", func() {
+		_, err := stub.CreateLabel(ctx, carriers.Parcel{Weight: -1})
+		Expect(err).To(MatchError(carriers.ErrInvalidParcel))
+	})
+})
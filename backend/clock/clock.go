@@ -0,0 +1,94 @@
+// Package clock abstracts time so that code waiting on timers can be tested
+// with a fake clock that only moves when the test advances it.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for durations to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+func (Real) Now() time.Time { return time.Now() }
+
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Fake is a Clock that stands still until Advance or Set is called.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFake returns a fake clock stopped at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel that receives the fake time once the clock has
+// been advanced by at least d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{until: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires every timer that is now due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.set(f.now.Add(d))
+	f.mu.Unlock()
+}
+
+// Set moves the clock to t, firing every timer that is due by then.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	f.set(t)
+	f.mu.Unlock()
+}
+
+// Waiters reports how many timers are pending. Tests use it to wait until
+// a goroutine is blocked on the clock before advancing it.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+func (f *Fake) set(t time.Time) {
+	f.now = t
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.until.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- t
+	}
+	f.waiters = pending
+}
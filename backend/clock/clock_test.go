@@ -0,0 +1,56 @@
+package clock_test
+
+import (
+	"time"
+
+	"ecommerce-backend/clock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fake", func() {
+	var (
+		start time.Time
+		fake  *clock.Fake
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		fake = clock.NewFake(start)
+	})
+
+	It("stands still until advanced", func() {
+		Expect(fake.Now()).To(Equal(start))
+		fake.Advance(time.Hour)
+		Expect(fake.Now()).To(Equal(start.Add(time.Hour)))
+	})
+
+	It("fires timers once they are due", func() {
+		ch := fake.After(10 * time.Minute)
+		Expect(fake.Waiters()).To(Equal(1))
+
+		fake.Advance(5 * time.Minute)
+		Consistently(ch).ShouldNot(Receive())
+
+		fake.Advance(5 * time.Minute)
+		Expect(ch).To(Receive(Equal(start.Add(10 * time.Minute))))
+		Expect(fake.Waiters()).To(BeZero())
+	})
+
+	It("fires immediately for non-positive durations", func() {
+		Expect(fake.After(0)).To(Receive(Equal(start)))
+	})
+
+	It("fires every due timer when set", func() {
+		first := fake.After(time.Minute)
+		second := fake.After(time.Hour)
+		third := fake.After(2 * time.Hour)
+
+		fake.Set(start.Add(time.Hour))
+		Expect(first).To(Receive())
+		Expect(second).To(Receive())
+		Expect(third).ToNot(Receive())
+		Expect(fake.Waiters()).To(Equal(1))
+	})
+})
@@ -16,8 +16,74 @@ type InMemoryDB struct {
 	Carts     map[uint]*models.Cart
 	CartItems map[string]*models.CartItem // key: "cartID-itemID"
 	Orders    map[uint]*models.Order
-	Mutex     sync.RWMutex
-	nextID    uint
+
+	// Stock held by lines in active carts
+	Reservations map[string]*models.StockReservation // key: "cartID-itemID"
+
+	// Promotions and coupon redemptions
+	Promotions  map[uint]*models.Promotion
+	Redemptions map[uint]*models.Redemption
+
+	TaxRules map[uint]*models.TaxRule
+
+	// Address book and shipping catalog
+	Addresses       map[uint]*models.Address
+	ShippingZones   map[uint]*models.ShippingZone
+	ShippingMethods map[uint]*models.ShippingMethod
+
+	// Payments taken at checkout and refunds against them
+	Payments map[uint]*models.Payment
+	Refunds  map[uint]*models.Refund
+
+	Shipments map[uint]*models.Shipment
+
+	// Invoices and credit notes, numbered from DocumentSequences
+	Invoices          map[uint]*models.Invoice
+	DocumentSequences map[string]*models.DocumentSequence // key: sequence name
+
+	// Returns and the store credit ledger
+	Returns      map[uint]*models.Return
+	StoreCredits map[uint]*models.StoreCredit
+
+	// Domain events waiting to be published, and those recently published
+	Outbox map[uint]*models.OutboxEvent
+
+	// Outgoing webhook subscriptions and the queue and log of deliveries
+	WebhookEndpoints  map[uint]*models.WebhookEndpoint
+	WebhookDeliveries map[uint]*models.WebhookDelivery
+
+	// Responses remembered for Idempotency-Key retries
+	IdempotencyKeys map[string]*models.IdempotencyRecord // key: "scope|route|key"
+
+	// Two-factor enrollments, and the roles that must use two-factor login
+	MFAEnrollments   map[uint]*models.MFAEnrollment // key: user ID
+	MFARequiredRoles map[string]bool
+
+	// Password reset and email verification tokens
+	UserTokens map[uint]*models.UserToken
+
+	// Login sessions, one per login, until they expire
+	Sessions map[uint]*models.Session
+
+	// API keys for service-to-service access, revoked ones included
+	APIKeys map[uint]*models.APIKey
+
+	// Accounts at external identity providers, and sign-ins waiting for
+	// the provider's callback
+	UserIdentities map[uint]*models.UserIdentity
+	OIDCLogins     map[string]*models.OIDCLogin // key: state
+
+	// Recent failed logins per account and per client address
+	LoginThrottles map[string]*models.LoginThrottle // key: "user:<username>" or "ip:<address>"
+
+	// Append-only, hash-chained audit log. AuditMutex guards it on its own
+	// so actions can be audited while Mutex is only read-locked.
+	AuditLog   []*models.AuditEntry
+	AuditMutex sync.Mutex
+
+	Mutex   sync.RWMutex
+	nextID  uint
+	idMutex sync.Mutex // guards nextID so handlers holding Mutex can allocate IDs
 }
 
 var DB *InMemoryDB
@@ -29,19 +95,65 @@ func Connect() {
 		Carts:     make(map[uint]*models.Cart),
 		CartItems: make(map[string]*models.CartItem),
 		Orders:    make(map[uint]*models.Order),
-		nextID:    1,
+
+		Reservations: make(map[string]*models.StockReservation),
+
+		Promotions:  make(map[uint]*models.Promotion),
+		Redemptions: make(map[uint]*models.Redemption),
+
+		TaxRules: make(map[uint]*models.TaxRule),
+
+		Addresses:       make(map[uint]*models.Address),
+		ShippingZones:   make(map[uint]*models.ShippingZone),
+		ShippingMethods: make(map[uint]*models.ShippingMethod),
+
+		Payments: make(map[uint]*models.Payment),
+		Refunds:  make(map[uint]*models.Refund),
+
+		Shipments: make(map[uint]*models.Shipment),
+
+		Invoices:          make(map[uint]*models.Invoice),
+		DocumentSequences: make(map[string]*models.DocumentSequence),
+
+		Returns:      make(map[uint]*models.Return),
+		StoreCredits: make(map[uint]*models.StoreCredit),
+
+		Outbox: make(map[uint]*models.OutboxEvent),
+
+		WebhookEndpoints:  make(map[uint]*models.WebhookEndpoint),
+		WebhookDeliveries: make(map[uint]*models.WebhookDelivery),
+
+		IdempotencyKeys: make(map[string]*models.IdempotencyRecord),
+
+		MFAEnrollments:   make(map[uint]*models.MFAEnrollment),
+		MFARequiredRoles: make(map[string]bool),
+
+		UserTokens:     make(map[uint]*models.UserToken),
+		Sessions:       make(map[uint]*models.Session),
+		APIKeys:        make(map[uint]*models.APIKey),
+		UserIdentities: make(map[uint]*models.UserIdentity),
+		OIDCLogins:     make(map[string]*models.OIDCLogin),
+		LoginThrottles: make(map[string]*models.LoginThrottle),
+
+		nextID: 1,
 	}
 
 	// Seed some initial items
 	seedItems()
 	// Create admin user
 	seedAdminUser()
+	// Seed the tax rule table
+	seedTaxRules()
+	// Seed shipping zones and methods
+	seedShipping()
+	// Seed invoice and credit note numbering
+	seedDocumentSequences()
 	log.Println("In-memory database initialized successfully")
 }
 
 func (db *InMemoryDB) GetNextID() uint {
-	db.Mutex.Lock()
-	defer db.Mutex.Unlock()
+	db.idMutex.Lock()
+	defer db.idMutex.Unlock()
 	id := db.nextID
 	db.nextID++
 	return id
@@ -49,18 +161,21 @@ func (db *InMemoryDB) GetNextID() uint {
 
 func seedItems() {
 	if len(DB.Items) == 0 {
+		stock := func(n int) *int { return &n }
 		items := []models.Item{
-			{ID: DB.GetNextID(), Name: "Laptop", Status: "active", Image: "/assets/products/laptop.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Smartphone", Status: "active", Image: "/assets/products/smartphone.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Headphones", Status: "active", Image: "/assets/products/headphones.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Keyboard", Status: "active", Image: "/assets/products/keyboard.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Mouse", Status: "active", Image: "/assets/products/mouse.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Monitor", Status: "active", Image: "/assets/products/monitor.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Tablet", Status: "active", Image: "/assets/products/tablet.jpg", CreatedAt: time.Now()},
-			{ID: DB.GetNextID(), Name: "Webcam", Status: "active", Image: "/assets/products/webcam.jpg", CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Laptop", Category: "computers", Status: "active", Image: "/assets/products/laptop.jpg", Price: 99999, Weight: 2000, Stock: stock(25), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Smartphone", Category: "phones", Status: "active", Image: "/assets/products/smartphone.jpg", Price: 69999, Weight: 200, Stock: stock(40), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Headphones", Category: "audio", Status: "active", Image: "/assets/products/headphones.jpg", Price: 14999, Weight: 300, Stock: stock(60), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Keyboard", Category: "accessories", Status: "active", Image: "/assets/products/keyboard.jpg", Price: 4999, Weight: 800, Stock: stock(80), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Mouse", Category: "accessories", Status: "active", Image: "/assets/products/mouse.jpg", Price: 2499, Weight: 100, Stock: stock(120), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Monitor", Category: "computers", Status: "active", Image: "/assets/products/monitor.jpg", Price: 24999, Weight: 5000, Stock: stock(30), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Tablet", Category: "phones", Status: "active", Image: "/assets/products/tablet.jpg", Price: 39999, Weight: 500, Stock: stock(35), CreatedAt: time.Now()},
+			{ID: DB.GetNextID(), Name: "Webcam", Category: "accessories", Status: "active", Image: "/assets/products/webcam.jpg", Price: 5999, Weight: 150, Stock: stock(50), CreatedAt: time.Now()},
 		}
 
 		for _, item := range items {
+			item := item
+			item.TaxClass = models.TaxClassStandard
 			DB.Items[item.ID] = &item
 		}
 		log.Println("Seeded initial items with image URLs")
@@ -89,6 +204,7 @@ func seedAdminUser() {
 		ID:        adminID,
 		Username:  "admin",
 		Password:  string(hashedPassword),
+		Role:      models.RoleAdmin,
 		CreatedAt: time.Now(),
 	}
 
@@ -98,8 +214,9 @@ func seedAdminUser() {
 		ID:        cartID,
 		UserID:    adminID,
 		Name:      "Admin Cart",
-		Status:    "active",
+		Status:    models.CartStatusActive,
 		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 		CartItems: []models.CartItem{},
 	}
 
@@ -110,3 +227,70 @@ func seedAdminUser() {
 
 	log.Println("Created admin user (username: admin, password: Admin@123)")
 }
+
+func seedTaxRules() {
+	if len(DB.TaxRules) > 0 {
+		return
+	}
+
+	rules := []models.TaxRule{
+		{Country: "US", Region: "CA", TaxClass: models.TaxClassStandard, Name: "California State Tax", Rate: 725},
+		{Country: "US", Region: "NY", TaxClass: models.TaxClassStandard, Name: "New York State Tax", Rate: 400},
+		{Country: "US", Region: "TX", TaxClass: models.TaxClassStandard, Name: "Texas State Tax", Rate: 625},
+	}
+
+	for _, rule := range rules {
+		rule := rule
+		rule.ID = DB.GetNextID()
+		rule.CreatedAt = time.Now()
+		DB.TaxRules[rule.ID] = &rule
+	}
+	log.Println("Seeded tax rules")
+}
+
+func seedShipping() {
+	if len(DB.ShippingZones) > 0 {
+		return
+	}
+
+	zones := []models.ShippingZone{
+		{Code: "domestic", Name: "Contiguous US", Countries: []string{"US"}},
+		{Code: "remote", Name: "Alaska, Hawaii and territories", Regions: []string{"US-AK", "US-HI", "US-PR"}},
+		{Code: "international", Name: "Rest of world", Countries: []string{"*"}},
+	}
+	for _, zone := range zones {
+		zone := zone
+		zone.ID = DB.GetNextID()
+		zone.CreatedAt = time.Now()
+		DB.ShippingZones[zone.ID] = &zone
+	}
+
+	methods := []models.ShippingMethod{
+		{Code: "standard", Name: "Standard", Zones: []string{"domestic"}, BaseRate: 599, PerKgRate: 100, FreeOver: 5000, MinDays: 3, MaxDays: 5},
+		{Code: "express", Name: "Express", Zones: []string{"domestic", "remote"}, BaseRate: 1499, PerKgRate: 200, MinDays: 1, MaxDays: 2},
+		{Code: "international", Name: "International", Zones: []string{"international"}, BaseRate: 2499, PerKgRate: 500, MaxWeight: 20000, MinDays: 7, MaxDays: 14},
+	}
+	for _, method := range methods {
+		method := method
+		method.ID = DB.GetNextID()
+		method.Active = true
+		method.CreatedAt = time.Now()
+		DB.ShippingMethods[method.ID] = &method
+	}
+	log.Println("Seeded shipping zones and methods")
+}
+
+func seedDocumentSequences() {
+	if len(DB.DocumentSequences) > 0 {
+		return
+	}
+
+	sequences := []models.DocumentSequence{
+		{Name: models.InvoiceKindInvoice, Prefix: "INV-", Padding: 6, Next: 1},
+		{Name: models.InvoiceKindCreditNote, Prefix: "CN-", Padding: 6, Next: 1},
+	}
+	for _, sequence := range sequences {
+		sequence := sequence
+		DB.DocumentSequences[sequence.Name] = &sequence
+	}
+}
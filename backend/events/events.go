@@ -0,0 +1,92 @@
+// Package events is an in-process bus for domain events. Events are
+// published after the write that caused them has been stored, so
+// subscribers such as webhooks, search indexing or analytics never hear
+// about changes that did not happen.
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Type names a domain event.
+type Type string
+
+const (
+	UserRegistered     Type = "user.registered"
+	UserDeleted        Type = "user.deleted"
+	ItemCreated        Type = "item.created"
+	ItemUpdated        Type = "item.updated"
+	CartItemAdded      Type = "cart.item_added"
+	CartAbandoned      Type = "cart.abandoned"
+	OrderPlaced        Type = "order.placed"
+	OrderStatusChanged Type = "order.status_changed"
+)
+
+// Event is one domain event. Payload is the JSON of the event's data as it
+// was when the event was recorded.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       Type            `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// Decode unmarshals the event's payload into v.
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Handler receives published events.
+type Handler func(Event)
+
+// Bus delivers each published event to the handlers subscribed to its type
+// and to those subscribed to every type.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[Type][]Handler
+	all      []Handler
+}
+
+// NewBus returns a bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{handlers: map[Type][]Handler{}}
+}
+
+// Subscribe registers h for events of type t.
+func (b *Bus) Subscribe(t Type, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[t] = append(b.handlers[t], h)
+}
+
+// SubscribeAll registers h for every event.
+func (b *Bus) SubscribeAll(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.all = append(b.all, h)
+}
+
+// Publish calls the event's handlers in the order they subscribed, those
+// for its type first. A handler that panics is logged and the rest still
+// run.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.handlers[e.Type]...), b.all...)
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		call(h, e)
+	}
+}
+
+func call(h Handler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("events: handler for %s %s panicked: %v", e.Type, e.ID, r)
+		}
+	}()
+	h(e)
+}
@@ -0,0 +1,44 @@
+package events_test
+
+import (
+	"ecommerce-backend/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bus", func() {
+	var bus *events.Bus
+
+	BeforeEach(func() {
+		bus = events.NewBus()
+	})
+
+	It("delivers events to the handlers for their type, then to catch-all handlers", func() {
+		got := []string{}
+		bus.SubscribeAll(func(e events.Event) { got = append(got, "all:"+e.ID) })
+		bus.Subscribe(events.OrderPlaced, func(e events.Event) { got = append(got, "orders:"+e.ID) })
+		bus.Subscribe(events.ItemCreated, func(e events.Event) { got = append(got, "items:"+e.ID) })
+
+		bus.Publish(events.Event{ID: "1", Type: events.OrderPlaced})
+		bus.Publish(events.Event{ID: "2", Type: events.UserRegistered})
+
+		Expect(got).To(Equal([]string{"orders:1", "all:1", "all:2"}))
+	})
+
+	It("keeps going when a handler panics", func() {
+		called := false
+		bus.Subscribe(events.OrderPlaced, func(events.Event) { panic("boom") })
+		bus.Subscribe(events.OrderPlaced, func(events.Event) { called = true })
+
+		Expect(func() { bus.Publish(events.Event{Type: events.OrderPlaced}) }).ToNot(Panic())
+		Expect(called).To(BeTrue())
+	})
+
+	It("decodes payloads", func() {
+		var data struct{ OrderID uint }
+		e := events.Event{Type: events.OrderPlaced, Payload: []byte(`{"OrderID":7}`)}
+		Expect(e.Decode(&data)).To(Succeed())
+		Expect(data.OrderID).To(Equal(uint(7)))
+	})
+})
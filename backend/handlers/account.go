@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"ecommerce-backend/database"
+	"ecommerce-backend/mailer"
+	"ecommerce-backend/models"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Mailer sends account emails. main picks SMTP, files or the log.
+var Mailer mailer.Mailer = mailer.Log{}
+
+// MailFrom is the sender of account emails.
+var MailFrom = "E-commerce Store <no-reply@localhost>"
+
+// AppURL is the storefront that links in emails point to.
+var AppURL = "http://localhost:3000"
+
+// How long emailed tokens stay valid.
+var (
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+)
+
+// passwordResetInterval is how soon after one reset email another can be
+// sent, so the form cannot be used to flood someone's inbox.
+const passwordResetInterval = time.Minute
+
+var errInvalidUserToken = errors.New("invalid or expired token")
+
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// sendMail sends a message in the background so a slow mail server holds up
+// neither the request nor the database lock. Failures are logged.
+func sendMail(to, subject, body string) {
+	m := Mailer
+	msg := mailer.Message{From: MailFrom, To: to, Subject: subject, Body: body}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := m.Send(ctx, msg); err != nil {
+			log.Printf("Mail to %s failed: %v", to, err)
+		}
+	}()
+}
+
+// appLink returns a storefront URL carrying a token.
+func appLink(path, token string) string {
+	return strings.TrimRight(AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// userByEmail finds the user with an address, ignoring case.
+// Callers must hold database.DB.Mutex.
+func userByEmail(email string) *models.User {
+	for _, user := range database.DB.Users {
+		if user.Email != "" && strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+// issueUserToken makes a token for a user and purpose, replacing any unused
+// one, and returns it. Only its hash is stored.
+// Callers must hold database.DB.Mutex.
+func issueUserToken(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	revokeUserTokens(user.ID, purpose)
+	now := Clock.Now()
+	id := database.DB.GetNextID()
+	database.DB.UserTokens[id] = &models.UserToken{
+		ID:        id,
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Hash:      hashUserToken(token),
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	return token, nil
+}
+
+// revokeUserTokens deletes a user's unused tokens for a purpose.
+// Callers must hold database.DB.Mutex.
+func revokeUserTokens(userID uint, purpose string) {
+	for id, t := range database.DB.UserTokens {
+		if t.UserID == userID && t.Purpose == purpose && t.UsedAt == nil {
+			delete(database.DB.UserTokens, id)
+		}
+	}
+}
+
+// redeemUserToken uses up a token and returns its user. A token is only
+// good once, before it expires, and while the user still has the address
+// it was sent to.
+// Callers must hold database.DB.Mutex.
+func redeemUserToken(token, purpose string) (*models.User, error) {
+	now := Clock.Now()
+	hash := hashUserToken(token)
+	for _, t := range database.DB.UserTokens {
+		if t.Hash != hash || t.Purpose != purpose {
+			continue
+		}
+		user, exists := database.DB.Users[t.UserID]
+		if t.UsedAt != nil || !now.Before(t.ExpiresAt) || !exists || !strings.EqualFold(user.Email, t.Email) {
+			return nil, errInvalidUserToken
+		}
+		t.UsedAt = &now
+		return user, nil
+	}
+	return nil, errInvalidUserToken
+}
+
+// sendVerification emails a user a link to confirm their address.
+// Callers must hold database.DB.Mutex.
+func sendVerification(user *models.User) error {
+	token, err := issueUserToken(user, models.TokenEmailVerification, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	sendMail(user.Email, "Confirm your email address", fmt.Sprintf(
+		"Hi %s,\n\nConfirm this is your email address by opening the link below:\n\n%s\n\nThe link is valid for %s. If you did not ask for this, ignore this email.\n",
+		user.Username, appLink("/verify-email", token), EmailVerificationTTL))
+	return nil
+}
+
+// PruneUserTokens drops tokens that are used or expired.
+func PruneUserTokens(now time.Time) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for id, t := range database.DB.UserTokens {
+		if t.UsedAt != nil || !now.Before(t.ExpiresAt) {
+			delete(database.DB.UserTokens, id)
+		}
+	}
+}
+
+// changeEmail gives a user a new address. The new one needs verifying, and
+// the old one, if verified, is told about the change. When the address
+// cannot be used it answers the request itself and returns false.
+// Callers must hold database.DB.Mutex.
+func changeEmail(c *gin.Context, user *models.User, email string) bool {
+	if strings.EqualFold(user.Email, email) {
+		return true
+	}
+	if userByEmail(email) != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return false
+	}
+
+	before := *user
+	user.Email = email
+	user.EmailVerified = false
+	if err := sendVerification(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if before.Email != "" && before.EmailVerified {
+		sendMail(before.Email, "Your email address was changed", fmt.Sprintf(
+			"Hi %s,\n\nThe email address on your account was changed to %s. If you did not do this, contact us straight away.\n",
+			user.Username, email))
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserEmailChanged,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Changes:    auditChanges(before, *user),
+	})
+	return true
+}
+
+// UpdateEmail changes the current user's address. The new one needs
+// verifying, and the old one, if verified, is told about the change.
+func UpdateEmail(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	if !changeEmail(c, user, strings.TrimSpace(req.Email)) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email": user.Email, "email_verified": user.EmailVerified})
+}
+
+// ResendVerification emails the current user a new verification link.
+func ResendVerification(c *gin.Context) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	if user.Email == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "No email address on the account"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email address already verified"})
+		return
+	}
+	if err := sendVerification(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// VerifyEmail confirms an address with the token from a verification email.
+func VerifyEmail(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, err := redeemUserToken(req.Token, models.TokenEmailVerification)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user.EmailVerified = true
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserEmailVerified,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   map[string]string{"email": user.Email},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"email": user.Email, "email_verified": true})
+}
+
+// ForgotPassword emails a reset link to the account with a verified
+// address. The answer is the same whether or not there is one, so it
+// cannot be used to find out who has an account.
+func ForgotPassword(c *gin.Context) {
+	var req EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if user := userByEmail(strings.TrimSpace(req.Email)); user != nil && user.EmailVerified && !recentlySentReset(user.ID) {
+		token, err := issueUserToken(user, models.TokenPasswordReset, PasswordResetTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sendMail(user.Email, "Reset your password", fmt.Sprintf(
+			"Hi %s,\n\nSomeone asked to reset the password for your account. To choose a new one, open the link below:\n\n%s\n\nThe link is valid for %s and can be used once. If you did not ask for this, ignore this email; your password has not changed.\n",
+			user.Username, appLink("/reset-password", token), PasswordResetTTL))
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserPasswordResetRequested,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+		})
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account has that verified email address, a reset link has been sent to it"})
+}
+
+// recentlySentReset reports whether a user was sent a reset email within
+// passwordResetInterval.
+// Callers must hold database.DB.Mutex.
+func recentlySentReset(userID uint) bool {
+	since := Clock.Now().Add(-passwordResetInterval)
+	for _, t := range database.DB.UserTokens {
+		if t.UserID == userID && t.Purpose == models.TokenPasswordReset && t.UsedAt == nil && t.CreatedAt.After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResetPassword sets a new password with the token from a reset email. It
+// also ends any login lockout on the account, and signs it out everywhere
+// in case the old password was stolen.
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Hash first so the lock is not held while bcrypt runs
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, err := redeemUserToken(req.Token, models.TokenPasswordReset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user.Password = string(hashedPassword)
+	revokeUserTokens(user.ID, models.TokenPasswordReset)
+	delete(database.DB.LoginThrottles, accountThrottleKey(user.Username))
+	revokeUserSessions(user, 0)
+
+	sendMail(user.Email, "Your password was changed", fmt.Sprintf(
+		"Hi %s,\n\nThe password for your account was just reset. If you did not do this, contact us straight away.\n",
+		user.Username))
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserPasswordReset,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated"})
+}
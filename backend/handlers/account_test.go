@@ -0,0 +1,200 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/mailer"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Account emails", func() {
+	var (
+		fake *clock.Fake
+		mail *mailer.Memory
+	)
+
+	tokenPattern := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+	// tokenSent waits for the nth email to an address and returns the token
+	// in its link
+	tokenSent := func(address string, n int) string {
+		Eventually(func() []mailer.Message { return mail.To(address) }).Should(HaveLen(n))
+		match := tokenPattern.FindStringSubmatch(mail.To(address)[n-1].Body)
+		Expect(match).To(HaveLen(2))
+		return match[1]
+	}
+
+	// register creates a user with an email address and returns their token
+	register := func(username, email string) map[string]string {
+		Expect(request("POST", "/users", map[string]string{"username": username, "password": "old-pass", "email": email}, nil).Code).To(Equal(http.StatusCreated))
+		w := request("POST", "/users/login", map[string]string{"username": username, "password": "old-pass"}, nil)
+		var login handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &login)
+		return map[string]string{"Authorization": "Bearer " + login.Token}
+	}
+
+	userNamed := func(username string) *models.User {
+		for _, u := range database.DB.Users {
+			if u.Username == username {
+				return u
+			}
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+		mail = &mailer.Memory{}
+		handlers.Mailer = mail
+
+		newTestRouter()
+		router.POST("/users", handlers.CreateUser)
+		router.POST("/users/login", handlers.LoginUser)
+		router.POST("/users/email/verify", handlers.VerifyEmail)
+		router.POST("/users/password/forgot", handlers.ForgotPassword)
+		router.POST("/users/password/reset", handlers.ResetPassword)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.PUT("/users/email", handlers.UpdateEmail)
+		auth.POST("/users/email/verification", handlers.ResendVerification)
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+		handlers.Mailer = mailer.Log{}
+	})
+
+	Describe("email verification", func() {
+		It("verifies the address given at registration", func() {
+			register("alice", "alice@example.com")
+			token := tokenSent("alice@example.com", 1)
+			Expect(userNamed("alice").EmailVerified).To(BeFalse())
+
+			Expect(request("POST", "/users/email/verify", map[string]string{"token": token}, nil).Code).To(Equal(http.StatusOK))
+			Expect(userNamed("alice").EmailVerified).To(BeTrue())
+
+			// Tokens are single use and only their hash is stored
+			Expect(request("POST", "/users/email/verify", map[string]string{"token": token}, nil).Code).To(Equal(http.StatusBadRequest))
+			for _, t := range database.DB.UserTokens {
+				Expect(t.Hash).ToNot(Equal(token))
+			}
+		})
+
+		It("refuses expired tokens and can send a new one", func() {
+			alice := register("alice", "alice@example.com")
+			token := tokenSent("alice@example.com", 1)
+
+			fake.Advance(handlers.EmailVerificationTTL)
+			Expect(request("POST", "/users/email/verify", map[string]string{"token": token}, nil).Code).To(Equal(http.StatusBadRequest))
+
+			Expect(request("POST", "/users/email/verification", nil, alice).Code).To(Equal(http.StatusAccepted))
+			Expect(request("POST", "/users/email/verify", map[string]string{"token": tokenSent("alice@example.com", 2)}, nil).Code).To(Equal(http.StatusOK))
+			Expect(request("POST", "/users/email/verification", nil, alice).Code).To(Equal(http.StatusConflict))
+		})
+
+		It("needs the new address verified after a change", func() {
+			alice := register("alice", "alice@example.com")
+			request("POST", "/users/email/verify", map[string]string{"token": tokenSent("alice@example.com", 1)}, nil)
+			register("bob", "bob@example.com")
+
+			Expect(request("PUT", "/users/email", map[string]string{"email": "BOB@example.com"}, alice).Code).To(Equal(http.StatusConflict))
+			Expect(request("PUT", "/users/email", map[string]string{"email": "not an address"}, alice).Code).To(Equal(http.StatusBadRequest))
+
+			Expect(request("PUT", "/users/email", map[string]string{"email": "alice@new.example.com"}, alice).Code).To(Equal(http.StatusOK))
+			Expect(userNamed("alice").EmailVerified).To(BeFalse())
+
+			// The old address is told about the change
+			Eventually(func() []mailer.Message { return mail.To("alice@example.com") }).Should(HaveLen(2))
+			Expect(request("POST", "/users/email/verify", map[string]string{"token": tokenSent("alice@new.example.com", 1)}, nil).Code).To(Equal(http.StatusOK))
+		})
+
+		It("refuses a token sent to an address the user no longer has", func() {
+			alice := register("alice", "alice@example.com")
+			token := tokenSent("alice@example.com", 1)
+			request("PUT", "/users/email", map[string]string{"email": "alice@new.example.com"}, alice)
+
+			Expect(request("POST", "/users/email/verify", map[string]string{"token": token}, nil).Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("password reset", func() {
+		BeforeEach(func() {
+			register("alice", "alice@example.com")
+			request("POST", "/users/email/verify", map[string]string{"token": tokenSent("alice@example.com", 1)}, nil)
+		})
+
+		It("resets the password with an emailed token", func() {
+			Expect(request("POST", "/users/password/forgot", map[string]string{"email": "Alice@Example.com"}, nil).Code).To(Equal(http.StatusAccepted))
+			token := tokenSent("alice@example.com", 2)
+
+			Expect(request("POST", "/users/password/reset", map[string]string{"token": token, "password": "new-pass"}, nil).Code).To(Equal(http.StatusOK))
+			Expect(request("POST", "/users/login", map[string]string{"username": "alice", "password": "old-pass"}, nil).Code).To(Equal(http.StatusUnauthorized))
+			Expect(request("POST", "/users/login", map[string]string{"username": "alice", "password": "new-pass"}, nil).Code).To(Equal(http.StatusOK))
+
+			Expect(request("POST", "/users/password/reset", map[string]string{"token": token, "password": "again"}, nil).Code).To(Equal(http.StatusBadRequest))
+
+			// A notice follows the reset
+			Eventually(func() []mailer.Message { return mail.To("alice@example.com") }).Should(HaveLen(3))
+		})
+
+		It("answers the same for unknown and unverified addresses", func() {
+			register("bob", "bob@example.com")
+
+			known := request("POST", "/users/password/forgot", map[string]string{"email": "alice@example.com"}, nil)
+			unknown := request("POST", "/users/password/forgot", map[string]string{"email": "nobody@example.com"}, nil)
+			unverified := request("POST", "/users/password/forgot", map[string]string{"email": "bob@example.com"}, nil)
+			Expect(unknown.Code).To(Equal(known.Code))
+			Expect(unknown.Body.String()).To(Equal(known.Body.String()))
+			Expect(unverified.Body.String()).To(Equal(known.Body.String()))
+
+			tokenSent("alice@example.com", 2)
+			Consistently(func() []mailer.Message { return mail.To("bob@example.com") }, "50ms").Should(HaveLen(1))
+		})
+
+		It("sends at most one reset a minute and only the newest token works", func() {
+			request("POST", "/users/password/forgot", map[string]string{"email": "alice@example.com"}, nil)
+			first := tokenSent("alice@example.com", 2)
+			request("POST", "/users/password/forgot", map[string]string{"email": "alice@example.com"}, nil)
+			Consistently(func() []mailer.Message { return mail.To("alice@example.com") }, "50ms").Should(HaveLen(2))
+
+			fake.Advance(time.Minute)
+			request("POST", "/users/password/forgot", map[string]string{"email": "alice@example.com"}, nil)
+			second := tokenSent("alice@example.com", 3)
+
+			Expect(request("POST", "/users/password/reset", map[string]string{"token": first, "password": "x"}, nil).Code).To(Equal(http.StatusBadRequest))
+			Expect(request("POST", "/users/password/reset", map[string]string{"token": second, "password": "x"}, nil).Code).To(Equal(http.StatusOK))
+		})
+
+		It("expires reset tokens and ends a lockout", func() {
+			for i := 0; i < 10; i++ {
+				request("POST", "/users/login", map[string]string{"username": "alice", "password": "guess"}, nil)
+				fake.Advance(time.Minute)
+			}
+			Expect(request("POST", "/users/login", map[string]string{"username": "alice", "password": "old-pass"}, nil).Code).To(Equal(http.StatusTooManyRequests))
+
+			request("POST", "/users/password/forgot", map[string]string{"email": "alice@example.com"}, nil)
+			token := tokenSent("alice@example.com", 2)
+			Expect(request("POST", "/users/password/reset", map[string]string{"token": token, "password": "new-pass"}, nil).Code).To(Equal(http.StatusOK))
+			Expect(request("POST", "/users/login", map[string]string{"username": "alice", "password": "new-pass"}, nil).Code).To(Equal(http.StatusOK))
+
+			fake.Advance(time.Minute)
+			request("POST", "/users/password/forgot", map[string]string{"email": "alice@example.com"}, nil)
+			token = tokenSent("alice@example.com", 4)
+			fake.Advance(handlers.PasswordResetTTL)
+			Expect(request("POST", "/users/password/reset", map[string]string{"token": token, "password": "x"}, nil).Code).To(Equal(http.StatusBadRequest))
+
+			handlers.PruneUserTokens(fake.Now())
+			Expect(database.DB.UserTokens).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AddressRequest struct {
+	Label      string `json:"label"`
+	Name       string `json:"name" binding:"required"`
+	Line1      string `json:"line1" binding:"required"`
+	Line2      string `json:"line2"`
+	City       string `json:"city" binding:"required"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country" binding:"required,len=2"`
+	Phone      string `json:"phone"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+func (req AddressRequest) apply(address *models.Address) {
+	address.Label = req.Label
+	address.Name = req.Name
+	address.Line1 = req.Line1
+	address.Line2 = req.Line2
+	address.City = req.City
+	address.Region = strings.ToUpper(strings.TrimSpace(req.Region))
+	address.PostalCode = req.PostalCode
+	address.Country = strings.ToUpper(strings.TrimSpace(req.Country))
+	address.Phone = req.Phone
+	address.IsDefault = req.IsDefault
+}
+
+// userAddress returns one of the user's addresses, or nil.
+// Callers must hold database.DB.Mutex.
+func userAddress(userID, addressID uint) *models.Address {
+	address, exists := database.DB.Addresses[addressID]
+	if !exists || address.UserID != userID {
+		return nil
+	}
+	return address
+}
+
+// defaultAddress returns the user's default address, or nil.
+// Callers must hold database.DB.Mutex.
+func defaultAddress(userID uint) *models.Address {
+	for _, address := range database.DB.Addresses {
+		if address.UserID == userID && address.IsDefault {
+			return address
+		}
+	}
+	return nil
+}
+
+// makeDefault clears the default flag on the user's other addresses.
+// Callers must hold database.DB.Mutex.
+func makeDefault(address *models.Address) {
+	for _, other := range database.DB.Addresses {
+		if other.UserID == address.UserID && other.ID != address.ID {
+			other.IsDefault = false
+		}
+	}
+	address.IsDefault = true
+}
+
+func GetAddresses(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	addresses := []models.Address{}
+	for _, address := range database.DB.Addresses {
+		if address.UserID == userID.(uint) {
+			addresses = append(addresses, *address)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].ID < addresses[j].ID })
+
+	c.JSON(http.StatusOK, addresses)
+}
+
+func CreateAddress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	address := &models.Address{
+		ID:        database.DB.GetNextID(),
+		UserID:    userID.(uint),
+		CreatedAt: time.Now(),
+	}
+	req.apply(address)
+
+	// The first address becomes the default
+	if address.IsDefault || defaultAddress(address.UserID) == nil {
+		makeDefault(address)
+	}
+	database.DB.Addresses[address.ID] = address
+
+	c.JSON(http.StatusCreated, *address)
+}
+
+func UpdateAddress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var addressID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &addressID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	var req AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	address := userAddress(userID.(uint), addressID)
+	if address == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+
+	wasDefault := address.IsDefault
+	req.apply(address)
+	if address.IsDefault || wasDefault {
+		makeDefault(address)
+	}
+
+	c.JSON(http.StatusOK, *address)
+}
+
+func DeleteAddress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var addressID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &addressID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	address := userAddress(userID.(uint), addressID)
+	if address == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		return
+	}
+	delete(database.DB.Addresses, addressID)
+
+	// Carts must not keep pointing at the deleted address
+	for _, cart := range database.DB.Carts {
+		if cart.UserID != address.UserID {
+			continue
+		}
+		if cart.ShippingAddressID == addressID {
+			cart.ShippingAddressID = 0
+			cart.ShippingMethod = ""
+		}
+		if cart.BillingAddressID == addressID {
+			cart.BillingAddressID = 0
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Address deleted"})
+}
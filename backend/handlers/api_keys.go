@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"ecommerce-backend/apikey"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	UserID    uint       `json:"user_id"` // defaults to the admin creating the key
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	RateLimit int        `json:"rate_limit" binding:"omitempty,min=1,max=10000"` // requests per minute
+}
+
+// CreatedAPIKey is a new key with the secret, which is only shown once.
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// apiKeyParam looks up the key named in the path.
+// Callers must hold database.DB.Mutex.
+func apiKeyParam(c *gin.Context) (*models.APIKey, bool) {
+	var keyID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &keyID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return nil, false
+	}
+	key, exists := database.DB.APIKeys[keyID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return nil, false
+	}
+	return key, true
+}
+
+// CreateAPIKey issues a key that acts as a user, by default the admin
+// issuing it, within its scopes. The key is in the response and cannot be
+// retrieved again.
+func CreateAPIKey(c *gin.Context) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !apikey.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope %q", scope)})
+			return
+		}
+	}
+	now := Clock.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	secret, prefix, err := apikey.New()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.GetUint("user_id")
+	if req.UserID == 0 {
+		req.UserID = adminID
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = apikey.DefaultRateLimit
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if _, exists := database.DB.Users[req.UserID]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	key := &models.APIKey{
+		ID:        database.DB.GetNextID(),
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      apikey.Hash(secret),
+		UserID:    req.UserID,
+		Scopes:    req.Scopes,
+		RateLimit: req.RateLimit,
+		CreatedBy: adminID,
+		CreatedAt: now,
+		ExpiresAt: req.ExpiresAt,
+	}
+	database.DB.APIKeys[key.ID] = key
+
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditAPIKeyCreated,
+		TargetType: AuditTargetAPIKey,
+		TargetID:   key.ID,
+		Changes:    auditChanges(nil, *key),
+	})
+
+	c.JSON(http.StatusCreated, CreatedAPIKey{APIKey: *key, Key: secret})
+}
+
+// GetAPIKeys lists every key, revoked ones included, optionally for one
+// ?user_id=.
+func GetAPIKeys(c *gin.Context) {
+	var userID uint
+	if id := c.Query("user_id"); id != "" {
+		if _, err := fmt.Sscanf(id, "%d", &userID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	keys := []models.APIKey{}
+	for _, key := range database.DB.APIKeys {
+		if userID == 0 || key.UserID == userID {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeUserAPIKeys stops all of a user's keys working. It returns how many
+// were revoked.
+// Callers must hold database.DB.Mutex.
+func revokeUserAPIKeys(user *models.User) int {
+	now := Clock.Now()
+	revoked := 0
+	for _, key := range database.DB.APIKeys {
+		if key.UserID == user.ID && key.RevokedAt == nil {
+			key.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// RevokeAPIKey stops a key working at once. It stays listed with the time
+// it was revoked.
+func RevokeAPIKey(c *gin.Context) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	key, ok := apiKeyParam(c)
+	if !ok {
+		return
+	}
+	if key.RevokedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key is already revoked"})
+		return
+	}
+	now := Clock.Now()
+	key.RevokedAt = &now
+
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditAPIKeyRevoked,
+		TargetType: AuditTargetAPIKey,
+		TargetID:   key.ID,
+	})
+
+	c.JSON(http.StatusOK, *key)
+}
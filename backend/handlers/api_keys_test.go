@@ -0,0 +1,164 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API keys", func() {
+	var (
+		admin *models.User
+		fake  *clock.Fake
+	)
+
+	withKey := func(key string) map[string]string {
+		return map[string]string{middleware.APIKeyHeader: key}
+	}
+
+	// issue creates a key as the admin and returns it
+	issue := func(body map[string]interface{}) handlers.CreatedAPIKey {
+		w := request("POST", "/api-keys", body, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		var created handlers.CreatedAPIKey
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created
+	}
+
+	newItem := map[string]interface{}{"name": "Widget", "price": 500}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+		middleware.Clock = fake
+
+		admin = newTestRouter()
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/items", handlers.CreateItem)
+		auth.GET("/orders/user", handlers.GetUserOrders)
+		auth.PUT("/users/email", handlers.UpdateEmail)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.PUT("/items/:id", handlers.UpdateItem)
+		staff.PUT("/users/:id/role", handlers.SetUserRole)
+		staff.GET("/audit", handlers.GetAuditLog)
+		staff.GET("/api-keys", handlers.GetAPIKeys)
+		staff.POST("/api-keys", handlers.CreateAPIKey)
+		staff.DELETE("/api-keys/:id", handlers.RevokeAPIKey)
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+		middleware.Clock = clock.Real{}
+	})
+
+	It("shows the key once and stores only its hash", func() {
+		created := issue(map[string]interface{}{"name": "ERP", "scopes": []string{"items:write"}})
+		Expect(created.Key).To(HavePrefix(created.Prefix + "_"))
+		Expect(created.UserID).To(Equal(admin.ID))
+		Expect(created.RateLimit).To(Equal(60))
+
+		w := request("GET", "/api-keys", nil, asAdmin())
+		Expect(w.Body.String()).To(ContainSubstring(created.Prefix))
+		Expect(w.Body.String()).ToNot(ContainSubstring(created.Key))
+		Expect(database.DB.APIKeys[created.ID].Hash).ToNot(Equal(created.Key))
+	})
+
+	It("authenticates requests as the key's user", func() {
+		created := issue(map[string]interface{}{"name": "ERP", "scopes": []string{"items:write"}})
+
+		Expect(request("POST", "/items", newItem, withKey(created.Key)).Code).To(Equal(http.StatusCreated))
+		w := request("POST", "/items", newItem, map[string]string{"Authorization": "Bearer " + created.Key})
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(w.Header().Get("X-RateLimit-Remaining")).To(Equal("58"))
+
+		key := database.DB.APIKeys[created.ID]
+		Expect(key.LastUsedAt).ToNot(BeNil())
+		Expect(key.LastUsedAt.Equal(fake.Now())).To(BeTrue())
+		Expect(key.LastUsedIP).To(Equal(clientIP))
+
+		// What the key did is audited against its user and the key
+		var entries []models.AuditEntry
+		json.Unmarshal(request("GET", "/audit?action=item.created", nil, asAdmin()).Body.Bytes(), &entries)
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].ActorID).To(Equal(admin.ID))
+		Expect(entries[0].Metadata).To(HaveKeyWithValue("api_key_id", itoa(created.ID)))
+	})
+
+	It("limits keys to their scopes", func() {
+		created := issue(map[string]interface{}{"name": "Reports", "scopes": []string{"orders:read"}})
+
+		Expect(request("GET", "/orders/user", nil, withKey(created.Key)).Code).To(Equal(http.StatusOK))
+		w := request("POST", "/items", newItem, withKey(created.Key))
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Body.String()).To(ContainSubstring("items:write"))
+
+		// Keys never reach key management or account settings
+		Expect(request("GET", "/api-keys", nil, withKey(created.Key)).Code).To(Equal(http.StatusForbidden))
+		Expect(request("PUT", "/users/email", map[string]string{"email": "x@example.com"}, withKey(created.Key)).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("gives a key no more than its user may do", func() {
+		svcID, _ := asCustomer("svc")
+		svc := database.DB.Users[svcID]
+		created := issue(map[string]interface{}{"name": "Sync", "user_id": svc.ID, "scopes": []string{"items:write"}})
+
+		Expect(request("PUT", "/items/1", newItem, withKey(created.Key)).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("keeps an admin's key away from other users' accounts", func() {
+		svcID, _ := asCustomer("svc")
+		svc := database.DB.Users[svcID]
+		created := issue(map[string]interface{}{"name": "Provisioning", "scopes": []string{"users:write"}})
+
+		w := request("PUT", "/users/"+itoa(svc.ID)+"/role", map[string]string{"role": models.RoleAdmin}, withKey(created.Key))
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(svc.Role).To(Equal(models.RoleCustomer))
+	})
+
+	It("refuses revoked, expired and unknown keys", func() {
+		revoked := issue(map[string]interface{}{"name": "Old", "scopes": []string{"items:write"}})
+		Expect(request("DELETE", "/api-keys/"+itoa(revoked.ID), nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(request("DELETE", "/api-keys/"+itoa(revoked.ID), nil, asAdmin()).Code).To(Equal(http.StatusConflict))
+		Expect(request("POST", "/items", newItem, withKey(revoked.Key)).Code).To(Equal(http.StatusUnauthorized))
+
+		expiring := issue(map[string]interface{}{"name": "Trial", "scopes": []string{"items:write"}, "expires_at": fake.Now().Add(time.Hour)})
+		Expect(request("POST", "/items", newItem, withKey(expiring.Key)).Code).To(Equal(http.StatusCreated))
+		fake.Advance(time.Hour)
+		Expect(request("POST", "/items", newItem, withKey(expiring.Key)).Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(request("POST", "/items", newItem, withKey(expiring.Prefix+"_forged")).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("rate limits each key separately", func() {
+		slow := issue(map[string]interface{}{"name": "Slow", "scopes": []string{"items:write"}, "rate_limit": 2})
+		other := issue(map[string]interface{}{"name": "Other", "scopes": []string{"items:write"}, "rate_limit": 2})
+
+		request("POST", "/items", newItem, withKey(slow.Key))
+		request("POST", "/items", newItem, withKey(slow.Key))
+		w := request("POST", "/items", newItem, withKey(slow.Key))
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("30"))
+
+		Expect(request("POST", "/items", newItem, withKey(other.Key)).Code).To(Equal(http.StatusCreated))
+
+		fake.Advance(30 * time.Second)
+		Expect(request("POST", "/items", newItem, withKey(slow.Key)).Code).To(Equal(http.StatusCreated))
+	})
+
+	It("validates new keys", func() {
+		Expect(request("POST", "/api-keys", map[string]interface{}{"name": "Bad", "scopes": []string{"mfa:write"}}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+		Expect(request("POST", "/api-keys", map[string]interface{}{"name": "Bad", "scopes": []string{}}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+		Expect(request("POST", "/api-keys", map[string]interface{}{"name": "Bad", "scopes": []string{"items:read"}, "expires_at": fake.Now()}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+		Expect(request("POST", "/api-keys", map[string]interface{}{"name": "Bad", "scopes": []string{"items:read"}, "user_id": 9999}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+	})
+})
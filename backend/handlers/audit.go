@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"ecommerce-backend/audit"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Audited actions.
+const (
+	AuditUserLogin                  = "user.login"
+	AuditUserLoggedOut              = "user.logged_out"
+	AuditUserForcedLogout           = "user.forced_logout" // by an admin, on every device
+	AuditSessionRevoked             = "session.revoked"
+	AuditUserLockedOut              = "user.locked_out"
+	AuditUserUnlocked               = "user.unlocked"
+	AuditUserRegistered             = "user.registered"
+	AuditUserMFAChallenged          = "user.mfa_challenged" // password accepted, second factor pending
+	AuditUserMFAFailed              = "user.mfa_failed"
+	AuditUserMFAEnabled             = "user.mfa_enabled"
+	AuditUserMFADisabled            = "user.mfa_disabled"
+	AuditUserRecoveryCodeUsed       = "user.mfa_recovery_code_used"
+	AuditUserRecoveryCodesRenewed   = "user.mfa_recovery_codes_renewed"
+	AuditMFAPolicyChanged           = "mfa.policy_changed"
+	AuditAPIKeyCreated              = "api_key.created"
+	AuditAPIKeyRevoked              = "api_key.revoked"
+	AuditUserEmailChanged           = "user.email_changed"
+	AuditUserEmailVerified          = "user.email_verified"
+	AuditUserPasswordResetRequested = "user.password_reset_requested"
+	AuditUserPasswordReset          = "user.password_reset"
+	AuditUserIdentityLinked         = "user.identity_linked"
+	AuditUserIdentityUnlinked       = "user.identity_unlinked"
+	AuditUserProfileUpdated         = "user.profile_updated"
+	AuditUserPasswordChanged        = "user.password_changed"
+	AuditUserDataExported           = "user.data_exported"
+	AuditUserDeleted                = "user.deleted" // by the user; their orders are anonymized
+	AuditUserRoleChanged            = "user.role_changed"
+	AuditUserTaxExempt              = "user.tax_exempt_changed"
+	AuditItemCreated                = "item.created"
+	AuditItemUpdated                = "item.updated"
+	AuditItemDeleted                = "item.deleted"
+	AuditOrderStatus                = "order.status_changed"
+	AuditDataRead                   = "data.read" // of other users' data
+)
+
+// Kinds of record an audit entry can be about. An admin read of a whole
+// listing has the kind listed and no target ID.
+const (
+	AuditTargetUser    = "user"
+	AuditTargetItem    = "item"
+	AuditTargetCart    = "cart"
+	AuditTargetOrder   = "order"
+	AuditTargetInvoice = "invoice"
+	AuditTargetReturn  = "return"
+	AuditTargetAPIKey  = "api_key"
+)
+
+// defaultAuditPageSize is the page size when an audit listing gives no limit.
+const defaultAuditPageSize = 100
+
+// AuditQuery filters an audit log listing. Entries are listed newest
+// first; Cursor is the X-Next-Cursor of the previous page.
+type AuditQuery struct {
+	Action     string `form:"action"`
+	Outcome    string `form:"outcome" binding:"omitempty,oneof=success failure"`
+	ActorID    uint   `form:"actor_id"`
+	TargetType string `form:"target_type"`
+	TargetID   uint   `form:"target_id"`
+	RequestID  string `form:"request_id"`
+	From       string `form:"from"`
+	To         string `form:"to"`
+	Limit      int    `form:"limit" binding:"omitempty,min=1,max=500"`
+	Cursor     string `form:"cursor"`
+}
+
+// AuditVerification reports whether the audit log's hash chain is intact.
+type AuditVerification struct {
+	Valid   bool   `json:"valid"`
+	Entries int    `json:"entries"`
+	Error   string `json:"error,omitempty"`
+}
+
+// recordAudit appends an entry to the audit log. The actor, client IP and
+// request ID are taken from c when it is given and the entry leaves them
+// unset, and actions taken with an API key note the key in the metadata;
+// c is nil for actions taken outside a request.
+// Callers must hold database.DB.Mutex, for reading at least.
+func recordAudit(c *gin.Context, entry models.AuditEntry) {
+	if c != nil {
+		if entry.ActorID == 0 {
+			if userID, exists := c.Get("user_id"); exists {
+				entry.ActorID = userID.(uint)
+			}
+		}
+		entry.IP = c.ClientIP()
+		entry.RequestID = c.GetString("request_id")
+		if keyID, exists := c.Get("api_key_id"); exists {
+			metadata := map[string]string{"api_key_id": fmt.Sprintf("%d", keyID)}
+			for k, v := range entry.Metadata {
+				metadata[k] = v
+			}
+			entry.Metadata = metadata
+		}
+	}
+	if entry.Outcome == "" {
+		entry.Outcome = models.AuditSuccess
+	}
+	if user, exists := database.DB.Users[entry.ActorID]; exists {
+		entry.ActorName = user.Username
+	}
+	entry.CreatedAt = Clock.Now()
+
+	database.DB.AuditMutex.Lock()
+	defer database.DB.AuditMutex.Unlock()
+
+	prevHash := ""
+	if n := len(database.DB.AuditLog); n > 0 {
+		prevHash = database.DB.AuditLog[n-1].Hash
+	}
+	entry.ID = uint(len(database.DB.AuditLog) + 1)
+	if err := audit.Seal(&entry, prevHash); err != nil {
+		log.Printf("Audit entry %s not recorded: %v", entry.Action, err)
+		return
+	}
+	database.DB.AuditLog = append(database.DB.AuditLog, &entry)
+}
+
+// auditChanges diffs two versions of a record for an audit entry. A diff
+// that cannot be made is logged and left out rather than failing the action.
+func auditChanges(before, after interface{}) []models.AuditChange {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		log.Printf("Audit diff failed: %v", err)
+		return nil
+	}
+	return changes
+}
+
+// auditStatusChange records an order moving between statuses.
+// Callers must hold database.DB.Mutex.
+func auditStatusChange(order *models.Order, previous, event string, by uint) {
+	before, _ := json.Marshal(previous)
+	after, _ := json.Marshal(order.Status)
+	recordAudit(nil, models.AuditEntry{
+		Action:     AuditOrderStatus,
+		ActorID:    by,
+		TargetType: AuditTargetOrder,
+		TargetID:   order.ID,
+		Changes:    []models.AuditChange{{Field: "status", Before: before, After: after}},
+		Metadata:   map[string]string{"event": event},
+	})
+}
+
+// auditRead records a user, normally an admin, reading data that is not
+// their own: a listing of every user's records, or one record owned by
+// someone else. Reads by the owner are not recorded.
+// Callers must hold database.DB.Mutex.
+func auditRead(c *gin.Context, targetType string, targetID, ownerID uint, metadata map[string]string) {
+	userID, _ := c.Get("user_id")
+	if id, ok := userID.(uint); ok && ownerID != 0 && id == ownerID {
+		return
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata["path"] = c.FullPath()
+	if ownerID != 0 {
+		metadata["owner_id"] = strconv.FormatUint(uint64(ownerID), 10)
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditDataRead,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Metadata:   metadata,
+	})
+}
+
+// matches reports whether an entry passes every filter of the query.
+func (q AuditQuery) matches(entry *models.AuditEntry, from, to time.Time) bool {
+	switch {
+	case q.Action != "" && entry.Action != q.Action,
+		q.Outcome != "" && entry.Outcome != q.Outcome,
+		q.ActorID != 0 && entry.ActorID != q.ActorID,
+		q.TargetType != "" && entry.TargetType != q.TargetType,
+		q.TargetID != 0 && entry.TargetID != q.TargetID,
+		q.RequestID != "" && entry.RequestID != q.RequestID,
+		!from.IsZero() && entry.CreatedAt.Before(from),
+		!to.IsZero() && entry.CreatedAt.After(to):
+		return false
+	}
+	return true
+}
+
+// GetAuditLog searches the audit log, newest first. See AuditQuery for the
+// filters.
+func GetAuditLog(c *gin.Context) {
+	var q AuditQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultAuditPageSize
+	}
+
+	var from, to time.Time
+	var err error
+	if q.From != "" {
+		if from, err = parseOrderDate(q.From, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if q.To != "" {
+		if to, err = parseOrderDate(q.To, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	database.DB.AuditMutex.Lock()
+	defer database.DB.AuditMutex.Unlock()
+
+	// The cursor is the ID of the last entry listed; entry IDs are their
+	// position in the log
+	start := len(database.DB.AuditLog)
+	if q.Cursor != "" {
+		before, err := strconv.Atoi(q.Cursor)
+		if err != nil || before < 1 || before > start+1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidCursor.Error()})
+			return
+		}
+		start = before - 1
+	}
+
+	entries := []models.AuditEntry{}
+	for i := start - 1; i >= 0; i-- {
+		entry := database.DB.AuditLog[i]
+		if !q.matches(entry, from, to) {
+			continue
+		}
+		if len(entries) == q.Limit {
+			c.Header(NextCursorHeader, strconv.FormatUint(uint64(entries[len(entries)-1].ID), 10))
+			break
+		}
+		entries = append(entries, *entry)
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// VerifyAuditLog checks the audit log's hash chain from the first entry.
+func VerifyAuditLog(c *gin.Context) {
+	database.DB.AuditMutex.Lock()
+	entries := make([]models.AuditEntry, 0, len(database.DB.AuditLog))
+	for _, entry := range database.DB.AuditLog {
+		entries = append(entries, *entry)
+	}
+	database.DB.AuditMutex.Unlock()
+
+	result := AuditVerification{Valid: true, Entries: len(entries)}
+	if err := audit.Verify(entries); err != nil {
+		if !errors.Is(err, audit.ErrChainBroken) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		result.Valid = false
+		result.Error = err.Error()
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,210 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Audit log", func() {
+	var admin *models.User
+
+	// search lists audit entries through the admin endpoint
+	search := func(query string) []models.AuditEntry {
+		w := request("GET", "/audit"+query, nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var entries []models.AuditEntry
+		json.Unmarshal(w.Body.Bytes(), &entries)
+		return entries
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+
+		router.Use(middleware.RequestIDMiddleware())
+		router.POST("/users", handlers.CreateUser)
+		router.POST("/users/login", handlers.LoginUser)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/items", handlers.CreateItem)
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.GET("/orders/:id", handlers.GetOrder)
+		auth.POST("/orders/:id/cancel", handlers.CancelOrder)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.GET("/users", handlers.GetUsers)
+		staff.PUT("/items/:id", handlers.UpdateItem)
+		staff.DELETE("/items/:id", handlers.DeleteItem)
+		staff.PUT("/users/:id/role", handlers.SetUserRole)
+		staff.GET("/audit", handlers.GetAuditLog)
+		staff.GET("/audit/verify", handlers.VerifyAuditLog)
+	})
+
+	It("records logins with their outcome, IP and request ID", func() {
+		request("POST", "/users/login", map[string]string{"username": "admin", "password": "wrong"}, map[string]string{"X-Request-ID": "req-1"})
+		request("POST", "/users/login", map[string]string{"username": "nobody", "password": "x"}, nil)
+		w := request("POST", "/users/login", map[string]string{"username": "admin", "password": "Admin@123"}, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get(middleware.RequestIDHeader)).ToNot(BeEmpty())
+
+		entries := search("?action=" + handlers.AuditUserLogin)
+		Expect(entries).To(HaveLen(3))
+
+		Expect(entries[0].Outcome).To(Equal(models.AuditSuccess))
+		Expect(entries[0].ActorID).To(Equal(admin.ID))
+		Expect(entries[0].ActorName).To(Equal("admin"))
+		Expect(entries[0].RequestID).To(Equal(w.Header().Get(middleware.RequestIDHeader)))
+
+		Expect(entries[1].Outcome).To(Equal(models.AuditFailure))
+		Expect(entries[1].Metadata).To(HaveKeyWithValue("username", "nobody"))
+
+		Expect(entries[2].Outcome).To(Equal(models.AuditFailure))
+		Expect(entries[2].TargetID).To(Equal(admin.ID))
+		Expect(entries[2].RequestID).To(Equal("req-1"))
+		Expect(entries[2].IP).To(Equal(clientIP))
+
+		Expect(search("?outcome=failure&request_id=req-1")).To(HaveLen(1))
+	})
+
+	It("records registrations without the password", func() {
+		request("POST", "/users", map[string]string{"username": "alice", "password": "secret"}, nil)
+
+		entries := search("?action=" + handlers.AuditUserRegistered)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].ActorName).To(Equal("alice"))
+		raw, _ := json.Marshal(entries[0])
+		Expect(string(raw)).ToNot(ContainSubstring("secret"))
+	})
+
+	It("records item changes with a diff", func() {
+		w := request("POST", "/items", map[string]interface{}{"name": "Lamp", "price": 2500}, asAdmin())
+		var item models.Item
+		json.Unmarshal(w.Body.Bytes(), &item)
+
+		request("PUT", "/items/"+itoa(item.ID), map[string]interface{}{"name": "Lamp", "price": 2000}, asAdmin())
+		Expect(request("DELETE", "/items/"+itoa(item.ID), nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(database.DB.Items[item.ID].Status).To(Equal("inactive"))
+
+		entries := search("?target_type=item&target_id=" + itoa(item.ID))
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[0].Action).To(Equal(handlers.AuditItemDeleted))
+		Expect(entries[0].Changes).To(Equal([]models.AuditChange{
+			{Field: "status", Before: json.RawMessage(`"active"`), After: json.RawMessage(`"inactive"`)},
+		}))
+		Expect(entries[1].Changes).To(Equal([]models.AuditChange{
+			{Field: "price", Before: json.RawMessage("2500"), After: json.RawMessage("2000")},
+		}))
+		Expect(entries[2].Action).To(Equal(handlers.AuditItemCreated))
+		Expect(entries[2].ActorID).To(Equal(admin.ID))
+	})
+
+	It("records role changes and refuses to change your own", func() {
+		request("POST", "/users", map[string]string{"username": "bob", "password": "pw"}, nil)
+		var bob *models.User
+		for _, u := range database.DB.Users {
+			if u.Username == "bob" {
+				bob = u
+			}
+		}
+
+		Expect(request("PUT", "/users/"+itoa(bob.ID)+"/role", map[string]string{"role": "admin"}, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(bob.Role).To(Equal(models.RoleAdmin))
+		Expect(request("PUT", "/users/"+itoa(admin.ID)+"/role", map[string]string{"role": "customer"}, asAdmin()).Code).To(Equal(http.StatusConflict))
+		Expect(request("PUT", "/users/"+itoa(bob.ID)+"/role", map[string]string{"role": "owner"}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+
+		entries := search("?action=" + handlers.AuditUserRoleChanged)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].TargetID).To(Equal(bob.ID))
+		Expect(entries[0].Changes).To(Equal([]models.AuditChange{
+			{Field: "role", Before: json.RawMessage(`"customer"`), After: json.RawMessage(`"admin"`)},
+		}))
+	})
+
+	It("records order status changes and admin reads of other users' orders", func() {
+		request("POST", "/users", map[string]string{"username": "carol", "password": "pw"}, nil)
+		w := request("POST", "/users/login", map[string]string{"username": "carol", "password": "pw"}, nil)
+		var login handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &login)
+		carol := map[string]string{"Authorization": "Bearer " + login.Token}
+
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, carol)
+		w = request("POST", "/orders", map[string]string{"payment_method": payments.FakeCardApproved}, carol)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		var order models.Order
+		json.Unmarshal(w.Body.Bytes(), &order)
+
+		// The owner's own read is not audited, an admin's is
+		request("GET", "/orders/"+itoa(order.ID), nil, carol)
+		request("GET", "/orders/"+itoa(order.ID), nil, asAdmin())
+		request("GET", "/users", nil, asAdmin())
+
+		reads := search("?action=" + handlers.AuditDataRead)
+		Expect(reads).To(HaveLen(2))
+		Expect(reads[0].TargetType).To(Equal(handlers.AuditTargetUser))
+		Expect(reads[1].TargetType).To(Equal(handlers.AuditTargetOrder))
+		Expect(reads[1].TargetID).To(Equal(order.ID))
+		Expect(reads[1].ActorID).To(Equal(admin.ID))
+		Expect(reads[1].Metadata).To(HaveKeyWithValue("owner_id", itoa(login.User.ID)))
+
+		Expect(request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "changed mind"}, carol).Code).To(Equal(http.StatusOK))
+		changes := search("?action=" + handlers.AuditOrderStatus + "&target_id=" + itoa(order.ID))
+		Expect(changes).ToNot(BeEmpty())
+		Expect(changes[len(changes)-1].ActorID).To(Equal(login.User.ID))
+		Expect(changes[0].Changes[0].After).To(MatchJSON(`"cancelled"`))
+	})
+
+	It("pages newest first", func() {
+		for i := 0; i < 5; i++ {
+			request("POST", "/users/login", map[string]string{"username": "nobody", "password": "x"}, nil)
+		}
+
+		w := request("GET", "/audit?limit=2", nil, asAdmin())
+		var first []models.AuditEntry
+		json.Unmarshal(w.Body.Bytes(), &first)
+		Expect(first).To(HaveLen(2))
+		Expect(first[0].ID).To(BeNumerically(">", first[1].ID))
+		next := w.Header().Get(handlers.NextCursorHeader)
+		Expect(next).To(Equal(itoa(first[1].ID)))
+
+		w = request("GET", "/audit?limit=10&cursor="+next, nil, asAdmin())
+		var rest []models.AuditEntry
+		json.Unmarshal(w.Body.Bytes(), &rest)
+		Expect(rest).To(HaveLen(3))
+		Expect(rest[0].ID).To(Equal(first[1].ID - 1))
+		Expect(w.Header().Get(handlers.NextCursorHeader)).To(BeEmpty())
+
+		Expect(request("GET", "/audit?cursor=abc", nil, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("verifies the hash chain and detects tampering", func() {
+		request("POST", "/users/login", map[string]string{"username": "admin", "password": "Admin@123"}, nil)
+		request("POST", "/users/login", map[string]string{"username": "nobody", "password": "x"}, nil)
+
+		var result handlers.AuditVerification
+		w := request("GET", "/audit/verify", nil, asAdmin())
+		json.Unmarshal(w.Body.Bytes(), &result)
+		Expect(result.Valid).To(BeTrue())
+		Expect(result.Entries).To(Equal(2))
+
+		database.DB.AuditLog[0].Outcome = models.AuditFailure
+		w = request("GET", "/audit/verify", nil, asAdmin())
+		json.Unmarshal(w.Body.Bytes(), &result)
+		Expect(result.Valid).To(BeFalse())
+		Expect(result.Error).To(ContainSubstring("at entry 1"))
+	})
+
+	It("is admin only", func() {
+		_, other := asCustomer("someone")
+		Expect(request("GET", "/audit", nil, other).Code).To(Equal(http.StatusForbidden))
+		Expect(request("GET", "/users", nil, other).Code).To(Equal(http.StatusForbidden))
+	})
+})
@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CartRequest struct {
+	Name   string `json:"name" binding:"required"`
+	Switch bool   `json:"switch"`
+}
+
+type MoveLineRequest struct {
+	CartID   uint `json:"cart_id"`
+	Quantity int  `json:"quantity" binding:"min=0"`
+}
+
+var (
+	errCartNotFound   = errors.New("cart not found")
+	errCartNotOpen    = errors.New("cart can no longer be changed")
+	errLineNotFound   = errors.New("item not in cart")
+	errSameCart       = errors.New("item is already in that cart")
+	errQuantityTooBig = errors.New("quantity exceeds the quantity in the cart")
+)
+
+// activeCart returns the user's current cart, falling back to their oldest
+// active cart. It returns nil if there is none.
+// Callers must hold database.DB.Mutex.
+func activeCart(userID uint) *models.Cart {
+	if user, exists := database.DB.Users[userID]; exists {
+		if cart, exists := database.DB.Carts[user.CartID]; exists && cart.UserID == userID && cart.Status == models.CartStatusActive {
+			return cart
+		}
+	}
+	carts := userCarts(userID, models.CartStatusActive)
+	if len(carts) == 0 {
+		return nil
+	}
+	return carts[0]
+}
+
+// userCarts returns the user's carts with the given status ordered by ID.
+// Callers must hold database.DB.Mutex.
+func userCarts(userID uint, status string) []*models.Cart {
+	carts := []*models.Cart{}
+	for _, cart := range database.DB.Carts {
+		if cart.UserID == userID && cart.Status == status {
+			carts = append(carts, cart)
+		}
+	}
+	sort.Slice(carts, func(i, j int) bool { return carts[i].ID < carts[j].ID })
+	return carts
+}
+
+// openCart returns one of the user's carts that can still be changed: an
+// active cart or the saved-for-later list. Callers must hold database.DB.Mutex.
+func openCart(userID, cartID uint) (*models.Cart, error) {
+	cart, exists := database.DB.Carts[cartID]
+	if !exists || cart.UserID != userID {
+		return nil, errCartNotFound
+	}
+	if cart.Status != models.CartStatusActive && cart.Status != models.CartStatusSaved {
+		return nil, errCartNotOpen
+	}
+	return cart, nil
+}
+
+// createCart stores a new, empty cart for the user.
+// Callers must hold database.DB.Mutex.
+func createCart(userID uint, name, status string) *models.Cart {
+	now := Clock.Now()
+	cart := &models.Cart{
+		ID:        database.DB.GetNextID(),
+		UserID:    userID,
+		Name:      name,
+		Status:    status,
+		CreatedAt: now,
+		UpdatedAt: now,
+		CartItems: []models.CartItem{},
+	}
+	database.DB.Carts[cart.ID] = cart
+	return cart
+}
+
+// savedList returns the user's saved-for-later list, creating it on first
+// use. Callers must hold database.DB.Mutex.
+func savedList(userID uint) *models.Cart {
+	if carts := userCarts(userID, models.CartStatusSaved); len(carts) > 0 {
+		return carts[0]
+	}
+	return createCart(userID, "Saved for Later", models.CartStatusSaved)
+}
+
+// touchCart records activity on a cart so it is not expired as idle.
+func touchCart(cart *models.Cart) {
+	cart.UpdatedAt = Clock.Now()
+}
+
+// switchCart makes cart the user's current cart.
+// Callers must hold database.DB.Mutex.
+func switchCart(cart *models.Cart) {
+	if user, exists := database.DB.Users[cart.UserID]; exists {
+		user.CartID = cart.ID
+	}
+}
+
+func cartItemKey(cartID, itemID uint) string {
+	return fmt.Sprintf("%d-%d", cartID, itemID)
+}
+
+// cartItemsFor returns the items in a cart ordered by item ID.
+// Callers must hold database.DB.Mutex.
+func cartItemsFor(cartID uint) []models.CartItem {
+	cartItems := []models.CartItem{}
+	for _, cartItem := range database.DB.CartItems {
+		if cartItem.CartID == cartID {
+			cartItems = append(cartItems, *cartItem)
+		}
+	}
+	sort.Slice(cartItems, func(i, j int) bool { return cartItems[i].ItemID < cartItems[j].ItemID })
+	return cartItems
+}
+
+// removeCartLines deletes a cart's lines and releases their stock.
+// Callers must hold database.DB.Mutex.
+func removeCartLines(cartID uint) {
+	for key, cartItem := range database.DB.CartItems {
+		if cartItem.CartID == cartID {
+			delete(database.DB.CartItems, key)
+		}
+	}
+	releaseReservations(cartID)
+}
+
+// moveLine moves quantity units of an item from one cart to another,
+// merging with a line for the same item in the target. A quantity of zero
+// moves the whole line. Callers must hold database.DB.Mutex.
+func moveLine(from, to *models.Cart, itemID uint, quantity int) error {
+	if from.ID == to.ID {
+		return errSameCart
+	}
+	line, exists := database.DB.CartItems[cartItemKey(from.ID, itemID)]
+	if !exists {
+		return errLineNotFound
+	}
+	if line.Quantity == 0 {
+		line.Quantity = 1
+	}
+	if quantity == 0 {
+		quantity = line.Quantity
+	}
+	if quantity > line.Quantity {
+		return errQuantityTooBig
+	}
+
+	if target, exists := database.DB.CartItems[cartItemKey(to.ID, itemID)]; exists {
+		if target.Quantity == 0 {
+			target.Quantity = 1
+		}
+		target.Quantity += quantity
+	} else {
+		database.DB.CartItems[cartItemKey(to.ID, itemID)] = &models.CartItem{
+			CartID:   to.ID,
+			ItemID:   itemID,
+			Quantity: quantity,
+			Cart:     *to,
+			Item:     line.Item,
+		}
+	}
+
+	line.Quantity -= quantity
+	if line.Quantity == 0 {
+		delete(database.DB.CartItems, cartItemKey(from.ID, itemID))
+	}
+
+	for _, cart := range []*models.Cart{from, to} {
+		touchCart(cart)
+		syncReservations(cart)
+	}
+	return nil
+}
+
+func cartErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errCartNotFound), errors.Is(err, errLineNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errCartNotOpen):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// GetMyCarts lists the user's active carts, flagging the current one.
+func GetMyCarts(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	carts := []CartResponse{}
+	for _, cart := range userCarts(userID.(uint), models.CartStatusActive) {
+		response, err := cartResponse(cart)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+			return
+		}
+		carts = append(carts, response)
+	}
+
+	c.JSON(http.StatusOK, carts)
+}
+
+func CreateCart(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart name is required"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart := createCart(userID.(uint), name, models.CartStatusActive)
+	if req.Switch || activeCart(userID.(uint)) == cart {
+		switchCart(cart)
+	}
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+func RenameCart(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var cartID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &cartID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart ID"})
+		return
+	}
+
+	var req CartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart name is required"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart, err := openCart(userID.(uint), cartID)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	cart.Name = name
+	if req.Switch && cart.Status == models.CartStatusActive {
+		switchCart(cart)
+	}
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SwitchCart makes one of the user's active carts the current cart, the one
+// that AddToCart, coupons, shipping and checkout use by default.
+func SwitchCart(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var cartID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &cartID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart, err := openCart(userID.(uint), cartID)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if cart.Status != models.CartStatusActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The saved-for-later list cannot be the current cart"})
+		return
+	}
+	switchCart(cart)
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteCart deletes an active cart and its lines. Deleting the current cart
+// switches to the oldest remaining one, or a new empty cart.
+func DeleteCart(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var cartID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &cartID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart, err := openCart(userID.(uint), cartID)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if cart.Status != models.CartStatusActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The saved-for-later list cannot be deleted"})
+		return
+	}
+
+	current := activeCart(cart.UserID) == cart
+	removeCartLines(cart.ID)
+	delete(database.DB.Carts, cart.ID)
+
+	if current {
+		next := activeCart(cart.UserID)
+		if next == nil {
+			next = createCart(cart.UserID, "Default Cart", models.CartStatusActive)
+		}
+		switchCart(next)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart deleted"})
+}
+
+// MoveCartLine moves an item, or part of its quantity, to another of the
+// user's carts. Without a cart_id it moves to the current cart, which is how
+// saved-for-later items go back into the cart.
+func MoveCartLine(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var cartID, itemID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &cartID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart ID"})
+		return
+	}
+	if _, err := fmt.Sscanf(c.Param("item_id"), "%d", &itemID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return
+	}
+
+	var req MoveLineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	from, err := openCart(userID.(uint), cartID)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	var to *models.Cart
+	if req.CartID != 0 {
+		if to, err = openCart(userID.(uint), req.CartID); err != nil {
+			c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	} else if to = activeCart(userID.(uint)); to == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+
+	if err := moveLine(from, to, itemID, req.Quantity); err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := cartResponse(to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SaveForLater moves a whole line from a cart to the saved-for-later list.
+func SaveForLater(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var cartID, itemID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &cartID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart ID"})
+		return
+	}
+	if _, err := fmt.Sscanf(c.Param("item_id"), "%d", &itemID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	from, err := openCart(userID.(uint), cartID)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if _, exists := database.DB.CartItems[cartItemKey(from.ID, itemID)]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errLineNotFound.Error()})
+		return
+	}
+
+	saved := savedList(userID.(uint))
+	if err := moveLine(from, saved, itemID, 0); err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := cartResponse(saved)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func GetSavedForLater(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// The list is created on first use, so this needs the write lock
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	response, err := cartResponse(savedList(userID.(uint)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
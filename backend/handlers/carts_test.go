@@ -0,0 +1,105 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cart Handlers", func() {
+	BeforeEach(func() {
+		newTestRouter()
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.GET("/carts/user", handlers.GetUserCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/carts/coupons", handlers.ApplyCoupon)
+		auth.DELETE("/carts/coupons/:code", handlers.RemoveCoupon)
+	})
+
+	Describe("Cart totals", func() {
+		It("prices the user's cart", func() {
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 2}, asAdmin()).Code).To(Equal(http.StatusCreated))
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 5}, asAdmin()).Code).To(Equal(http.StatusCreated))
+
+			w := request("GET", "/carts/user", nil, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var cart handlers.CartResponse
+			json.Unmarshal(w.Body.Bytes(), &cart)
+			Expect(cart.CartItems).To(HaveLen(2))
+			Expect(cart.Totals.Lines).To(HaveLen(2))
+			Expect(cart.Totals.Subtotal).To(Equal(int64(2*99999 + 2499)))
+			Expect(cart.Totals.Total).To(Equal(cart.Totals.Subtotal))
+		})
+
+		It("freezes the totals onto the order", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 2}, asAdmin())
+
+			w := request("POST", "/orders", nil, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusCreated))
+
+			var order models.Order
+			json.Unmarshal(w.Body.Bytes(), &order)
+			Expect(order.Totals.Total).To(Equal(int64(69999)))
+
+			database.DB.Items[2].Price = 1
+			Expect(database.DB.Orders[order.ID].Totals.Total).To(Equal(int64(69999)))
+		})
+	})
+
+	Describe("Coupons", func() {
+		BeforeEach(func() {
+			database.DB.Promotions[500] = &models.Promotion{
+				ID: 500, Code: "SAVE10", Name: "10 off", Type: models.PromotionAmountOff,
+				Value: 1000, PerUserLimit: 1, Active: true,
+			}
+			request("POST", "/carts", map[string]interface{}{"item_id": 4}, asAdmin())
+		})
+
+		It("applies and removes a coupon on the cart", func() {
+			w := request("POST", "/carts/coupons", map[string]string{"code": "save10"}, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var cart handlers.CartResponse
+			json.Unmarshal(w.Body.Bytes(), &cart)
+			Expect(cart.CouponCodes).To(ConsistOf("SAVE10"))
+			Expect(cart.Totals.DiscountTotal).To(Equal(int64(1000)))
+
+			Expect(request("POST", "/carts/coupons", map[string]string{"code": "SAVE10"}, asAdmin()).Code).To(Equal(http.StatusConflict))
+
+			w = request("DELETE", "/carts/coupons/save10", nil, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+			json.Unmarshal(w.Body.Bytes(), &cart)
+			Expect(cart.Totals.DiscountTotal).To(BeZero())
+		})
+
+		It("rejects unknown coupons", func() {
+			Expect(request("POST", "/carts/coupons", map[string]string{"code": "NOPE"}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("records the redemption on the order and enforces the per-user limit", func() {
+			request("POST", "/carts/coupons", map[string]string{"code": "SAVE10"}, asAdmin())
+
+			w := request("POST", "/orders", nil, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusCreated))
+
+			var order models.Order
+			json.Unmarshal(w.Body.Bytes(), &order)
+			Expect(order.Redemptions).To(HaveLen(1))
+			Expect(order.Redemptions[0].PromotionID).To(Equal(uint(500)))
+			Expect(order.Redemptions[0].Amount).To(Equal(int64(1000)))
+			Expect(database.DB.Redemptions).To(HaveLen(1))
+
+			request("POST", "/carts", map[string]interface{}{"item_id": 4}, asAdmin())
+			Expect(request("POST", "/carts/coupons", map[string]string{"code": "SAVE10"}, asAdmin()).Code).To(Equal(http.StatusConflict))
+		})
+	})
+})
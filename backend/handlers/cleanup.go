@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source for cart activity and expiry. Tests swap in a
+// fake clock.
+var Clock clock.Clock = clock.Real{}
+
+// CartAbandoned is emitted for every idle cart with items that the sweeper
+// expires. Cart is a copy taken before its lines were removed.
+type CartAbandoned struct {
+	Cart        models.Cart `json:"cart"`
+	Guest       bool        `json:"guest"`
+	AbandonedAt time.Time   `json:"abandoned_at"`
+}
+
+// SweepResult counts what one sweep did.
+type SweepResult struct {
+	Expired   int `json:"expired"`
+	Compacted int `json:"compacted"`
+}
+
+// CartSweeper expires idle carts and folds ordered carts into their orders
+// so the cart maps do not grow without bound.
+//
+// An active cart is idle once it has seen no activity for its TTL. Idle
+// guest carts and idle user carts other than the current one are deleted;
+// the user's current cart is emptied but kept. Either way its stock
+// reservations are released. A zero TTL turns expiry off for that kind of
+// cart. Saved-for-later lists never expire. Guest reservations past
+// GuestHold are dropped as well.
+type CartSweeper struct {
+	GuestTTL time.Duration
+	UserTTL  time.Duration
+
+	mu          sync.Mutex
+	subscribers []func(CartAbandoned)
+}
+
+// Sweeper is the cart cleanup job run by the scheduler.
+var Sweeper = &CartSweeper{
+	GuestTTL: 7 * 24 * time.Hour,
+	UserTTL:  30 * 24 * time.Hour,
+}
+
+// OnAbandoned registers fn to be called for every abandoned cart. Handlers
+// run after the sweep has released the database lock.
+func (s *CartSweeper) OnAbandoned(fn func(CartAbandoned)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Sweep runs one cleanup pass as of now.
+func (s *CartSweeper) Sweep(now time.Time) SweepResult {
+	database.DB.Mutex.Lock()
+	result, abandoned := s.sweep(now)
+	database.DB.Mutex.Unlock()
+
+	s.mu.Lock()
+	subscribers := append([]func(CartAbandoned){}, s.subscribers...)
+	s.mu.Unlock()
+
+	for _, event := range abandoned {
+		for _, fn := range subscribers {
+			fn(event)
+		}
+	}
+	return result
+}
+
+// sweep does the work of Sweep. Callers must hold database.DB.Mutex.
+func (s *CartSweeper) sweep(now time.Time) (SweepResult, []CartAbandoned) {
+	var result SweepResult
+	abandoned := []CartAbandoned{}
+
+	for key, reservation := range database.DB.Reservations {
+		if !held(reservation, now) {
+			delete(database.DB.Reservations, key)
+		}
+	}
+
+	carts := make([]*models.Cart, 0, len(database.DB.Carts))
+	for _, cart := range database.DB.Carts {
+		carts = append(carts, cart)
+	}
+	sort.Slice(carts, func(i, j int) bool { return carts[i].ID < carts[j].ID })
+
+	for _, cart := range carts {
+		switch cart.Status {
+		case models.CartStatusOrdered:
+			// The cart comes back if a pending payment fails
+			if awaitingPayment(cart.ID) {
+				continue
+			}
+			compactOrderedCart(cart)
+			result.Compacted++
+
+		case models.CartStatusActive:
+			guest := cart.UserID == 0
+			ttl := s.UserTTL
+			if guest {
+				ttl = s.GuestTTL
+			}
+			lastActive := cart.UpdatedAt
+			if lastActive.IsZero() {
+				lastActive = cart.CreatedAt
+			}
+			if ttl <= 0 || now.Sub(lastActive) < ttl {
+				continue
+			}
+
+			current := !guest && activeCart(cart.UserID) == cart
+			lines := cartItemsFor(cart.ID)
+			if current && len(lines) == 0 {
+				continue
+			}
+
+			snapshot := *cart
+			snapshot.CartItems = lines
+			removeCartLines(cart.ID)
+			if !current {
+				delete(database.DB.Carts, cart.ID)
+			}
+
+			result.Expired++
+			if len(lines) > 0 {
+				event := CartAbandoned{Cart: snapshot, Guest: guest, AbandonedAt: now}
+				abandoned = append(abandoned, event)
+				recordEvent(events.CartAbandoned, event)
+			}
+		}
+	}
+
+	return result, abandoned
+}
+
+// compactOrderedCart copies an ordered cart's lines onto its order, if the
+// order does not have them yet, and deletes the cart.
+// Callers must hold database.DB.Mutex.
+func compactOrderedCart(cart *models.Cart) {
+	for _, order := range database.DB.Orders {
+		if order.CartID == cart.ID && len(order.Cart.CartItems) == 0 {
+			order.Cart.CartItems = cartItemsFor(cart.ID)
+		}
+	}
+	removeCartLines(cart.ID)
+	delete(database.DB.Carts, cart.ID)
+}
@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"context"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/scheduler"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cart Cleanup", func() {
+	var (
+		admin   *models.User
+		fake    *clock.Fake
+		sweeper *handlers.CartSweeper
+
+		mu        sync.Mutex
+		abandoned []handlers.CartAbandoned
+	)
+
+	guestCartID := func() uint {
+		for _, cart := range database.DB.Carts {
+			if cart.UserID == 0 {
+				return cart.ID
+			}
+		}
+		return 0
+	}
+
+	events := func() []handlers.CartAbandoned {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]handlers.CartAbandoned(nil), abandoned...)
+	}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+
+		admin = newTestRouter()
+		guest := router.Group("/")
+		guest.Use(middleware.GuestMiddleware())
+		guest.POST("/carts", handlers.AddToCart)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.GET("/orders/user", handlers.GetUserOrders)
+
+		database.DB.Carts[admin.CartID].UpdatedAt = fake.Now()
+
+		abandoned = nil
+		sweeper = &handlers.CartSweeper{GuestTTL: 24 * time.Hour, UserTTL: 7 * 24 * time.Hour}
+		sweeper.OnAbandoned(func(event handlers.CartAbandoned) {
+			mu.Lock()
+			abandoned = append(abandoned, event)
+			mu.Unlock()
+		})
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+	})
+
+	Describe("stock reservations", func() {
+		It("holds stock for lines in active carts", func() {
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 20}, asAdmin()).Code).To(Equal(http.StatusCreated))
+			Expect(database.DB.Reservations).To(HaveKey(itoa(admin.CartID) + "-1"))
+
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 6}, nil).Code).To(Equal(http.StatusConflict))
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 5}, nil).Code).To(Equal(http.StatusCreated))
+		})
+
+		It("holds stock for guest carts only briefly", func() {
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 20}, nil).Code).To(Equal(http.StatusCreated))
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 6}, asAdmin()).Code).To(Equal(http.StatusConflict))
+
+			fake.Advance(handlers.GuestHold)
+			Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 6}, asAdmin()).Code).To(Equal(http.StatusCreated))
+
+			sweeper.Sweep(fake.Now())
+			Expect(database.DB.Carts).To(HaveKey(guestCartID()))
+			Expect(database.DB.Reservations).To(HaveLen(1))
+			Expect(database.DB.Reservations).To(HaveKey(itoa(admin.CartID) + "-1"))
+		})
+
+		It("takes stock at checkout and releases the reservation", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 2}, asAdmin())
+
+			Expect(request("POST", "/orders", nil, asAdmin()).Code).To(Equal(http.StatusCreated))
+			Expect(*database.DB.Items[1].Stock).To(Equal(23))
+			Expect(database.DB.Reservations).To(BeEmpty())
+		})
+
+		It("refuses checkout when stock ran out after the item was added", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 2}, asAdmin())
+			one := 1
+			database.DB.Items[1].Stock = &one
+
+			Expect(request("POST", "/orders", nil, asAdmin()).Code).To(Equal(http.StatusConflict))
+			Expect(*database.DB.Items[1].Stock).To(Equal(1))
+		})
+	})
+
+	Describe("sweeping", func() {
+		It("expires idle guest carts and releases their stock", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 2, "quantity": 3}, nil)
+			cartID := guestCartID()
+			Expect(database.DB.Reservations).To(HaveLen(1))
+
+			fake.Advance(23 * time.Hour)
+			Expect(sweeper.Sweep(fake.Now()).Expired).To(BeZero())
+			Expect(database.DB.Carts).To(HaveKey(cartID))
+
+			fake.Advance(time.Hour)
+			Expect(sweeper.Sweep(fake.Now()).Expired).To(Equal(1))
+			Expect(database.DB.Carts).ToNot(HaveKey(cartID))
+			Expect(database.DB.CartItems).To(BeEmpty())
+			Expect(database.DB.Reservations).To(BeEmpty())
+
+			Expect(events()).To(HaveLen(1))
+			Expect(events()[0].Guest).To(BeTrue())
+			Expect(events()[0].Cart.ID).To(Equal(cartID))
+			Expect(events()[0].Cart.CartItems).To(HaveLen(1))
+			Expect(events()[0].AbandonedAt).To(Equal(fake.Now()))
+		})
+
+		It("empties a user's idle current cart but keeps it", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 3}, asAdmin())
+
+			fake.Advance(8 * 24 * time.Hour)
+			sweeper.Sweep(fake.Now())
+
+			Expect(database.DB.Carts).To(HaveKey(admin.CartID))
+			Expect(database.DB.CartItems).To(BeEmpty())
+			Expect(events()).To(HaveLen(1))
+			Expect(events()[0].Guest).To(BeFalse())
+
+			// An empty cart is not abandoned again
+			fake.Advance(8 * 24 * time.Hour)
+			Expect(sweeper.Sweep(fake.Now()).Expired).To(BeZero())
+		})
+
+		It("does not expire carts with recent activity", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 3}, asAdmin())
+			fake.Advance(6 * 24 * time.Hour)
+			request("POST", "/carts", map[string]interface{}{"item_id": 4}, asAdmin())
+			fake.Advance(2 * 24 * time.Hour)
+
+			Expect(sweeper.Sweep(fake.Now()).Expired).To(BeZero())
+			Expect(database.DB.CartItems).To(HaveLen(2))
+		})
+
+		It("leaves carts alone when the TTL is zero", func() {
+			sweeper.GuestTTL = 0
+			request("POST", "/carts", map[string]interface{}{"item_id": 2}, nil)
+			fake.Advance(365 * 24 * time.Hour)
+
+			Expect(sweeper.Sweep(fake.Now()).Expired).To(BeZero())
+			Expect(guestCartID()).ToNot(BeZero())
+		})
+
+		It("folds ordered carts into their orders", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 1}, asAdmin())
+			w := request("POST", "/orders", nil, asAdmin())
+			var order models.Order
+			json.Unmarshal(w.Body.Bytes(), &order)
+			database.DB.Orders[order.ID].Cart.CartItems = nil
+
+			Expect(sweeper.Sweep(fake.Now()).Compacted).To(Equal(1))
+			Expect(database.DB.Carts).ToNot(HaveKey(order.CartID))
+			Expect(database.DB.CartItems).To(BeEmpty())
+			Expect(database.DB.Orders[order.ID].Cart.CartItems).To(HaveLen(1))
+
+			var orders []models.Order
+			json.Unmarshal(request("GET", "/orders/user", nil, asAdmin()).Body.Bytes(), &orders)
+			Expect(orders).To(HaveLen(1))
+			Expect(orders[0].Cart.CartItems).To(HaveLen(1))
+		})
+	})
+
+	It("runs on the scheduler", func() {
+		request("POST", "/carts", map[string]interface{}{"item_id": 2}, nil)
+
+		jobs := scheduler.New(fake)
+		jobs.Every("cart cleanup", time.Hour, func(now time.Time) { sweeper.Sweep(now) })
+		ctx, cancel := context.WithCancel(context.Background())
+		defer func() {
+			cancel()
+			jobs.Wait()
+		}()
+		jobs.Start(ctx)
+
+		for i := 0; i < 24; i++ {
+			Eventually(fake.Waiters).Should(Equal(1))
+			fake.Advance(time.Hour)
+		}
+		Eventually(events).Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Events is the domain event bus. Subscribe to it to hear about changes
+// without touching the handlers.
+var Events = events.NewBus()
+
+// OutboxRetention is how long published events are kept in the outbox.
+const OutboxRetention = 24 * time.Hour
+
+// relayMu makes sure only one relay publishes at a time, so no event is
+// published twice.
+var relayMu sync.Mutex
+
+// OrderStatusChange is the data of an order.status_changed event.
+type OrderStatusChange struct {
+	OrderID        uint         `json:"order_id"`
+	PreviousStatus string       `json:"previous_status"`
+	Status         string       `json:"status"`
+	Event          string       `json:"event"` // the order history event behind the change
+	Order          models.Order `json:"order"`
+}
+
+// UserRegistration is the data of a user.registered event.
+type UserRegistration struct {
+	UserID    uint      `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserDeletion is the data of a user.deleted event. Subscribers holding
+// the user's personal data should erase it.
+type UserDeletion struct {
+	UserID    uint      `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// CartItemAddition is the data of a cart.item_added event.
+type CartItemAddition struct {
+	CartID   uint `json:"cart_id"`
+	UserID   uint `json:"user_id,omitempty"` // zero for guest carts
+	ItemID   uint `json:"item_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// recordEvent stores a domain event in the outbox. Record events in the same
+// locked section as the write they describe, once nothing can fail any
+// more: the event is then published exactly when the write happened. The
+// payload is taken now, so later changes to data are not seen.
+// Callers must hold database.DB.Mutex.
+func recordEvent(eventType events.Type, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Event %s not recorded: %v", eventType, err)
+		return
+	}
+	record := &models.OutboxEvent{
+		ID:         database.DB.GetNextID(),
+		EventID:    "evt_" + uuid.NewString(),
+		Type:       string(eventType),
+		Payload:    payload,
+		OccurredAt: Clock.Now(),
+	}
+	database.DB.Outbox[record.ID] = record
+}
+
+// RelayOutbox publishes every unpublished event on Events, oldest first,
+// and returns how many it published. Subscribers run without the database
+// lock held, so they are free to take it.
+func RelayOutbox() int {
+	relayMu.Lock()
+	defer relayMu.Unlock()
+
+	database.DB.Mutex.RLock()
+	pending := []*models.OutboxEvent{}
+	for _, record := range database.DB.Outbox {
+		if record.PublishedAt == nil {
+			pending = append(pending, record)
+		}
+	}
+	database.DB.Mutex.RUnlock()
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+
+	for _, record := range pending {
+		Events.Publish(events.Event{
+			ID:         record.EventID,
+			Type:       events.Type(record.Type),
+			OccurredAt: record.OccurredAt,
+			Payload:    record.Payload,
+		})
+
+		database.DB.Mutex.Lock()
+		now := Clock.Now()
+		record.PublishedAt = &now
+		database.DB.Mutex.Unlock()
+	}
+	return len(pending)
+}
+
+// PruneOutbox drops events published more than OutboxRetention before now.
+func PruneOutbox(now time.Time) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for id, record := range database.DB.Outbox {
+		if record.PublishedAt != nil && now.Sub(*record.PublishedAt) > OutboxRetention {
+			delete(database.DB.Outbox, id)
+		}
+	}
+}
+
+// OutboxRelay publishes the events recorded by a request once its handler
+// has finished. Events recorded outside requests are left to the scheduled
+// relay.
+func OutboxRelay() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		RelayOutbox()
+	}
+}
@@ -0,0 +1,131 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Domain events", func() {
+	var published []events.Event
+
+	types := func() []events.Type {
+		seen := []events.Type{}
+		for _, e := range published {
+			seen = append(seen, e.Type)
+		}
+		return seen
+	}
+
+	BeforeEach(func() {
+		newTestRouter()
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+		published = nil
+		handlers.Events = events.NewBus()
+		handlers.Events.SubscribeAll(func(e events.Event) {
+			published = append(published, e)
+		})
+
+		router.Use(handlers.OutboxRelay())
+		router.POST("/users", handlers.CreateUser)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/items", handlers.CreateItem)
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/orders/:id/cancel", handlers.CancelOrder)
+	})
+
+	AfterEach(func() {
+		handlers.Events = events.NewBus()
+	})
+
+	It("publishes an event for each write once the request is done", func() {
+		Expect(request("POST", "/users", map[string]string{"username": "newbie", "password": "Secret@123"}, nil).Code).To(Equal(http.StatusCreated))
+		Expect(request("POST", "/items", map[string]interface{}{"name": "Desk", "price": 19999}, asAdmin()).Code).To(Equal(http.StatusCreated))
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin()).Code).To(Equal(http.StatusCreated))
+		w := request("POST", "/orders", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var order models.Order
+		json.Unmarshal(w.Body.Bytes(), &order)
+		request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Changed my mind"}, asAdmin())
+
+		Expect(types()).To(Equal([]events.Type{
+			events.UserRegistered,
+			events.ItemCreated,
+			events.CartItemAdded,
+			events.OrderPlaced,
+			events.OrderStatusChanged,
+			events.OrderStatusChanged,
+		}))
+
+		var registered handlers.UserRegistration
+		Expect(published[0].Decode(&registered)).To(Succeed())
+		Expect(registered.Username).To(Equal("newbie"))
+		Expect(string(published[0].Payload)).ToNot(ContainSubstring("password"))
+
+		var change handlers.OrderStatusChange
+		Expect(published[5].Decode(&change)).To(Succeed())
+		Expect(change.OrderID).To(Equal(order.ID))
+		Expect(change.Status).To(Equal(models.OrderStatusCancelled))
+	})
+
+	It("publishes nothing for writes that did not happen", func() {
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 999, "quantity": 1}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+		Expect(request("POST", "/users", map[string]string{"username": "admin", "password": "Secret@123"}, nil).Code).ToNot(Equal(http.StatusCreated))
+		*database.DB.Items[1].Stock = 0
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin()).Code).To(Equal(http.StatusConflict))
+
+		Expect(published).To(BeEmpty())
+		Expect(database.DB.Outbox).To(BeEmpty())
+	})
+
+	It("publishes each event once, in the order it was recorded", func() {
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin())
+		request("POST", "/carts", map[string]interface{}{"item_id": 2, "quantity": 1}, asAdmin())
+		Expect(handlers.RelayOutbox()).To(Equal(0))
+
+		Expect(published).To(HaveLen(2))
+		Expect(published[0].ID).ToNot(Equal(published[1].ID))
+		var first handlers.CartItemAddition
+		published[0].Decode(&first)
+		Expect(first.ItemID).To(Equal(uint(1)))
+		for _, record := range database.DB.Outbox {
+			Expect(record.PublishedAt).ToNot(BeNil())
+		}
+	})
+
+	It("keeps publishing when a subscriber panics", func() {
+		handlers.Events.Subscribe(events.CartItemAdded, func(events.Event) { panic("indexer down") })
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin())
+		Expect(types()).To(Equal([]events.Type{events.CartItemAdded}))
+	})
+
+	It("leaves events recorded outside a request to the relay job", func() {
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin())
+		Expect(handlers.Sweeper.Sweep(time.Now().Add(handlers.Sweeper.UserTTL + time.Hour)).Expired).To(Equal(1))
+		Expect(types()).To(Equal([]events.Type{events.CartItemAdded}))
+
+		Expect(handlers.RelayOutbox()).To(Equal(1))
+		Expect(types()).To(Equal([]events.Type{events.CartItemAdded, events.CartAbandoned}))
+	})
+
+	It("prunes published events after a while", func() {
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin())
+		Expect(database.DB.Outbox).To(HaveLen(1))
+
+		handlers.PruneOutbox(time.Now())
+		Expect(database.DB.Outbox).To(HaveLen(1))
+		handlers.PruneOutbox(time.Now().Add(handlers.OutboxRetention + time.Minute))
+		Expect(database.DB.Outbox).To(BeEmpty())
+	})
+})
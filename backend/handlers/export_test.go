@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartSession signs a user in as logging in does and returns the token of
+// the new session.
+func StartSession(user *models.User) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/users/login", nil)
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+	_, token, err := startSession(c, user)
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
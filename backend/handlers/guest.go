@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MergeRule decides the quantity of an item that is in both the guest cart
+// and the user's cart.
+type MergeRule string
+
+const (
+	MergeSum   MergeRule = "sum"   // add the two quantities
+	MergeMax   MergeRule = "max"   // keep the larger quantity
+	MergeGuest MergeRule = "guest" // the guest cart's quantity wins
+	MergeUser  MergeRule = "user"  // the user's cart's quantity wins
+)
+
+// Reasons reported for merged lines that could not keep their quantity.
+const (
+	MergeReasonQuantityLimit = "quantity_limit"
+	MergeReasonLimitedStock  = "limited_stock"
+	MergeReasonOutOfStock    = "out_of_stock"
+)
+
+// MergePolicy configures how a guest cart merges into the user's cart when
+// they sign in or register.
+type MergePolicy struct {
+	Quantity     MergeRule
+	MaxQuantity  int  // per line; 0 means no limit
+	ClampToStock bool // lower quantities to the stock available, dropping sold out lines
+}
+
+// GuestCartMerge is the policy used on login and registration.
+var GuestCartMerge = MergePolicy{Quantity: MergeSum, ClampToStock: true}
+
+// MergeConflict reports a line whose merged quantity had to be lowered.
+type MergeConflict struct {
+	ItemID    uint   `json:"item_id"`
+	Requested int    `json:"requested"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+}
+
+// MergeResult describes a guest cart merge.
+type MergeResult struct {
+	CartID    uint            `json:"cart_id"`
+	Merged    int             `json:"merged"`
+	Conflicts []MergeConflict `json:"conflicts"`
+}
+
+func (p MergePolicy) resolve(userQuantity, guestQuantity int) int {
+	switch p.Quantity {
+	case MergeMax:
+		return max(userQuantity, guestQuantity)
+	case MergeGuest:
+		return guestQuantity
+	case MergeUser:
+		return userQuantity
+	default:
+		return userQuantity + guestQuantity
+	}
+}
+
+// limit applies the quantity and stock limits to a merged quantity. Stock
+// reserved by the carts in exclude is available to the merge.
+func (p MergePolicy) limit(item *models.Item, quantity int, exclude ...uint) (int, string) {
+	reason := ""
+	if p.MaxQuantity > 0 && quantity > p.MaxQuantity {
+		quantity, reason = p.MaxQuantity, MergeReasonQuantityLimit
+	}
+	if p.ClampToStock {
+		if available, tracked := availableStock(item, exclude...); tracked && quantity > available {
+			quantity, reason = available, MergeReasonLimitedStock
+			if available == 0 {
+				reason = MergeReasonOutOfStock
+			}
+		}
+	}
+	return quantity, reason
+}
+
+// guestCart returns the active guest cart with the given ID.
+// Callers must hold database.DB.Mutex.
+func guestCart(cartID uint) *models.Cart {
+	cart, exists := database.DB.Carts[cartID]
+	if !exists || cart.UserID != 0 || cart.Status != models.CartStatusActive {
+		return nil
+	}
+	return cart
+}
+
+// requestCart returns the cart a request works on. Signed-in users get the
+// cart given by cartID or their current cart; guests get the cart from
+// their cart token. With create set, a guest without a cart gets a new one
+// and a token for it. Callers must hold database.DB.Mutex.
+func requestCart(c *gin.Context, cartID uint, create bool) (*models.Cart, error) {
+	if userID, exists := c.Get("user_id"); exists {
+		if cartID != 0 {
+			return openCart(userID.(uint), cartID)
+		}
+		if cart := activeCart(userID.(uint)); cart != nil {
+			return cart, nil
+		}
+		return nil, errCartNotFound
+	}
+
+	if guestID, exists := c.Get("guest_cart_id"); exists {
+		if cart := guestCart(guestID.(uint)); cart != nil && (cartID == 0 || cartID == cart.ID) {
+			return cart, nil
+		}
+	}
+	if !create || cartID != 0 {
+		return nil, errCartNotFound
+	}
+
+	cart := createCart(0, "Guest Cart", models.CartStatusActive)
+	token, err := utils.GenerateCartToken(cart.ID)
+	if err != nil {
+		delete(database.DB.Carts, cart.ID)
+		return nil, err
+	}
+	c.Header(utils.CartTokenHeader, token)
+	c.SetCookie(utils.CartTokenCookie, token, int(utils.CartTokenTTL.Seconds()), "/", "", false, true)
+	return cart, nil
+}
+
+// mergeGuestCart moves the request's guest cart into the user's current
+// cart following GuestCartMerge, then deletes the guest cart. It returns nil
+// when the request has no guest cart. Callers must hold database.DB.Mutex.
+func mergeGuestCart(c *gin.Context, userID uint) *MergeResult {
+	guestID, exists := c.Get("guest_cart_id")
+	if !exists {
+		return nil
+	}
+	guest := guestCart(guestID.(uint))
+	if guest == nil {
+		return nil
+	}
+
+	target := activeCart(userID)
+	if target == nil {
+		target = createCart(userID, "Default Cart", models.CartStatusActive)
+		switchCart(target)
+	}
+
+	result := &MergeResult{CartID: target.ID, Conflicts: []MergeConflict{}}
+	for _, line := range cartItemsFor(guest.ID) {
+		item, exists := database.DB.Items[line.ItemID]
+		if !exists {
+			continue
+		}
+
+		key := cartItemKey(target.ID, line.ItemID)
+		existing := database.DB.CartItems[key]
+		requested := max(line.Quantity, 1)
+		if existing != nil {
+			requested = GuestCartMerge.resolve(max(existing.Quantity, 1), requested)
+		}
+
+		quantity, reason := GuestCartMerge.limit(item, requested, guest.ID, target.ID)
+		if reason != "" {
+			result.Conflicts = append(result.Conflicts, MergeConflict{
+				ItemID:    line.ItemID,
+				Requested: requested,
+				Quantity:  quantity,
+				Reason:    reason,
+			})
+		}
+
+		switch {
+		case quantity == 0:
+			delete(database.DB.CartItems, key)
+			continue
+		case existing != nil:
+			existing.Quantity = quantity
+		default:
+			database.DB.CartItems[key] = &models.CartItem{
+				CartID:   target.ID,
+				ItemID:   line.ItemID,
+				Quantity: quantity,
+				Cart:     *target,
+				Item:     *item,
+			}
+		}
+		result.Merged++
+	}
+
+	for _, code := range guest.CouponCodes {
+		applied := false
+		for _, existing := range target.CouponCodes {
+			applied = applied || existing == code
+		}
+		if !applied {
+			target.CouponCodes = append(target.CouponCodes, code)
+		}
+	}
+
+	removeCartLines(guest.ID)
+	delete(database.DB.Carts, guest.ID)
+	touchCart(target)
+	syncReservations(target)
+	c.SetCookie(utils.CartTokenCookie, "", -1, "/", "", false, true)
+
+	return result
+}
@@ -0,0 +1,177 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Guest Cart Handlers", func() {
+	var (
+		cartToken string
+		admin     *models.User
+	)
+
+	asGuest := func() map[string]string {
+		return map[string]string{utils.CartTokenHeader: cartToken}
+	}
+
+	addAsGuest := func(itemID uint, quantity int) {
+		w := request("POST", "/carts", map[string]interface{}{"item_id": itemID, "quantity": quantity}, asGuest())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		if token := w.Header().Get(utils.CartTokenHeader); token != "" {
+			cartToken = token
+		}
+	}
+
+	login := func() handlers.LoginResponse {
+		w := request("POST", "/users/login", map[string]string{"username": "admin", "password": "Admin@123"}, asGuest())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var response handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	adminLine := func(itemID uint) *models.CartItem {
+		return database.DB.CartItems[itoa(admin.CartID)+"-"+itoa(itemID)]
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		guest := router.Group("/")
+		guest.Use(middleware.GuestMiddleware())
+		guest.POST("/users", handlers.CreateUser)
+		guest.POST("/users/login", handlers.LoginUser)
+		guest.POST("/carts", handlers.AddToCart)
+		guest.GET("/carts/user", handlers.GetUserCart)
+		cartToken = ""
+	})
+
+	AfterEach(func() {
+		handlers.GuestCartMerge = handlers.MergePolicy{Quantity: handlers.MergeSum, ClampToStock: true}
+	})
+
+	It("gives guests a signed cart they can keep adding to", func() {
+		Expect(request("GET", "/carts/user", nil, nil).Code).To(Equal(http.StatusNotFound))
+
+		w := request("POST", "/carts", map[string]interface{}{"item_id": 1}, nil)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		cartToken = w.Header().Get(utils.CartTokenHeader)
+		Expect(cartToken).ToNot(BeEmpty())
+		Expect(w.Header().Get("Set-Cookie")).To(ContainSubstring(utils.CartTokenCookie + "="))
+
+		addAsGuest(5, 2)
+
+		w = request("GET", "/carts/user", nil, asGuest())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var cart handlers.CartResponse
+		json.Unmarshal(w.Body.Bytes(), &cart)
+		Expect(cart.UserID).To(BeZero())
+		Expect(cart.CartItems).To(HaveLen(2))
+		Expect(cart.Totals.Subtotal).To(Equal(int64(99999 + 2*2499)))
+	})
+
+	It("reads the cart token from the cookie", func() {
+		addAsGuest(1, 1)
+
+		w := request("GET", "/carts/user", nil, map[string]string{"Cookie": utils.CartTokenCookie + "=" + cartToken})
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("does not accept a cart token as a bearer token", func() {
+		addAsGuest(1, 1)
+		Expect(request("GET", "/carts/user", nil, map[string]string{"Authorization": "Bearer " + cartToken}).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("keeps signed-in users on their own cart", func() {
+		addAsGuest(1, 1)
+		w := request("POST", "/carts", map[string]interface{}{"item_id": 2}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(adminLine(2)).ToNot(BeNil())
+	})
+
+	It("refuses quantities beyond the stock on hand", func() {
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 26}, nil).Code).To(Equal(http.StatusConflict))
+	})
+
+	Describe("merging on login", func() {
+		BeforeEach(func() {
+			database.DB.CartItems[itoa(admin.CartID)+"-5"] = &models.CartItem{CartID: admin.CartID, ItemID: 5, Quantity: 1}
+			addAsGuest(5, 2)
+			addAsGuest(1, 1)
+		})
+
+		It("adds the guest lines to the user's cart and removes the guest cart", func() {
+			guestCarts := 0
+			for _, cart := range database.DB.Carts {
+				if cart.UserID == 0 {
+					guestCarts++
+				}
+			}
+			Expect(guestCarts).To(Equal(1))
+
+			response := login()
+			Expect(response.MergedCart).ToNot(BeNil())
+			Expect(response.MergedCart.CartID).To(Equal(admin.CartID))
+			Expect(response.MergedCart.Merged).To(Equal(2))
+			Expect(response.MergedCart.Conflicts).To(BeEmpty())
+
+			Expect(adminLine(5).Quantity).To(Equal(3))
+			Expect(adminLine(1).Quantity).To(Equal(1))
+			for _, cart := range database.DB.Carts {
+				Expect(cart.UserID).ToNot(BeZero())
+			}
+
+			// The token is spent; logging in again merges nothing
+			Expect(login().MergedCart).To(BeNil())
+		})
+
+		It("follows the configured quantity rule", func() {
+			handlers.GuestCartMerge.Quantity = handlers.MergeMax
+			login()
+			Expect(adminLine(5).Quantity).To(Equal(2))
+		})
+
+		It("lowers quantities to the stock available", func() {
+			one, none := 1, 0
+			database.DB.Items[5].Stock = &one
+			database.DB.Items[1].Stock = &none
+
+			response := login()
+			Expect(response.MergedCart.Conflicts).To(ConsistOf(
+				handlers.MergeConflict{ItemID: 1, Requested: 1, Quantity: 0, Reason: handlers.MergeReasonOutOfStock},
+				handlers.MergeConflict{ItemID: 5, Requested: 3, Quantity: 1, Reason: handlers.MergeReasonLimitedStock},
+			))
+			Expect(adminLine(5).Quantity).To(Equal(1))
+			Expect(adminLine(1)).To(BeNil())
+		})
+
+		It("caps quantities per line when configured", func() {
+			handlers.GuestCartMerge.MaxQuantity = 2
+			response := login()
+			Expect(response.MergedCart.Conflicts).To(ConsistOf(
+				handlers.MergeConflict{ItemID: 5, Requested: 3, Quantity: 2, Reason: handlers.MergeReasonQuantityLimit},
+			))
+		})
+	})
+
+	It("hands the guest cart to a newly registered user", func() {
+		addAsGuest(3, 2)
+
+		w := request("POST", "/users", map[string]string{"username": "shopper", "password": "secret"}, asGuest())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var user models.User
+		json.Unmarshal(w.Body.Bytes(), &user)
+
+		line := database.DB.CartItems[itoa(user.CartID)+"-3"]
+		Expect(line).ToNot(BeNil())
+		Expect(line.Quantity).To(Equal(2))
+	})
+})
@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"ecommerce-backend/database"
+	"ecommerce-backend/events"
 	"ecommerce-backend/models"
-	"ecommerce-backend/utils"
+	"ecommerce-backend/payments"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 	"golang.org/x/crypto/bcrypt"
 	"github.com/gin-gonic/gin"
@@ -14,11 +18,20 @@ import (
 type UserRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email"` // registration only
 }
 
+// LoginResponse answers a login. When a second factor is needed it holds
+// only the MFA fields, and the login is finished with the MFA token.
 type LoginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token      string       `json:"token,omitempty"`
+	User       *models.User `json:"user,omitempty"`
+	MergedCart *MergeResult `json:"merged_cart,omitempty"`
+
+	MFARequired           bool     `json:"mfa_required,omitempty"`
+	MFAToken              string   `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool     `json:"mfa_enrollment_required,omitempty"` // enroll with the MFA token first
+	RecoveryCodes         []string `json:"recovery_codes,omitempty"`          // when enrollment finished with this login
 }
 
 func CreateUser(c *gin.Context) {
@@ -38,6 +51,11 @@ func CreateUser(c *gin.Context) {
 			return
 		}
 	}
+	email := strings.TrimSpace(req.Email)
+	if email != "" && userByEmail(email) != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -46,11 +64,33 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	user := registerUser(c, req.Username, email, string(hashedPassword), nil)
+	if user.Email != "" {
+		// Should this fail the address just stays unverified; the user
+		// can ask for another link
+		sendVerification(user)
+	}
+
+	// Keep what the user put in their cart before registering
+	mergeGuestCart(c, user.ID)
+
+	// Remove password from response
+	responseUser := *user
+	responseUser.Password = ""
+	c.JSON(http.StatusCreated, responseUser)
+}
+
+// registerUser creates a customer and their cart, and records the
+// registration. metadata is added to the audit entry.
+// Callers must hold database.DB.Mutex.
+func registerUser(c *gin.Context, username, email, passwordHash string, metadata map[string]string) *models.User {
 	userID := database.DB.GetNextID()
 	user := &models.User{
 		ID:        userID,
-		Username:  req.Username,
-		Password:  string(hashedPassword),
+		Username:  username,
+		Email:     email,
+		Password:  passwordHash,
+		Role:      models.RoleCustomer,
 		CreatedAt: time.Now(),
 	}
 
@@ -60,7 +100,7 @@ func CreateUser(c *gin.Context) {
 		ID:        cartID,
 		UserID:    userID,
 		Name:      "Default Cart",
-		Status:    "active",
+		Status:    models.CartStatusActive,
 		CreatedAt: time.Now(),
 		CartItems: []models.CartItem{},
 	}
@@ -70,21 +110,50 @@ func CreateUser(c *gin.Context) {
 	user.CartID = cartID
 	database.DB.Users[userID] = user
 
-	// Remove password from response
-	responseUser := *user
-	responseUser.Password = ""
-	c.JSON(http.StatusCreated, responseUser)
+	auditMetadata := map[string]string{"username": user.Username, "role": user.Role}
+	for k, v := range metadata {
+		auditMetadata[k] = v
+	}
+	recordEvent(events.UserRegistered, UserRegistration{UserID: user.ID, Username: user.Username, Role: user.Role, CreatedAt: user.CreatedAt})
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserRegistered,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   auditMetadata,
+	})
+	return user
 }
 
+// LoginUser signs a user in. Failed logins are throttled per account and
+// per client address, and an unknown username is checked against a dummy
+// hash so it takes as long to reject as a wrong password.
 func LoginUser(c *gin.Context) {
 	var req UserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	ip := c.ClientIP()
 
-	database.DB.Mutex.RLock()
-	defer database.DB.Mutex.RUnlock()
+	database.DB.Mutex.Lock()
+	now := Clock.Now()
+	if wait, locked := loginWait(req.Username, ip, now); wait > 0 {
+		reason := "throttled"
+		if locked {
+			reason = "locked out"
+		}
+		recordAudit(c, models.AuditEntry{
+			Action:   AuditUserLogin,
+			Outcome:  models.AuditFailure,
+			Metadata: map[string]string{"username": req.Username, "reason": reason},
+		})
+		database.DB.Mutex.Unlock()
+		c.Header("Retry-After", retryAfter(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+		return
+	}
+	lockedOut := countLoginAttempt(req.Username, ip, now)
 
 	var user *models.User
 	for _, u := range database.DB.Users {
@@ -93,36 +162,87 @@ func LoginUser(c *gin.Context) {
 			break
 		}
 	}
+	// Users who only sign in with an identity provider have no password
+	hash := dummyPasswordHash()
+	if user != nil && user.Password != "" {
+		hash = []byte(user.Password)
+	}
+	database.DB.Mutex.Unlock()
+
+	// Check password without holding the database lock
+	err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password))
 
-	if user == nil {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if user == nil || user.Password == "" || err != nil {
+		entry := models.AuditEntry{
+			Action:   AuditUserLogin,
+			Outcome:  models.AuditFailure,
+			Metadata: map[string]string{"username": req.Username, "reason": "unknown user"},
+		}
+		if user != nil {
+			entry.TargetType = AuditTargetUser
+			entry.TargetID = user.ID
+			entry.Metadata["reason"] = "wrong password"
+		}
+		recordAudit(c, entry)
+		if lockedOut {
+			recordAudit(c, models.AuditEntry{
+				Action:     AuditUserLockedOut,
+				TargetType: entry.TargetType,
+				TargetID:   entry.TargetID,
+				Metadata:   map[string]string{"username": req.Username},
+			})
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+	// With two-factor login the password only earns a challenge token. The
+	// attempt stays counted until the second step succeeds, so guessing
+	// codes cannot be reset by logging in again.
+	if challengeMFA(c, user) {
 		return
 	}
+	clearLoginAttempt(req.Username, ip)
 
-	// Generate token
-	token, err := utils.GenerateToken(user.ID, user.Username)
+	completeLogin(c, user, LoginResponse{}, nil)
+}
+
+// completeLogin signs a user in once every factor has checked out: it
+// starts a session for the device with its own token and moves their guest
+// cart, if any, into their cart.
+// Callers must hold database.DB.Mutex.
+func completeLogin(c *gin.Context, user *models.User, response LoginResponse, metadata map[string]string) {
+	session, token, err := startSession(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	// Update user token
-	user.Token = token
+	auditMetadata := map[string]string{"session_id": fmt.Sprintf("%d", session.ID)}
+	for k, v := range metadata {
+		auditMetadata[k] = v
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserLogin,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   auditMetadata,
+	})
+
+	// Move the guest cart, if any, into the user's cart
+	response.MergedCart = mergeGuestCart(c, user.ID)
 
 	// Remove password from response
 	responseUser := *user
 	responseUser.Password = ""
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  responseUser,
-	})
+	response.Token = token
+	response.User = &responseUser
+	c.JSON(http.StatusOK, response)
 }
 
 func GetUsers(c *gin.Context) {
@@ -136,9 +256,60 @@ func GetUsers(c *gin.Context) {
 		users = append(users, responseUser)
 	}
 
+	auditRead(c, AuditTargetUser, 0, 0, nil)
 	c.JSON(http.StatusOK, users)
 }
 
+type RoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=customer admin"`
+}
+
+// SetUserRole makes a user an admin or a customer. Admins cannot change
+// their own role, so the last admin cannot demote themselves by mistake.
+func SetUserRole(c *gin.Context) {
+	adminID, _ := c.Get("user_id")
+
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if userID == adminID.(uint) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot change your own role"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, exists := database.DB.Users[userID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Role != req.Role {
+		before := *user
+		user.Role = req.Role
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserRoleChanged,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Changes:    auditChanges(before, *user),
+		})
+	}
+
+	responseUser := *user
+	responseUser.Password = ""
+	c.JSON(http.StatusOK, responseUser)
+}
+
 func GetItems(c *gin.Context) {
 	database.DB.Mutex.RLock()
 	defer database.DB.Mutex.RUnlock()
@@ -154,8 +325,12 @@ func GetItems(c *gin.Context) {
 }
 
 type ItemRequest struct {
-	Name   string `json:"name" binding:"required"`
-	Status string `json:"status"`
+	Name     string `json:"name" binding:"required"`
+	Category string `json:"category"`
+	TaxClass string `json:"tax_class"`
+	Status   string `json:"status"`
+	Price    int64  `json:"price" binding:"min=0"`
+	Stock    *int   `json:"stock" binding:"omitempty,min=0"`
 }
 
 func CreateItem(c *gin.Context) {
@@ -168,6 +343,9 @@ func CreateItem(c *gin.Context) {
 	if req.Status == "" {
 		req.Status = "active"
 	}
+	if req.TaxClass == "" {
+		req.TaxClass = models.TaxClassStandard
+	}
 
 	database.DB.Mutex.Lock()
 	defer database.DB.Mutex.Unlock()
@@ -176,26 +354,34 @@ func CreateItem(c *gin.Context) {
 	item := &models.Item{
 		ID:        itemID,
 		Name:      req.Name,
+		Category:  req.Category,
+		TaxClass:  req.TaxClass,
 		Status:    req.Status,
+		Price:     req.Price,
+		Stock:     req.Stock,
 		CreatedAt: time.Now(),
 	}
 
 	database.DB.Items[itemID] = item
+	recordEvent(events.ItemCreated, *item)
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditItemCreated,
+		TargetType: AuditTargetItem,
+		TargetID:   item.ID,
+		Changes:    auditChanges(nil, *item),
+	})
 	c.JSON(http.StatusCreated, *item)
 }
 
-type AddToCartRequest struct {
-	ItemID uint `json:"item_id" binding:"required"`
-}
-
-func AddToCart(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+// UpdateItem replaces an item's details.
+func UpdateItem(c *gin.Context) {
+	var itemID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &itemID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
 		return
 	}
 
-	var req AddToCartRequest
+	var req ItemRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -204,19 +390,87 @@ func AddToCart(c *gin.Context) {
 	database.DB.Mutex.Lock()
 	defer database.DB.Mutex.Unlock()
 
-	// Get user's cart
-	var cart *models.Cart
-	for _, c := range database.DB.Carts {
-		if c.UserID == userID.(uint) && c.Status == "active" {
-			cart = c
-			break
-		}
+	item, exists := database.DB.Items[itemID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
 	}
 
-	if cart == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+	before := *item
+	item.Name = req.Name
+	item.Category = req.Category
+	if req.TaxClass != "" {
+		item.TaxClass = req.TaxClass
+	}
+	if req.Status != "" {
+		item.Status = req.Status
+	}
+	item.Price = req.Price
+	item.Stock = req.Stock
+
+	recordEvent(events.ItemUpdated, *item)
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditItemUpdated,
+		TargetType: AuditTargetItem,
+		TargetID:   item.ID,
+		Changes:    auditChanges(before, *item),
+	})
+	c.JSON(http.StatusOK, *item)
+}
+
+// DeleteItem takes an item off sale. It is kept, inactive, because orders
+// and carts still refer to it.
+func DeleteItem(c *gin.Context) {
+	var itemID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &itemID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	item, exists := database.DB.Items[itemID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+
+	if item.Status != "inactive" {
+		before := *item
+		item.Status = "inactive"
+		recordEvent(events.ItemUpdated, *item)
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditItemDeleted,
+			TargetType: AuditTargetItem,
+			TargetID:   item.ID,
+			Changes:    auditChanges(before, *item),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item deleted"})
+}
+
+type AddToCartRequest struct {
+	ItemID   uint `json:"item_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"min=0"`
+	CartID   uint `json:"cart_id"` // defaults to the current cart
+}
+
+// AddToCart adds an item to the user's cart. Guests get a cart of their own
+// on their first add, identified by the cart token sent back with it.
+func AddToCart(c *gin.Context) {
+	var req AddToCartRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
 
 	// Check if item exists
 	item, exists := database.DB.Items[req.ItemID]
@@ -224,23 +478,38 @@ func AddToCart(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
 		return
 	}
+	if available, tracked := availableStock(item); tracked && req.Quantity > available {
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock"})
+		return
+	}
+
+	// Get the requested cart, or the user's current one
+	cart, err := requestCart(c, req.CartID, true)
+	if err != nil {
+		c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
 
 	// Check if item already in cart
-	cartItemKey := fmt.Sprintf("%d-%d", cart.ID, req.ItemID)
-	if _, exists := database.DB.CartItems[cartItemKey]; exists {
+	key := cartItemKey(cart.ID, req.ItemID)
+	if _, exists := database.DB.CartItems[key]; exists {
 		c.JSON(http.StatusConflict, gin.H{"error": "Item already in cart"})
 		return
 	}
 
 	// Add item to cart
 	cartItem := &models.CartItem{
-		CartID: cart.ID,
-		ItemID: req.ItemID,
-		Cart:   *cart,
-		Item:   *item,
+		CartID:   cart.ID,
+		ItemID:   req.ItemID,
+		Quantity: req.Quantity,
+		Cart:     *cart,
+		Item:     *item,
 	}
 
-	database.DB.CartItems[cartItemKey] = cartItem
+	database.DB.CartItems[key] = cartItem
+	touchCart(cart)
+	syncReservations(cart)
+	recordEvent(events.CartItemAdded, CartItemAddition{CartID: cart.ID, UserID: cart.UserID, ItemID: req.ItemID, Quantity: req.Quantity})
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Item added to cart successfully"})
 }
@@ -249,59 +518,38 @@ func GetCarts(c *gin.Context) {
 	database.DB.Mutex.RLock()
 	defer database.DB.Mutex.RUnlock()
 
-	var carts []models.Cart
+	var carts []CartResponse
 	for _, cart := range database.DB.Carts {
-		cartWithItems := *cart
-		cartWithItems.CartItems = []models.CartItem{}
-		
-		// Get cart items
-		for _, cartItem := range database.DB.CartItems {
-			if cartItem.CartID == cart.ID {
-				cartWithItems.CartItems = append(cartWithItems.CartItems, *cartItem)
-			}
+		response, err := cartResponse(cart)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+			return
 		}
-		
-		carts = append(carts, cartWithItems)
+		carts = append(carts, response)
 	}
 
+	auditRead(c, AuditTargetCart, 0, 0, nil)
 	c.JSON(http.StatusOK, carts)
 }
 
 func GetUserCart(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-
 	database.DB.Mutex.RLock()
 	defer database.DB.Mutex.RUnlock()
 
-	// Get user's cart
-	var cart *models.Cart
-	for _, c := range database.DB.Carts {
-		if c.UserID == userID.(uint) && c.Status == "active" {
-			cart = c
-			break
-		}
-	}
-
-	if cart == nil {
+	// Get user's current cart, or the guest's cart
+	cart, err := requestCart(c, 0, false)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
 		return
 	}
 
-	cartWithItems := *cart
-	cartWithItems.CartItems = []models.CartItem{}
-	
-	// Get cart items
-	for _, cartItem := range database.DB.CartItems {
-		if cartItem.CartID == cart.ID {
-			cartWithItems.CartItems = append(cartWithItems.CartItems, *cartItem)
-		}
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
 	}
 
-	c.JSON(http.StatusOK, cartWithItems)
+	c.JSON(http.StatusOK, response)
 }
 
 func GetCartByID(c *gin.Context) {
@@ -321,17 +569,22 @@ func GetCartByID(c *gin.Context) {
 		return
 	}
 
-	cartWithItems := *cart
-	cartWithItems.CartItems = []models.CartItem{}
-	
-	// Get cart items
-	for _, cartItem := range database.DB.CartItems {
-		if cartItem.CartID == cartID {
-			cartWithItems.CartItems = append(cartWithItems.CartItems, *cartItem)
-		}
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
 	}
 
-	c.JSON(http.StatusOK, cartWithItems)
+	auditRead(c, AuditTargetCart, cart.ID, cart.UserID, nil)
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateOrderRequest picks the cart to check out, its checkout selections
+// and the payment method token. Every field is optional.
+type CreateOrderRequest struct {
+	CartID        uint   `json:"cart_id"`
+	PaymentMethod string `json:"payment_method"`
+	ShippingSelectionRequest
 }
 
 func CreateOrder(c *gin.Context) {
@@ -341,15 +594,29 @@ func CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// The body is optional; without it the current cart is checked out with
+	// its saved checkout selections
+	var req CreateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The lock is let go while the payment provider is called below
 	database.DB.Mutex.Lock()
 	defer database.DB.Mutex.Unlock()
 
-	// Get user's active cart
-	var cart *models.Cart
-	for _, c := range database.DB.Carts {
-		if c.UserID == userID.(uint) && c.Status == "active" {
-			cart = c
-			break
+	// Get the requested cart, or the user's current one
+	cart := activeCart(userID.(uint))
+	if req.CartID != 0 {
+		var err error
+		if cart, err = openCart(userID.(uint), req.CartID); err != nil {
+			c.JSON(cartErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if cart.Status != models.CartStatusActive {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Saved-for-later items must be moved to a cart before checkout"})
+			return
 		}
 	}
 
@@ -359,16 +626,34 @@ func CreateOrder(c *gin.Context) {
 	}
 
 	// Check if cart has items
-	hasItems := false
-	for _, cartItem := range database.DB.CartItems {
-		if cartItem.CartID == cart.ID {
-			hasItems = true
-			break
-		}
+	cartItems := cartItemsFor(cart.ID)
+	if len(cartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
 	}
 
-	if !hasItems {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	// Coupons may have expired or run out since they were applied
+	if err := checkCoupons(cart); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Destination and shipping method
+	if err := applyShippingSelection(cart, req.ShippingSelectionRequest); err != nil {
+		c.JSON(shippingSelectionStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// Freeze the totals the customer saw at checkout
+	totals, err := Pricer.Price(pricingInput(cart, cartItems))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Take the items out of stock
+	if err := commitStock(cart, cartItems); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -380,93 +665,78 @@ func CreateOrder(c *gin.Context) {
 		UserID:    cart.UserID,
 		CreatedAt: time.Now(),
 		Cart:      *cart,
+		Totals:    totals,
+		Status:    models.OrderStatusConfirmed,
 	}
-
-	database.DB.Orders[orderID] = order
-
-	// Mark cart as ordered and create a new cart for the user
-	cart.Status = "ordered"
-
-	// Create new cart for user
-	newCartID := database.DB.GetNextID()
-	newCart := &models.Cart{
-		ID:        newCartID,
-		UserID:    cart.UserID,
-		Name:      "Default Cart",
-		Status:    "active",
-		CreatedAt: time.Now(),
-		CartItems: []models.CartItem{},
+	order.Cart.CartItems = cartItems
+	if address := shippingAddressFor(cart); address != nil {
+		shippingAddress := *address
+		order.ShippingAddress = &shippingAddress
+		order.ShippingMethod = cart.ShippingMethod
 	}
-	database.DB.Carts[newCartID] = newCart
-
-	// Update user's cart ID
-	if user, exists := database.DB.Users[cart.UserID]; exists {
-		user.CartID = newCartID
+	if address := billingAddressFor(cart); address != nil {
+		billingAddress := *address
+		order.BillingAddress = &billingAddress
 	}
 
-	c.JSON(http.StatusCreated, *order)
-}
+	// Checking out the current cart gives the user a new empty one once the
+	// order is placed; other carts are left alone
+	if activeCart(cart.UserID) == cart {
+		switchCart(cart)
+	}
 
-func GetOrders(c *gin.Context) {
-	database.DB.Mutex.RLock()
-	defer database.DB.Mutex.RUnlock()
+	// Hold the stock, coupon uses and cart while the customer is charged, so
+	// the lock is not kept while the provider answers
+	recordRedemptions(order)
+	cart.Status = models.CartStatusCheckout
+	database.DB.Mutex.Unlock()
 
-	var orders []models.Order
-	for _, order := range database.DB.Orders {
-		orderWithCart := *order
-		
-		// Get cart with items
-		if cart, exists := database.DB.Carts[order.CartID]; exists {
-			cartWithItems := *cart
-			cartWithItems.CartItems = []models.CartItem{}
-			
-			for _, cartItem := range database.DB.CartItems {
-				if cartItem.CartID == cart.ID {
-					cartWithItems.CartItems = append(cartWithItems.CartItems, *cartItem)
-				}
-			}
-			
-			orderWithCart.Cart = cartWithItems
-		}
-		
-		orders = append(orders, orderWithCart)
+	var payment *models.Payment
+	var chargeErr error
+	if totals.Total > 0 {
+		payment, chargeErr = chargeOrder(c.Request.Context(), order, req.PaymentMethod)
 	}
 
-	c.JSON(http.StatusOK, orders)
-}
+	database.DB.Mutex.Lock()
 
-func GetUserOrders(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	// A failed charge puts the stock back and leaves the cart as it was
+	if chargeErr != nil {
+		cart.Status = models.CartStatusActive
+		restoreStock(cartItems)
+		releaseRedemptions(order)
+		syncReservations(cart)
+		if errors.Is(chargeErr, payments.ErrDeclined) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": chargeErr.Error(), "decline_code": payment.FailureCode})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment could not be processed: " + chargeErr.Error()})
 		return
 	}
+	if payment != nil {
+		database.DB.Payments[payment.ID] = payment
+		order.Payment = payment
+		if payment.Status == string(payments.StatusRequiresAction) {
+			order.Status = models.OrderStatusPendingPayment
+		}
+	}
+	updateBalance(order)
+	addOrderHistory(order, "placed", "", 0, userID.(uint))
 
-	database.DB.Mutex.RLock()
-	defer database.DB.Mutex.RUnlock()
+	database.DB.Orders[orderID] = order
+	if order.Status == models.OrderStatusConfirmed {
+		issueInvoice(order)
+	}
+	recordEvent(events.OrderPlaced, *order)
 
-	var orders []models.Order
-	for _, order := range database.DB.Orders {
-		if order.UserID == userID.(uint) {
-			orderWithCart := *order
-			
-			// Get cart with items
-			if cart, exists := database.DB.Carts[order.CartID]; exists {
-				cartWithItems := *cart
-				cartWithItems.CartItems = []models.CartItem{}
-				
-				for _, cartItem := range database.DB.CartItems {
-					if cartItem.CartID == cart.ID {
-						cartWithItems.CartItems = append(cartWithItems.CartItems, *cartItem)
-					}
-				}
-				
-				orderWithCart.Cart = cartWithItems
-			}
-			
-			orders = append(orders, orderWithCart)
-		}
+	cart.Status = models.CartStatusOrdered
+	if user, exists := database.DB.Users[cart.UserID]; exists && user.CartID == cart.ID {
+		switchCart(createCart(cart.UserID, "Default Cart", models.CartStatusActive))
 	}
 
-	c.JSON(http.StatusOK, orders)
+	// An order waiting on the customer is accepted but not yet confirmed
+	if order.Status == models.OrderStatusPendingPayment {
+		c.JSON(http.StatusAccepted, *order)
+		return
+	}
+	c.JSON(http.StatusCreated, *order)
 }
@@ -0,0 +1,142 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIP is the address test requests come from.
+const clientIP = "203.0.113.5"
+
+var (
+	// router serves the running spec's requests. Each suite builds its own
+	// in BeforeEach; specs run one at a time.
+	router *gin.Engine
+	// token signs requests in as the seeded admin.
+	token string
+)
+
+// request sends a JSON request with the given headers through router.
+func request(method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = clientIP + ":50000"
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// newTestRouter resets the database and router and signs token in as the
+// seeded admin, whom it returns.
+func newTestRouter() *models.User {
+	database.Connect()
+	router = gin.New()
+	var admin *models.User
+	for _, u := range database.DB.Users {
+		admin = u
+	}
+	token = handlers.StartSession(admin)
+	return admin
+}
+
+// asAdmin returns the headers to act as the seeded admin.
+func asAdmin() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// checkout adds two laptops to the admin's current cart and orders the cart,
+// paying with method.
+func checkout(method string) (*httptest.ResponseRecorder, models.Order) {
+	request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 2}, asAdmin())
+	w := request("POST", "/orders", map[string]string{"payment_method": method}, asAdmin())
+	var order models.Order
+	json.Unmarshal(w.Body.Bytes(), &order)
+	return w, order
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// asCustomer adds a customer and returns their ID and the headers to act
+// as them.
+func asCustomer(username string) (uint, map[string]string) {
+	id := database.DB.GetNextID()
+	database.DB.Mutex.Lock()
+	user := &models.User{ID: id, Username: username, Role: models.RoleCustomer, CreatedAt: time.Now()}
+	database.DB.Users[id] = user
+	database.DB.Mutex.Unlock()
+	token := handlers.StartSession(user)
+	return id, map[string]string{"Authorization": "Bearer " + token}
+}
+
+// lockCheckingProvider wraps a payment provider and counts the calls it gets
+// while database.DB.Mutex is held. When gate is set, Capture waits for it to
+// close.
+type lockCheckingProvider struct {
+	payments.Provider
+	held     int32
+	captures int32
+	gate     chan struct{}
+}
+
+func (p *lockCheckingProvider) check() {
+	if !database.DB.Mutex.TryLock() {
+		atomic.AddInt32(&p.held, 1)
+		return
+	}
+	database.DB.Mutex.Unlock()
+}
+
+// Captures returns how many times Capture was called.
+func (p *lockCheckingProvider) Captures() int32 {
+	return atomic.LoadInt32(&p.captures)
+}
+
+// Held returns how many calls were made under the lock.
+func (p *lockCheckingProvider) Held() int32 {
+	return atomic.LoadInt32(&p.held)
+}
+
+func (p *lockCheckingProvider) Authorize(ctx context.Context, req payments.AuthorizeRequest) (payments.Intent, error) {
+	p.check()
+	return p.Provider.Authorize(ctx, req)
+}
+
+func (p *lockCheckingProvider) Capture(ctx context.Context, intentID string, amount int64) (payments.Intent, error) {
+	p.check()
+	atomic.AddInt32(&p.captures, 1)
+	if p.gate != nil {
+		<-p.gate
+	}
+	return p.Provider.Capture(ctx, intentID, amount)
+}
+
+func (p *lockCheckingProvider) Void(ctx context.Context, intentID string) (payments.Intent, error) {
+	p.check()
+	return p.Provider.Void(ctx, intentID)
+}
+
+func (p *lockCheckingProvider) Refund(ctx context.Context, intentID string, amount int64) (payments.Intent, error) {
+	p.check()
+	return p.Provider.Refund(ctx, intentID, amount)
+}
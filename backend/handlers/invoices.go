@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/invoices"
+	"ecommerce-backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InvoiceSeller is the seller named on every invoice and credit note.
+var InvoiceSeller = models.InvoiceParty{
+	Name:       "E-commerce Store",
+	Line1:      "1 Commerce Way",
+	City:       "Austin",
+	Region:     "TX",
+	PostalCode: "73301",
+	Country:    "US",
+}
+
+// DocumentSequenceRequest configures how invoices or credit notes are
+// numbered.
+type DocumentSequenceRequest struct {
+	Prefix  string `json:"prefix" binding:"required"`
+	Padding int    `json:"padding"`
+	Next    int    `json:"next" binding:"required"`
+}
+
+// orderVisibleTo reports whether a user may see an order: its owner or an
+// admin. Callers must hold database.DB.Mutex.
+func orderVisibleTo(order *models.Order, userID uint) bool {
+	if order.UserID == userID {
+		return true
+	}
+	user, exists := database.DB.Users[userID]
+	return exists && user.Role == models.RoleAdmin
+}
+
+// invoiceBuyer names the customer on an order's invoice, at the billing
+// address or else the shipping address.
+// Callers must hold database.DB.Mutex.
+func invoiceBuyer(order *models.Order) models.InvoiceParty {
+	buyer := models.InvoiceParty{}
+	if user, exists := database.DB.Users[order.UserID]; exists {
+		buyer.Name = user.Username
+		if user.DisplayName != "" {
+			buyer.Name = user.DisplayName
+		}
+	}
+	address := order.BillingAddress
+	if address == nil {
+		address = order.ShippingAddress
+	}
+	if address != nil {
+		buyer.Name = address.Name
+		buyer.Line1, buyer.Line2 = address.Line1, address.Line2
+		buyer.City, buyer.Region, buyer.PostalCode, buyer.Country = address.City, address.Region, address.PostalCode, address.Country
+	}
+	return buyer
+}
+
+// orderInvoice returns the invoice issued for an order, if any.
+// Callers must hold database.DB.Mutex.
+func orderInvoice(orderID uint) *models.Invoice {
+	for _, invoice := range database.DB.Invoices {
+		if invoice.OrderID == orderID && invoice.Kind == models.InvoiceKindInvoice {
+			return invoice
+		}
+	}
+	return nil
+}
+
+// issueDocument numbers and stores an invoice or credit note.
+// Callers must hold database.DB.Mutex.
+func issueDocument(document *models.Invoice) {
+	document.ID = database.DB.GetNextID()
+	document.Number = invoices.Next(database.DB.DocumentSequences[document.Kind])
+	document.IssuedAt = Clock.Now()
+	database.DB.Invoices[document.ID] = document
+}
+
+// issueInvoice invoices an order once it is paid for.
+// Callers must hold database.DB.Mutex.
+func issueInvoice(order *models.Order) {
+	if orderInvoice(order.ID) != nil {
+		return
+	}
+	invoice := invoices.FromOrder(order, InvoiceSeller, invoiceBuyer(order))
+	issueDocument(invoice)
+	addOrderHistory(order, "invoice_issued", invoice.Number, 0, 0)
+}
+
+// issueCreditNote credits an order's invoice for a refund.
+// Callers must hold database.DB.Mutex.
+func issueCreditNote(order *models.Order, refund *models.Refund) {
+	invoice := orderInvoice(order.ID)
+	if invoice == nil || refund.Amount == 0 {
+		return
+	}
+	note := invoices.CreditNote(order, refund, invoice)
+	issueDocument(note)
+	addOrderHistory(order, "credit_note_issued", note.Number, refund.ReturnID, refund.CreatedBy)
+}
+
+// writeDocument sends an invoice or credit note as PDF (the default), HTML
+// or JSON, chosen by the ?format= query.
+func writeDocument(c *gin.Context, document *models.Invoice) {
+	switch c.DefaultQuery("format", "pdf") {
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, document.Number))
+		c.Data(http.StatusOK, "application/pdf", invoices.RenderPDF(document))
+	case "html":
+		html, err := invoices.RenderHTML(document)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", html)
+	case "json":
+		c.JSON(http.StatusOK, *document)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf, html or json"})
+	}
+}
+
+// GetOrderInvoice downloads the invoice for an order.
+func GetOrderInvoice(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || !orderVisibleTo(order, userID.(uint)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	invoice := orderInvoice(order.ID)
+	if invoice == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order has not been invoiced"})
+		return
+	}
+
+	auditRead(c, AuditTargetInvoice, invoice.ID, order.UserID, map[string]string{"number": invoice.Number})
+	writeDocument(c, invoice)
+}
+
+// GetOrderInvoices lists an order's invoice and credit notes.
+func GetOrderInvoices(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || !orderVisibleTo(order, userID.(uint)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	documents := []models.Invoice{}
+	for _, document := range database.DB.Invoices {
+		if document.OrderID == order.ID {
+			documents = append(documents, *document)
+		}
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].ID < documents[j].ID })
+	auditRead(c, AuditTargetOrder, order.ID, order.UserID, map[string]string{"view": "invoices"})
+	c.JSON(http.StatusOK, documents)
+}
+
+// GetInvoice downloads any invoice or credit note by its number.
+func GetInvoice(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	for _, document := range database.DB.Invoices {
+		if document.Number != c.Param("number") {
+			continue
+		}
+		if order, exists := database.DB.Orders[document.OrderID]; exists && orderVisibleTo(order, userID.(uint)) {
+			auditRead(c, AuditTargetInvoice, document.ID, order.UserID, map[string]string{"number": document.Number})
+			writeDocument(c, document)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+}
+
+// GetDocumentSequences lists the invoice and credit note sequences.
+func GetDocumentSequences(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	sequences := []models.DocumentSequence{}
+	for _, sequence := range database.DB.DocumentSequences {
+		sequences = append(sequences, *sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i].Name < sequences[j].Name })
+	c.JSON(http.StatusOK, sequences)
+}
+
+// UpdateDocumentSequence changes the prefix, padding or next number of a
+// sequence. The next number can only move forward.
+func UpdateDocumentSequence(c *gin.Context) {
+	var req DocumentSequenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	current, exists := database.DB.DocumentSequences[c.Param("name")]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sequence not found"})
+		return
+	}
+
+	updated := models.DocumentSequence{Name: current.Name, Prefix: req.Prefix, Padding: req.Padding, Next: req.Next}
+	if err := invoices.ValidateSequence(&updated, current); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, invoices.ErrInvalidSequence) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	*current = updated
+	c.JSON(http.StatusOK, updated)
+}
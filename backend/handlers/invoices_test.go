@@ -0,0 +1,148 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Invoices", func() {
+	var (
+		admin    *models.User
+		provider *payments.Fake
+
+		mu       sync.Mutex
+		webhooks [][2]string
+	)
+
+	documents := func(order models.Order) []models.Invoice {
+		var list []models.Invoice
+		w := request("GET", "/orders/"+itoa(order.ID)+"/invoices", nil, asAdmin())
+		json.Unmarshal(w.Body.Bytes(), &list)
+		return list
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		webhooks = nil
+		provider = payments.NewFake(payments.FakeConfig{
+			Sink: func(payload []byte, signature string) {
+				mu.Lock()
+				webhooks = append(webhooks, [2]string{string(payload), signature})
+				mu.Unlock()
+			},
+		})
+		handlers.Payments = provider
+
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.GET("/orders/:id/invoice", handlers.GetOrderInvoice)
+		auth.GET("/orders/:id/invoices", handlers.GetOrderInvoices)
+		auth.GET("/invoices/:number", handlers.GetInvoice)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.POST("/orders/:id/refunds", handlers.RefundOrder)
+		staff.GET("/invoice-sequences", handlers.GetDocumentSequences)
+		staff.PUT("/invoice-sequences/:name", handlers.UpdateDocumentSequence)
+	})
+
+	It("invoices an order once it is paid", func() {
+		_, order := checkout(payments.FakeCardApproved)
+
+		w := request("GET", "/orders/"+itoa(order.ID)+"/invoice", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/pdf"))
+		Expect(w.Header().Get("Content-Disposition")).To(ContainSubstring("INV-000001.pdf"))
+		Expect(w.Body.String()).To(HavePrefix("%PDF-"))
+
+		w = request("GET", "/orders/"+itoa(order.ID)+"/invoice?format=html", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("INV-000001"))
+		Expect(w.Body.String()).To(ContainSubstring(handlers.InvoiceSeller.Name))
+
+		var invoice models.Invoice
+		w = request("GET", "/orders/"+itoa(order.ID)+"/invoice?format=json", nil, asAdmin())
+		json.Unmarshal(w.Body.Bytes(), &invoice)
+		Expect(invoice.Kind).To(Equal(models.InvoiceKindInvoice))
+		Expect(invoice.Total).To(Equal(order.Totals.Total))
+		Expect(invoice.Buyer.Name).To(Equal(admin.Username))
+	})
+
+	It("numbers invoices without gaps", func() {
+		_, first := checkout(payments.FakeCardApproved)
+		checkout(payments.FakeCardDeclined)
+		_, second := checkout(payments.FakeCardApproved)
+
+		Expect(documents(first)[0].Number).To(Equal("INV-000001"))
+		Expect(documents(second)[0].Number).To(Equal("INV-000002"))
+	})
+
+	It("waits for a pending payment before invoicing", func() {
+		_, order := checkout(payments.FakeCard3DS)
+		Expect(request("GET", "/orders/"+itoa(order.ID)+"/invoice", nil, asAdmin()).Code).To(Equal(http.StatusNotFound))
+
+		_, err := provider.Complete(order.Payment.IntentID, true)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(webhooks)
+		}).Should(Equal(1))
+		for _, webhook := range webhooks {
+			Expect(handlers.ProcessPaymentWebhook([]byte(webhook[0]), webhook[1])).To(Succeed())
+		}
+
+		Expect(request("GET", "/orders/"+itoa(order.ID)+"/invoice", nil, asAdmin()).Code).To(Equal(http.StatusOK))
+	})
+
+	It("issues a credit note for each refund", func() {
+		_, order := checkout(payments.FakeCardApproved)
+		w := request("POST", "/orders/"+itoa(order.ID)+"/refunds", map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 1, "quantity": 1}}}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var body struct{ Refund models.Refund }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		refund := body.Refund
+
+		list := documents(order)
+		Expect(list).To(HaveLen(2))
+		note := list[1]
+		Expect(note.Kind).To(Equal(models.InvoiceKindCreditNote))
+		Expect(note.Number).To(Equal("CN-000001"))
+		Expect(note.Credits).To(Equal("INV-000001"))
+		Expect(note.RefundID).To(Equal(refund.ID))
+		Expect(note.Total).To(Equal(refund.Amount))
+
+		w = request("GET", "/invoices/CN-000001?format=html", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("Credit Note"))
+	})
+
+	It("hides invoices from other customers", func() {
+		_, order := checkout(payments.FakeCardApproved)
+		_, headers := asCustomer("someone")
+
+		Expect(request("GET", "/orders/"+itoa(order.ID)+"/invoice", nil, headers).Code).To(Equal(http.StatusNotFound))
+		Expect(request("GET", "/invoices/INV-000001", nil, headers).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("lets staff configure the sequence but never reuse a number", func() {
+		w := request("PUT", "/invoice-sequences/invoice", map[string]interface{}{"prefix": "2024-", "padding": 4, "next": 100}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		_, order := checkout(payments.FakeCardApproved)
+		Expect(documents(order)[0].Number).To(Equal("2024-0100"))
+
+		w = request("PUT", "/invoice-sequences/invoice", map[string]interface{}{"prefix": "2024-", "padding": 4, "next": 50}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(request("PUT", "/invoice-sequences/unknown", map[string]interface{}{"prefix": "X", "next": 1}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/lockout"
+	"ecommerce-backend/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Throttling applied to failed logins against one account and from one
+// client address.
+var (
+	AccountLockout = lockout.AccountPolicy
+	AddressLockout = lockout.AddressPolicy
+)
+
+// dummyPasswordHash is compared against when the username is unknown, so
+// that takes as long as a wrong password.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not anyone's password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+func accountThrottleKey(username string) string { return "user:" + username }
+
+func addressThrottleKey(ip string) string { return "ip:" + ip }
+
+// throttlePolicy returns the policy for a throttle key.
+func throttlePolicy(key string) lockout.Policy {
+	if strings.HasPrefix(key, "ip:") {
+		return AddressLockout
+	}
+	return AccountLockout
+}
+
+// loginWait returns how long a login for the account from the address must
+// wait, and whether either is locked out.
+// Callers must hold database.DB.Mutex.
+func loginWait(username, ip string, now time.Time) (time.Duration, bool) {
+	var wait time.Duration
+	locked := false
+	for _, key := range []string{accountThrottleKey(username), addressThrottleKey(ip)} {
+		if t, exists := database.DB.LoginThrottles[key]; exists {
+			w, l := throttlePolicy(key).Check(t, now)
+			wait = max(wait, w)
+			locked = locked || l
+		}
+	}
+	return wait, locked
+}
+
+// countLoginAttempt counts a login as failed against the account and the
+// address, and reports whether that locked the account out. It is counted
+// before the password is checked so concurrent guesses cannot all get past
+// the throttle; clearLoginAttempt takes it back if the login succeeds.
+// Callers must hold database.DB.Mutex.
+func countLoginAttempt(username, ip string, now time.Time) bool {
+	locked := false
+	for _, key := range []string{accountThrottleKey(username), addressThrottleKey(ip)} {
+		t, exists := database.DB.LoginThrottles[key]
+		if !exists {
+			t = &models.LoginThrottle{Key: key}
+			database.DB.LoginThrottles[key] = t
+		}
+		if throttlePolicy(key).Fail(t, now) && key == accountThrottleKey(username) {
+			locked = true
+		}
+	}
+	return locked
+}
+
+// clearLoginAttempt forgets an account's failures after a successful login
+// and takes back the attempt counted against the address.
+// Callers must hold database.DB.Mutex.
+func clearLoginAttempt(username, ip string) {
+	delete(database.DB.LoginThrottles, accountThrottleKey(username))
+	if t, exists := database.DB.LoginThrottles[addressThrottleKey(ip)]; exists && t.Failures > 0 {
+		t.Failures--
+		if t.Failures == 0 && t.LockedUntil == nil {
+			delete(database.DB.LoginThrottles, t.Key)
+		}
+	}
+}
+
+// retryAfter formats a wait as a Retry-After header value in whole seconds.
+func retryAfter(wait time.Duration) string {
+	return fmt.Sprintf("%d", (wait+time.Second-1)/time.Second)
+}
+
+// PruneLoginThrottles drops throttles whose failures have all expired.
+func PruneLoginThrottles(now time.Time) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for key, t := range database.DB.LoginThrottles {
+		if !throttlePolicy(key).Expire(t, now) {
+			delete(database.DB.LoginThrottles, key)
+		}
+	}
+}
+
+// UnlockUser clears a user's failed logins, ending any lockout.
+func UnlockUser(c *gin.Context) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, exists := database.DB.Users[userID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	key := accountThrottleKey(user.Username)
+	if t, exists := database.DB.LoginThrottles[key]; exists {
+		delete(database.DB.LoginThrottles, key)
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserUnlocked,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Metadata:   map[string]string{"failures": fmt.Sprintf("%d", t.Failures)},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
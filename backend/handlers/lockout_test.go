@@ -0,0 +1,146 @@
+package handlers_test
+
+import (
+	"bytes"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/lockout"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Login lockout", func() {
+	var (
+		fake  *clock.Fake
+		admin *models.User
+	)
+
+	login := func(username, password, ip string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(map[string]string{"username": username, "password": password})
+		req, _ := http.NewRequest("POST", "/users/login", &buf)
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+		handlers.AccountLockout = lockout.Policy{
+			FreeAttempts: 2,
+			BaseDelay:    time.Second,
+			MaxDelay:     8 * time.Second,
+			LockAfter:    5,
+			LockFor:      15 * time.Minute,
+			Window:       15 * time.Minute,
+		}
+		handlers.AddressLockout = lockout.Policy{FreeAttempts: 6, BaseDelay: time.Minute, MaxDelay: time.Minute, Window: time.Hour}
+
+		admin = newTestRouter()
+		router.POST("/users/login", handlers.LoginUser)
+		staff := router.Group("/")
+		staff.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+		staff.POST("/users/:id/unlock", handlers.UnlockUser)
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+		handlers.AccountLockout = lockout.AccountPolicy
+		handlers.AddressLockout = lockout.AddressPolicy
+	})
+
+	It("makes attempts wait after a few failures", func() {
+		Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+		Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+		Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+
+		w := login("admin", "Admin@123", "192.0.2.1")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("1"))
+
+		fake.Advance(time.Second)
+		Expect(login("admin", "Admin@123", "192.0.2.1").Code).To(Equal(http.StatusOK))
+
+		// A successful login forgets the failures
+		Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+		Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+		Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("locks the account out, from any address, until an admin unlocks it", func() {
+		for i := 0; i < 5; i++ {
+			Expect(login("admin", "wrong", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+			fake.Advance(8 * time.Second)
+		}
+
+		w := login("admin", "Admin@123", "198.51.100.7")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("892"))
+
+		locks := 0
+		for _, entry := range database.DB.AuditLog {
+			if entry.Action == handlers.AuditUserLockedOut {
+				locks++
+				Expect(entry.TargetID).To(Equal(admin.ID))
+			}
+		}
+		Expect(locks).To(Equal(1))
+
+		Expect(request("POST", "/users/"+itoa(admin.ID)+"/unlock", nil, asAdmin()).Code).To(Equal(http.StatusOK))
+
+		Expect(login("admin", "Admin@123", "198.51.100.7").Code).To(Equal(http.StatusOK))
+	})
+
+	It("ends the lockout after its time", func() {
+		for i := 0; i < 5; i++ {
+			login("admin", "wrong", "192.0.2.1")
+			fake.Advance(8 * time.Second)
+		}
+		Expect(login("admin", "Admin@123", "192.0.2.1").Code).To(Equal(http.StatusTooManyRequests))
+
+		fake.Advance(15 * time.Minute)
+		Expect(login("admin", "Admin@123", "192.0.2.1").Code).To(Equal(http.StatusOK))
+	})
+
+	It("throttles an address guessing across accounts", func() {
+		for i := 0; i < 6; i++ {
+			Expect(login("user"+itoa(uint(i)), "guess", "203.0.113.9").Code).To(Equal(http.StatusUnauthorized))
+		}
+		Expect(login("admin", "Admin@123", "203.0.113.9").Code).To(Equal(http.StatusOK))
+		Expect(login("someone", "guess", "203.0.113.9").Code).To(Equal(http.StatusUnauthorized))
+		Expect(login("admin", "Admin@123", "203.0.113.9").Code).To(Equal(http.StatusTooManyRequests))
+
+		// Other addresses are unaffected
+		Expect(login("admin", "Admin@123", "192.0.2.1").Code).To(Equal(http.StatusOK))
+	})
+
+	It("treats unknown usernames like wrong passwords", func() {
+		for i := 0; i < 3; i++ {
+			Expect(login("ghost", "guess", "192.0.2.1").Code).To(Equal(http.StatusUnauthorized))
+		}
+		w := login("ghost", "guess", "192.0.2.1")
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).To(Equal("1"))
+	})
+
+	It("prunes throttles once their failures expire", func() {
+		login("admin", "wrong", "192.0.2.1")
+		Expect(database.DB.LoginThrottles).To(HaveLen(2))
+
+		handlers.PruneLoginThrottles(fake.Now().Add(30 * time.Minute))
+		Expect(database.DB.LoginThrottles).To(HaveLen(1))
+		handlers.PruneLoginThrottles(fake.Now().Add(2 * time.Hour))
+		Expect(database.DB.LoginThrottles).To(BeEmpty())
+	})
+})
@@ -0,0 +1,569 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/mfa"
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MFAIssuer names the store in authenticator apps.
+var MFAIssuer = "E-commerce Store"
+
+const (
+	recoveryCodeCount = 10
+	mfaQRCodeSize     = 256 // pixels
+)
+
+var (
+	errMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	errMFANotEnrolled    = errors.New("two-factor authentication is not set up")
+)
+
+// MFAEnrollmentResponse is what an authenticator app needs to be set up:
+// the secret to type in, or the URI to scan as the PNG QR code.
+type MFAEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode []byte `json:"qr_png"` // base64 in JSON
+}
+
+// MFACodeRequest carries a code from the user's app or one of their
+// recovery codes.
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFALoginRequest is the second step of a login.
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	MFACodeRequest
+}
+
+// MFAStatus describes a user's two-factor setup.
+type MFAStatus struct {
+	Enabled           bool `json:"enabled"`
+	Pending           bool `json:"pending"` // enrolled but not yet confirmed with a code
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFARoleRequest struct {
+	Required bool `json:"required"`
+}
+
+// mfaRequired reports whether a user's role must use two-factor login.
+// Callers must hold database.DB.Mutex.
+func mfaRequired(user *models.User) bool {
+	return database.DB.MFARequiredRoles[user.Role]
+}
+
+// startEnrollment gives a user a new, unconfirmed TOTP secret, replacing
+// any earlier unconfirmed one.
+// Callers must hold database.DB.Mutex.
+func startEnrollment(user *models.User) (*MFAEnrollmentResponse, error) {
+	if enrollment, exists := database.DB.MFAEnrollments[user.ID]; exists && enrollment.Confirmed {
+		return nil, errMFAAlreadyEnabled
+	}
+
+	secret, err := mfa.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	uri := mfa.URI(MFAIssuer, user.Username, secret)
+	qr, err := mfa.QRCode(uri, mfaQRCodeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	database.DB.MFAEnrollments[user.ID] = &models.MFAEnrollment{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: Clock.Now(),
+	}
+	return &MFAEnrollmentResponse{Secret: secret, URI: uri, QRCode: qr}, nil
+}
+
+// newRecoveryCodes replaces an enrollment's recovery codes and returns the
+// new ones. Only their hashes are kept.
+// Callers must hold database.DB.Mutex.
+func newRecoveryCodes(enrollment *models.MFAEnrollment) ([]string, error) {
+	codes, err := mfa.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	enrollment.RecoveryCodes = make([]string, 0, len(codes))
+	for _, code := range codes {
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes, mfa.HashRecoveryCode(code))
+	}
+	return codes, nil
+}
+
+// confirmEnrollment turns two-factor login on once the user has entered a
+// code, and returns their recovery codes.
+// Callers must hold database.DB.Mutex.
+func confirmEnrollment(c *gin.Context, user *models.User, enrollment *models.MFAEnrollment) ([]string, error) {
+	codes, err := newRecoveryCodes(enrollment)
+	if err != nil {
+		return nil, err
+	}
+	now := Clock.Now()
+	enrollment.Confirmed = true
+	enrollment.ConfirmedAt = &now
+	user.MFAEnabled = true
+
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserMFAEnabled,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+	})
+	return codes, nil
+}
+
+// disableMFA removes a user's two-factor setup.
+// Callers must hold database.DB.Mutex.
+func disableMFA(c *gin.Context, user *models.User) {
+	delete(database.DB.MFAEnrollments, user.ID)
+	user.MFAEnabled = false
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserMFADisabled,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+	})
+}
+
+// checkMFACode reports whether a request carries a valid code, or a recovery
+// code of a confirmed enrollment. A code cannot be used twice, and a
+// recovery code is used up.
+// Callers must hold database.DB.Mutex.
+func checkMFACode(enrollment *models.MFAEnrollment, req MFACodeRequest) (method string, ok bool) {
+	if req.Code != "" {
+		step, ok := mfa.Validate(enrollment.Secret, req.Code, Clock.Now(), enrollment.LastUsedStep)
+		if !ok {
+			return "", false
+		}
+		enrollment.LastUsedStep = step
+		return "totp", true
+	}
+
+	if req.RecoveryCode != "" && enrollment.Confirmed {
+		hash := mfa.HashRecoveryCode(req.RecoveryCode)
+		for i, stored := range enrollment.RecoveryCodes {
+			if stored == hash {
+				enrollment.RecoveryCodes = append(enrollment.RecoveryCodes[:i], enrollment.RecoveryCodes[i+1:]...)
+				return "recovery_code", true
+			}
+		}
+	}
+	return "", false
+}
+
+// verifyMFA checks the second factor sent with a request. Wrong codes count
+// towards the same lockout as wrong passwords. On failure it answers the
+// request itself and returns false.
+// Callers must hold database.DB.Mutex.
+func verifyMFA(c *gin.Context, user *models.User, enrollment *models.MFAEnrollment, req MFACodeRequest) (string, bool) {
+	now := Clock.Now()
+	ip := c.ClientIP()
+	if wait, _ := loginWait(user.Username, ip, now); wait > 0 {
+		c.Header("Retry-After", retryAfter(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return "", false
+	}
+
+	method, ok := checkMFACode(enrollment, req)
+	if !ok {
+		lockedOut := countLoginAttempt(user.Username, ip, now)
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserMFAFailed,
+			Outcome:    models.AuditFailure,
+			ActorID:    user.ID,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+		})
+		if lockedOut {
+			recordAudit(c, models.AuditEntry{
+				Action:     AuditUserLockedOut,
+				TargetType: AuditTargetUser,
+				TargetID:   user.ID,
+				Metadata:   map[string]string{"username": user.Username},
+			})
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return "", false
+	}
+
+	if method == "recovery_code" {
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserRecoveryCodeUsed,
+			ActorID:    user.ID,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Metadata:   map[string]string{"remaining": fmt.Sprint(len(enrollment.RecoveryCodes))},
+		})
+	}
+	return method, true
+}
+
+// currentUser looks up the signed-in user. When there is none it answers
+// the request itself and returns nil.
+// Callers must hold database.DB.Mutex.
+func currentUser(c *gin.Context) *models.User {
+	userID, _ := c.Get("user_id")
+	id, _ := userID.(uint)
+	user, exists := database.DB.Users[id]
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil
+	}
+	return user
+}
+
+// GetMFAStatus describes the current user's two-factor setup.
+func GetMFAStatus(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	status := MFAStatus{Enabled: user.MFAEnabled, Required: mfaRequired(user)}
+	if enrollment, exists := database.DB.MFAEnrollments[user.ID]; exists {
+		status.Pending = !enrollment.Confirmed
+		status.RecoveryCodesLeft = len(enrollment.RecoveryCodes)
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// EnrollMFA starts setting up two-factor login for the current user. It is
+// not used until confirmed with ConfirmMFA.
+func EnrollMFA(c *gin.Context) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	enrollment, err := startEnrollment(user)
+	if errors.Is(err, errMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, enrollment)
+}
+
+// ConfirmMFA turns two-factor login on with a first code from the app and
+// returns the recovery codes. They are shown only this once.
+func ConfirmMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	enrollment, exists := database.DB.MFAEnrollments[user.ID]
+	if !exists {
+		c.JSON(http.StatusConflict, gin.H{"error": errMFANotEnrolled.Error()})
+		return
+	}
+	if enrollment.Confirmed {
+		c.JSON(http.StatusConflict, gin.H{"error": errMFAAlreadyEnabled.Error()})
+		return
+	}
+	if _, ok := verifyMFA(c, user, enrollment, MFACodeRequest{Code: req.Code}); !ok {
+		return
+	}
+
+	codes, err := confirmEnrollment(c, user, enrollment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableMFA turns two-factor login off for the current user, given a code
+// or recovery code. Users whose role requires it cannot turn it off.
+func DisableMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	enrollment, exists := database.DB.MFAEnrollments[user.ID]
+	if !exists || !enrollment.Confirmed {
+		c.JSON(http.StatusConflict, gin.H{"error": errMFANotEnrolled.Error()})
+		return
+	}
+	if mfaRequired(user) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role requires two-factor authentication"})
+		return
+	}
+	if _, ok := verifyMFA(c, user, enrollment, req); !ok {
+		return
+	}
+
+	disableMFA(c, user)
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes, given
+// a code from their app.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	enrollment, exists := database.DB.MFAEnrollments[user.ID]
+	if !exists || !enrollment.Confirmed {
+		c.JSON(http.StatusConflict, gin.H{"error": errMFANotEnrolled.Error()})
+		return
+	}
+	if _, ok := verifyMFA(c, user, enrollment, MFACodeRequest{Code: req.Code}); !ok {
+		return
+	}
+
+	codes, err := newRecoveryCodes(enrollment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserRecoveryCodesRenewed,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+	})
+	c.JSON(http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// challengeMFA answers a login whose first factor checked out with a
+// two-factor challenge token, if the user needs one, and reports whether it
+// did.
+// Callers must hold database.DB.Mutex.
+func challengeMFA(c *gin.Context, user *models.User) bool {
+	if !user.MFAEnabled && !mfaRequired(user) {
+		return false
+	}
+	challenge, err := utils.GenerateMFAToken(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return true
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserMFAChallenged,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+	})
+	c.JSON(http.StatusOK, LoginResponse{
+		MFARequired:           true,
+		MFAToken:              challenge,
+		MFAEnrollmentRequired: !user.MFAEnabled,
+	})
+	return true
+}
+
+// mfaChallengeUser returns the user a login challenge token was issued to.
+// On failure it answers the request itself and returns nil.
+// Callers must hold database.DB.Mutex.
+func mfaChallengeUser(c *gin.Context, token string) *models.User {
+	claims, err := utils.ValidateMFAToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil
+	}
+	user, exists := database.DB.Users[claims.UserID]
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil
+	}
+	return user
+}
+
+// EnrollMFALogin sets up two-factor login partway through a login, for a
+// user whose role requires it but who has not enrolled yet. The login is
+// finished with CompleteMFALogin and the first code.
+func EnrollMFALogin(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := mfaChallengeUser(c, req.MFAToken)
+	if user == nil {
+		return
+	}
+	enrollment, err := startEnrollment(user)
+	if errors.Is(err, errMFAAlreadyEnabled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, enrollment)
+}
+
+// CompleteMFALogin finishes a login with the challenge token from
+// LoginUser and a code or recovery code. If the user was enrolling, the
+// code confirms it and the response includes their recovery codes.
+func CompleteMFALogin(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := mfaChallengeUser(c, req.MFAToken)
+	if user == nil {
+		return
+	}
+	enrollment, exists := database.DB.MFAEnrollments[user.ID]
+	if !exists {
+		c.JSON(http.StatusConflict, gin.H{"error": errMFANotEnrolled.Error()})
+		return
+	}
+	method, ok := verifyMFA(c, user, enrollment, req.MFACodeRequest)
+	if !ok {
+		return
+	}
+	clearLoginAttempt(user.Username, c.ClientIP())
+
+	response := LoginResponse{}
+	if !enrollment.Confirmed {
+		codes, err := confirmEnrollment(c, user, enrollment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response.RecoveryCodes = codes
+	}
+	completeLogin(c, user, response, map[string]string{"mfa": method})
+}
+
+// ResetUserMFA removes a user's two-factor setup, for a user who has lost
+// both their app and their recovery codes. If their role requires it they
+// enroll again at their next login.
+func ResetUserMFA(c *gin.Context) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, exists := database.DB.Users[userID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if _, exists := database.DB.MFAEnrollments[user.ID]; exists {
+		disableMFA(c, user)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
+
+// GetMFARoles lists whether each role must use two-factor login.
+func GetMFARoles(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	roles := map[string]bool{}
+	for _, role := range []string{models.RoleCustomer, models.RoleAdmin} {
+		roles[role] = database.DB.MFARequiredRoles[role]
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// SetMFARole sets whether a role must use two-factor login. Users of the
+// role who have not enrolled are made to at their next login; sessions
+// already signed in are not affected.
+func SetMFARole(c *gin.Context) {
+	role := c.Param("role")
+	if role != models.RoleCustomer && role != models.RoleAdmin {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	var req MFARoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	before := database.DB.MFARequiredRoles[role]
+	if req.Required {
+		database.DB.MFARequiredRoles[role] = true
+	} else {
+		delete(database.DB.MFARequiredRoles, role)
+	}
+	if before != req.Required {
+		recordAudit(c, models.AuditEntry{
+			Action:   AuditMFAPolicyChanged,
+			Changes:  auditChanges(map[string]bool{role: before}, map[string]bool{role: req.Required}),
+			Metadata: map[string]string{"role": role},
+		})
+	}
+
+	roles := []string{}
+	for r := range database.DB.MFARequiredRoles {
+		roles = append(roles, r)
+	}
+	sort.Strings(roles)
+	c.JSON(http.StatusOK, gin.H{"required_roles": roles})
+}
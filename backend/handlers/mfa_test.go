@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"bytes"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/mfa"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Two-factor authentication", func() {
+	var (
+		fake  *clock.Fake
+		admin *models.User
+	)
+
+	login := func(username, password string) handlers.LoginResponse {
+		w := request("POST", "/users/login", map[string]string{"username": username, "password": password}, nil)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var response handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	code := func(secret string) string {
+		c, err := mfa.Code(secret, fake.Now())
+		Expect(err).ToNot(HaveOccurred())
+		return c
+	}
+
+	// enroll sets up and confirms two-factor login for the admin and
+	// returns the secret and recovery codes
+	enroll := func() (string, []string) {
+		w := request("POST", "/mfa/enroll", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		var enrollment handlers.MFAEnrollmentResponse
+		json.Unmarshal(w.Body.Bytes(), &enrollment)
+
+		w = request("POST", "/mfa/verify", map[string]string{"code": code(enrollment.Secret)}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var codes handlers.RecoveryCodesResponse
+		json.Unmarshal(w.Body.Bytes(), &codes)
+
+		fake.Advance(mfa.Period)
+		return enrollment.Secret, codes.RecoveryCodes
+	}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+
+		admin = newTestRouter()
+		router.POST("/users", handlers.CreateUser)
+		router.POST("/users/login", handlers.LoginUser)
+		router.POST("/users/login/mfa", handlers.CompleteMFALogin)
+		router.POST("/users/login/mfa/enroll", handlers.EnrollMFALogin)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.GET("/mfa", handlers.GetMFAStatus)
+		auth.POST("/mfa/enroll", handlers.EnrollMFA)
+		auth.POST("/mfa/verify", handlers.ConfirmMFA)
+		auth.POST("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
+		auth.DELETE("/mfa", handlers.DisableMFA)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.DELETE("/users/:id/mfa", handlers.ResetUserMFA)
+		staff.GET("/mfa/roles", handlers.GetMFARoles)
+		staff.PUT("/mfa/roles/:role", handlers.SetMFARole)
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+	})
+
+	It("enrolls with a secret, otpauth URI and QR code", func() {
+		w := request("POST", "/mfa/enroll", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var enrollment handlers.MFAEnrollmentResponse
+		json.Unmarshal(w.Body.Bytes(), &enrollment)
+		Expect(enrollment.Secret).ToNot(BeEmpty())
+		Expect(enrollment.URI).To(HavePrefix("otpauth://totp/"))
+		_, err := png.Decode(bytes.NewReader(enrollment.QRCode))
+		Expect(err).ToNot(HaveOccurred())
+
+		// Nothing changes until the enrollment is confirmed
+		Expect(login("admin", "Admin@123").Token).ToNot(BeEmpty())
+		Expect(request("POST", "/mfa/verify", map[string]string{"code": "000000"}, asAdmin()).Code).To(Equal(http.StatusUnauthorized))
+
+		w = request("POST", "/mfa/verify", map[string]string{"code": code(enrollment.Secret)}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var codes handlers.RecoveryCodesResponse
+		json.Unmarshal(w.Body.Bytes(), &codes)
+		Expect(codes.RecoveryCodes).To(HaveLen(10))
+		Expect(database.DB.Users[admin.ID].MFAEnabled).To(BeTrue())
+
+		Expect(request("POST", "/mfa/enroll", nil, asAdmin()).Code).To(Equal(http.StatusConflict))
+	})
+
+	It("logs in in two steps once enabled", func() {
+		secret, _ := enroll()
+
+		first := login("admin", "Admin@123")
+		Expect(first.MFARequired).To(BeTrue())
+		Expect(first.Token).To(BeEmpty())
+		Expect(first.User).To(BeNil())
+
+		// The challenge token is not a session
+		Expect(request("GET", "/mfa", nil, map[string]string{"Authorization": "Bearer " + first.MFAToken}).Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "code": "123456"}, nil).Code).To(Equal(http.StatusUnauthorized))
+
+		current := code(secret)
+		w := request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "code": current}, nil)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var second handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &second)
+		Expect(second.Token).ToNot(BeEmpty())
+		Expect(second.User.ID).To(Equal(admin.ID))
+
+		// The same code cannot be used twice
+		Expect(request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "code": current}, nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("accepts each recovery code once", func() {
+		_, recovery := enroll()
+
+		first := login("admin", "Admin@123")
+		body := map[string]string{"mfa_token": first.MFAToken, "recovery_code": recovery[0]}
+		Expect(request("POST", "/users/login/mfa", body, nil).Code).To(Equal(http.StatusOK))
+		Expect(request("POST", "/users/login/mfa", body, nil).Code).To(Equal(http.StatusUnauthorized))
+
+		w := request("GET", "/mfa", nil, asAdmin())
+		var status handlers.MFAStatus
+		json.Unmarshal(w.Body.Bytes(), &status)
+		Expect(status.Enabled).To(BeTrue())
+		Expect(status.RecoveryCodesLeft).To(Equal(9))
+	})
+
+	It("replaces recovery codes and disables with a code", func() {
+		secret, recovery := enroll()
+
+		w := request("POST", "/mfa/recovery-codes", map[string]string{"code": code(secret)}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		fake.Advance(mfa.Period)
+
+		first := login("admin", "Admin@123")
+		Expect(request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "recovery_code": recovery[0]}, nil).Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(request("DELETE", "/mfa", map[string]string{"code": "000000"}, asAdmin()).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("DELETE", "/mfa", map[string]string{"code": code(secret)}, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(login("admin", "Admin@123").Token).ToNot(BeEmpty())
+	})
+
+	It("makes a role enroll at login once it is required", func() {
+		request("POST", "/users", map[string]string{"username": "dana", "password": "pw"}, nil)
+		w := request("PUT", "/mfa/roles/customer", map[string]bool{"required": true}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(request("PUT", "/mfa/roles/owner", map[string]bool{"required": true}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+
+		first := login("dana", "pw")
+		Expect(first.MFARequired).To(BeTrue())
+		Expect(first.MFAEnrollmentRequired).To(BeTrue())
+
+		w = request("POST", "/users/login/mfa/enroll", map[string]string{"mfa_token": first.MFAToken}, nil)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var enrollment handlers.MFAEnrollmentResponse
+		json.Unmarshal(w.Body.Bytes(), &enrollment)
+
+		w = request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "code": code(enrollment.Secret)}, nil)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var second handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &second)
+		Expect(second.Token).ToNot(BeEmpty())
+		Expect(second.RecoveryCodes).To(HaveLen(10))
+
+		// A required second factor cannot be turned off by the user, only
+		// reset by an admin
+		fake.Advance(mfa.Period)
+		dana := map[string]string{"Authorization": "Bearer " + second.Token}
+		Expect(request("DELETE", "/mfa", map[string]string{"code": code(enrollment.Secret)}, dana).Code).To(Equal(http.StatusForbidden))
+		Expect(request("DELETE", "/users/"+itoa(second.User.ID)+"/mfa", nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(login("dana", "pw").MFAEnrollmentRequired).To(BeTrue())
+	})
+
+	It("counts wrong codes towards the lockout", func() {
+		enroll()
+		first := login("admin", "Admin@123")
+		for i := 0; i < 3; i++ {
+			request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "code": "000000"}, nil)
+		}
+		w := request("POST", "/users/login/mfa", map[string]string{"mfa_token": first.MFAToken, "code": "000000"}, nil)
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("refuses expired or forged challenge tokens", func() {
+		Expect(request("POST", "/users/login/mfa", map[string]string{"mfa_token": token, "code": "123456"}, nil).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("POST", "/users/login/mfa/enroll", map[string]string{"mfa_token": "nonsense"}, nil).Code).To(Equal(http.StatusUnauthorized))
+	})
+})
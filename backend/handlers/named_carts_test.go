@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Named Cart Handlers", func() {
+	var admin *models.User
+
+	decode := func(w *httptest.ResponseRecorder) handlers.CartResponse {
+		var cart handlers.CartResponse
+		json.Unmarshal(w.Body.Bytes(), &cart)
+		return cart
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.GET("/carts/user", handlers.GetUserCart)
+		auth.GET("/carts/mine", handlers.GetMyCarts)
+		auth.POST("/carts/mine", handlers.CreateCart)
+		auth.PUT("/carts/:id", handlers.RenameCart)
+		auth.DELETE("/carts/:id", handlers.DeleteCart)
+		auth.POST("/carts/:id/switch", handlers.SwitchCart)
+		auth.POST("/carts/:id/items/:item_id/move", handlers.MoveCartLine)
+		auth.POST("/carts/:id/items/:item_id/save", handlers.SaveForLater)
+		auth.GET("/carts/saved", handlers.GetSavedForLater)
+		auth.POST("/orders", handlers.CreateOrder)
+	})
+
+	It("creates, renames and switches between carts", func() {
+		w := request("POST", "/carts/mine", map[string]interface{}{"name": "Gifts"}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		gifts := decode(w)
+		Expect(gifts.Current).To(BeFalse())
+
+		Expect(request("PUT", "/carts/"+itoa(gifts.ID), map[string]string{"name": "Birthday"}, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(database.DB.Carts[gifts.ID].Name).To(Equal("Birthday"))
+
+		Expect(request("POST", "/carts/"+itoa(gifts.ID)+"/switch", nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(admin.CartID).To(Equal(gifts.ID))
+		Expect(decode(request("GET", "/carts/user", nil, asAdmin())).ID).To(Equal(gifts.ID))
+
+		var carts []handlers.CartResponse
+		json.Unmarshal(request("GET", "/carts/mine", nil, asAdmin()).Body.Bytes(), &carts)
+		Expect(carts).To(HaveLen(2))
+		Expect(carts[0].Current).To(BeFalse())
+		Expect(carts[1].Current).To(BeTrue())
+	})
+
+	It("adds to a chosen cart and moves lines between carts", func() {
+		first := admin.CartID
+		second := decode(request("POST", "/carts/mine", map[string]interface{}{"name": "Office"}, asAdmin())).ID
+
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 5, "quantity": 3}, asAdmin()).Code).To(Equal(http.StatusCreated))
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 5, "cart_id": second}, asAdmin()).Code).To(Equal(http.StatusCreated))
+
+		w := request("POST", "/carts/"+itoa(first)+"/items/5/move", map[string]interface{}{"cart_id": second, "quantity": 2}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(decode(w).CartItems[0].Quantity).To(Equal(3))
+		Expect(database.DB.CartItems[itoa(first)+"-5"].Quantity).To(Equal(1))
+
+		Expect(request("POST", "/carts/"+itoa(first)+"/items/5/move", map[string]interface{}{"cart_id": second, "quantity": 5}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+		Expect(request("POST", "/carts/"+itoa(first)+"/items/9/move", map[string]interface{}{"cart_id": second}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+		Expect(request("POST", "/carts/"+itoa(first)+"/items/5/move", map[string]interface{}{"cart_id": 9999}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("saves items for later and brings them back", func() {
+		request("POST", "/carts", map[string]interface{}{"item_id": 2}, asAdmin())
+		current := admin.CartID
+
+		w := request("POST", "/carts/"+itoa(current)+"/items/2/save", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		saved := decode(w)
+		Expect(saved.Status).To(Equal(models.CartStatusSaved))
+		Expect(saved.CartItems).To(HaveLen(1))
+		Expect(decode(request("GET", "/carts/user", nil, asAdmin())).CartItems).To(BeEmpty())
+		Expect(decode(request("GET", "/carts/saved", nil, asAdmin())).ID).To(Equal(saved.ID))
+
+		// Saved items cannot be checked out directly
+		Expect(request("POST", "/orders", map[string]interface{}{"cart_id": saved.ID}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+
+		w = request("POST", "/carts/"+itoa(saved.ID)+"/items/2/move", map[string]interface{}{}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(decode(w).ID).To(Equal(current))
+		Expect(decode(w).CartItems).To(HaveLen(1))
+	})
+
+	It("checks out a chosen cart and leaves the current cart alone", func() {
+		request("POST", "/carts", map[string]interface{}{"item_id": 1}, asAdmin())
+		current := admin.CartID
+		office := decode(request("POST", "/carts/mine", map[string]interface{}{"name": "Office"}, asAdmin())).ID
+		request("POST", "/carts", map[string]interface{}{"item_id": 4, "cart_id": office}, asAdmin())
+
+		w := request("POST", "/orders", map[string]interface{}{"cart_id": office}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var order models.Order
+		json.Unmarshal(w.Body.Bytes(), &order)
+		Expect(order.CartID).To(Equal(office))
+		Expect(order.Totals.Subtotal).To(Equal(int64(4999)))
+
+		Expect(database.DB.Carts[office].Status).To(Equal(models.CartStatusOrdered))
+		Expect(admin.CartID).To(Equal(current))
+		Expect(request("POST", "/orders", map[string]interface{}{"cart_id": office}, asAdmin()).Code).To(Equal(http.StatusConflict))
+	})
+
+	It("switches to another cart when the current one is deleted", func() {
+		current := admin.CartID
+		other := decode(request("POST", "/carts/mine", map[string]interface{}{"name": "Other"}, asAdmin())).ID
+		request("POST", "/carts", map[string]interface{}{"item_id": 1}, asAdmin())
+
+		Expect(request("DELETE", "/carts/"+itoa(current), nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(database.DB.Carts).ToNot(HaveKey(current))
+		Expect(database.DB.CartItems).ToNot(HaveKey(itoa(current) + "-1"))
+		Expect(admin.CartID).To(Equal(other))
+
+		Expect(request("DELETE", "/carts/"+itoa(other), nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(admin.CartID).ToNot(Equal(other))
+		Expect(database.DB.Carts[admin.CartID].Name).To(Equal("Default Cart"))
+	})
+})
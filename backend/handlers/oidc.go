@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/oidc"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDC signs users in with an external identity provider. It is nil, and
+// those routes answer 404, unless a provider is configured.
+var OIDC *oidc.Client
+
+// OIDCLoginTTL is how long a user has to come back from the provider.
+var OIDCLoginTTL = 10 * time.Minute
+
+type OIDCStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"` // send the user's browser here
+	State            string `json:"state"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// usernameUnsafe matches what is left out of usernames made from provider
+// claims.
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// oidcConfigured answers 404 and returns false when no provider is set up.
+func oidcConfigured(c *gin.Context) bool {
+	if OIDC == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return false
+	}
+	return true
+}
+
+// startOIDCLogin sends the user to the provider, remembering the state,
+// nonce and PKCE verifier for the callback. userID is set when a signed-in
+// user is linking an identity.
+func startOIDCLogin(c *gin.Context, userID uint) {
+	if !oidcConfigured(c) {
+		return
+	}
+	var secrets [3]string
+	for i := range secrets {
+		s, err := oidc.NewVerifier()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		secrets[i] = s
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := OIDC.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	database.DB.OIDCLogins[state] = &models.OIDCLogin{
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		UserID:    userID,
+		ExpiresAt: Clock.Now().Add(OIDCLoginTTL),
+	}
+	database.DB.Mutex.Unlock()
+
+	c.JSON(http.StatusOK, OIDCStartResponse{AuthorizationURL: authURL, State: state})
+}
+
+// StartOIDCLogin begins signing in with the identity provider.
+func StartOIDCLogin(c *gin.Context) {
+	startOIDCLogin(c, 0)
+}
+
+// LinkOIDCIdentity begins linking the current user to an identity at the
+// provider. It is finished by the same callback as a login.
+func LinkOIDCIdentity(c *gin.Context) {
+	startOIDCLogin(c, c.GetUint("user_id"))
+}
+
+// identityUser finds the user an identity belongs to, linking or creating
+// one as needed:
+//   - an identity seen before signs in as its user;
+//   - a signed-in user linking gets the identity;
+//   - otherwise a user whose verified email the provider has also verified
+//     is linked;
+//   - otherwise a new customer is created, unless the email is already
+//     someone else's.
+//
+// When none of these apply it returns why instead of a user.
+// Callers must hold database.DB.Mutex.
+func identityUser(c *gin.Context, login *models.OIDCLogin, claims *oidc.Claims, now time.Time) (*models.User, string) {
+	var identity *models.UserIdentity
+	for _, i := range database.DB.UserIdentities {
+		if i.Issuer == claims.Issuer && i.Subject == claims.Subject {
+			identity = i
+			break
+		}
+	}
+
+	var user *models.User
+	switch {
+	case identity != nil:
+		if login.UserID != 0 && identity.UserID != login.UserID {
+			return nil, "This identity is linked to another account"
+		}
+		user = database.DB.Users[identity.UserID]
+		if user == nil {
+			return nil, "The linked account no longer exists"
+		}
+	case login.UserID != 0:
+		user = database.DB.Users[login.UserID]
+		if user == nil {
+			return nil, "The account no longer exists"
+		}
+	case claims.Email != "" && userByEmail(claims.Email) != nil:
+		user = userByEmail(claims.Email)
+		if !claims.EmailVerified || !user.EmailVerified {
+			return nil, "An account already uses this email; sign in and link the identity from there"
+		}
+	default:
+		user = registerUser(c, oidcUsername(claims), claims.Email, "", map[string]string{"method": "oidc", "issuer": claims.Issuer})
+		user.EmailVerified = claims.Email != "" && claims.EmailVerified
+		if user.Email != "" && !user.EmailVerified {
+			sendVerification(user)
+		}
+	}
+
+	if identity == nil {
+		identity = &models.UserIdentity{
+			ID:        database.DB.GetNextID(),
+			UserID:    user.ID,
+			Issuer:    claims.Issuer,
+			Subject:   claims.Subject,
+			CreatedAt: now,
+		}
+		database.DB.UserIdentities[identity.ID] = identity
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserIdentityLinked,
+			ActorID:    user.ID,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Metadata:   map[string]string{"issuer": identity.Issuer, "subject": identity.Subject},
+		})
+	}
+	identity.Email = claims.Email
+	identity.LastLoginAt = &now
+	return user, ""
+}
+
+// oidcUsername picks an unused username for a user created from provider
+// claims.
+// Callers must hold database.DB.Mutex.
+func oidcUsername(claims *oidc.Claims) string {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameUnsafe.ReplaceAllString(base, ""), ".-_")
+	if base == "" {
+		base = "user"
+	}
+
+	taken := map[string]bool{}
+	for _, u := range database.DB.Users {
+		taken[u.Username] = true
+	}
+	username := base
+	for n := 2; taken[username]; n++ {
+		username = fmt.Sprintf("%s%d", base, n)
+	}
+	return username
+}
+
+// CompleteOIDCLogin finishes a sign-in when the user comes back from the
+// provider with a code. The response is the same as a password login's,
+// including the two-factor challenge for users who have it on.
+func CompleteOIDCLogin(c *gin.Context) {
+	if !oidcConfigured(c) {
+		return
+	}
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Each state is good for one try
+	database.DB.Mutex.Lock()
+	login, exists := database.DB.OIDCLogins[req.State]
+	delete(database.DB.OIDCLogins, req.State)
+	database.DB.Mutex.Unlock()
+	if !exists || !Clock.Now().Before(login.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in expired or not recognised, please start again"})
+		return
+	}
+
+	// Talk to the provider without holding the database lock
+	var claims *oidc.Claims
+	token, err := OIDC.Exchange(c.Request.Context(), req.Code, login.Verifier)
+	if err == nil {
+		claims, err = OIDC.Verify(c.Request.Context(), token.IDToken, login.Nonce, Clock.Now())
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if err != nil {
+		recordAudit(c, models.AuditEntry{
+			Action:   AuditUserLogin,
+			Outcome:  models.AuditFailure,
+			Metadata: map[string]string{"method": "oidc", "reason": err.Error()},
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in with the identity provider failed"})
+		return
+	}
+
+	user, conflict := identityUser(c, login, claims, Clock.Now())
+	if user == nil {
+		recordAudit(c, models.AuditEntry{
+			Action:   AuditUserLogin,
+			Outcome:  models.AuditFailure,
+			Metadata: map[string]string{"method": "oidc", "issuer": claims.Issuer, "subject": claims.Subject, "reason": conflict},
+		})
+		c.JSON(http.StatusConflict, gin.H{"error": conflict})
+		return
+	}
+
+	if challengeMFA(c, user) {
+		return
+	}
+	completeLogin(c, user, LoginResponse{}, map[string]string{"method": "oidc", "issuer": claims.Issuer})
+}
+
+// GetUserIdentities lists the provider identities linked to the current
+// user.
+func GetUserIdentities(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	identities := []models.UserIdentity{}
+	for _, identity := range database.DB.UserIdentities {
+		if identity.UserID == userID {
+			identities = append(identities, *identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].ID < identities[j].ID })
+	c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkUserIdentity removes one of the current user's identities. The last
+// one cannot be removed from a user who has no password to sign in with.
+func UnlinkUserIdentity(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var identityID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &identityID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	identity, exists := database.DB.UserIdentities[identityID]
+	if !exists || identity.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+	linked := 0
+	for _, i := range database.DB.UserIdentities {
+		if i.UserID == userID {
+			linked++
+		}
+	}
+	if user := database.DB.Users[userID]; linked == 1 && user != nil && user.Password == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Set a password with a password reset before removing your only way to sign in"})
+		return
+	}
+	delete(database.DB.UserIdentities, identityID)
+
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserIdentityUnlinked,
+		TargetType: AuditTargetUser,
+		TargetID:   userID,
+		Metadata:   map[string]string{"issuer": identity.Issuer, "subject": identity.Subject},
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
+
+// PruneOIDCLogins drops sign-ins the user never came back from.
+func PruneOIDCLogins(now time.Time) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for state, login := range database.DB.OIDCLogins {
+		if !now.Before(login.ExpiresAt) {
+			delete(database.DB.OIDCLogins, state)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/mailer"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/oidc"
+	"ecommerce-backend/oidc/oidctest"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenID Connect login", func() {
+	var (
+		provider *oidctest.Provider
+		fake     *clock.Fake
+	)
+
+	// start begins a sign-in, or a link when headers sign a user in, and
+	// returns the code and state the provider sends the browser back with
+	start := func(path string, identity oidctest.Identity, headers map[string]string) (string, string) {
+		provider.SignIn(identity)
+		w := request("POST", path, nil, headers)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var started handlers.OIDCStartResponse
+		json.Unmarshal(w.Body.Bytes(), &started)
+		code, state, err := provider.Authorize(started.AuthorizationURL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(started.State))
+		return code, state
+	}
+
+	finish := func(code, state string) (*httptest.ResponseRecorder, handlers.LoginResponse) {
+		w := request("POST", "/users/login/oidc/callback", map[string]string{"code": code, "state": state}, nil)
+		var login handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &login)
+		return w, login
+	}
+
+	signIn := func(identity oidctest.Identity) (*httptest.ResponseRecorder, handlers.LoginResponse) {
+		return finish(start("/users/login/oidc", identity, nil))
+	}
+
+	// register creates a password user and returns their bearer header
+	register := func(username, email string) (*models.User, map[string]string) {
+		Expect(request("POST", "/users", map[string]string{"username": username, "password": "pass-word", "email": email}, nil).Code).To(Equal(http.StatusCreated))
+		var login handlers.LoginResponse
+		json.Unmarshal(request("POST", "/users/login", map[string]string{"username": username, "password": "pass-word"}, nil).Body.Bytes(), &login)
+		return database.DB.Users[login.User.ID], map[string]string{"Authorization": "Bearer " + login.Token}
+	}
+
+	ada := oidctest.Identity{Subject: "sub-ada", Email: "ada@example.com", EmailVerified: true, PreferredUsername: "ada"}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+		handlers.Mailer = &mailer.Memory{}
+
+		provider = oidctest.NewProvider("shop", "s3cret")
+		provider.Now = fake.Now
+		handlers.OIDC = oidc.NewClient(oidc.Config{
+			Issuer:       provider.Issuer,
+			ClientID:     "shop",
+			ClientSecret: "s3cret",
+			RedirectURL:  "http://localhost:3000/login/callback",
+		}, nil)
+
+		newTestRouter()
+		router.POST("/users", handlers.CreateUser)
+		router.POST("/users/login", handlers.LoginUser)
+		router.POST("/users/login/oidc", handlers.StartOIDCLogin)
+		router.POST("/users/login/oidc/callback", handlers.CompleteOIDCLogin)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.GET("/users/identities", handlers.GetUserIdentities)
+		auth.POST("/users/identities/oidc", handlers.LinkOIDCIdentity)
+		auth.DELETE("/users/identities/:id", handlers.UnlinkUserIdentity)
+	})
+
+	AfterEach(func() {
+		provider.Close()
+		handlers.OIDC = nil
+		handlers.Clock = clock.Real{}
+		handlers.Mailer = mailer.Log{}
+	})
+
+	It("creates a customer the first time and signs them in after", func() {
+		users := len(database.DB.Users)
+		w, login := signIn(ada)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(login.Token).ToNot(BeEmpty())
+		Expect(login.User.Username).To(Equal("ada"))
+		Expect(login.User.Email).To(Equal("ada@example.com"))
+		Expect(login.User.EmailVerified).To(BeTrue())
+		Expect(login.User.Role).To(Equal(models.RoleCustomer))
+		Expect(database.DB.Users).To(HaveLen(users + 1))
+
+		w, again := signIn(ada)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(again.User.ID).To(Equal(login.User.ID))
+		Expect(database.DB.Users).To(HaveLen(users + 1))
+	})
+
+	It("gives new users an unused username and no password", func() {
+		_, login := signIn(oidctest.Identity{Subject: "sub-x", PreferredUsername: "admin"})
+		Expect(login.User.Username).To(Equal("admin2"))
+
+		// Not even the dummy hash's password gets in
+		for _, password := range []string{"", "not anyone's password"} {
+			Expect(request("POST", "/users/login", map[string]string{"username": "admin2", "password": password}, nil).Code).ToNot(Equal(http.StatusOK))
+		}
+	})
+
+	It("links a verified email to the existing account", func() {
+		user, _ := register("ada_l", "ada@example.com")
+		user.EmailVerified = true
+
+		w, login := signIn(ada)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(login.User.ID).To(Equal(user.ID))
+	})
+
+	It("does not link an email either side has not verified", func() {
+		user, _ := register("ada_l", "ada@example.com")
+		w, _ := signIn(ada)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		user.EmailVerified = true
+		unverified := ada
+		unverified.EmailVerified = false
+		w, _ = signIn(unverified)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+		Expect(database.DB.UserIdentities).To(BeEmpty())
+	})
+
+	It("lets a signed-in user link and unlink an identity", func() {
+		user, headers := register("grace", "grace@example.com")
+
+		code, state := start("/users/identities/oidc", ada, headers)
+		w, login := finish(code, state)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(login.User.ID).To(Equal(user.ID))
+
+		var identities []models.UserIdentity
+		json.Unmarshal(request("GET", "/users/identities", nil, headers).Body.Bytes(), &identities)
+		Expect(identities).To(HaveLen(1))
+		Expect(identities[0].Subject).To(Equal("sub-ada"))
+
+		// The identity now signs in as grace, and cannot be linked elsewhere
+		_, login = signIn(ada)
+		Expect(login.User.ID).To(Equal(user.ID))
+		_, other := register("linus", "linus@example.com")
+		w, _ = finish(start("/users/identities/oidc", ada, other))
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		Expect(request("DELETE", "/users/identities/"+itoa(identities[0].ID), nil, other).Code).To(Equal(http.StatusNotFound))
+		Expect(request("DELETE", "/users/identities/"+itoa(identities[0].ID), nil, headers).Code).To(Equal(http.StatusOK))
+		Expect(database.DB.UserIdentities).To(BeEmpty())
+	})
+
+	It("keeps the only way in for users without a password", func() {
+		_, login := signIn(ada)
+		headers := map[string]string{"Authorization": "Bearer " + login.Token}
+		var identities []models.UserIdentity
+		json.Unmarshal(request("GET", "/users/identities", nil, headers).Body.Bytes(), &identities)
+
+		Expect(request("DELETE", "/users/identities/"+itoa(identities[0].ID), nil, headers).Code).To(Equal(http.StatusConflict))
+	})
+
+	It("accepts each state once and only for a while", func() {
+		code, state := start("/users/login/oidc", ada, nil)
+		w, _ := finish("forged-code", state)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		w, _ = finish(code, state)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		code, state = start("/users/login/oidc", ada, nil)
+		fake.Advance(handlers.OIDCLoginTTL)
+		w, _ = finish(code, state)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		start("/users/login/oidc", ada, nil)
+		handlers.PruneOIDCLogins(fake.Now().Add(handlers.OIDCLoginTTL))
+		Expect(database.DB.OIDCLogins).To(BeEmpty())
+	})
+
+	It("still asks users with two-factor login for a code", func() {
+		_, login := signIn(ada)
+		database.DB.Users[login.User.ID].MFAEnabled = true
+
+		w, challenged := signIn(ada)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(challenged.MFARequired).To(BeTrue())
+		Expect(challenged.Token).To(BeEmpty())
+	})
+
+	It("answers 404 when no provider is configured", func() {
+		handlers.OIDC = nil
+		Expect(request("POST", "/users/login/oidc", nil, nil).Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"bytes"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NextCursorHeader carries the cursor for the next page of a listing. It is
+// left out on the last page.
+const NextCursorHeader = "X-Next-Cursor"
+
+// defaultOrderPageSize is the page size when a listing gives no limit.
+const defaultOrderPageSize = 50
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// OrderQuery filters, sorts and pages an order listing. Dates are RFC 3339
+// timestamps or YYYY-MM-DD days; a day given as To includes all of it.
+// Sort is created_at, total or id, prefixed with - for descending, and
+// defaults to newest first.
+type OrderQuery struct {
+	Status   string `form:"status"` // comma-separated
+	UserID   uint   `form:"user_id"`
+	ItemID   uint   `form:"item_id"`
+	From     string `form:"from"`
+	To       string `form:"to"`
+	MinTotal *int64 `form:"min_total"`
+	MaxTotal *int64 `form:"max_total"`
+	Sort     string `form:"sort"`
+	Limit    int    `form:"limit" binding:"omitempty,min=1,max=200"`
+	Cursor   string `form:"cursor"`
+	Format   string `form:"format" binding:"omitempty,oneof=json csv"`
+}
+
+// orderSortKeys are the fields an order listing can be sorted by.
+var orderSortKeys = map[string]func(*models.Order) int64{
+	"created_at": func(order *models.Order) int64 { return order.CreatedAt.UnixNano() },
+	"total":      func(order *models.Order) int64 { return order.Totals.Total },
+	"id":         func(order *models.Order) int64 { return int64(order.ID) },
+}
+
+// orderFilter is a parsed OrderQuery.
+type orderFilter struct {
+	statuses map[string]bool
+	userID   uint
+	itemID   uint
+	from, to time.Time
+	minTotal *int64
+	maxTotal *int64
+	sort     string
+	key      func(*models.Order) int64
+	desc     bool
+	limit    int
+	after    *orderCursor
+}
+
+// orderCursor marks the last order of a page by its sort value and ID.
+type orderCursor struct {
+	value int64
+	id    uint
+}
+
+// parseOrderDate reads an RFC 3339 timestamp or a YYYY-MM-DD day. A day
+// read as an upper bound runs to its last instant.
+func parseOrderDate(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if end {
+		return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+// encodeCursor makes an opaque cursor pointing after an order. The sort is
+// part of the cursor so it cannot be reused with a different one.
+func encodeCursor(sortBy string, value int64, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d|%d", sortBy, value, id)))
+}
+
+// decodeCursor reads a cursor made by encodeCursor for the same sort.
+func decodeCursor(cursor, sortBy string) (*orderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 || parts[0] != sortBy {
+		return nil, errInvalidCursor
+	}
+	value, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 0)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &orderCursor{value: value, id: uint(id)}, nil
+}
+
+// parseOrderQuery checks a query and turns it into a filter.
+func parseOrderQuery(q OrderQuery) (*orderFilter, error) {
+	f := &orderFilter{
+		userID:   q.UserID,
+		itemID:   q.ItemID,
+		minTotal: q.MinTotal,
+		maxTotal: q.MaxTotal,
+		sort:     q.Sort,
+		limit:    q.Limit,
+	}
+	if f.sort == "" {
+		f.sort = "-created_at"
+	}
+	f.key = orderSortKeys[strings.TrimPrefix(f.sort, "-")]
+	if f.key == nil {
+		return nil, fmt.Errorf("cannot sort by %q", f.sort)
+	}
+	f.desc = strings.HasPrefix(f.sort, "-")
+	if f.limit == 0 {
+		f.limit = defaultOrderPageSize
+	}
+
+	if q.Status != "" {
+		f.statuses = map[string]bool{}
+		for _, status := range strings.Split(q.Status, ",") {
+			f.statuses[strings.TrimSpace(status)] = true
+		}
+	}
+
+	var err error
+	if q.From != "" {
+		if f.from, err = parseOrderDate(q.From, false); err != nil {
+			return nil, err
+		}
+	}
+	if q.To != "" {
+		if f.to, err = parseOrderDate(q.To, true); err != nil {
+			return nil, err
+		}
+	}
+	if !f.from.IsZero() && !f.to.IsZero() && f.to.Before(f.from) {
+		return nil, errors.New("to is before from")
+	}
+	if f.minTotal != nil && f.maxTotal != nil && *f.maxTotal < *f.minTotal {
+		return nil, errors.New("max_total is below min_total")
+	}
+
+	if q.Cursor != "" {
+		if f.after, err = decodeCursor(q.Cursor, f.sort); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// matches reports whether an order passes every filter.
+func (f *orderFilter) matches(order *models.Order) bool {
+	if f.statuses != nil && !f.statuses[order.Status] {
+		return false
+	}
+	if f.userID != 0 && order.UserID != f.userID {
+		return false
+	}
+	if !f.from.IsZero() && order.CreatedAt.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && order.CreatedAt.After(f.to) {
+		return false
+	}
+	if f.minTotal != nil && order.Totals.Total < *f.minTotal {
+		return false
+	}
+	if f.maxTotal != nil && order.Totals.Total > *f.maxTotal {
+		return false
+	}
+	if f.itemID != 0 {
+		for _, line := range order.Totals.Lines {
+			if line.ItemID == f.itemID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// before reports whether an order at (value, id) is listed before one at
+// (otherValue, otherID). The ID breaks ties so the order is total.
+func (f *orderFilter) before(value int64, id uint, otherValue int64, otherID uint) bool {
+	if value != otherValue {
+		return (value < otherValue) != f.desc
+	}
+	return (id < otherID) != f.desc
+}
+
+// listOrders returns copies of the matching orders in sort order, starting
+// after the filter's cursor. With paged set it stops after one page and
+// also returns the cursor for the next, if there is one.
+// Callers must hold database.DB.Mutex.
+func listOrders(f *orderFilter, paged bool) ([]models.Order, string) {
+	matched := []*models.Order{}
+	for _, order := range database.DB.Orders {
+		if !f.matches(order) {
+			continue
+		}
+		if f.after != nil && !f.before(f.after.value, f.after.id, f.key(order), order.ID) {
+			continue
+		}
+		matched = append(matched, order)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return f.before(f.key(matched[i]), matched[i].ID, f.key(matched[j]), matched[j].ID)
+	})
+
+	next := ""
+	if paged && len(matched) > f.limit {
+		matched = matched[:f.limit]
+		last := matched[len(matched)-1]
+		next = encodeCursor(f.sort, f.key(last), last.ID)
+	}
+
+	orders := make([]models.Order, 0, len(matched))
+	for _, order := range matched {
+		orders = append(orders, orderWithCart(order))
+	}
+	return orders, next
+}
+
+// orderWithCart copies an order along with the lines of its cart.
+// Callers must hold database.DB.Mutex.
+func orderWithCart(order *models.Order) models.Order {
+	copied := *order
+	if cart, exists := database.DB.Carts[order.CartID]; exists {
+		cartWithItems := *cart
+		cartWithItems.CartItems = []models.CartItem{}
+		for _, cartItem := range database.DB.CartItems {
+			if cartItem.CartID == cart.ID {
+				cartWithItems.CartItems = append(cartWithItems.CartItems, *cartItem)
+			}
+		}
+		copied.Cart = cartWithItems
+	}
+	return copied
+}
+
+// writeOrdersCSV sends orders as a CSV file. Amounts are in cents.
+// Callers must hold database.DB.Mutex.
+func writeOrdersCSV(c *gin.Context, orders []models.Order) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		"id", "created_at", "user_id", "username", "status", "currency", "item_count",
+		"subtotal", "discount_total", "shipping", "tax", "total", "paid", "refunded", "outstanding",
+	})
+	for _, order := range orders {
+		username := ""
+		if user, exists := database.DB.Users[order.UserID]; exists {
+			username = user.Username
+		}
+		t := order.Totals
+		w.Write([]string{
+			strconv.FormatUint(uint64(order.ID), 10),
+			order.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(order.UserID), 10),
+			username,
+			order.Status,
+			t.Currency,
+			strconv.Itoa(t.ItemCount),
+			strconv.FormatInt(t.Subtotal, 10),
+			strconv.FormatInt(t.DiscountTotal, 10),
+			strconv.FormatInt(t.Shipping-t.ShippingDiscount, 10),
+			strconv.FormatInt(t.Tax, 10),
+			strconv.FormatInt(t.Total, 10),
+			strconv.FormatInt(order.Balance.Paid, 10),
+			strconv.FormatInt(order.Balance.Refunded, 10),
+			strconv.FormatInt(order.Balance.Outstanding, 10),
+		})
+	}
+	w.Flush()
+
+	c.Header("Content-Disposition", `attachment; filename="orders.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// searchOrders answers an order listing. The user filter is forced to
+// userID unless it is zero; other searches are audited as reads of other
+// users' data.
+func searchOrders(c *gin.Context, userID uint) {
+	var q OrderQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if userID != 0 {
+		q.UserID = userID
+	}
+	f, err := parseOrderQuery(q)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	if userID == 0 {
+		auditRead(c, AuditTargetOrder, 0, q.UserID, map[string]string{"query": c.Request.URL.RawQuery})
+	}
+
+	// An export holds every matching order, not one page
+	if q.Format == "csv" {
+		orders, _ := listOrders(f, false)
+		writeOrdersCSV(c, orders)
+		return
+	}
+
+	orders, next := listOrders(f, true)
+	if next != "" {
+		c.Header(NextCursorHeader, next)
+	}
+	c.JSON(http.StatusOK, orders)
+}
+
+// GetOrders searches all orders. See OrderQuery for the filters.
+func GetOrders(c *gin.Context) {
+	searchOrders(c, 0)
+}
+
+// GetUserOrders searches the current user's orders.
+func GetUserOrders(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	searchOrders(c, userID.(uint))
+}
+
+// GetOrder returns one order to its owner or an admin.
+func GetOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || !orderVisibleTo(order, userID.(uint)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	auditRead(c, AuditTargetOrder, order.ID, order.UserID, nil)
+	c.JSON(http.StatusOK, orderWithCart(order))
+}
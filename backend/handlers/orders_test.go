@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Orders", func() {
+	var (
+		admin  *models.User
+		orders []models.Order
+	)
+
+	// list fetches an order listing and returns the order IDs and the
+	// cursor for the next page
+	list := func(path string, headers map[string]string) ([]uint, string) {
+		w := request("GET", path, nil, headers)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var page []models.Order
+		json.Unmarshal(w.Body.Bytes(), &page)
+		ids := []uint{}
+		for _, order := range page {
+			ids = append(ids, order.ID)
+		}
+		return ids, w.Header().Get(handlers.NextCursorHeader)
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.GET("/orders/user", handlers.GetUserOrders)
+		auth.GET("/orders/:id", handlers.GetOrder)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.GET("/orders", handlers.GetOrders)
+
+		// Three orders a day apart: item 1, item 5, and item 1 again still
+		// waiting on payment
+		orders = nil
+		for i, line := range []struct {
+			item   uint
+			method string
+		}{{1, payments.FakeCardApproved}, {5, payments.FakeCardApproved}, {1, payments.FakeCard3DS}} {
+			request("POST", "/carts", map[string]interface{}{"item_id": line.item, "quantity": 1}, asAdmin())
+			request("POST", "/orders", map[string]string{"payment_method": line.method}, asAdmin())
+
+			var newest *models.Order
+			for _, order := range database.DB.Orders {
+				if newest == nil || order.ID > newest.ID {
+					newest = order
+				}
+			}
+			newest.CreatedAt = time.Date(2024, 3, 1+i, 12, 0, 0, 0, time.UTC)
+			orders = append(orders, *newest)
+		}
+		Expect(orders).To(HaveLen(3))
+	})
+
+	It("shows one order to its owner or an admin", func() {
+		w := request("GET", "/orders/"+itoa(orders[0].ID), nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var order models.Order
+		json.Unmarshal(w.Body.Bytes(), &order)
+		Expect(order.ID).To(Equal(orders[0].ID))
+		Expect(order.Cart.CartItems).To(HaveLen(1))
+
+		_, other := asCustomer("someone")
+		Expect(request("GET", "/orders/"+itoa(orders[0].ID), nil, other).Code).To(Equal(http.StatusNotFound))
+		Expect(request("GET", "/orders/9999", nil, asAdmin()).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("lists newest first by default", func() {
+		ids, next := list("/orders", asAdmin())
+		Expect(ids).To(Equal([]uint{orders[2].ID, orders[1].ID, orders[0].ID}))
+		Expect(next).To(BeEmpty())
+	})
+
+	It("filters by status, item, date and total", func() {
+		ids, _ := list("/orders?status="+models.OrderStatusPendingPayment, asAdmin())
+		Expect(ids).To(Equal([]uint{orders[2].ID}))
+
+		ids, _ = list("/orders?item_id=1&sort=id", asAdmin())
+		Expect(ids).To(Equal([]uint{orders[0].ID, orders[2].ID}))
+
+		ids, _ = list("/orders?from=2024-03-02&to=2024-03-02", asAdmin())
+		Expect(ids).To(Equal([]uint{orders[1].ID}))
+
+		min := orders[1].Totals.Total
+		ids, _ = list("/orders?sort=total&min_total="+itoa(uint(min))+"&max_total="+itoa(uint(min)), asAdmin())
+		Expect(ids).To(ContainElement(orders[1].ID))
+		Expect(ids).ToNot(ContainElement(orders[0].ID))
+
+		Expect(request("GET", "/orders?from=yesterday", nil, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+		Expect(request("GET", "/orders?sort=name", nil, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("filters by user and keeps customers to their own orders", func() {
+		otherID, headers := asCustomer("someone")
+		database.DB.Orders[orders[1].ID].UserID = otherID
+
+		ids, _ := list("/orders?user_id="+itoa(otherID), asAdmin())
+		Expect(ids).To(Equal([]uint{orders[1].ID}))
+
+		ids, _ = list("/orders/user?user_id="+itoa(admin.ID), headers)
+		Expect(ids).To(Equal([]uint{orders[1].ID}))
+		Expect(request("GET", "/orders", nil, headers).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("pages with a cursor", func() {
+		ids, next := list("/orders?sort=id&limit=2", asAdmin())
+		Expect(ids).To(Equal([]uint{orders[0].ID, orders[1].ID}))
+		Expect(next).ToNot(BeEmpty())
+
+		ids, last := list("/orders?sort=id&limit=2&cursor="+next, asAdmin())
+		Expect(ids).To(Equal([]uint{orders[2].ID}))
+		Expect(last).To(BeEmpty())
+
+		// A cursor only works with the sort it was made for
+		Expect(request("GET", "/orders?sort=-id&cursor="+next, nil, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("exports the filtered orders as CSV", func() {
+		w := request("GET", "/orders?format=csv&item_id=1&sort=id&limit=1", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/csv"))
+
+		rows, err := csv.NewReader(w.Body).ReadAll()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rows).To(HaveLen(3))
+		Expect(rows[0][0]).To(Equal("id"))
+		Expect(rows[1][0]).To(Equal(itoa(orders[0].ID)))
+		Expect(rows[2][4]).To(Equal(models.OrderStatusPendingPayment))
+	})
+})
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentSignatureHeader carries the provider's signature on webhooks.
+const PaymentSignatureHeader = "X-Payment-Signature"
+
+// Payments is the provider that checkout charges. main wires the fake
+// provider's webhooks to ProcessPaymentWebhook; without that, orders that
+// need customer action stay pending.
+var Payments payments.Provider = payments.NewFake(payments.FakeConfig{})
+
+// FakePaymentActionRequest is the customer's answer to a fake 3DS challenge.
+type FakePaymentActionRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// chargeOrder authorizes the order total and, when the customer has nothing
+// left to do, captures it. On error the returned payment describes the
+// failure and nothing is left held at the provider. It waits on the provider,
+// so callers must not hold database.DB.Mutex.
+func chargeOrder(ctx context.Context, order *models.Order, method string) (*models.Payment, error) {
+	now := Clock.Now()
+	payment := &models.Payment{
+		ID:        database.DB.GetNextID(),
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		Provider:  Payments.Name(),
+		Amount:    order.Totals.Total,
+		Currency:  order.Totals.Currency,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	intent, err := Payments.Authorize(ctx, payments.AuthorizeRequest{
+		Amount:        order.Totals.Total,
+		Currency:      order.Totals.Currency,
+		PaymentMethod: method,
+		Reference:     fmt.Sprintf("order-%d", order.ID),
+	})
+	if intent.ID != "" {
+		applyIntent(payment, intent)
+	}
+	if err != nil {
+		return payment, err
+	}
+
+	if intent.Status == payments.StatusAuthorized {
+		captured, err := Payments.Capture(ctx, intent.ID, intent.Amount)
+		if err != nil {
+			Payments.Void(ctx, intent.ID)
+			return payment, err
+		}
+		applyIntent(payment, captured)
+	}
+	return payment, nil
+}
+
+// applyIntent copies the provider's view of an intent onto a payment unless
+// the payment already reflects a later state. It reports whether anything
+// changed.
+func applyIntent(payment *models.Payment, intent payments.Intent) bool {
+	if payment.IntentID != "" && !intent.Supersedes(intentOf(payment)) {
+		return false
+	}
+	payment.IntentID = intent.ID
+	payment.Status = string(intent.Status)
+	payment.Captured = intent.Captured
+	payment.Refunded = intent.Refunded
+	payment.NextAction = intent.NextAction
+	payment.FailureCode = intent.DeclineCode
+	payment.UpdatedAt = Clock.Now()
+	return true
+}
+
+func intentOf(payment *models.Payment) payments.Intent {
+	return payments.Intent{
+		ID:       payment.IntentID,
+		Status:   payments.Status(payment.Status),
+		Amount:   payment.Amount,
+		Currency: payment.Currency,
+		Captured: payment.Captured,
+		Refunded: payment.Refunded,
+	}
+}
+
+// paymentByIntent finds the payment for a provider intent.
+// Callers must hold database.DB.Mutex.
+func paymentByIntent(intentID string) *models.Payment {
+	for _, payment := range database.DB.Payments {
+		if payment.IntentID == intentID {
+			return payment
+		}
+	}
+	return nil
+}
+
+// awaitingPayment reports whether a cart's order is still waiting on the
+// customer to pay. Callers must hold database.DB.Mutex.
+func awaitingPayment(cartID uint) bool {
+	for _, order := range database.DB.Orders {
+		if order.CartID == cartID && order.Status == models.OrderStatusPendingPayment {
+			return true
+		}
+	}
+	return false
+}
+
+// failOrder gives up on an order whose payment failed: its stock goes back,
+// its coupon redemptions are released and its cart is reopened.
+// Callers must hold database.DB.Mutex.
+func failOrder(order *models.Order) {
+	order.Status = models.OrderStatusPaymentFailed
+	restoreStock(order.Cart.CartItems)
+	releaseRedemptions(order)
+	updateBalance(order)
+	addOrderHistory(order, "payment_failed", order.Payment.FailureCode, 0, 0)
+
+	if cart, exists := database.DB.Carts[order.CartID]; exists && cart.Status == models.CartStatusOrdered {
+		cart.Status = models.CartStatusActive
+		touchCart(cart)
+		syncReservations(cart)
+	}
+}
+
+// ProcessPaymentWebhook applies a signed provider event to its payment and
+// order. Authorizing a pending payment captures it and confirms the order;
+// a pending payment that fails or is voided fails the order. Stale and
+// repeated events change nothing, as do events for intents that never
+// became an order.
+func ProcessPaymentWebhook(payload []byte, signature string) error {
+	event, err := Payments.VerifyWebhook(payload, signature)
+	if err != nil {
+		return err
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	payment := paymentByIntent(event.Intent.ID)
+	if payment == nil || !applyIntent(payment, event.Intent) {
+		return nil
+	}
+	order, exists := database.DB.Orders[payment.OrderID]
+	if !exists || order.Status != models.OrderStatusPendingPayment {
+		return nil
+	}
+
+	switch event.Intent.Status {
+	case payments.StatusAuthorized:
+		// A redelivered event applies as well, so only the first one captures
+		if payment.Capturing {
+			return nil
+		}
+		payment.Capturing = true
+		intentID, amount := payment.IntentID, payment.Amount
+		database.DB.Mutex.Unlock()
+		intent, err := Payments.Capture(context.Background(), intentID, amount)
+		database.DB.Mutex.Lock()
+		payment.Capturing = false
+		if err != nil {
+			return err
+		}
+		applyIntent(payment, intent)
+		if order.Status != models.OrderStatusPendingPayment {
+			return nil
+		}
+		order.Status = models.OrderStatusConfirmed
+		updateBalance(order)
+		addOrderHistory(order, "payment_captured", "", 0, 0)
+		issueInvoice(order)
+	case payments.StatusFailed, payments.StatusVoided:
+		failOrder(order)
+	}
+	return nil
+}
+
+// HandlePaymentWebhook receives provider webhooks. Anything but a bad
+// signature that fails is answered with 500 so the provider retries.
+func HandlePaymentWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	if err := ProcessPaymentWebhook(payload, c.GetHeader(PaymentSignatureHeader)); err != nil {
+		if errors.Is(err, payments.ErrInvalidSignature) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// CompleteFakePayment stands in for the bank page a customer is sent to
+// when a payment needs action. It only works with the fake provider; the
+// outcome reaches the order by webhook.
+func CompleteFakePayment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fake, ok := Payments.(*payments.Fake)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment provider has no test actions"})
+		return
+	}
+
+	var req FakePaymentActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	intentID := c.Param("intent_id")
+	database.DB.Mutex.RLock()
+	payment := paymentByIntent(intentID)
+	owned := payment != nil && payment.UserID == userID.(uint)
+	database.DB.Mutex.RUnlock()
+	if !owned {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	intent, err := fake.Complete(intentID, req.Approve)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, intent)
+}
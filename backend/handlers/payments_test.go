@@ -0,0 +1,201 @@
+package handlers_test
+
+import (
+	"bytes"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Payments", func() {
+	var (
+		admin    *models.User
+		provider *payments.Fake
+
+		mu       sync.Mutex
+		webhooks [][2]string
+	)
+
+	pending := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(webhooks)
+	}
+
+	// deliver posts every queued webhook to the endpoint, in order
+	deliver := func() {
+		mu.Lock()
+		queued := webhooks
+		webhooks = nil
+		mu.Unlock()
+		for _, webhook := range queued {
+			req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewBufferString(webhook[0]))
+			req.Header.Set(handlers.PaymentSignatureHeader, webhook[1])
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+		}
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+
+		webhooks = nil
+		provider = payments.NewFake(payments.FakeConfig{
+			Sink: func(payload []byte, signature string) {
+				mu.Lock()
+				webhooks = append(webhooks, [2]string{string(payload), signature})
+				mu.Unlock()
+			},
+		})
+		handlers.Payments = provider
+
+		router.POST("/payments/webhook", handlers.HandlePaymentWebhook)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/payments/fake/:intent_id/complete", handlers.CompleteFakePayment)
+	})
+
+	AfterEach(func() {
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+	})
+
+	It("captures the payment and confirms the order", func() {
+		w, order := checkout(payments.FakeCardApproved)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(order.Status).To(Equal(models.OrderStatusConfirmed))
+		Expect(order.Payment).ToNot(BeNil())
+		Expect(order.Payment.Status).To(Equal(string(payments.StatusCaptured)))
+		Expect(order.Payment.Captured).To(Equal(order.Totals.Total))
+
+		intent, _ := provider.Intent(order.Payment.IntentID)
+		Expect(intent.Status).To(Equal(payments.StatusCaptured))
+	})
+
+	It("ignores the late webhooks for a payment it already captured", func() {
+		_, order := checkout(payments.FakeCardApproved)
+		Eventually(pending).Should(Equal(2))
+		deliver()
+
+		Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusConfirmed))
+		Expect(database.DB.Payments[order.Payment.ID].Status).To(Equal(string(payments.StatusCaptured)))
+	})
+
+	It("places no order and restores stock when the card is declined", func() {
+		w, _ := checkout(payments.FakeCardDeclined)
+		Expect(w.Code).To(Equal(http.StatusPaymentRequired))
+		Expect(w.Body.String()).To(ContainSubstring("card_declined"))
+
+		Expect(database.DB.Orders).To(BeEmpty())
+		Expect(*database.DB.Items[1].Stock).To(Equal(25))
+		Expect(database.DB.Carts[admin.CartID].Status).To(Equal(models.CartStatusActive))
+		Expect(database.DB.Reservations).To(HaveKey(itoa(admin.CartID) + "-1"))
+	})
+
+	It("calls the provider without holding the database lock", func() {
+		checking := &lockCheckingProvider{Provider: provider}
+		handlers.Payments = checking
+
+		w, order := checkout(payments.FakeCardApproved)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		w, pending3DS := checkout(payments.FakeCard3DS)
+		Expect(w.Code).To(Equal(http.StatusAccepted))
+		_, err := provider.Complete(pending3DS.Payment.IntentID, true)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(pending).Should(Equal(3))
+		deliver()
+		w, _ = checkout(payments.FakeCardDeclined)
+		Expect(w.Code).To(Equal(http.StatusPaymentRequired))
+
+		Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusConfirmed))
+		Expect(database.DB.Orders[pending3DS.ID].Status).To(Equal(models.OrderStatusConfirmed))
+		Expect(checking.Held()).To(BeZero())
+	})
+
+	It("captures once when the same webhook arrives twice", func() {
+		checking := &lockCheckingProvider{Provider: provider, gate: make(chan struct{})}
+		handlers.Payments = checking
+
+		_, order := checkout(payments.FakeCard3DS)
+		_, err := provider.Complete(order.Payment.IntentID, true)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(pending).Should(Equal(1))
+		webhook := webhooks[0]
+		send := func() int {
+			req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewBufferString(webhook[0]))
+			req.Header.Set(handlers.PaymentSignatureHeader, webhook[1])
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		first, second := make(chan int, 1), make(chan int, 1)
+		go func() { first <- send() }()
+		Eventually(checking.Captures).Should(Equal(int32(1)))
+		go func() { second <- send() }()
+		Eventually(second).Should(Receive(Equal(http.StatusOK)))
+		close(checking.gate)
+		Eventually(first).Should(Receive(Equal(http.StatusOK)))
+
+		Expect(checking.Captures()).To(Equal(int32(1)))
+		Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusConfirmed))
+		Expect(database.DB.Payments[order.Payment.ID].Status).To(Equal(string(payments.StatusCaptured)))
+	})
+
+	Describe("payments that need customer action", func() {
+		var order models.Order
+
+		BeforeEach(func() {
+			var w *httptest.ResponseRecorder
+			w, order = checkout(payments.FakeCard3DS)
+			Expect(w.Code).To(Equal(http.StatusAccepted))
+			Expect(order.Status).To(Equal(models.OrderStatusPendingPayment))
+			Expect(order.Payment.NextAction).ToNot(BeEmpty())
+			Expect(*database.DB.Items[1].Stock).To(Equal(23))
+		})
+
+		It("confirms the order once the customer passes the challenge", func() {
+			Expect(request("POST", order.Payment.NextAction, map[string]interface{}{"approve": true}, asAdmin()).Code).To(Equal(http.StatusOK))
+			Eventually(pending).Should(Equal(1))
+			deliver()
+
+			Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusConfirmed))
+			Expect(database.DB.Payments[order.Payment.ID].Status).To(Equal(string(payments.StatusCaptured)))
+		})
+
+		It("fails the order and reopens the cart when the challenge fails", func() {
+			Expect(request("POST", order.Payment.NextAction, map[string]interface{}{"approve": false}, asAdmin()).Code).To(Equal(http.StatusOK))
+			Eventually(pending).Should(Equal(1))
+			deliver()
+
+			Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusPaymentFailed))
+			Expect(*database.DB.Items[1].Stock).To(Equal(25))
+			Expect(database.DB.Carts[order.CartID].Status).To(Equal(models.CartStatusActive))
+			Expect(database.DB.Reservations).To(HaveKey(itoa(order.CartID) + "-1"))
+		})
+
+		It("keeps the cart while the payment is pending", func() {
+			handlers.Sweeper.Sweep(handlers.Clock.Now())
+			Expect(database.DB.Carts).To(HaveKey(order.CartID))
+		})
+
+		It("rejects webhooks with a bad signature", func() {
+			req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewBufferString(`{"type":"payment.authorized"}`))
+			req.Header.Set(handlers.PaymentSignatureHeader, "bogus")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusPendingPayment))
+		})
+	})
+})
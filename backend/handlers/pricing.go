@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"ecommerce-backend/promotions"
+	"ecommerce-backend/shipping"
+	"ecommerce-backend/tax"
+)
+
+// Taxes applies the tax rule table. By default catalog prices are
+// tax-exclusive and tax is rounded per line; carts without a destination use
+// DefaultCountry. main sets these from the environment.
+var Taxes = &tax.Calculator{
+	Rules:          allTaxRules,
+	Rounding:       tax.RoundPerLine,
+	DefaultCountry: "US",
+}
+
+// Shipping charges the shipping method selected on the cart.
+var Shipping = &shipping.Calculator{
+	Methods: allShippingMethods,
+	Zones:   allShippingZones,
+}
+
+// Pricer prices every cart and order response so the storefront and
+// checkout always show the same amounts.
+var Pricer = pricing.NewEngine(Shipping, Taxes, &promotions.Discounter{
+	Promotions: allPromotions,
+	Usage:      redemptionUsage{},
+})
+
+// CartResponse is a cart with its computed totals. Current is set on the
+// cart the user is shopping in.
+type CartResponse struct {
+	models.Cart
+	Current bool          `json:"current"`
+	Totals  models.Totals `json:"totals"`
+}
+
+// pricingInput converts cart items into pricing lines using the current
+// catalog price. Callers must hold database.DB.Mutex.
+func pricingInput(cart *models.Cart, cartItems []models.CartItem) pricing.Input {
+	input := pricing.Input{
+		UserID: cart.UserID,
+		Codes:  cart.CouponCodes,
+		Lines:  make([]models.Line, 0, len(cartItems)),
+	}
+	if user, exists := database.DB.Users[cart.UserID]; exists {
+		input.TaxExempt = user.TaxExempt
+	}
+	shippingDestination(cart, &input)
+	for _, cartItem := range cartItems {
+		item := cartItem.Item
+		if current, exists := database.DB.Items[cartItem.ItemID]; exists {
+			item = *current
+		}
+		quantity := cartItem.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		input.Lines = append(input.Lines, models.Line{
+			ItemID:    cartItem.ItemID,
+			Name:      item.Name,
+			Category:  item.Category,
+			TaxClass:  item.TaxClass,
+			UnitPrice: item.Price,
+			Quantity:  quantity,
+			Weight:    item.Weight,
+		})
+	}
+	return input
+}
+
+// cartResponse loads a cart's items and prices them.
+// Callers must hold database.DB.Mutex.
+func cartResponse(cart *models.Cart) (CartResponse, error) {
+	cartWithItems := *cart
+	cartWithItems.CartItems = cartItemsFor(cart.ID)
+
+	totals, err := Pricer.Price(pricingInput(cart, cartWithItems.CartItems))
+	if err != nil {
+		return CartResponse{}, err
+	}
+	response := CartResponse{Cart: cartWithItems, Totals: totals}
+	if user, exists := database.DB.Users[cart.UserID]; exists {
+		response.Current = user.CartID == cart.ID && cart.Status == models.CartStatusActive
+	}
+	return response, nil
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/models"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// deletedCustomerName replaces the name on the addresses of a deleted
+// user's orders.
+const deletedCustomerName = "Deleted customer"
+
+type ProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=100"`
+	Email       *string `json:"email" binding:"omitempty,email"` // needs verifying again
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Confirm  string `json:"confirm"` // the username, for accounts without a password
+}
+
+// UserDataExport is everything kept about a user, as handed to them on
+// request.
+type UserDataExport struct {
+	ExportedAt  time.Time             `json:"exported_at"`
+	Profile     models.User           `json:"profile"`
+	Addresses   []models.Address      `json:"addresses"`
+	Carts       []models.Cart         `json:"carts"` // open carts and the saved-for-later list
+	Orders      []models.Order        `json:"orders"`
+	Returns     []models.Return       `json:"returns"`
+	StoreCredit []models.StoreCredit  `json:"store_credit"`
+	Invoices    []models.Invoice      `json:"invoices"`
+	Identities  []models.UserIdentity `json:"identities"`
+	Sessions    []models.Session      `json:"sessions"`
+}
+
+// reauthenticate checks the current user's password again before a
+// sensitive change. Wrong passwords count towards the same lockout as
+// failed logins. When the check fails it answers the request itself and
+// returns false.
+func reauthenticate(c *gin.Context, password string) bool {
+	ip := c.ClientIP()
+
+	database.DB.Mutex.Lock()
+	user := currentUser(c)
+	if user == nil {
+		database.DB.Mutex.Unlock()
+		return false
+	}
+	if user.Password == "" {
+		database.DB.Mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Your account has no password; set one with a password reset"})
+		return false
+	}
+	now := Clock.Now()
+	if wait, _ := loginWait(user.Username, ip, now); wait > 0 {
+		database.DB.Mutex.Unlock()
+		c.Header("Retry-After", retryAfter(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, try again later"})
+		return false
+	}
+	lockedOut := countLoginAttempt(user.Username, ip, now)
+	hash := []byte(user.Password)
+	database.DB.Mutex.Unlock()
+
+	// Check password without holding the database lock
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if err != nil {
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserLogin,
+			Outcome:    models.AuditFailure,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Metadata:   map[string]string{"username": user.Username, "reason": "wrong password", "method": "reauthentication"},
+		})
+		if lockedOut {
+			recordAudit(c, models.AuditEntry{
+				Action:     AuditUserLockedOut,
+				TargetType: AuditTargetUser,
+				TargetID:   user.ID,
+				Metadata:   map[string]string{"username": user.Username},
+			})
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return false
+	}
+	clearLoginAttempt(user.Username, ip)
+	return true
+}
+
+// GetProfile returns the current user's account.
+func GetProfile(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	responseUser := *user
+	responseUser.Password = ""
+	c.JSON(http.StatusOK, responseUser)
+}
+
+// UpdateProfile changes the current user's display name and email address.
+// Fields left out are kept; a new address needs verifying like one set
+// with UpdateEmail.
+func UpdateProfile(c *gin.Context) {
+	var req ProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	if req.Email != nil && !changeEmail(c, user, strings.TrimSpace(*req.Email)) {
+		return
+	}
+	if req.DisplayName != nil && strings.TrimSpace(*req.DisplayName) != user.DisplayName {
+		before := *user
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserProfileUpdated,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Changes:    auditChanges(before, *user),
+		})
+	}
+
+	responseUser := *user
+	responseUser.Password = ""
+	c.JSON(http.StatusOK, responseUser)
+}
+
+// ChangePassword sets a new password for the current user, given their
+// current one. Their other sessions are ended in case the old password was
+// stolen; the one making the request stays signed in.
+func ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !reauthenticate(c, req.CurrentPassword) {
+		return
+	}
+
+	// Hash first so the lock is not held while bcrypt runs
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	user.Password = string(hashedPassword)
+	revokeUserTokens(user.ID, models.TokenPasswordReset)
+	revoked := revokeUserSessions(user, c.GetUint("session_id"))
+
+	if user.Email != "" && user.EmailVerified {
+		sendMail(user.Email, "Your password was changed", fmt.Sprintf(
+			"Hi %s,\n\nThe password for your account was just changed. If you did not do this, reset it straight away and contact us.\n",
+			user.Username))
+	}
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserPasswordChanged,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   map[string]string{"sessions_revoked": fmt.Sprintf("%d", revoked)},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "sessions_revoked": revoked})
+}
+
+// ExportUserData hands the current user everything kept about them as a
+// JSON file.
+func ExportUserData(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+
+	export := UserDataExport{
+		ExportedAt:  Clock.Now(),
+		Profile:     *user,
+		Addresses:   []models.Address{},
+		Carts:       []models.Cart{},
+		Orders:      []models.Order{},
+		Returns:     listReturns(func(ret *models.Return) bool { return ret.UserID == user.ID }),
+		StoreCredit: []models.StoreCredit{},
+		Invoices:    []models.Invoice{},
+		Identities:  []models.UserIdentity{},
+		Sessions:    []models.Session{},
+	}
+	export.Profile.Password = ""
+
+	for _, address := range database.DB.Addresses {
+		if address.UserID == user.ID {
+			export.Addresses = append(export.Addresses, *address)
+		}
+	}
+	sort.Slice(export.Addresses, func(i, j int) bool { return export.Addresses[i].ID < export.Addresses[j].ID })
+
+	for _, status := range []string{models.CartStatusActive, models.CartStatusSaved} {
+		for _, cart := range userCarts(user.ID, status) {
+			cartWithItems := *cart
+			cartWithItems.CartItems = cartItemsFor(cart.ID)
+			export.Carts = append(export.Carts, cartWithItems)
+		}
+	}
+
+	for _, order := range database.DB.Orders {
+		if order.UserID == user.ID {
+			export.Orders = append(export.Orders, orderWithCart(order))
+		}
+	}
+	sort.Slice(export.Orders, func(i, j int) bool { return export.Orders[i].ID < export.Orders[j].ID })
+
+	for _, credit := range database.DB.StoreCredits {
+		if credit.UserID == user.ID {
+			export.StoreCredit = append(export.StoreCredit, *credit)
+		}
+	}
+	sort.Slice(export.StoreCredit, func(i, j int) bool { return export.StoreCredit[i].ID < export.StoreCredit[j].ID })
+
+	for _, invoice := range database.DB.Invoices {
+		if invoice.UserID == user.ID {
+			export.Invoices = append(export.Invoices, *invoice)
+		}
+	}
+	sort.Slice(export.Invoices, func(i, j int) bool { return export.Invoices[i].ID < export.Invoices[j].ID })
+
+	for _, identity := range database.DB.UserIdentities {
+		if identity.UserID == user.ID {
+			export.Identities = append(export.Identities, *identity)
+		}
+	}
+	sort.Slice(export.Identities, func(i, j int) bool { return export.Identities[i].ID < export.Identities[j].ID })
+
+	export.Sessions = activeSessions(user.ID, c.GetUint("session_id"))
+
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserDataExported,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+	})
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="account-%d.json"`, user.ID))
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+// accountInUse explains why a user's account cannot be deleted yet, or
+// returns "" when it can.
+// Callers must hold database.DB.Mutex.
+func accountInUse(userID uint) string {
+	for _, order := range database.DB.Orders {
+		if order.UserID != userID {
+			continue
+		}
+		switch order.Status {
+		case models.OrderStatusPendingPayment, models.OrderStatusConfirmed, models.OrderStatusPartiallyShipped:
+			return "You have orders that are still being processed; cancel them or wait until they have shipped"
+		}
+	}
+	for _, cart := range database.DB.Carts {
+		if cart.UserID == userID && cart.Status == models.CartStatusCheckout {
+			return "You have a checkout in progress"
+		}
+	}
+	for _, ret := range database.DB.Returns {
+		if ret.UserID != userID {
+			continue
+		}
+		switch ret.Status {
+		case models.ReturnStatusRequested, models.ReturnStatusApproved, models.ReturnStatusReceived, models.ReturnStatusInspected:
+			return "You have returns that are still open"
+		}
+	}
+	return ""
+}
+
+// anonymizedAddress keeps only the parts of an order's address that tax
+// reporting needs.
+func anonymizedAddress(address *models.Address) *models.Address {
+	if address == nil {
+		return nil
+	}
+	return &models.Address{
+		ID:      address.ID,
+		Name:    deletedCustomerName,
+		Region:  address.Region,
+		Country: address.Country,
+	}
+}
+
+// deleteAccount removes a user and what is kept about them. Their orders
+// stay for the shop's books with the addresses anonymized, and invoices,
+// which must be kept as issued, and the audit log are left alone.
+// Callers must hold database.DB.Mutex.
+func deleteAccount(c *gin.Context, user *models.User) {
+	now := Clock.Now()
+
+	anonymized := 0
+	for _, order := range database.DB.Orders {
+		if order.UserID == user.ID {
+			order.ShippingAddress = anonymizedAddress(order.ShippingAddress)
+			order.BillingAddress = anonymizedAddress(order.BillingAddress)
+			anonymized++
+		}
+	}
+	for _, cart := range database.DB.Carts {
+		if cart.UserID != user.ID {
+			continue
+		}
+		if cart.Status == models.CartStatusOrdered {
+			compactOrderedCart(cart)
+			continue
+		}
+		removeCartLines(cart.ID)
+		delete(database.DB.Carts, cart.ID)
+	}
+	for id, address := range database.DB.Addresses {
+		if address.UserID == user.ID {
+			delete(database.DB.Addresses, id)
+		}
+	}
+
+	// Nothing can sign in as them any more
+	for id, session := range database.DB.Sessions {
+		if session.UserID == user.ID {
+			delete(database.DB.Sessions, id)
+		}
+	}
+	revokeUserAPIKeys(user)
+	for id, identity := range database.DB.UserIdentities {
+		if identity.UserID == user.ID {
+			delete(database.DB.UserIdentities, id)
+		}
+	}
+	for state, login := range database.DB.OIDCLogins {
+		if login.UserID == user.ID {
+			delete(database.DB.OIDCLogins, state)
+		}
+	}
+	for id, token := range database.DB.UserTokens {
+		if token.UserID == user.ID {
+			delete(database.DB.UserTokens, id)
+		}
+	}
+	delete(database.DB.MFAEnrollments, user.ID)
+	delete(database.DB.LoginThrottles, accountThrottleKey(user.Username))
+
+	// Audit while the user can still be named
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserDeleted,
+		ActorID:    user.ID,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   map[string]string{"orders_anonymized": fmt.Sprintf("%d", anonymized)},
+	})
+	recordEvent(events.UserDeleted, UserDeletion{UserID: user.ID, DeletedAt: now})
+	delete(database.DB.Users, user.ID)
+}
+
+// DeleteAccount deletes the current user's account, given their password,
+// or their username in confirm if they only sign in with an identity
+// provider. It is refused while orders or returns are open, and for admins,
+// who must first be made customers by another admin.
+func DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	user := currentUser(c)
+	if user == nil {
+		database.DB.Mutex.RUnlock()
+		return
+	}
+	role, username, hasPassword := user.Role, user.Username, user.Password != ""
+	database.DB.Mutex.RUnlock()
+
+	if role == models.RoleAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Admins cannot delete their own account; ask another admin to make you a customer first"})
+		return
+	}
+	if hasPassword {
+		if !reauthenticate(c, req.Password) {
+			return
+		}
+	} else if req.Confirm != username {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Send your username in confirm to delete your account"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user = currentUser(c)
+	if user == nil {
+		return
+	}
+	if reason := accountInUse(user.ID); reason != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": reason})
+		return
+	}
+	deleteAccount(c, user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
@@ -0,0 +1,214 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/mailer"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Profile", func() {
+	var (
+		mail    *mailer.Memory
+		user    *models.User
+		headers map[string]string
+	)
+
+	login := func(username, password string) map[string]string {
+		w := request("POST", "/users/login", map[string]string{"username": username, "password": password}, nil)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var response handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return map[string]string{"Authorization": "Bearer " + response.Token}
+	}
+
+	// placeOrder checks out an item to a new default address
+	placeOrder := func() models.Order {
+		address := map[string]interface{}{"name": "Nora Berg", "line1": "1 Harbour St", "city": "Bergen", "postal_code": "5003", "country": "NO", "phone": "+47 555 0100", "is_default": true}
+		Expect(request("POST", "/addresses", address, headers).Code).To(Equal(http.StatusCreated))
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, headers).Code).To(Equal(http.StatusCreated))
+		w := request("POST", "/orders", nil, headers)
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		var order models.Order
+		json.Unmarshal(w.Body.Bytes(), &order)
+		return order
+	}
+
+	BeforeEach(func() {
+		fake := clock.NewFake(time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+		middleware.Clock = fake
+		mail = &mailer.Memory{}
+		handlers.Mailer = mail
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+
+		newTestRouter()
+		router.POST("/users", handlers.CreateUser)
+		router.POST("/users/login", handlers.LoginUser)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.GET("/profile", handlers.GetProfile)
+		auth.PUT("/profile", handlers.UpdateProfile)
+		auth.PUT("/profile/password", handlers.ChangePassword)
+		auth.GET("/profile/export", handlers.ExportUserData)
+		auth.DELETE("/profile", handlers.DeleteAccount)
+		auth.POST("/addresses", handlers.CreateAddress)
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+
+		Expect(request("POST", "/users", map[string]string{"username": "nora", "password": "pass-word", "email": "nora@example.com"}, nil).Code).To(Equal(http.StatusCreated))
+		headers = login("nora", "pass-word")
+		for _, u := range database.DB.Users {
+			if u.Username == "nora" {
+				user = u
+			}
+		}
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+		middleware.Clock = clock.Real{}
+		handlers.Mailer = mailer.Log{}
+	})
+
+	It("shows and updates the profile", func() {
+		w := request("GET", "/profile", nil, headers)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var profile models.User
+		json.Unmarshal(w.Body.Bytes(), &profile)
+		Expect(profile.Username).To(Equal("nora"))
+		Expect(profile.Password).To(BeEmpty())
+
+		w = request("PUT", "/profile", map[string]string{"display_name": " Nora Berg ", "email": "nora@berg.example"}, headers)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		json.Unmarshal(w.Body.Bytes(), &profile)
+		Expect(profile.DisplayName).To(Equal("Nora Berg"))
+		Expect(profile.Email).To(Equal("nora@berg.example"))
+		Expect(profile.EmailVerified).To(BeFalse())
+		Eventually(func() []mailer.Message { return mail.To("nora@berg.example") }).Should(HaveLen(1))
+	})
+
+	It("keeps the profile as it was when the email is taken", func() {
+		Expect(request("POST", "/users", map[string]string{"username": "olga", "password": "pass-word", "email": "olga@example.com"}, nil).Code).To(Equal(http.StatusCreated))
+
+		w := request("PUT", "/profile", map[string]string{"display_name": "Nora", "email": "OLGA@example.com"}, headers)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+		Expect(user.DisplayName).To(BeEmpty())
+		Expect(user.Email).To(Equal("nora@example.com"))
+	})
+
+	It("changes the password given the current one, signing other devices out", func() {
+		phone := login("nora", "pass-word")
+
+		w := request("PUT", "/profile/password", map[string]string{"current_password": "guess", "new_password": "new-pass"}, headers)
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+		w = request("PUT", "/profile/password", map[string]string{"current_password": "pass-word", "new_password": "new-pass"}, headers)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		Expect(w.Body.String()).To(ContainSubstring(`"sessions_revoked":1`))
+
+		Expect(request("GET", "/profile", nil, headers).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "/profile", nil, phone).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("POST", "/users/login", map[string]string{"username": "nora", "password": "pass-word"}, nil).Code).To(Equal(http.StatusUnauthorized))
+		login("nora", "new-pass")
+	})
+
+	It("has users without a password set one with a reset", func() {
+		user.Password = ""
+		w := request("PUT", "/profile/password", map[string]string{"current_password": "pass-word", "new_password": "new-pass"}, headers)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("exports the user's data as a file", func() {
+		order := placeOrder()
+		Expect(request("POST", "/carts", map[string]interface{}{"item_id": 2, "quantity": 3}, headers).Code).To(Equal(http.StatusCreated))
+
+		w := request("GET", "/profile/export", nil, headers)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Disposition")).To(Equal(`attachment; filename="account-` + itoa(user.ID) + `.json"`))
+
+		var export handlers.UserDataExport
+		Expect(json.Unmarshal(w.Body.Bytes(), &export)).To(Succeed())
+		Expect(export.Profile.Username).To(Equal("nora"))
+		Expect(export.Profile.Password).To(BeEmpty())
+		Expect(export.Addresses).To(HaveLen(1))
+		Expect(export.Orders).To(HaveLen(1))
+		Expect(export.Orders[0].ID).To(Equal(order.ID))
+		Expect(export.Orders[0].Cart.CartItems).To(HaveLen(1))
+		Expect(export.Carts).To(HaveLen(1))
+		Expect(export.Carts[0].CartItems).To(HaveLen(1))
+		Expect(export.Carts[0].CartItems[0].Quantity).To(Equal(3))
+		Expect(export.Sessions).To(HaveLen(1))
+		Expect(export.Sessions[0].Current).To(BeTrue())
+		Expect(database.DB.AuditLog[len(database.DB.AuditLog)-1].Action).To(Equal(handlers.AuditUserDataExported))
+	})
+
+	It("deletes the account once its orders are done, anonymizing them", func() {
+		order := placeOrder()
+		database.DB.APIKeys[1] = &models.APIKey{ID: 1, UserID: user.ID}
+
+		w := request("DELETE", "/profile", map[string]string{"password": "pass-word"}, headers)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		database.DB.Orders[order.ID].Status = models.OrderStatusDelivered
+		Expect(request("DELETE", "/profile", map[string]string{"password": "guess"}, headers).Code).To(Equal(http.StatusUnauthorized))
+		w = request("DELETE", "/profile", map[string]string{"password": "pass-word"}, headers)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+
+		Expect(database.DB.Users).ToNot(HaveKey(user.ID))
+		Expect(request("GET", "/profile", nil, headers).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("POST", "/users/login", map[string]string{"username": "nora", "password": "pass-word"}, nil).Code).To(Equal(http.StatusUnauthorized))
+		Expect(database.DB.APIKeys[1].RevokedAt).ToNot(BeNil())
+		Expect(database.DB.Addresses).To(BeEmpty())
+		for _, cart := range database.DB.Carts {
+			Expect(cart.UserID).ToNot(Equal(user.ID))
+		}
+
+		// The order stays, without who it was for
+		kept := database.DB.Orders[order.ID]
+		Expect(kept.ShippingAddress.Name).To(Equal("Deleted customer"))
+		Expect(kept.ShippingAddress.Line1).To(BeEmpty())
+		Expect(kept.ShippingAddress.Phone).To(BeEmpty())
+		Expect(kept.ShippingAddress.Country).To(Equal("NO"))
+		Expect(kept.Cart.CartItems).To(HaveLen(1))
+
+		var entries []models.AuditEntry
+		for _, e := range database.DB.AuditLog {
+			if e.Action == handlers.AuditUserDeleted {
+				entries = append(entries, *e)
+			}
+		}
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].ActorName).To(Equal("nora"))
+		var deleted bool
+		for _, e := range database.DB.Outbox {
+			deleted = deleted || e.Type == string(events.UserDeleted)
+		}
+		Expect(deleted).To(BeTrue())
+
+		// The username and email are free again
+		Expect(request("POST", "/users", map[string]string{"username": "nora", "password": "pass-word", "email": "nora@example.com"}, nil).Code).To(Equal(http.StatusCreated))
+	})
+
+	It("has accounts without a password confirm with the username", func() {
+		user.Password = ""
+		Expect(request("DELETE", "/profile", map[string]string{}, headers).Code).To(Equal(http.StatusBadRequest))
+		Expect(request("DELETE", "/profile", map[string]string{"confirm": "nora"}, headers).Code).To(Equal(http.StatusOK))
+	})
+
+	It("does not let admins delete themselves", func() {
+		user.Role = models.RoleAdmin
+		Expect(request("DELETE", "/profile", map[string]string{"password": "pass-word"}, headers).Code).To(Equal(http.StatusConflict))
+		Expect(database.DB.Users).To(HaveKey(user.ID))
+	})
+})
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/promotions"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PromotionRequest struct {
+	Code         string     `json:"code"`
+	Name         string     `json:"name" binding:"required"`
+	Type         string     `json:"type" binding:"required"`
+	Value        int64      `json:"value"`
+	BuyQuantity  int        `json:"buy_quantity"`
+	GetQuantity  int        `json:"get_quantity"`
+	MinSubtotal  int64      `json:"min_subtotal"`
+	Categories   []string   `json:"categories"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	UsageLimit   int        `json:"usage_limit"`
+	PerUserLimit int        `json:"per_user_limit"`
+	Stackable    bool       `json:"stackable"`
+	Priority     int        `json:"priority"`
+	Active       *bool      `json:"active"`
+}
+
+type CouponRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// allPromotions lists every promotion. Callers must hold database.DB.Mutex.
+func allPromotions() []*models.Promotion {
+	promos := make([]*models.Promotion, 0, len(database.DB.Promotions))
+	for _, p := range database.DB.Promotions {
+		promos = append(promos, p)
+	}
+	return promos
+}
+
+// redemptionUsage counts redemptions in the database.
+// Callers must hold database.DB.Mutex.
+type redemptionUsage struct{}
+
+func (redemptionUsage) Redemptions(promotionID uint) int {
+	count := 0
+	for _, r := range database.DB.Redemptions {
+		if r.PromotionID == promotionID {
+			count++
+		}
+	}
+	return count
+}
+
+func (redemptionUsage) UserRedemptions(promotionID, userID uint) int {
+	count := 0
+	for _, r := range database.DB.Redemptions {
+		if r.PromotionID == promotionID && r.UserID == userID {
+			count++
+		}
+	}
+	return count
+}
+
+// promotionByCode finds a promotion by coupon code.
+// Callers must hold database.DB.Mutex.
+func promotionByCode(code string) *models.Promotion {
+	code = promotions.NormalizeCode(code)
+	for _, p := range database.DB.Promotions {
+		if p.Code != "" && p.Code == code {
+			return p
+		}
+	}
+	return nil
+}
+
+// checkCoupons makes sure every code on the cart can still be redeemed.
+// Callers must hold database.DB.Mutex.
+func checkCoupons(cart *models.Cart) error {
+	for _, code := range cart.CouponCodes {
+		p := promotionByCode(code)
+		if p == nil {
+			return fmt.Errorf("coupon %s no longer exists", code)
+		}
+		if err := promotions.Check(p, cart.UserID, time.Now(), redemptionUsage{}); err != nil {
+			return fmt.Errorf("coupon %s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// recordRedemptions stores a redemption for every promotion that discounted
+// the order. Callers must hold database.DB.Mutex.
+func recordRedemptions(order *models.Order) {
+	order.Redemptions = []models.Redemption{}
+	for _, adjustment := range order.Totals.Discounts {
+		if adjustment.RuleID == 0 {
+			continue
+		}
+		redemption := &models.Redemption{
+			ID:          database.DB.GetNextID(),
+			PromotionID: adjustment.RuleID,
+			OrderID:     order.ID,
+			UserID:      order.UserID,
+			Code:        adjustment.Code,
+			Amount:      adjustment.Amount,
+			CreatedAt:   order.CreatedAt,
+		}
+		database.DB.Redemptions[redemption.ID] = redemption
+		order.Redemptions = append(order.Redemptions, *redemption)
+	}
+}
+
+func applyPromotionRequest(p *models.Promotion, req PromotionRequest) {
+	p.Code = promotions.NormalizeCode(req.Code)
+	p.Name = req.Name
+	p.Type = req.Type
+	p.Value = req.Value
+	p.BuyQuantity = req.BuyQuantity
+	p.GetQuantity = req.GetQuantity
+	p.MinSubtotal = req.MinSubtotal
+	p.Categories = req.Categories
+	p.StartsAt = req.StartsAt
+	p.EndsAt = req.EndsAt
+	p.UsageLimit = req.UsageLimit
+	p.PerUserLimit = req.PerUserLimit
+	p.Stackable = req.Stackable
+	p.Priority = req.Priority
+	p.Active = req.Active == nil || *req.Active
+}
+
+func CreatePromotion(c *gin.Context) {
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	promotion := &models.Promotion{}
+	applyPromotionRequest(promotion, req)
+	if err := promotions.Validate(promotion); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if promotion.Code != "" && promotionByCode(promotion.Code) != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	promotion.ID = database.DB.GetNextID()
+	promotion.CreatedAt = time.Now()
+	database.DB.Promotions[promotion.ID] = promotion
+
+	c.JSON(http.StatusCreated, *promotion)
+}
+
+func GetPromotions(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	promos := []models.Promotion{}
+	for _, p := range allPromotions() {
+		promos = append(promos, *p)
+	}
+	sort.Slice(promos, func(i, j int) bool { return promos[i].ID < promos[j].ID })
+
+	c.JSON(http.StatusOK, promos)
+}
+
+func UpdatePromotion(c *gin.Context) {
+	var promotionID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &promotionID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	existing, exists := database.DB.Promotions[promotionID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+
+	updated := *existing
+	applyPromotionRequest(&updated, req)
+	if err := promotions.Validate(&updated); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if other := promotionByCode(updated.Code); updated.Code != "" && other != nil && other.ID != promotionID {
+		c.JSON(http.StatusConflict, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	*existing = updated
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeletePromotion deactivates a promotion. It is kept so past redemptions
+// still point at it.
+func DeletePromotion(c *gin.Context) {
+	var promotionID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &promotionID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	promotion, exists := database.DB.Promotions[promotionID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promotion not found"})
+		return
+	}
+	promotion.Active = false
+
+	c.JSON(http.StatusOK, gin.H{"message": "Promotion deactivated"})
+}
+
+func ApplyCoupon(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart := activeCart(userID.(uint))
+	if cart == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+
+	promotion := promotionByCode(req.Code)
+	if promotion == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+	for _, code := range cart.CouponCodes {
+		if code == promotion.Code {
+			c.JSON(http.StatusConflict, gin.H{"error": "Coupon already applied"})
+			return
+		}
+	}
+	if err := promotions.Check(promotion, cart.UserID, time.Now(), redemptionUsage{}); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, promotions.ErrUsageLimit) || errors.Is(err, promotions.ErrUserLimit) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	cart.CouponCodes = append(cart.CouponCodes, promotion.Code)
+	touchCart(cart)
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func RemoveCoupon(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	code := promotions.NormalizeCode(c.Param("code"))
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart := activeCart(userID.(uint))
+	if cart == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+
+	codes := []string{}
+	for _, applied := range cart.CouponCodes {
+		if applied != code {
+			codes = append(codes, applied)
+		}
+	}
+	if len(codes) == len(cart.CouponCodes) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not applied to cart"})
+		return
+	}
+	cart.CouponCodes = codes
+	touchCart(cart)
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"context"
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"ecommerce-backend/pricing"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errNotCancellable     = errors.New("order can no longer be cancelled")
+	errNotRefundable      = errors.New("only paid orders that are not cancelled can be refunded")
+	errPaymentNotCaptured = errors.New("payment has not been captured")
+	errUnknownLine        = errors.New("order has no such line")
+	errRefundQuantity     = errors.New("refund quantity exceeds what is left on the line")
+	errNothingToRefund    = errors.New("nothing left to refund")
+	errRefundPending      = errors.New("a refund for this order is still being processed")
+)
+
+// CancelOrderRequest explains why the customer cancelled.
+type CancelOrderRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// RefundLineRequest picks a quantity of one order line to refund.
+type RefundLineRequest struct {
+	ItemID   uint `json:"item_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"required,min=1"`
+}
+
+// RefundRequest refunds lines of an order and, with Shipping set, its
+// shipping charge. Without lines or shipping everything not yet refunded is
+// refunded. Refunded units go back in stock unless Restock is false.
+type RefundRequest struct {
+	Reason   string              `json:"reason" binding:"required"`
+	Lines    []RefundLineRequest `json:"lines"`
+	Shipping bool                `json:"shipping"`
+	Restock  *bool               `json:"restock"`
+}
+
+// refundValues returns what each order line and the shipping charge are
+// worth to the customer, each with its share of the order's tax, so that
+// refunding everything returns the order total.
+func refundValues(t models.Totals) ([]int64, int64) {
+	weights := make([]int64, 0, len(t.Lines)+1)
+	for _, line := range t.Lines {
+		weights = append(weights, line.Total)
+	}
+	weights = append(weights, t.Shipping-t.ShippingDiscount)
+
+	tax := pricing.Allocate(t.Tax, weights)
+	if t.Tax < 0 {
+		tax = pricing.Allocate(-t.Tax, weights)
+		for i := range tax {
+			tax[i] = -tax[i]
+		}
+	}
+
+	values := make([]int64, len(weights))
+	for i, w := range weights {
+		values[i] = w + tax[i]
+	}
+	return values[:len(t.Lines)], values[len(t.Lines)]
+}
+
+// refundedQuantity returns how many units of an order line were refunded.
+func refundedQuantity(order *models.Order, itemID uint) int {
+	quantity := 0
+	for _, refund := range order.Refunds {
+		for _, line := range refund.Lines {
+			if line.ItemID == itemID {
+				quantity += line.Quantity
+			}
+		}
+	}
+	return quantity
+}
+
+func shippingRefunded(order *models.Order) bool {
+	for _, refund := range order.Refunds {
+		if refund.Shipping != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// planRefund prices a refund. Requested lines for the same item are added
+// together; no lines and no shipping means everything that is left.
+func planRefund(order *models.Order, requested []RefundLineRequest, shipping bool) ([]models.RefundLine, int64, error) {
+	values, shippingValue := refundValues(order.Totals)
+
+	if len(requested) == 0 && !shipping {
+		for _, line := range order.Totals.Lines {
+			if left := line.Quantity - refundedQuantity(order, line.ItemID); left > 0 {
+				requested = append(requested, RefundLineRequest{ItemID: line.ItemID, Quantity: left})
+			}
+		}
+		shipping = shippingValue != 0 && !shippingRefunded(order)
+	}
+
+	quantities := map[uint]int{}
+	itemIDs := []uint{}
+	for _, line := range requested {
+		if _, seen := quantities[line.ItemID]; !seen {
+			itemIDs = append(itemIDs, line.ItemID)
+		}
+		quantities[line.ItemID] += line.Quantity
+	}
+
+	lines := []models.RefundLine{}
+	for _, itemID := range itemIDs {
+		index := -1
+		for i, line := range order.Totals.Lines {
+			if line.ItemID == itemID {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, 0, fmt.Errorf("%w: item %d", errUnknownLine, itemID)
+		}
+
+		total := order.Totals.Lines[index].Quantity
+		already := refundedQuantity(order, itemID)
+		quantity := quantities[itemID]
+		if quantity <= 0 || already+quantity > total {
+			return nil, 0, fmt.Errorf("%w: item %d", errRefundQuantity, itemID)
+		}
+		lines = append(lines, models.RefundLine{
+			ItemID:   itemID,
+			Quantity: quantity,
+			Amount:   pricing.Share(values[index], already+quantity, total) - pricing.Share(values[index], already, total),
+		})
+	}
+
+	var shippingAmount int64
+	if shipping {
+		if shippingValue == 0 || shippingRefunded(order) {
+			return nil, 0, fmt.Errorf("%w: shipping", errNothingToRefund)
+		}
+		shippingAmount = shippingValue
+	}
+
+	if len(lines) == 0 && shippingAmount == 0 {
+		return nil, 0, errNothingToRefund
+	}
+	return lines, shippingAmount, nil
+}
+
+// refundOrder settles a refund drafted from planRefund: it returns the
+// money through the payment provider, or as store credit when the refund's
+// Method says so, and records it on the order. The refund is kept pending,
+// so its lines cannot be refunded twice, while the provider is called
+// without the lock; nothing is recorded if the provider refuses it.
+// Callers must hold database.DB.Mutex and not rely on what they read before
+// the call.
+func refundOrder(ctx context.Context, order *models.Order, refund *models.Refund) error {
+	refund.OrderID = order.ID
+	refund.CreatedAt = Clock.Now()
+	if refund.Method == "" {
+		refund.Method = models.RefundMethodPayment
+	}
+	refund.Amount = refund.Shipping
+	for _, line := range refund.Lines {
+		refund.Amount += line.Amount
+	}
+
+	payment := order.Payment
+	if payment != nil {
+		refund.PaymentID = payment.ID
+	}
+	toPayment := refund.Method == models.RefundMethodPayment && payment != nil && refund.Amount > 0
+	if toPayment && payment.Status != string(payments.StatusCaptured) && payment.Status != string(payments.StatusPartiallyRefunded) {
+		return errPaymentNotCaptured
+	}
+
+	refund.ID = database.DB.GetNextID()
+	refund.Status = models.RefundStatusPending
+	database.DB.Refunds[refund.ID] = refund
+	order.Refunds = append(order.Refunds, *refund)
+
+	if toPayment {
+		intentID := payment.IntentID
+		database.DB.Mutex.Unlock()
+		intent, err := Payments.Refund(ctx, intentID, refund.Amount)
+		database.DB.Mutex.Lock()
+		if err != nil {
+			dropRefund(order, refund.ID)
+			return err
+		}
+		applyIntent(payment, intent)
+	}
+
+	refund.Status = models.RefundStatusSucceeded
+	for i := range order.Refunds {
+		if order.Refunds[i].ID == refund.ID {
+			order.Refunds[i] = *refund
+		}
+	}
+
+	if refund.Method == models.RefundMethodStoreCredit && refund.Amount > 0 {
+		credit := &models.StoreCredit{
+			ID:        database.DB.GetNextID(),
+			UserID:    order.UserID,
+			Amount:    refund.Amount,
+			OrderID:   order.ID,
+			ReturnID:  refund.ReturnID,
+			Note:      refund.Reason,
+			CreatedAt: refund.CreatedAt,
+		}
+		database.DB.StoreCredits[credit.ID] = credit
+	}
+
+	issueCreditNote(order, refund)
+
+	if refund.Restocked {
+		restocked := make([]models.CartItem, 0, len(refund.Lines))
+		for _, line := range refund.Lines {
+			restocked = append(restocked, models.CartItem{ItemID: line.ItemID, Quantity: line.Quantity})
+		}
+		restoreStock(restocked)
+	}
+
+	if fulfilling(order) && fullyRefunded(order) {
+		order.Status = models.OrderStatusRefunded
+	}
+	syncFulfillment(order, refund.CreatedBy)
+	updateBalance(order)
+	addOrderHistory(order, "refunded", fmt.Sprintf("%d refunded to %s: %s", refund.Amount, refund.Method, refund.Reason), refund.ReturnID, refund.CreatedBy)
+	return nil
+}
+
+// dropRefund forgets a pending refund the provider refused.
+// Callers must hold database.DB.Mutex.
+func dropRefund(order *models.Order, refundID uint) {
+	delete(database.DB.Refunds, refundID)
+	for i, refund := range order.Refunds {
+		if refund.ID == refundID {
+			order.Refunds = append(order.Refunds[:i], order.Refunds[i+1:]...)
+			return
+		}
+	}
+}
+
+// refundPending reports whether a refund of an order is still waiting on the
+// payment provider.
+func refundPending(order *models.Order) bool {
+	for _, refund := range order.Refunds {
+		if refund.Status == models.RefundStatusPending {
+			return true
+		}
+	}
+	return false
+}
+
+// fullyRefunded reports whether every line and the shipping charge of an
+// order have been refunded.
+func fullyRefunded(order *models.Order) bool {
+	for _, line := range order.Totals.Lines {
+		if refundedQuantity(order, line.ItemID) < line.Quantity {
+			return false
+		}
+	}
+	_, shippingValue := refundValues(order.Totals)
+	return shippingValue == 0 || shippingRefunded(order)
+}
+
+// updateBalance recomputes what the customer has paid, had refunded and
+// still owes on an order.
+func updateBalance(order *models.Order) {
+	var balance models.OrderBalance
+	if order.Payment != nil {
+		balance.Paid = order.Payment.Captured
+	}
+	var credited int64
+	for _, refund := range order.Refunds {
+		if refund.Status == models.RefundStatusPending {
+			continue
+		}
+		credited += refund.Amount
+		if refund.Method == models.RefundMethodStoreCredit {
+			balance.StoreCredit += refund.Amount
+		} else {
+			balance.Refunded += refund.Amount
+		}
+	}
+
+	switch order.Status {
+	case models.OrderStatusCancelled, models.OrderStatusPaymentFailed:
+	default:
+		balance.Outstanding = max(order.Totals.Total-credited-(balance.Paid-balance.Refunded), 0)
+	}
+	order.Balance = balance
+}
+
+// addOrderHistory records an event against an order at its current status
+// and records an OrderStatusChanged event when the status moved.
+// Callers must hold database.DB.Mutex.
+func addOrderHistory(order *models.Order, event, note string, returnID, by uint) {
+	previous := ""
+	if n := len(order.History); n > 0 {
+		previous = order.History[n-1].Status
+	}
+	order.History = append(order.History, models.OrderHistoryEntry{
+		Event:     event,
+		Status:    order.Status,
+		Note:      note,
+		ReturnID:  returnID,
+		ActorID:   by,
+		CreatedAt: Clock.Now(),
+	})
+
+	if previous != "" && previous != order.Status {
+		auditStatusChange(order, previous, event, by)
+		recordEvent(events.OrderStatusChanged, OrderStatusChange{
+			OrderID:        order.ID,
+			PreviousStatus: previous,
+			Status:         order.Status,
+			Event:          event,
+			Order:          *order,
+		})
+	}
+}
+
+// releaseRedemptions gives back the coupon uses of an order that will not
+// go ahead. Callers must hold database.DB.Mutex.
+func releaseRedemptions(order *models.Order) {
+	for id, redemption := range database.DB.Redemptions {
+		if redemption.OrderID == order.ID {
+			delete(database.DB.Redemptions, id)
+		}
+	}
+	order.Redemptions = []models.Redemption{}
+}
+
+func refundErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNotCancellable), errors.Is(err, errNotRefundable), errors.Is(err, errPaymentNotCaptured),
+		errors.Is(err, errRefundPending), errors.Is(err, payments.ErrInvalidState):
+		return http.StatusConflict
+	case errors.Is(err, errUnknownLine), errors.Is(err, errRefundQuantity), errors.Is(err, errNothingToRefund):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// CancelOrder lets a customer call off one of their orders before anything
+// has shipped. A payment waiting on the customer or not yet captured is
+// voided; a captured payment is refunded in full. The stock goes back and
+// coupon uses are released.
+func CancelOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req CancelOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || order.UserID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if err := cancelOrder(c.Request.Context(), order, req.Reason, userID.(uint)); err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, *order)
+}
+
+// cancelOrder cancels an order that has not been fulfilled. The payment
+// provider is called without the lock.
+// Callers must hold database.DB.Mutex.
+func cancelOrder(ctx context.Context, order *models.Order, reason string, by uint) error {
+	if order.Status != models.OrderStatusPendingPayment && order.Status != models.OrderStatusConfirmed {
+		return errNotCancellable
+	}
+	if refundPending(order) {
+		return errRefundPending
+	}
+
+	payment := order.Payment
+	switch {
+	case payment != nil && (payment.Status == string(payments.StatusRequiresAction) || payment.Status == string(payments.StatusAuthorized)):
+		intentID := payment.IntentID
+		database.DB.Mutex.Unlock()
+		intent, err := Payments.Void(ctx, intentID)
+		database.DB.Mutex.Lock()
+		if err != nil {
+			return err
+		}
+		applyIntent(payment, intent)
+
+		// A payment webhook may have failed the order, putting its stock
+		// back, while the payment was voided
+		if order.Status != models.OrderStatusPendingPayment && order.Status != models.OrderStatusConfirmed {
+			return errNotCancellable
+		}
+		restoreStock(order.Cart.CartItems)
+
+	case len(order.Totals.Lines) > 0 && !fullyRefunded(order):
+		lines, shipping, err := planRefund(order, nil, false)
+		if err != nil {
+			return err
+		}
+		refund := &models.Refund{CreatedBy: by, Reason: reason, Lines: lines, Shipping: shipping, Restocked: true}
+		if err := refundOrder(ctx, order, refund); err != nil {
+			return err
+		}
+	}
+
+	now := Clock.Now()
+	order.Status = models.OrderStatusCancelled
+	order.CancelReason = reason
+	order.CancelledAt = &now
+	releaseRedemptions(order)
+	updateBalance(order)
+	addOrderHistory(order, "cancelled", reason, 0, by)
+	return nil
+}
+
+// RefundOrder refunds some or all lines of a paid order, shipped or not.
+func RefundOrder(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if !fulfilling(order) {
+		c.JSON(http.StatusConflict, gin.H{"error": errNotRefundable.Error()})
+		return
+	}
+
+	lines, shipping, err := planRefund(order, req.Lines, req.Shipping)
+	if err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	refund := &models.Refund{
+		CreatedBy: userID.(uint),
+		Reason:    req.Reason,
+		Lines:     lines,
+		Shipping:  shipping,
+		Restocked: req.Restock == nil || *req.Restock,
+	}
+	if err := refundOrder(c.Request.Context(), order, refund); err != nil {
+		c.JSON(refundErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"refund": refund, "order": *order})
+}
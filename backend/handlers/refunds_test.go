@@ -0,0 +1,267 @@
+package handlers_test
+
+import (
+	"context"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// slowRefunds keeps refunds waiting at the provider until released, then
+// fails them with err when it is set.
+type slowRefunds struct {
+	payments.Provider
+	started chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (p *slowRefunds) Refund(ctx context.Context, intentID string, amount int64) (payments.Intent, error) {
+	close(p.started)
+	<-p.release
+	if p.err != nil {
+		return payments.Intent{}, p.err
+	}
+	return p.Provider.Refund(ctx, intentID, amount)
+}
+
+var _ = Describe("Cancellations and Refunds", func() {
+	var (
+		admin    *models.User
+		provider *payments.Fake
+		order    models.Order
+	)
+
+	// placeOrder orders 2 laptops and 3 mice to a taxed, shipped destination
+	placeOrder := func(method string) models.Order {
+		request("POST", "/carts", map[string]interface{}{"item_id": 5, "quantity": 3}, asAdmin())
+		address := &models.Address{ID: database.DB.GetNextID(), UserID: admin.ID, Name: "Admin", Line1: "1 Main St", City: "Austin", Region: "TX", Country: "US"}
+		database.DB.Addresses[address.ID] = address
+		cart := database.DB.Carts[admin.CartID]
+		cart.ShippingAddressID = address.ID
+		cart.ShippingMethod = "express"
+
+		w, placed := checkout(method)
+		Expect(w.Code).To(BeNumerically("<", 300), w.Body.String())
+		return placed
+	}
+
+	refund := func(body interface{}) (*httptest.ResponseRecorder, models.Refund) {
+		w := request("POST", "/orders/"+itoa(order.ID)+"/refunds", body, asAdmin())
+		var resp struct {
+			Refund models.Refund `json:"refund"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp.Refund
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		provider = payments.NewFake(payments.FakeConfig{})
+		handlers.Payments = provider
+
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/orders/:id/cancel", handlers.CancelOrder)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.POST("/orders/:id/refunds", handlers.RefundOrder)
+	})
+
+	AfterEach(func() {
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+	})
+
+	Describe("cancelling", func() {
+		It("refunds a captured payment in full and restores stock", func() {
+			order = placeOrder(payments.FakeCardApproved)
+			Expect(order.Totals.Tax).To(BeNumerically(">", 0))
+			Expect(order.Balance).To(Equal(models.OrderBalance{Paid: order.Totals.Total}))
+			Expect(*database.DB.Items[1].Stock).To(Equal(23))
+
+			w := request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Ordered by mistake"}, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var cancelled models.Order
+			json.Unmarshal(w.Body.Bytes(), &cancelled)
+			Expect(cancelled.Status).To(Equal(models.OrderStatusCancelled))
+			Expect(cancelled.CancelReason).To(Equal("Ordered by mistake"))
+			Expect(cancelled.Refunds).To(HaveLen(1))
+			Expect(cancelled.Refunds[0].Amount).To(Equal(order.Totals.Total))
+			Expect(cancelled.Refunds[0].Reason).To(Equal("Ordered by mistake"))
+			Expect(cancelled.Balance).To(Equal(models.OrderBalance{Paid: order.Totals.Total, Refunded: order.Totals.Total}))
+
+			intent, _ := provider.Intent(order.Payment.IntentID)
+			Expect(intent.Status).To(Equal(payments.StatusRefunded))
+			Expect(*database.DB.Items[1].Stock).To(Equal(25))
+			Expect(*database.DB.Items[5].Stock).To(Equal(120))
+		})
+
+		It("voids a payment that is still waiting on the customer", func() {
+			order = placeOrder(payments.FakeCard3DS)
+			Expect(order.Balance.Outstanding).To(Equal(order.Totals.Total))
+
+			w := request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Changed my mind"}, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			stored := database.DB.Orders[order.ID]
+			Expect(stored.Status).To(Equal(models.OrderStatusCancelled))
+			Expect(stored.Refunds).To(BeEmpty())
+			Expect(stored.Payment.Status).To(Equal(string(payments.StatusVoided)))
+			Expect(stored.Balance).To(Equal(models.OrderBalance{}))
+			Expect(*database.DB.Items[1].Stock).To(Equal(25))
+		})
+
+		It("requires a reason and can only happen once", func() {
+			order = placeOrder(payments.FakeCardApproved)
+			path := "/orders/" + itoa(order.ID) + "/cancel"
+
+			Expect(request("POST", path, map[string]string{}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+			Expect(request("POST", path, map[string]string{"reason": "No longer needed"}, asAdmin()).Code).To(Equal(http.StatusOK))
+			Expect(request("POST", path, map[string]string{"reason": "No longer needed"}, asAdmin()).Code).To(Equal(http.StatusConflict))
+		})
+
+		It("calls the provider without holding the database lock", func() {
+			checking := &lockCheckingProvider{Provider: provider}
+			handlers.Payments = checking
+
+			order = placeOrder(payments.FakeCardApproved)
+			Expect(request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Ordered by mistake"}, asAdmin()).Code).To(Equal(http.StatusOK))
+			Expect(database.DB.Orders[order.ID].Payment.Status).To(Equal(string(payments.StatusRefunded)))
+
+			order = placeOrder(payments.FakeCard3DS)
+			Expect(request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Changed my mind"}, asAdmin()).Code).To(Equal(http.StatusOK))
+			Expect(database.DB.Orders[order.ID].Payment.Status).To(Equal(string(payments.StatusVoided)))
+
+			Expect(checking.Held()).To(BeZero())
+		})
+
+		It("does not let customers cancel other users' orders", func() {
+			order = placeOrder(payments.FakeCardApproved)
+			_, other := asCustomer("someone")
+
+			w := request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Mine now"}, other)
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+			Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusConfirmed))
+		})
+	})
+
+	Describe("refunding", func() {
+		BeforeEach(func() {
+			order = placeOrder(payments.FakeCardApproved)
+		})
+
+		It("refunds part of a line with its share of tax", func() {
+			w, first := refund(map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 1, "quantity": 1}}})
+			Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+			Expect(first.Lines).To(HaveLen(1))
+			Expect(first.Amount).To(BeNumerically(">", order.Totals.Lines[0].Total/2))
+			Expect(first.Restocked).To(BeTrue())
+			Expect(*database.DB.Items[1].Stock).To(Equal(24))
+
+			stored := database.DB.Orders[order.ID]
+			Expect(stored.Status).To(Equal(models.OrderStatusConfirmed))
+			Expect(stored.Balance.Refunded).To(Equal(first.Amount))
+			Expect(stored.Payment.Status).To(Equal(string(payments.StatusPartiallyRefunded)))
+		})
+
+		It("leaves stock alone when asked not to restock", func() {
+			w, _ := refund(map[string]interface{}{"reason": "Goodwill", "restock": false, "lines": []map[string]interface{}{{"item_id": 5, "quantity": 1}}})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+			Expect(*database.DB.Items[5].Stock).To(Equal(117))
+		})
+
+		It("refunds exactly the order total across several refunds", func() {
+			_, first := refund(map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 5, "quantity": 1}}})
+			_, second := refund(map[string]interface{}{"reason": "Late", "shipping": true})
+			w, rest := refund(map[string]interface{}{"reason": "Returned"})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+
+			Expect(first.Amount + second.Amount + rest.Amount).To(Equal(order.Totals.Total))
+			stored := database.DB.Orders[order.ID]
+			Expect(stored.Status).To(Equal(models.OrderStatusRefunded))
+			Expect(stored.Balance).To(Equal(models.OrderBalance{Paid: order.Totals.Total, Refunded: order.Totals.Total}))
+
+			w, _ = refund(map[string]interface{}{"reason": "Again"})
+			Expect(w.Code).To(Equal(http.StatusConflict))
+		})
+
+		It("rejects quantities beyond what is left on the line", func() {
+			refund(map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 1, "quantity": 1}}})
+
+			w, _ := refund(map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 1, "quantity": 2}}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			w, _ = refund(map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 3, "quantity": 1}}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(database.DB.Orders[order.ID].Refunds).To(HaveLen(1))
+		})
+
+		It("holds a refund as pending while the provider answers", func() {
+			slow := &slowRefunds{Provider: provider, started: make(chan struct{}), release: make(chan struct{})}
+			handlers.Payments = slow
+			body := map[string]interface{}{"reason": "Damaged", "lines": []map[string]interface{}{{"item_id": 1, "quantity": 2}}}
+
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				defer GinkgoRecover()
+				w, _ := refund(body)
+				done <- w
+			}()
+			<-slow.started
+
+			database.DB.Mutex.RLock()
+			pending := database.DB.Orders[order.ID].Refunds
+			balance := database.DB.Orders[order.ID].Balance
+			database.DB.Mutex.RUnlock()
+			Expect(pending).To(HaveLen(1))
+			Expect(pending[0].Status).To(Equal(models.RefundStatusPending))
+			Expect(balance.Refunded).To(BeZero())
+
+			// The lines cannot be refunded twice, nor the order cancelled
+			w, _ := refund(body)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Changed my mind"}, asAdmin()).Code).To(Equal(http.StatusConflict))
+
+			close(slow.release)
+			w = <-done
+			Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+			stored := database.DB.Orders[order.ID]
+			Expect(stored.Refunds).To(HaveLen(1))
+			Expect(stored.Refunds[0].Status).To(Equal(models.RefundStatusSucceeded))
+			Expect(database.DB.Refunds[stored.Refunds[0].ID].Status).To(Equal(models.RefundStatusSucceeded))
+			Expect(stored.Balance.Refunded).To(Equal(stored.Refunds[0].Amount))
+		})
+
+		It("forgets a refund the provider refuses", func() {
+			slow := &slowRefunds{Provider: provider, started: make(chan struct{}), release: make(chan struct{}), err: errors.New("gateway timeout")}
+			close(slow.release)
+			handlers.Payments = slow
+
+			w, _ := refund(map[string]interface{}{"reason": "Damaged"})
+			Expect(w.Code).To(Equal(http.StatusBadGateway))
+			Expect(database.DB.Orders[order.ID].Refunds).To(BeEmpty())
+			Expect(database.DB.Refunds).To(BeEmpty())
+			Expect(*database.DB.Items[1].Stock).To(Equal(23))
+
+			handlers.Payments = provider
+			w, _ = refund(map[string]interface{}{"reason": "Damaged"})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+		})
+
+		It("needs a reason", func() {
+			w, _ := refund(map[string]interface{}{"lines": []map[string]interface{}{{"item_id": 1, "quantity": 1}}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
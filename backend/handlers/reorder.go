@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reasons reported for order lines that could not be reordered in full.
+const (
+	ReorderReasonUnavailable  = "unavailable" // deleted or no longer active
+	ReorderReasonLimitedStock = MergeReasonLimitedStock
+	ReorderReasonOutOfStock   = MergeReasonOutOfStock
+)
+
+// ReorderLine reports what became of one line of the original order.
+type ReorderLine struct {
+	ItemID    uint   `json:"item_id"`
+	Name      string `json:"name"`
+	Requested int    `json:"requested"` // units on the original order
+	Quantity  int    `json:"quantity"`  // units added to the cart
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ReorderPriceChange reports an item whose price changed since the order.
+type ReorderPriceChange struct {
+	ItemID   uint   `json:"item_id"`
+	Name     string `json:"name"`
+	OldPrice int64  `json:"old_price"`
+	NewPrice int64  `json:"new_price"`
+}
+
+// ReorderResult describes a reorder. Lines added with fewer units than
+// ordered appear in Added with a reason; Repriced lists added lines whose
+// price is no longer the one on the order.
+type ReorderResult struct {
+	CartID   uint                 `json:"cart_id"`
+	Added    []ReorderLine        `json:"added"`
+	Skipped  []ReorderLine        `json:"skipped"`
+	Repriced []ReorderPriceChange `json:"repriced"`
+	Cart     CartResponse         `json:"cart"`
+}
+
+// reorder copies an order's lines into the user's current cart at today's
+// availability, merging with lines already in it.
+// Callers must hold database.DB.Mutex.
+func reorder(order *models.Order) (*ReorderResult, error) {
+	cart := activeCart(order.UserID)
+	if cart == nil {
+		cart = createCart(order.UserID, "Default Cart", models.CartStatusActive)
+		switchCart(cart)
+	}
+
+	result := &ReorderResult{
+		CartID:   cart.ID,
+		Added:    []ReorderLine{},
+		Skipped:  []ReorderLine{},
+		Repriced: []ReorderPriceChange{},
+	}
+	for _, line := range order.Totals.Lines {
+		report := ReorderLine{ItemID: line.ItemID, Name: line.Name, Requested: line.Quantity}
+
+		item, exists := database.DB.Items[line.ItemID]
+		if !exists || item.Status != "active" {
+			report.Reason = ReorderReasonUnavailable
+			result.Skipped = append(result.Skipped, report)
+			continue
+		}
+
+		key := cartItemKey(cart.ID, line.ItemID)
+		existing := database.DB.CartItems[key]
+		inCart := 0
+		if existing != nil {
+			inCart = max(existing.Quantity, 1)
+		}
+
+		report.Quantity = line.Quantity
+		if available, tracked := availableStock(item, cart.ID); tracked && inCart+line.Quantity > available {
+			report.Quantity = max(available-inCart, 0)
+			report.Reason = ReorderReasonLimitedStock
+			if report.Quantity == 0 {
+				report.Reason = ReorderReasonOutOfStock
+				result.Skipped = append(result.Skipped, report)
+				continue
+			}
+		}
+
+		if existing != nil {
+			existing.Quantity = inCart + report.Quantity
+		} else {
+			database.DB.CartItems[key] = &models.CartItem{
+				CartID:   cart.ID,
+				ItemID:   line.ItemID,
+				Quantity: report.Quantity,
+				Cart:     *cart,
+				Item:     *item,
+			}
+		}
+		result.Added = append(result.Added, report)
+
+		if item.Price != line.UnitPrice {
+			result.Repriced = append(result.Repriced, ReorderPriceChange{
+				ItemID:   item.ID,
+				Name:     item.Name,
+				OldPrice: line.UnitPrice,
+				NewPrice: item.Price,
+			})
+		}
+	}
+
+	touchCart(cart)
+	syncReservations(cart)
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		return nil, err
+	}
+	result.Cart = response
+	return result, nil
+}
+
+// ReorderOrder puts the lines of one of the user's orders back in their
+// current cart and reports what was skipped or re-priced.
+func ReorderOrder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || order.UserID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	result, err := reorder(order)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
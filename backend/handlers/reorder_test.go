@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reorder", func() {
+	var (
+		admin *models.User
+		order models.Order
+	)
+
+	reorder := func(headers map[string]string) (*httptest.ResponseRecorder, handlers.ReorderResult) {
+		w := request("POST", "/orders/"+itoa(order.ID)+"/reorder", nil, headers)
+		var result handlers.ReorderResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w, result
+	}
+
+	BeforeEach(func() {
+		admin = newTestRouter()
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/orders/:id/reorder", handlers.ReorderOrder)
+
+		for item, quantity := range map[uint]int{1: 2, 2: 1, 3: 3, 4: 1} {
+			request("POST", "/carts", map[string]interface{}{"item_id": item, "quantity": quantity}, asAdmin())
+		}
+		w := request("POST", "/orders", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		json.Unmarshal(w.Body.Bytes(), &order)
+	})
+
+	It("copies the order into the current cart at today's prices", func() {
+		w, result := reorder(asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(result.CartID).To(Equal(database.DB.Users[admin.ID].CartID))
+		Expect(result.Added).To(HaveLen(4))
+		Expect(result.Skipped).To(BeEmpty())
+		Expect(result.Repriced).To(BeEmpty())
+		Expect(result.Cart.Totals.ItemCount).To(Equal(7))
+	})
+
+	It("reports lines that were skipped or re-priced", func() {
+		database.DB.Items[1].Price = 89999
+		database.DB.Items[2].Status = "inactive"
+		*database.DB.Items[3].Stock = 1
+		*database.DB.Items[4].Stock = 0
+
+		_, result := reorder(asAdmin())
+		Expect(result.Added).To(ConsistOf(
+			handlers.ReorderLine{ItemID: 1, Name: "Laptop", Requested: 2, Quantity: 2},
+			handlers.ReorderLine{ItemID: 3, Name: "Headphones", Requested: 3, Quantity: 1, Reason: handlers.ReorderReasonLimitedStock},
+		))
+		Expect(result.Skipped).To(ConsistOf(
+			handlers.ReorderLine{ItemID: 2, Name: "Smartphone", Requested: 1, Reason: handlers.ReorderReasonUnavailable},
+			handlers.ReorderLine{ItemID: 4, Name: "Keyboard", Requested: 1, Reason: handlers.ReorderReasonOutOfStock},
+		))
+		Expect(result.Repriced).To(Equal([]handlers.ReorderPriceChange{{ItemID: 1, Name: "Laptop", OldPrice: 99999, NewPrice: 89999}}))
+		Expect(result.Cart.Totals.Subtotal).To(Equal(int64(2*89999 + 14999)))
+	})
+
+	It("adds to lines already in the cart within the stock left", func() {
+		*database.DB.Items[1].Stock = 3
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 1}, asAdmin())
+
+		_, result := reorder(asAdmin())
+		Expect(result.Added).To(ContainElement(handlers.ReorderLine{ItemID: 1, Name: "Laptop", Requested: 2, Quantity: 2}))
+
+		_, result = reorder(asAdmin())
+		Expect(result.Skipped).To(ContainElement(handlers.ReorderLine{ItemID: 1, Name: "Laptop", Requested: 2, Reason: handlers.ReorderReasonOutOfStock}))
+		Expect(database.DB.Reservations[itoa(result.CartID)+"-1"].Quantity).To(Equal(3))
+	})
+
+	It("only reorders the user's own orders", func() {
+		_, other := asCustomer("someone")
+		w, _ := reorder(other)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+})
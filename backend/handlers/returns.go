@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+var (
+	errNotReturnable   = errors.New("order cannot be returned")
+	errReturnQuantity  = errors.New("return quantity exceeds what can still be returned")
+	errReturnNotFound  = errors.New("return not found")
+	errReturnState     = errors.New("return is not in a state that allows this")
+	errDuplicateReturn = errors.New("item is listed more than once")
+)
+
+// ReturnLineRequest asks to send back a quantity of one order line.
+type ReturnLineRequest struct {
+	ItemID   uint `json:"item_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"required,min=1"`
+}
+
+// ReturnRequest is a customer's return request.
+type ReturnRequest struct {
+	Reason string              `json:"reason" binding:"required"`
+	Lines  []ReturnLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// ReturnDecisionRequest carries an optional note for a return step.
+type ReturnDecisionRequest struct {
+	Note string `json:"note"`
+}
+
+// ReturnInspectionRequest records which returned items go back in stock.
+// Items that are not listed are not restocked.
+type ReturnInspectionRequest struct {
+	Restock []uint `json:"restock"`
+	Note    string `json:"note"`
+}
+
+// ResolveReturnRequest picks how the customer is paid back.
+type ResolveReturnRequest struct {
+	Outcome string `json:"outcome" binding:"required,oneof=refund store_credit"`
+	Note    string `json:"note"`
+}
+
+// returnTransitions lists the steps staff can take from each return status.
+var returnTransitions = map[string][]string{
+	models.ReturnStatusRequested: {models.ReturnStatusApproved, models.ReturnStatusRejected},
+	models.ReturnStatusApproved:  {models.ReturnStatusReceived, models.ReturnStatusRejected},
+	models.ReturnStatusReceived:  {models.ReturnStatusInspected},
+	models.ReturnStatusInspected: {models.ReturnStatusRefunded, models.ReturnStatusCredited},
+}
+
+// returnable reports whether an order has anything delivered that could be
+// returned.
+func returnable(order *models.Order) bool {
+	switch order.Status {
+	case models.OrderStatusPartiallyShipped, models.OrderStatusShipped, models.OrderStatusDelivered:
+		return true
+	}
+	return false
+}
+
+// openReturnQuantity returns how many units of an order line are in returns
+// that have not been rejected or resolved. Resolved returns are counted by
+// their refunds. Callers must hold database.DB.Mutex.
+func openReturnQuantity(order *models.Order, itemID uint) int {
+	quantity := 0
+	for _, ret := range database.DB.Returns {
+		if ret.OrderID != order.ID {
+			continue
+		}
+		switch ret.Status {
+		case models.ReturnStatusRejected, models.ReturnStatusRefunded, models.ReturnStatusCredited:
+			continue
+		}
+		for _, line := range ret.Lines {
+			if line.ItemID == itemID {
+				quantity += line.Quantity
+			}
+		}
+	}
+	return quantity
+}
+
+// returnLines checks requested lines against what was delivered and is not
+// already refunded or being returned.
+// Callers must hold database.DB.Mutex.
+func returnLines(order *models.Order, requested []ReturnLineRequest) ([]models.ReturnLine, error) {
+	lines := make([]models.ReturnLine, 0, len(requested))
+	for _, req := range requested {
+		for _, line := range lines {
+			if line.ItemID == req.ItemID {
+				return nil, fmt.Errorf("%w: item %d", errDuplicateReturn, req.ItemID)
+			}
+		}
+
+		purchased := -1
+		for _, line := range order.Totals.Lines {
+			if line.ItemID == req.ItemID {
+				purchased = line.Quantity
+			}
+		}
+		if purchased < 0 {
+			return nil, fmt.Errorf("%w: item %d", errUnknownLine, req.ItemID)
+		}
+
+		// Only delivered units can come back, and not those already refunded
+		kept := min(shippedQuantity(order, req.ItemID, true), purchased-refundedQuantity(order, req.ItemID))
+		left := kept - openReturnQuantity(order, req.ItemID)
+		if req.Quantity > left {
+			return nil, fmt.Errorf("%w: item %d", errReturnQuantity, req.ItemID)
+		}
+		lines = append(lines, models.ReturnLine{ItemID: req.ItemID, Quantity: req.Quantity})
+	}
+	return lines, nil
+}
+
+// advanceReturn moves a return to its next status and notes it in the
+// order's history. Callers must hold database.DB.Mutex.
+func advanceReturn(ret *models.Return, order *models.Order, status, note string, by uint) error {
+	allowed := false
+	for _, next := range returnTransitions[ret.Status] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return fmt.Errorf("%w: return is %s", errReturnState, ret.Status)
+	}
+
+	ret.Status = status
+	ret.UpdatedAt = Clock.Now()
+	if note != "" {
+		ret.StaffNote = note
+	}
+	addOrderHistory(order, "return_"+status, note, ret.ID, by)
+	return nil
+}
+
+func returnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errReturnNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errReturnState), errors.Is(err, errNotReturnable):
+		return http.StatusConflict
+	case errors.Is(err, errUnknownLine), errors.Is(err, errReturnQuantity), errors.Is(err, errDuplicateReturn):
+		return http.StatusBadRequest
+	default:
+		return refundErrorStatus(err)
+	}
+}
+
+// CreateReturn opens a return request against delivered lines of one of
+// the user's orders.
+func CreateReturn(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req ReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || order.UserID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if !returnable(order) {
+		c.JSON(http.StatusConflict, gin.H{"error": errNotReturnable.Error()})
+		return
+	}
+
+	lines, err := returnLines(order, req.Lines)
+	if err != nil {
+		c.JSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	now := Clock.Now()
+	ret := &models.Return{
+		ID:        database.DB.GetNextID(),
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		Status:    models.ReturnStatusRequested,
+		Reason:    req.Reason,
+		Lines:     lines,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	database.DB.Returns[ret.ID] = ret
+	addOrderHistory(order, "return_requested", req.Reason, ret.ID, userID.(uint))
+
+	c.JSON(http.StatusCreated, *ret)
+}
+
+// GetMyReturns lists the user's returns, newest first.
+func GetMyReturns(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	c.JSON(http.StatusOK, listReturns(func(ret *models.Return) bool { return ret.UserID == userID.(uint) }))
+}
+
+// GetReturns lists all returns, newest first, optionally only those with
+// the ?status= given.
+func GetReturns(c *gin.Context) {
+	status := c.Query("status")
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	auditRead(c, AuditTargetReturn, 0, 0, map[string]string{"query": c.Request.URL.RawQuery})
+	c.JSON(http.StatusOK, listReturns(func(ret *models.Return) bool { return status == "" || ret.Status == status }))
+}
+
+// listReturns returns copies of the matching returns, newest first.
+// Callers must hold database.DB.Mutex.
+func listReturns(match func(*models.Return) bool) []models.Return {
+	returns := []models.Return{}
+	for _, ret := range database.DB.Returns {
+		if match(ret) {
+			returns = append(returns, *ret)
+		}
+	}
+	sort.Slice(returns, func(i, j int) bool { return returns[i].ID > returns[j].ID })
+	return returns
+}
+
+// updateReturn runs a staff step against the return named in the path.
+func updateReturn(c *gin.Context, req interface{}, step func(ret *models.Return, order *models.Order, by uint) error) {
+	userID, _ := c.Get("user_id")
+
+	var returnID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &returnID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return
+	}
+
+	// Notes are optional, so an empty body is validated as an empty request
+	err := c.ShouldBindJSON(req)
+	if errors.Is(err, io.EOF) {
+		err = binding.Validator.ValidateStruct(req)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	ret, exists := database.DB.Returns[returnID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errReturnNotFound.Error()})
+		return
+	}
+	order, exists := database.DB.Orders[ret.OrderID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	if err := step(ret, order, userID.(uint)); err != nil {
+		c.JSON(returnErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, *ret)
+}
+
+// ApproveReturn accepts a return request; the customer can send the items.
+func ApproveReturn(c *gin.Context) {
+	var req ReturnDecisionRequest
+	updateReturn(c, &req, func(ret *models.Return, order *models.Order, by uint) error {
+		return advanceReturn(ret, order, models.ReturnStatusApproved, req.Note, by)
+	})
+}
+
+// RejectReturn turns down a return that has not been received.
+func RejectReturn(c *gin.Context) {
+	var req ReturnDecisionRequest
+	updateReturn(c, &req, func(ret *models.Return, order *models.Order, by uint) error {
+		return advanceReturn(ret, order, models.ReturnStatusRejected, req.Note, by)
+	})
+}
+
+// ReceiveReturn records that the returned items arrived.
+func ReceiveReturn(c *gin.Context) {
+	var req ReturnDecisionRequest
+	updateReturn(c, &req, func(ret *models.Return, order *models.Order, by uint) error {
+		return advanceReturn(ret, order, models.ReturnStatusReceived, req.Note, by)
+	})
+}
+
+// InspectReturn records the inspection and puts the items staff chose to
+// restock back in stock.
+func InspectReturn(c *gin.Context) {
+	var req ReturnInspectionRequest
+	updateReturn(c, &req, func(ret *models.Return, order *models.Order, by uint) error {
+		if err := advanceReturn(ret, order, models.ReturnStatusInspected, req.Note, by); err != nil {
+			return err
+		}
+		restocked := []models.CartItem{}
+		for i, line := range ret.Lines {
+			if containsID(req.Restock, line.ItemID) {
+				ret.Lines[i].Restock = true
+				restocked = append(restocked, models.CartItem{ItemID: line.ItemID, Quantity: line.Quantity})
+			}
+		}
+		restoreStock(restocked)
+		return nil
+	})
+}
+
+// ResolveReturn pays the customer back for an inspected return, either to
+// the original payment or as store credit.
+func ResolveReturn(c *gin.Context) {
+	var req ResolveReturnRequest
+	updateReturn(c, &req, func(ret *models.Return, order *models.Order, by uint) error {
+		status, method := models.ReturnStatusRefunded, models.RefundMethodPayment
+		if req.Outcome == "store_credit" {
+			status, method = models.ReturnStatusCredited, models.RefundMethodStoreCredit
+		}
+		if ret.Status != models.ReturnStatusInspected {
+			return fmt.Errorf("%w: return is %s", errReturnState, ret.Status)
+		}
+
+		requested := make([]RefundLineRequest, 0, len(ret.Lines))
+		for _, line := range ret.Lines {
+			requested = append(requested, RefundLineRequest{ItemID: line.ItemID, Quantity: line.Quantity})
+		}
+		lines, _, err := planRefund(order, requested, false)
+		if err != nil {
+			return err
+		}
+
+		refund := &models.Refund{
+			ReturnID:  ret.ID,
+			Method:    method,
+			CreatedBy: by,
+			Reason:    "Return: " + ret.Reason,
+			Lines:     lines,
+		}
+		if err := refundOrder(c.Request.Context(), order, refund); err != nil {
+			return err
+		}
+
+		now := Clock.Now()
+		ret.RefundID = refund.ID
+		ret.Amount = refund.Amount
+		ret.ResolvedAt = &now
+		return advanceReturn(ret, order, status, req.Note, by)
+	})
+}
+
+// GetStoreCredit returns the user's store credit balance and ledger.
+func GetStoreCredit(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	var balance int64
+	entries := []models.StoreCredit{}
+	for _, credit := range database.DB.StoreCredits {
+		if credit.UserID == userID.(uint) {
+			balance += credit.Amount
+			entries = append(entries, *credit)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	c.JSON(http.StatusOK, gin.H{"balance": balance, "entries": entries})
+}
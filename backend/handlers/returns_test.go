@@ -0,0 +1,196 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/carriers"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Returns", func() {
+	var order models.Order
+
+	requestReturn := func(lines ...map[string]interface{}) (*httptest.ResponseRecorder, models.Return) {
+		w := request("POST", "/orders/"+itoa(order.ID)+"/returns", map[string]interface{}{"reason": "Too small", "lines": lines}, asAdmin())
+		var ret models.Return
+		json.Unmarshal(w.Body.Bytes(), &ret)
+		return w, ret
+	}
+
+	step := func(ret models.Return, action string, body interface{}) *httptest.ResponseRecorder {
+		return request("POST", "/returns/"+itoa(ret.ID)+"/"+action, body, asAdmin())
+	}
+
+	// inspected opens a return for one laptop and takes it through inspection
+	inspected := func(restock bool) models.Return {
+		_, ret := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 1})
+		Expect(step(ret, "approve", nil).Code).To(Equal(http.StatusOK))
+		Expect(step(ret, "receive", nil).Code).To(Equal(http.StatusOK))
+		inspection := map[string]interface{}{"note": "Box opened"}
+		if restock {
+			inspection["restock"] = []uint{1}
+		}
+		Expect(step(ret, "inspect", inspection).Code).To(Equal(http.StatusOK))
+		return ret
+	}
+
+	BeforeEach(func() {
+		newTestRouter()
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/orders/:id/returns", handlers.CreateReturn)
+		auth.GET("/returns/mine", handlers.GetMyReturns)
+		auth.GET("/store-credit", handlers.GetStoreCredit)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.GET("/returns", handlers.GetReturns)
+		staff.POST("/returns/:id/approve", handlers.ApproveReturn)
+		staff.POST("/returns/:id/reject", handlers.RejectReturn)
+		staff.POST("/returns/:id/receive", handlers.ReceiveReturn)
+		staff.POST("/returns/:id/inspect", handlers.InspectReturn)
+		staff.POST("/returns/:id/resolve", handlers.ResolveReturn)
+		staff.POST("/orders/:id/shipments", handlers.CreateShipment)
+
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 2}, asAdmin())
+		w := request("POST", "/orders", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		json.Unmarshal(w.Body.Bytes(), &order)
+
+		w = request("POST", "/orders/"+itoa(order.ID)+"/shipments", map[string]string{"carrier": "ups", "tracking_number": "1Z999"}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(handlers.ProcessTrackingEvent(carriers.TrackingEvent{TrackingNumber: "1Z999", Status: carriers.StatusDelivered, OccurredAt: time.Now()})).To(Succeed())
+		Expect(database.DB.Orders[order.ID].Status).To(Equal(models.OrderStatusDelivered))
+	})
+
+	Describe("requesting", func() {
+		It("opens a return and notes it in the order history", func() {
+			w, ret := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 1})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+			Expect(ret.Status).To(Equal(models.ReturnStatusRequested))
+			Expect(ret.Reason).To(Equal("Too small"))
+
+			history := database.DB.Orders[order.ID].History
+			Expect(history[len(history)-1].Event).To(Equal("return_requested"))
+			Expect(history[len(history)-1].ReturnID).To(Equal(ret.ID))
+
+			w = request("GET", "/returns/mine", nil, asAdmin())
+			Expect(w.Body.String()).To(ContainSubstring(`"status":"requested"`))
+		})
+
+		It("does not return more than was bought or is already being returned", func() {
+			w, _ := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 3})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+			w, _ = requestReturn(map[string]interface{}{"item_id": 1, "quantity": 2})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+			w, _ = requestReturn(map[string]interface{}{"item_id": 1, "quantity": 1})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("frees the quantity again when a return is rejected", func() {
+			_, ret := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 2})
+			Expect(step(ret, "reject", map[string]string{"note": "Outside the return window"}).Code).To(Equal(http.StatusOK))
+			Expect(database.DB.Returns[ret.ID].StaffNote).To(Equal("Outside the return window"))
+
+			w, _ := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 2})
+			Expect(w.Code).To(Equal(http.StatusCreated))
+		})
+
+		It("only takes back delivered units", func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 2, "quantity": 1}, asAdmin())
+			var undelivered models.Order
+			json.Unmarshal(request("POST", "/orders", nil, asAdmin()).Body.Bytes(), &undelivered)
+
+			w := request("POST", "/orders/"+itoa(undelivered.ID)+"/returns", map[string]interface{}{"reason": "Too small", "lines": []map[string]interface{}{{"item_id": 2, "quantity": 1}}}, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusConflict))
+		})
+
+		It("rejects lines that are not on the order", func() {
+			w, _ := requestReturn(map[string]interface{}{"item_id": 3, "quantity": 1})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("processing", func() {
+		It("enforces the order of steps", func() {
+			_, ret := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 1})
+			Expect(step(ret, "receive", nil).Code).To(Equal(http.StatusConflict))
+			Expect(step(ret, "approve", nil).Code).To(Equal(http.StatusOK))
+			Expect(step(ret, "resolve", map[string]string{"outcome": "refund"}).Code).To(Equal(http.StatusConflict))
+			Expect(step(ret, "approve", nil).Code).To(Equal(http.StatusConflict))
+		})
+
+		It("restocks only what inspection chose to", func() {
+			Expect(*database.DB.Items[1].Stock).To(Equal(23))
+			inspected(true)
+			Expect(*database.DB.Items[1].Stock).To(Equal(24))
+
+			inspected(false)
+			Expect(*database.DB.Items[1].Stock).To(Equal(24))
+		})
+
+		It("refunds the original payment", func() {
+			ret := inspected(true)
+			w := step(ret, "resolve", map[string]string{"outcome": "refund"})
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			resolved := database.DB.Returns[ret.ID]
+			Expect(resolved.Status).To(Equal(models.ReturnStatusRefunded))
+			Expect(resolved.Amount).To(Equal(order.Totals.Total / 2))
+			Expect(resolved.RefundID).ToNot(BeZero())
+
+			stored := database.DB.Orders[order.ID]
+			Expect(stored.Balance.Refunded).To(Equal(resolved.Amount))
+			Expect(stored.Payment.Refunded).To(Equal(resolved.Amount))
+			Expect(*database.DB.Items[1].Stock).To(Equal(24))
+			Expect(stored.History[len(stored.History)-1].Event).To(Equal("return_refunded"))
+		})
+
+		It("pays out store credit instead", func() {
+			ret := inspected(false)
+			Expect(step(ret, "resolve", map[string]string{"outcome": "store_credit"}).Code).To(Equal(http.StatusOK))
+
+			stored := database.DB.Orders[order.ID]
+			Expect(stored.Balance.StoreCredit).To(Equal(order.Totals.Total / 2))
+			Expect(stored.Balance.Refunded).To(BeZero())
+			Expect(stored.Payment.Refunded).To(BeZero())
+
+			w := request("GET", "/store-credit", nil, asAdmin())
+			var credit struct {
+				Balance int64 `json:"balance"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &credit)
+			Expect(credit.Balance).To(Equal(order.Totals.Total / 2))
+		})
+
+		It("needs a known outcome to resolve", func() {
+			ret := inspected(false)
+			Expect(step(ret, "resolve", nil).Code).To(Equal(http.StatusBadRequest))
+			Expect(step(ret, "resolve", map[string]string{"outcome": "cash"}).Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("lists returns by status for staff", func() {
+			_, first := requestReturn(map[string]interface{}{"item_id": 1, "quantity": 1})
+			requestReturn(map[string]interface{}{"item_id": 1, "quantity": 1})
+			step(first, "approve", nil)
+
+			var returns []models.Return
+			json.Unmarshal(request("GET", "/returns?status=approved", nil, asAdmin()).Body.Bytes(), &returns)
+			Expect(returns).To(HaveLen(1))
+			Expect(returns[0].ID).To(Equal(first.ID))
+		})
+	})
+})
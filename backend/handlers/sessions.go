@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxUserAgentLength caps the user agent kept with a session.
+const maxUserAgentLength = 512
+
+// Browsers and systems named in session device descriptions, in the order
+// they are looked for: Edge and Opera also claim to be Chrome, and Chrome
+// also claims to be Safari.
+var (
+	userAgentBrowsers = [][2]string{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"}, {"PostmanRuntime/", "Postman"},
+	}
+	userAgentSystems = [][2]string{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// describeDevice turns a user agent into a short description such as
+// "Firefox on Windows".
+func describeDevice(userAgent string) string {
+	browser, system := "", ""
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b[0]) {
+			browser = b[1]
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s[0]) {
+			system = s[1]
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return "Unknown device"
+}
+
+// startSession records a new login session for the request's device and
+// returns it with its token.
+// Callers must hold database.DB.Mutex.
+func startSession(c *gin.Context, user *models.User) (*models.Session, string, error) {
+	now := Clock.Now()
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session := &models.Session{
+		ID:         database.DB.GetNextID(),
+		UserID:     user.ID,
+		Device:     describeDevice(userAgent),
+		UserAgent:  userAgent,
+		IP:         c.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(utils.TokenTTL),
+	}
+	// The token's own expiry is on the wall clock
+	token, err := utils.GenerateSessionToken(user.ID, user.Username, session.ID, time.Now().Add(utils.TokenTTL))
+	if err != nil {
+		return nil, "", err
+	}
+	database.DB.Sessions[session.ID] = session
+	return session, token, nil
+}
+
+// activeSessions lists a user's sessions that can still be used, newest
+// first, marking the one with ID current.
+// Callers must hold database.DB.Mutex, for reading at least.
+func activeSessions(userID, current uint) []models.Session {
+	now := Clock.Now()
+	sessions := []models.Session{}
+	for _, session := range database.DB.Sessions {
+		if session.UserID == userID && session.RevokedAt == nil && now.Before(session.ExpiresAt) {
+			s := *session
+			s.Current = s.ID == current
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID > sessions[j].ID })
+	return sessions
+}
+
+// revokeSession ends a session.
+// Callers must hold database.DB.Mutex.
+func revokeSession(c *gin.Context, session *models.Session, action string) {
+	now := Clock.Now()
+	session.RevokedAt = &now
+	recordAudit(c, models.AuditEntry{
+		Action:     action,
+		TargetType: AuditTargetUser,
+		TargetID:   session.UserID,
+		Metadata:   map[string]string{"session_id": fmt.Sprintf("%d", session.ID), "device": session.Device},
+	})
+}
+
+// revokeUserSessions ends all of a user's sessions but the one with ID
+// keep, if any. It returns how many sessions were ended.
+// Callers must hold database.DB.Mutex.
+func revokeUserSessions(user *models.User, keep uint) int {
+	now := Clock.Now()
+	revoked := 0
+	for _, session := range database.DB.Sessions {
+		if session.UserID == user.ID && session.ID != keep && session.RevokedAt == nil && now.Before(session.ExpiresAt) {
+			session.RevokedAt = &now
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// ownSession looks up one of the current user's sessions named in the
+// path.
+// Callers must hold database.DB.Mutex.
+func ownSession(c *gin.Context) (*models.Session, bool) {
+	var sessionID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &sessionID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return nil, false
+	}
+	session, exists := database.DB.Sessions[sessionID]
+	if !exists || session.UserID != c.GetUint("user_id") || session.RevokedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+	return session, true
+}
+
+// GetSessions lists the current user's active sessions.
+func GetSessions(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	c.JSON(http.StatusOK, activeSessions(c.GetUint("user_id"), c.GetUint("session_id")))
+}
+
+// RevokeSession signs one of the current user's devices out.
+func RevokeSession(c *gin.Context) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	session, ok := ownSession(c)
+	if !ok {
+		return
+	}
+	revokeSession(c, session, AuditSessionRevoked)
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// LogoutUser ends the session the request was made with.
+func LogoutUser(c *gin.Context) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	session, exists := database.DB.Sessions[c.GetUint("session_id")]
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This token has no session to log out of"})
+		return
+	}
+	revokeSession(c, session, AuditUserLoggedOut)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// GetUserSessions lists any user's active sessions.
+func GetUserSessions(c *gin.Context) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	if _, exists := database.DB.Users[userID]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	auditRead(c, AuditTargetUser, userID, userID, map[string]string{"data": "sessions"})
+	c.JSON(http.StatusOK, activeSessions(userID, c.GetUint("session_id")))
+}
+
+// ForceLogoutUser signs a user out on every device at once and revokes
+// their API keys.
+func ForceLogoutUser(c *gin.Context) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, exists := database.DB.Users[userID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	revoked := revokeUserSessions(user, 0)
+	keys := revokeUserAPIKeys(user)
+	recordAudit(c, models.AuditEntry{
+		Action:     AuditUserForcedLogout,
+		TargetType: AuditTargetUser,
+		TargetID:   user.ID,
+		Metadata:   map[string]string{"sessions": fmt.Sprintf("%d", revoked), "api_keys": fmt.Sprintf("%d", keys)},
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "User logged out everywhere", "sessions_revoked": revoked, "api_keys_revoked": keys})
+}
+
+// PruneSessions drops sessions that have expired.
+func PruneSessions(now time.Time) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for id, session := range database.DB.Sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(database.DB.Sessions, id)
+		}
+	}
+}
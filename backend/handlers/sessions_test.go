@@ -0,0 +1,178 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/utils"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sessions", func() {
+	const (
+		firefox = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:126.0) Gecko/20100101 Firefox/126.0"
+		iphone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1"
+	)
+
+	var (
+		fake  *clock.Fake
+		admin *models.User
+	)
+
+	// login signs in from a device and returns the headers to use its session
+	login := func(username, password, userAgent string) map[string]string {
+		w := request("POST", "/users/login", map[string]string{"username": username, "password": password}, map[string]string{"User-Agent": userAgent})
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var response handlers.LoginResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return map[string]string{"Authorization": "Bearer " + response.Token}
+	}
+
+	sessions := func(headers map[string]string) []models.Session {
+		w := request("GET", "/sessions", nil, headers)
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var list []models.Session
+		json.Unmarshal(w.Body.Bytes(), &list)
+		return list
+	}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 8, 1, 9, 0, 0, 0, time.UTC))
+		handlers.Clock = fake
+		middleware.Clock = fake
+
+		admin = newTestRouter()
+		router.POST("/users", handlers.CreateUser)
+		router.POST("/users/login", handlers.LoginUser)
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/users/logout", handlers.LogoutUser)
+		auth.GET("/sessions", handlers.GetSessions)
+		auth.DELETE("/sessions/:id", handlers.RevokeSession)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.GET("/users/:id/sessions", handlers.GetUserSessions)
+		staff.POST("/users/:id/logout", handlers.ForceLogoutUser)
+
+		Expect(request("POST", "/users", map[string]string{"username": "mia", "password": "pass-word"}, nil).Code).To(Equal(http.StatusCreated))
+	})
+
+	AfterEach(func() {
+		handlers.Clock = clock.Real{}
+		middleware.Clock = clock.Real{}
+	})
+
+	It("keeps a session per login, so devices do not sign each other out", func() {
+		laptop := login("mia", "pass-word", firefox)
+		phone := login("mia", "pass-word", iphone)
+
+		Expect(request("GET", "/sessions", nil, laptop).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "/sessions", nil, phone).Code).To(Equal(http.StatusOK))
+
+		list := sessions(phone)
+		Expect(list).To(HaveLen(2))
+		Expect(list[0].Device).To(Equal("Safari on iOS"))
+		Expect(list[0].Current).To(BeTrue())
+		Expect(list[0].IP).To(Equal(clientIP))
+		Expect(list[1].Device).To(Equal("Firefox on Windows"))
+		Expect(list[1].UserAgent).To(Equal(firefox))
+		Expect(list[1].Current).To(BeFalse())
+	})
+
+	It("notes when each session was last seen", func() {
+		laptop := login("mia", "pass-word", firefox)
+		fake.Advance(10 * time.Minute)
+		request("GET", "/sessions", nil, laptop)
+
+		list := sessions(laptop)
+		Expect(list[0].LastSeenAt.Equal(fake.Now())).To(BeTrue())
+		Expect(list[0].CreatedAt.Equal(fake.Now().Add(-10 * time.Minute))).To(BeTrue())
+	})
+
+	It("lets users sign a device out", func() {
+		laptop := login("mia", "pass-word", firefox)
+		phone := login("mia", "pass-word", iphone)
+		phoneSession := sessions(phone)[0]
+
+		Expect(request("DELETE", "/sessions/"+itoa(phoneSession.ID), nil, laptop).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "/sessions", nil, phone).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("GET", "/sessions", nil, laptop).Code).To(Equal(http.StatusOK))
+		Expect(sessions(laptop)).To(HaveLen(1))
+
+		// Only their own sessions
+		other := login("admin", "Admin@123", firefox)
+		laptopSession := sessions(laptop)[0]
+		Expect(request("DELETE", "/sessions/"+itoa(laptopSession.ID), nil, other).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("refuses tokens that have no session", func() {
+		laptop := login("mia", "pass-word", firefox)
+		mia := sessions(laptop)[0].UserID
+		sessionless, _ := utils.GenerateSessionToken(mia, "mia", 0, time.Now().Add(utils.TokenTTL))
+
+		Expect(request("GET", "/sessions", nil, map[string]string{"Authorization": "Bearer " + sessionless}).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("logs out of the current session", func() {
+		laptop := login("mia", "pass-word", firefox)
+		phone := login("mia", "pass-word", iphone)
+
+		Expect(request("POST", "/users/logout", nil, laptop).Code).To(Equal(http.StatusOK))
+		Expect(request("GET", "/sessions", nil, laptop).Code).To(Equal(http.StatusUnauthorized))
+		Expect(request("GET", "/sessions", nil, phone).Code).To(Equal(http.StatusOK))
+	})
+
+	It("lets admins see a user's sessions and log them out everywhere", func() {
+		staff := login("admin", "Admin@123", firefox)
+		laptop := login("mia", "pass-word", firefox)
+		phone := login("mia", "pass-word", iphone)
+		mia := sessions(laptop)[0].UserID
+		keyID := database.DB.GetNextID()
+		database.DB.APIKeys[keyID] = &models.APIKey{ID: keyID, UserID: mia}
+
+		w := request("GET", "/users/"+itoa(mia)+"/sessions", nil, staff)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var list []models.Session
+		json.Unmarshal(w.Body.Bytes(), &list)
+		Expect(list).To(HaveLen(2))
+
+		w = request("POST", "/users/"+itoa(mia)+"/logout", nil, staff)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`"sessions_revoked":2`))
+		Expect(w.Body.String()).To(ContainSubstring(`"api_keys_revoked":1`))
+		Expect(database.DB.APIKeys[keyID].RevokedAt).ToNot(BeNil())
+
+		for _, headers := range []map[string]string{laptop, phone} {
+			Expect(request("GET", "/sessions", nil, headers).Code).To(Equal(http.StatusUnauthorized))
+		}
+		Expect(request("GET", "/sessions", nil, staff).Code).To(Equal(http.StatusOK))
+
+		// Logging in again works
+		Expect(request("GET", "/sessions", nil, login("mia", "pass-word", iphone)).Code).To(Equal(http.StatusOK))
+
+		var entries []models.AuditEntry
+		for _, e := range database.DB.AuditLog {
+			if e.Action == handlers.AuditUserForcedLogout {
+				entries = append(entries, *e)
+			}
+		}
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].ActorID).To(Equal(admin.ID))
+	})
+
+	It("forgets expired sessions", func() {
+		laptop := login("mia", "pass-word", firefox)
+		fake.Advance(utils.TokenTTL)
+		Expect(sessions(laptop)).To(BeEmpty())
+
+		handlers.PruneSessions(fake.Now())
+		Expect(database.DB.Sessions).To(BeEmpty())
+	})
+})
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"ecommerce-backend/carriers"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errNotShippable     = errors.New("order cannot be shipped")
+	errShipmentQuantity = errors.New("shipment quantity exceeds what is left to ship")
+	errNothingToShip    = errors.New("nothing left to ship")
+)
+
+// Carrier buys labels for shipments. main wires the stub carrier's tracking
+// updates to ProcessTrackingEvent; without that, parcels stay at
+// label_created.
+var Carrier carriers.Carrier = carriers.NewStub(carriers.StubConfig{})
+
+// ShipmentLineRequest puts a quantity of one order line in a parcel.
+type ShipmentLineRequest struct {
+	ItemID   uint `json:"item_id" binding:"required"`
+	Quantity int  `json:"quantity" binding:"required,min=1"`
+}
+
+// ShipmentRequest creates a parcel for an order. Without lines, everything
+// left to ship goes in it. With a Carrier and TrackingNumber the parcel was
+// sent outside the carrier adapter and no label is bought.
+type ShipmentRequest struct {
+	Lines          []ShipmentLineRequest `json:"lines" binding:"dive"`
+	Carrier        string                `json:"carrier"`
+	TrackingNumber string                `json:"tracking_number"`
+}
+
+// fulfilling reports whether an order has been paid for and is not
+// cancelled or refunded: it can be shipped, refunded or returned.
+func fulfilling(order *models.Order) bool {
+	switch order.Status {
+	case models.OrderStatusConfirmed, models.OrderStatusPartiallyShipped,
+		models.OrderStatusShipped, models.OrderStatusDelivered:
+		return true
+	}
+	return false
+}
+
+// orderShipments returns an order's shipments, oldest first.
+// Callers must hold database.DB.Mutex.
+func orderShipments(orderID uint) []*models.Shipment {
+	shipments := []*models.Shipment{}
+	for _, shipment := range database.DB.Shipments {
+		if shipment.OrderID == orderID {
+			shipments = append(shipments, shipment)
+		}
+	}
+	sort.Slice(shipments, func(i, j int) bool { return shipments[i].ID < shipments[j].ID })
+	return shipments
+}
+
+// shippedQuantity returns how many units of an order line have been put in
+// parcels; with delivered set, only those in delivered parcels.
+// Callers must hold database.DB.Mutex.
+func shippedQuantity(order *models.Order, itemID uint, delivered bool) int {
+	quantity := 0
+	for _, shipment := range orderShipments(order.ID) {
+		if delivered && shipment.Status != models.ShipmentStatusDelivered {
+			continue
+		}
+		for _, line := range shipment.Lines {
+			if line.ItemID == itemID {
+				quantity += line.Quantity
+			}
+		}
+	}
+	return quantity
+}
+
+// unshippedQuantity returns how many units of an order line still need to
+// be shipped. Refunded units do not.
+// Callers must hold database.DB.Mutex.
+func unshippedQuantity(order *models.Order, line models.LineTotal) int {
+	return max(line.Quantity-refundedQuantity(order, line.ItemID)-shippedQuantity(order, line.ItemID, false), 0)
+}
+
+// shipmentLines checks requested lines against what is left to ship.
+// Callers must hold database.DB.Mutex.
+func shipmentLines(order *models.Order, requested []ShipmentLineRequest) ([]models.ShipmentLine, error) {
+	if len(requested) == 0 {
+		for _, line := range order.Totals.Lines {
+			if left := unshippedQuantity(order, line); left > 0 {
+				requested = append(requested, ShipmentLineRequest{ItemID: line.ItemID, Quantity: left})
+			}
+		}
+		if len(requested) == 0 {
+			return nil, errNothingToShip
+		}
+	}
+
+	quantities := map[uint]int{}
+	itemIDs := []uint{}
+	for _, req := range requested {
+		if _, seen := quantities[req.ItemID]; !seen {
+			itemIDs = append(itemIDs, req.ItemID)
+		}
+		quantities[req.ItemID] += req.Quantity
+	}
+
+	lines := make([]models.ShipmentLine, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		index := -1
+		for i, line := range order.Totals.Lines {
+			if line.ItemID == itemID {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("%w: item %d", errUnknownLine, itemID)
+		}
+		if quantities[itemID] > unshippedQuantity(order, order.Totals.Lines[index]) {
+			return nil, fmt.Errorf("%w: item %d", errShipmentQuantity, itemID)
+		}
+		lines = append(lines, models.ShipmentLine{ItemID: itemID, Quantity: quantities[itemID]})
+	}
+	return lines, nil
+}
+
+// syncFulfillment sets the status of an order being fulfilled from its
+// shipments: partially_shipped while units are left to ship, shipped once
+// none are, and delivered once every parcel has arrived.
+// Callers must hold database.DB.Mutex.
+func syncFulfillment(order *models.Order, by uint) {
+	if !fulfilling(order) {
+		return
+	}
+
+	shipments := orderShipments(order.ID)
+	status := models.OrderStatusConfirmed
+	if len(shipments) > 0 {
+		status = models.OrderStatusShipped
+		for _, line := range order.Totals.Lines {
+			if unshippedQuantity(order, line) > 0 {
+				status = models.OrderStatusPartiallyShipped
+			}
+		}
+	}
+	if status == models.OrderStatusShipped {
+		delivered := true
+		for _, shipment := range shipments {
+			delivered = delivered && shipment.Status == models.ShipmentStatusDelivered
+		}
+		if delivered {
+			status = models.OrderStatusDelivered
+		}
+	}
+
+	if status != order.Status {
+		order.Status = status
+		addOrderHistory(order, status, "", 0, by)
+	}
+}
+
+// ProcessTrackingEvent records a carrier's tracking update on its shipment
+// and moves the order along. Updates for unknown parcels and repeated
+// updates change nothing, and a delivered parcel stays delivered.
+func ProcessTrackingEvent(event carriers.TrackingEvent) error {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	var shipment *models.Shipment
+	for _, s := range database.DB.Shipments {
+		if s.TrackingNumber == event.TrackingNumber {
+			shipment = s
+		}
+	}
+	if shipment == nil {
+		return nil
+	}
+	for _, seen := range shipment.Events {
+		if seen.Status == string(event.Status) && seen.OccurredAt.Equal(event.OccurredAt) {
+			return nil
+		}
+	}
+
+	shipment.Events = append(shipment.Events, models.ShipmentEvent{
+		Status:      string(event.Status),
+		Description: event.Description,
+		Location:    event.Location,
+		OccurredAt:  event.OccurredAt,
+	})
+	if shipment.Status == models.ShipmentStatusDelivered {
+		return nil
+	}
+	shipment.Status = string(event.Status)
+
+	order, exists := database.DB.Orders[shipment.OrderID]
+	if !exists {
+		return nil
+	}
+	switch shipment.Status {
+	case models.ShipmentStatusDelivered:
+		deliveredAt := event.OccurredAt
+		shipment.DeliveredAt = &deliveredAt
+		addOrderHistory(order, "shipment_delivered", shipment.TrackingNumber, 0, 0)
+		syncFulfillment(order, 0)
+	case models.ShipmentStatusException:
+		addOrderHistory(order, "shipment_exception", shipment.TrackingNumber+": "+event.Description, 0, 0)
+	}
+	return nil
+}
+
+// CreateShipment ships some or all of what is left on a paid order.
+func CreateShipment(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	// The body is optional; without it everything left is shipped
+	var req ShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	manual := strings.TrimSpace(req.TrackingNumber) != ""
+	if manual && strings.TrimSpace(req.Carrier) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "carrier is required with a tracking number"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if order.Status != models.OrderStatusConfirmed && order.Status != models.OrderStatusPartiallyShipped {
+		c.JSON(http.StatusConflict, gin.H{"error": errNotShippable.Error()})
+		return
+	}
+
+	lines, err := shipmentLines(order, req.Lines)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shipmentID := database.DB.GetNextID()
+	label := carriers.Label{Carrier: strings.TrimSpace(req.Carrier), TrackingNumber: strings.TrimSpace(req.TrackingNumber)}
+	if !manual {
+		parcel := carriers.Parcel{Reference: fmt.Sprintf("shipment-%d", shipmentID)}
+		for _, line := range lines {
+			for _, ordered := range order.Totals.Lines {
+				if ordered.ItemID == line.ItemID {
+					parcel.Weight += ordered.Weight * line.Quantity
+				}
+			}
+		}
+		if address := order.ShippingAddress; address != nil {
+			parcel.Country, parcel.Region = address.Country, address.Region
+		}
+		if label, err = Carrier.CreateLabel(c.Request.Context(), parcel); err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Could not create a shipping label: " + err.Error()})
+			return
+		}
+	}
+
+	now := Clock.Now()
+	shipment := &models.Shipment{
+		ID:             shipmentID,
+		OrderID:        order.ID,
+		Carrier:        label.Carrier,
+		TrackingNumber: label.TrackingNumber,
+		Status:         models.ShipmentStatusLabelCreated,
+		Lines:          lines,
+		Events: []models.ShipmentEvent{{
+			Status:      models.ShipmentStatusLabelCreated,
+			Description: "Shipping label created",
+			OccurredAt:  now,
+		}},
+		CreatedBy: userID.(uint),
+		CreatedAt: now,
+	}
+	database.DB.Shipments[shipment.ID] = shipment
+
+	addOrderHistory(order, "shipment_created", shipment.Carrier+" "+shipment.TrackingNumber, 0, userID.(uint))
+	syncFulfillment(order, userID.(uint))
+
+	c.JSON(http.StatusCreated, *shipment)
+}
+
+// GetOrderShipments lists an order's shipments for its owner or an admin.
+func GetOrderShipments(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var orderID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &orderID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	order, exists := database.DB.Orders[orderID]
+	if !exists || !orderVisibleTo(order, userID.(uint)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	shipments := []models.Shipment{}
+	for _, shipment := range orderShipments(order.ID) {
+		shipments = append(shipments, *shipment)
+	}
+	auditRead(c, AuditTargetOrder, order.ID, order.UserID, map[string]string{"view": "shipments"})
+	c.JSON(http.StatusOK, shipments)
+}
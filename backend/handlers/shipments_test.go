@@ -0,0 +1,187 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/carriers"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shipments", func() {
+	var (
+		order    models.Order
+		fakeTime *clock.Fake
+
+		mu      sync.Mutex
+		updates []carriers.TrackingEvent
+	)
+
+	ship := func(body interface{}) (*httptest.ResponseRecorder, models.Shipment) {
+		w := request("POST", "/orders/"+itoa(order.ID)+"/shipments", body, asAdmin())
+		var shipment models.Shipment
+		json.Unmarshal(w.Body.Bytes(), &shipment)
+		return w, shipment
+	}
+
+	status := func() string {
+		return database.DB.Orders[order.ID].Status
+	}
+
+	// track lets the stub carrier take every parcel one step further and
+	// applies the updates it reports
+	track := func(parcels int) {
+		Eventually(fakeTime.Waiters).Should(Equal(parcels))
+		fakeTime.Advance(time.Hour)
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(updates)
+		}).Should(Equal(parcels))
+
+		mu.Lock()
+		queued := updates
+		updates = nil
+		mu.Unlock()
+		for _, event := range queued {
+			Expect(handlers.ProcessTrackingEvent(event)).To(Succeed())
+		}
+	}
+
+	BeforeEach(func() {
+		newTestRouter()
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+		fakeTime = clock.NewFake(time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC))
+		updates = nil
+		handlers.Carrier = carriers.NewStub(carriers.StubConfig{
+			Clock: fakeTime,
+			Step:  time.Hour,
+			Sink: func(event carriers.TrackingEvent) {
+				mu.Lock()
+				updates = append(updates, event)
+				mu.Unlock()
+			},
+		})
+
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/orders/:id/cancel", handlers.CancelOrder)
+		auth.GET("/orders/:id/shipments", handlers.GetOrderShipments)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.POST("/orders/:id/shipments", handlers.CreateShipment)
+		staff.POST("/orders/:id/refunds", handlers.RefundOrder)
+
+		request("POST", "/carts", map[string]interface{}{"item_id": 1, "quantity": 2}, asAdmin())
+		request("POST", "/carts", map[string]interface{}{"item_id": 5, "quantity": 1}, asAdmin())
+		w := request("POST", "/orders", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		json.Unmarshal(w.Body.Bytes(), &order)
+	})
+
+	AfterEach(func() {
+		handlers.Carrier = carriers.NewStub(carriers.StubConfig{})
+	})
+
+	It("ships in several parcels and tracks them to delivery", func() {
+		w, first := ship(map[string]interface{}{"lines": []map[string]interface{}{{"item_id": 1, "quantity": 1}}})
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(first.Carrier).To(Equal("stub"))
+		Expect(first.TrackingNumber).ToNot(BeEmpty())
+		Expect(first.Status).To(Equal(models.ShipmentStatusLabelCreated))
+		Expect(status()).To(Equal(models.OrderStatusPartiallyShipped))
+
+		w, second := ship(nil)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(second.Lines).To(ConsistOf(
+			models.ShipmentLine{ItemID: 1, Quantity: 1},
+			models.ShipmentLine{ItemID: 5, Quantity: 1},
+		))
+		Expect(status()).To(Equal(models.OrderStatusShipped))
+
+		track(2)
+		Expect(database.DB.Shipments[first.ID].Status).To(Equal(models.ShipmentStatusInTransit))
+		track(2)
+		track(2)
+		Expect(database.DB.Shipments[first.ID].Status).To(Equal(models.ShipmentStatusDelivered))
+		Expect(database.DB.Shipments[first.ID].Events).To(HaveLen(4))
+		Expect(status()).To(Equal(models.OrderStatusDelivered))
+
+		history := database.DB.Orders[order.ID].History
+		Expect(history[len(history)-1].Event).To(Equal(models.OrderStatusDelivered))
+	})
+
+	It("waits for every parcel before the order is delivered", func() {
+		_, first := ship(map[string]interface{}{"carrier": "ups", "tracking_number": "1Z1", "lines": []map[string]interface{}{{"item_id": 1, "quantity": 2}}})
+		ship(map[string]interface{}{"carrier": "ups", "tracking_number": "1Z2"})
+
+		Expect(handlers.ProcessTrackingEvent(carriers.TrackingEvent{TrackingNumber: "1Z1", Status: carriers.StatusDelivered, OccurredAt: fakeTime.Now()})).To(Succeed())
+		Expect(database.DB.Shipments[first.ID].DeliveredAt).ToNot(BeNil())
+		Expect(status()).To(Equal(models.OrderStatusShipped))
+
+		// A late in-transit update does not undo the delivery
+		Expect(handlers.ProcessTrackingEvent(carriers.TrackingEvent{TrackingNumber: "1Z1", Status: carriers.StatusInTransit, OccurredAt: fakeTime.Now()})).To(Succeed())
+		Expect(database.DB.Shipments[first.ID].Status).To(Equal(models.ShipmentStatusDelivered))
+
+		Expect(handlers.ProcessTrackingEvent(carriers.TrackingEvent{TrackingNumber: "1Z2", Status: carriers.StatusDelivered, OccurredAt: fakeTime.Now()})).To(Succeed())
+		Expect(status()).To(Equal(models.OrderStatusDelivered))
+	})
+
+	It("does not ship more than was ordered", func() {
+		w, _ := ship(map[string]interface{}{"lines": []map[string]interface{}{{"item_id": 1, "quantity": 3}}})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		ship(nil)
+		w, _ = ship(nil)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("needs a carrier with a manual tracking number", func() {
+		w, _ := ship(map[string]interface{}{"tracking_number": "1Z1"})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("counts refunded units as nothing left to ship", func() {
+		ship(map[string]interface{}{"lines": []map[string]interface{}{{"item_id": 1, "quantity": 2}}})
+		Expect(status()).To(Equal(models.OrderStatusPartiallyShipped))
+
+		w := request("POST", "/orders/"+itoa(order.ID)+"/refunds", map[string]interface{}{"reason": "Out of stock", "lines": []map[string]interface{}{{"item_id": 5, "quantity": 1}}}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(status()).To(Equal(models.OrderStatusShipped))
+	})
+
+	It("stops cancellation once something has shipped", func() {
+		ship(map[string]interface{}{"lines": []map[string]interface{}{{"item_id": 5, "quantity": 1}}})
+		w := request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Too slow"}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("refuses to ship a cancelled order", func() {
+		request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Too slow"}, asAdmin())
+		w, _ := ship(nil)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+	})
+
+	It("shows shipments only to the order's owner and admins", func() {
+		ship(nil)
+		w := request("GET", "/orders/"+itoa(order.ID)+"/shipments", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("STUB"))
+
+		_, other := asCustomer("someone")
+		w = request("GET", "/orders/"+itoa(order.ID)+"/shipments", nil, other)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+})
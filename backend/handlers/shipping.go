@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"ecommerce-backend/shipping"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShippingSelectionRequest struct {
+	ShippingAddressID uint   `json:"shipping_address_id"`
+	BillingAddressID  uint   `json:"billing_address_id"`
+	ShippingMethod    string `json:"shipping_method"`
+}
+
+type ShippingMethodRequest struct {
+	Code      string   `json:"code" binding:"required"`
+	Name      string   `json:"name" binding:"required"`
+	Zones     []string `json:"zones" binding:"required"`
+	BaseRate  int64    `json:"base_rate"`
+	PerKgRate int64    `json:"per_kg_rate"`
+	FreeOver  int64    `json:"free_over"`
+	MaxWeight int      `json:"max_weight"`
+	MinDays   int      `json:"min_days"`
+	MaxDays   int      `json:"max_days"`
+	Active    *bool    `json:"active"`
+}
+
+type ShippingZoneRequest struct {
+	Code      string   `json:"code" binding:"required"`
+	Name      string   `json:"name"`
+	Countries []string `json:"countries"`
+	Regions   []string `json:"regions"`
+}
+
+var (
+	errAddressNotFound     = errors.New("address not found")
+	errAddressRequired     = errors.New("a shipping address is required to choose a shipping method")
+	errMethodNotAvailable  = errors.New("shipping method is not available for this cart and destination")
+	errShippingCodeInUse   = errors.New("shipping method code already exists")
+	errShippingZoneInUse   = errors.New("shipping zone code already exists")
+	errShippingZoneUnknown = errors.New("unknown shipping zone")
+)
+
+// allShippingMethods lists the shipping catalog.
+// Callers must hold database.DB.Mutex.
+func allShippingMethods() []*models.ShippingMethod {
+	methods := make([]*models.ShippingMethod, 0, len(database.DB.ShippingMethods))
+	for _, method := range database.DB.ShippingMethods {
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+// allShippingZones lists the shipping zones.
+// Callers must hold database.DB.Mutex.
+func allShippingZones() []*models.ShippingZone {
+	zones := make([]*models.ShippingZone, 0, len(database.DB.ShippingZones))
+	for _, zone := range database.DB.ShippingZones {
+		zones = append(zones, zone)
+	}
+	return zones
+}
+
+// shippingAddressFor returns the cart's shipping address, falling back to
+// the user's default address. Callers must hold database.DB.Mutex.
+func shippingAddressFor(cart *models.Cart) *models.Address {
+	if cart.ShippingAddressID != 0 {
+		if address := userAddress(cart.UserID, cart.ShippingAddressID); address != nil {
+			return address
+		}
+	}
+	return defaultAddress(cart.UserID)
+}
+
+// billingAddressFor returns the cart's billing address, falling back to the
+// shipping address. Callers must hold database.DB.Mutex.
+func billingAddressFor(cart *models.Cart) *models.Address {
+	if cart.BillingAddressID != 0 {
+		if address := userAddress(cart.UserID, cart.BillingAddressID); address != nil {
+			return address
+		}
+	}
+	return shippingAddressFor(cart)
+}
+
+// shippingDestination returns the country and region used to price a cart.
+// Callers must hold database.DB.Mutex.
+func shippingDestination(cart *models.Cart, input *pricing.Input) {
+	if address := shippingAddressFor(cart); address != nil {
+		input.Country = address.Country
+		input.Region = address.Region
+	}
+	input.ShippingMethod = cart.ShippingMethod
+}
+
+// applyShippingSelection validates a selection and copies it onto cart.
+// Zero values keep the cart's current choice. Callers must hold
+// database.DB.Mutex.
+func applyShippingSelection(cart *models.Cart, req ShippingSelectionRequest) error {
+	selected := *cart
+	if req.ShippingAddressID != 0 {
+		if userAddress(cart.UserID, req.ShippingAddressID) == nil {
+			return errAddressNotFound
+		}
+		selected.ShippingAddressID = req.ShippingAddressID
+	}
+	if req.BillingAddressID != 0 {
+		if userAddress(cart.UserID, req.BillingAddressID) == nil {
+			return errAddressNotFound
+		}
+		selected.BillingAddressID = req.BillingAddressID
+	}
+	if req.ShippingMethod != "" {
+		selected.ShippingMethod = strings.ToLower(strings.TrimSpace(req.ShippingMethod))
+	}
+
+	if err := checkShippingMethod(&selected); err != nil {
+		return err
+	}
+
+	*cart = selected
+	return nil
+}
+
+// checkShippingMethod makes sure the cart's shipping method can ship the
+// cart to its destination. Callers must hold database.DB.Mutex.
+func checkShippingMethod(cart *models.Cart) error {
+	if cart.ShippingMethod == "" {
+		return nil
+	}
+	address := shippingAddressFor(cart)
+	if address == nil {
+		return errAddressRequired
+	}
+	totals, err := Pricer.Price(pricingInput(cart, cartItemsFor(cart.ID)))
+	if err != nil {
+		return err
+	}
+	if _, ok := shipping.QuoteFor(allShippingMethods(), allShippingZones(), address.Country, address.Region, cart.ShippingMethod, &totals); !ok {
+		return errMethodNotAvailable
+	}
+	return nil
+}
+
+func shippingSelectionStatus(err error) int {
+	switch {
+	case errors.Is(err, errAddressNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errAddressRequired):
+		return http.StatusBadRequest
+	case errors.Is(err, errMethodNotAvailable):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func GetShippingQuotes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	cart := activeCart(userID.(uint))
+	if cart == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+	address := shippingAddressFor(cart)
+	if address == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Add a shipping address to get shipping quotes"})
+		return
+	}
+
+	totals, err := Pricer.Price(pricingInput(cart, cartItemsFor(cart.ID)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shipping_address_id": address.ID,
+		"selected":            cart.ShippingMethod,
+		"quotes":              shipping.Quotes(allShippingMethods(), allShippingZones(), address.Country, address.Region, &totals),
+	})
+}
+
+func SelectShipping(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req ShippingSelectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	cart := activeCart(userID.(uint))
+	if cart == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+
+	if err := applyShippingSelection(cart, req); err != nil {
+		c.JSON(shippingSelectionStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	touchCart(cart)
+
+	response, err := cartResponse(cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func GetShippingMethods(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	methods := []models.ShippingMethod{}
+	for _, method := range allShippingMethods() {
+		if method.Active {
+			methods = append(methods, *method)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].ID < methods[j].ID })
+
+	c.JSON(http.StatusOK, methods)
+}
+
+func (req ShippingMethodRequest) apply(method *models.ShippingMethod) {
+	method.Code = strings.ToLower(strings.TrimSpace(req.Code))
+	method.Name = req.Name
+	method.Zones = req.Zones
+	method.BaseRate = req.BaseRate
+	method.PerKgRate = req.PerKgRate
+	method.FreeOver = req.FreeOver
+	method.MaxWeight = req.MaxWeight
+	method.MinDays = req.MinDays
+	method.MaxDays = req.MaxDays
+	method.Active = req.Active == nil || *req.Active
+}
+
+// checkShippingMethodRequest validates a catalog entry against the zones
+// and other methods. Callers must hold database.DB.Mutex.
+func checkShippingMethodRequest(method *models.ShippingMethod) error {
+	if err := shipping.ValidateMethod(method); err != nil {
+		return err
+	}
+	for _, code := range method.Zones {
+		found := false
+		for _, zone := range database.DB.ShippingZones {
+			found = found || zone.Code == code
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", errShippingZoneUnknown, code)
+		}
+	}
+	for _, other := range database.DB.ShippingMethods {
+		if other.Code == method.Code && other.ID != method.ID {
+			return errShippingCodeInUse
+		}
+	}
+	return nil
+}
+
+func CreateShippingMethod(c *gin.Context) {
+	var req ShippingMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	method := &models.ShippingMethod{}
+	req.apply(method)
+	if err := checkShippingMethodRequest(method); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errShippingCodeInUse) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	method.ID = database.DB.GetNextID()
+	method.CreatedAt = time.Now()
+	database.DB.ShippingMethods[method.ID] = method
+
+	c.JSON(http.StatusCreated, *method)
+}
+
+func UpdateShippingMethod(c *gin.Context) {
+	var methodID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &methodID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+		return
+	}
+
+	var req ShippingMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	method, exists := database.DB.ShippingMethods[methodID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+		return
+	}
+
+	updated := *method
+	req.apply(&updated)
+	if err := checkShippingMethodRequest(&updated); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errShippingCodeInUse) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	*method = updated
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteShippingMethod deactivates a method so past orders keep their code.
+func DeleteShippingMethod(c *gin.Context) {
+	var methodID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &methodID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	method, exists := database.DB.ShippingMethods[methodID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+		return
+	}
+	method.Active = false
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipping method deactivated"})
+}
+
+func GetShippingZones(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	zones := []models.ShippingZone{}
+	for _, zone := range allShippingZones() {
+		zones = append(zones, *zone)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ID < zones[j].ID })
+
+	c.JSON(http.StatusOK, zones)
+}
+
+func CreateShippingZone(c *gin.Context) {
+	var req ShippingZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zone := &models.ShippingZone{
+		Code:      strings.ToLower(strings.TrimSpace(req.Code)),
+		Name:      req.Name,
+		Countries: req.Countries,
+		Regions:   req.Regions,
+	}
+	if err := shipping.ValidateZone(zone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for _, other := range database.DB.ShippingZones {
+		if other.Code == zone.Code {
+			c.JSON(http.StatusConflict, gin.H{"error": errShippingZoneInUse.Error()})
+			return
+		}
+	}
+
+	zone.ID = database.DB.GetNextID()
+	zone.CreatedAt = time.Now()
+	database.DB.ShippingZones[zone.ID] = zone
+
+	c.JSON(http.StatusCreated, *zone)
+}
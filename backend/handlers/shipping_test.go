@@ -0,0 +1,166 @@
+package handlers_test
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Address and Shipping Handlers", func() {
+	createAddress := func(body map[string]interface{}) models.Address {
+		w := request("POST", "/addresses", body, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var address models.Address
+		json.Unmarshal(w.Body.Bytes(), &address)
+		return address
+	}
+
+	californiaAddress := map[string]interface{}{"name": "Ada", "line1": "1 Main St", "city": "San Jose", "region": "ca", "country": "us"}
+
+	BeforeEach(func() {
+		newTestRouter()
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.GET("/carts/shipping/quotes", handlers.GetShippingQuotes)
+		auth.PUT("/carts/shipping", handlers.SelectShipping)
+		auth.GET("/addresses", handlers.GetAddresses)
+		auth.POST("/addresses", handlers.CreateAddress)
+		auth.PUT("/addresses/:id", handlers.UpdateAddress)
+		auth.DELETE("/addresses/:id", handlers.DeleteAddress)
+	})
+
+	Describe("Address book", func() {
+		It("makes the first address the default and keeps a single default", func() {
+			first := createAddress(californiaAddress)
+			Expect(first.IsDefault).To(BeTrue())
+			Expect(first.Country).To(Equal("US"))
+			Expect(first.Region).To(Equal("CA"))
+
+			second := createAddress(map[string]interface{}{"name": "Ada", "line1": "2 Side St", "city": "Austin", "region": "TX", "country": "US", "is_default": true})
+			Expect(second.IsDefault).To(BeTrue())
+			Expect(database.DB.Addresses[first.ID].IsDefault).To(BeFalse())
+
+			var addresses []models.Address
+			json.Unmarshal(request("GET", "/addresses", nil, asAdmin()).Body.Bytes(), &addresses)
+			Expect(addresses).To(HaveLen(2))
+		})
+
+		It("validates, updates and deletes addresses", func() {
+			Expect(request("POST", "/addresses", map[string]interface{}{"name": "Ada", "line1": "x", "city": "y", "country": "USA"}, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+
+			address := createAddress(californiaAddress)
+			update := map[string]interface{}{"name": "Ada L", "line1": "1 Main St", "city": "San Jose", "region": "CA", "country": "US"}
+			Expect(request("PUT", "/addresses/9999", update, asAdmin()).Code).To(Equal(http.StatusNotFound))
+
+			w := request("PUT", "/addresses/"+itoa(address.ID), update, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(database.DB.Addresses[address.ID].Name).To(Equal("Ada L"))
+			Expect(database.DB.Addresses[address.ID].IsDefault).To(BeTrue())
+
+			Expect(request("DELETE", "/addresses/"+itoa(address.ID), nil, asAdmin()).Code).To(Equal(http.StatusOK))
+			Expect(database.DB.Addresses).To(BeEmpty())
+		})
+	})
+
+	Describe("Shipping at checkout", func() {
+		BeforeEach(func() {
+			request("POST", "/carts", map[string]interface{}{"item_id": 4}, asAdmin()) // Keyboard, 49.99, 800g
+		})
+
+		It("needs an address before quoting", func() {
+			Expect(request("GET", "/carts/shipping/quotes", nil, asAdmin()).Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("quotes rates for the destination and prices the selected method", func() {
+			createAddress(californiaAddress)
+
+			w := request("GET", "/carts/shipping/quotes", nil, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var body struct {
+				Quotes []struct {
+					Method string `json:"method"`
+					Amount int64  `json:"amount"`
+				} `json:"quotes"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			Expect(body.Quotes).To(HaveLen(2))
+			Expect(body.Quotes[0].Method).To(Equal("standard"))
+			Expect(body.Quotes[0].Amount).To(Equal(int64(699)))
+
+			w = request("PUT", "/carts/shipping", map[string]string{"shipping_method": "express"}, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var cart handlers.CartResponse
+			json.Unmarshal(w.Body.Bytes(), &cart)
+			Expect(cart.ShippingMethod).To(Equal("express"))
+			Expect(cart.Totals.Shipping).To(Equal(int64(1699)))
+			// California tax applies now that the destination is known
+			Expect(cart.Totals.Tax).To(Equal(int64(362)))
+		})
+
+		It("rejects methods that do not serve the destination", func() {
+			createAddress(map[string]interface{}{"name": "Ada", "line1": "1 Rue", "city": "Paris", "country": "FR"})
+
+			Expect(request("PUT", "/carts/shipping", map[string]string{"shipping_method": "standard"}, asAdmin()).Code).To(Equal(http.StatusConflict))
+			Expect(request("PUT", "/carts/shipping", map[string]interface{}{"shipping_address_id": 9999}, asAdmin()).Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("captures addresses and the method on the order", func() {
+			shipTo := createAddress(californiaAddress)
+			billTo := createAddress(map[string]interface{}{"name": "Ada", "line1": "2 Side St", "city": "Austin", "region": "TX", "country": "US"})
+
+			w := request("POST", "/orders", map[string]interface{}{
+				"shipping_address_id": shipTo.ID,
+				"billing_address_id":  billTo.ID,
+				"shipping_method":     "standard",
+			}, asAdmin())
+			Expect(w.Code).To(Equal(http.StatusCreated))
+
+			var order models.Order
+			json.Unmarshal(w.Body.Bytes(), &order)
+			Expect(order.ShippingAddress.City).To(Equal("San Jose"))
+			Expect(order.BillingAddress.City).To(Equal("Austin"))
+			Expect(order.ShippingMethod).To(Equal("standard"))
+			Expect(order.Totals.Shipping).To(Equal(int64(699)))
+
+			// The order keeps its copy when the address book changes
+			database.DB.Addresses[shipTo.ID].City = "Elsewhere"
+			Expect(database.DB.Orders[order.ID].ShippingAddress.City).To(Equal("San Jose"))
+		})
+
+		It("still accepts orders without a body", func() {
+			Expect(request("POST", "/orders", nil, asAdmin()).Code).To(Equal(http.StatusCreated))
+		})
+
+		Describe("with tax-inclusive prices", func() {
+			BeforeEach(func() {
+				handlers.Taxes.Inclusive = true
+			})
+
+			AfterEach(func() {
+				handlers.Taxes.Inclusive = false
+			})
+
+			It("charges the catalog price and reports the tax it contains", func() {
+				shipTo := createAddress(californiaAddress)
+
+				w := request("POST", "/orders", map[string]interface{}{"shipping_address_id": shipTo.ID, "shipping_method": "standard"}, asAdmin())
+				Expect(w.Code).To(Equal(http.StatusCreated))
+
+				var order models.Order
+				json.Unmarshal(w.Body.Bytes(), &order)
+				Expect(order.Totals.Tax).To(BeZero())
+				// 7.25% California tax contained in 49.99
+				Expect(order.Totals.TaxInclusive).To(Equal(int64(338)))
+				Expect(order.Totals.Total).To(Equal(int64(4999 + 699)))
+			})
+		})
+	})
+})
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"fmt"
+	"time"
+)
+
+// GuestHold is how long a guest cart's reservations hold stock after the
+// cart last changed, so anonymous carts cannot tie stock up for as long as
+// they are kept. Changing the cart or signing in holds it again. Zero holds
+// for the life of the cart.
+var GuestHold = 30 * time.Minute
+
+// availableStock returns how many units of an item can still be put in a
+// cart: the stock on hand less what other active carts have reserved. Carts
+// in exclude do not count against it. It returns false when the item does
+// not track stock. Callers must hold database.DB.Mutex.
+func availableStock(item *models.Item, exclude ...uint) (int, bool) {
+	if item.Stock == nil {
+		return 0, false
+	}
+	now := Clock.Now()
+	available := *item.Stock
+	for _, reservation := range database.DB.Reservations {
+		if reservation.ItemID != item.ID || containsID(exclude, reservation.CartID) || !held(reservation, now) {
+			continue
+		}
+		available -= reservation.Quantity
+	}
+	return max(available, 0), true
+}
+
+// held reports whether a reservation still holds stock as of now: a guest
+// cart's lapses GuestHold after it was made.
+// Callers must hold database.DB.Mutex.
+func held(reservation *models.StockReservation, now time.Time) bool {
+	if GuestHold <= 0 || now.Sub(reservation.CreatedAt) < GuestHold {
+		return true
+	}
+	cart, exists := database.DB.Carts[reservation.CartID]
+	return exists && cart.UserID != 0
+}
+
+// syncReservations makes a cart's reservations match its lines. Only active
+// carts hold stock; for any other cart every reservation is released.
+// Callers must hold database.DB.Mutex.
+func syncReservations(cart *models.Cart) {
+	releaseReservations(cart.ID)
+	if cart.Status != models.CartStatusActive {
+		return
+	}
+	for _, line := range cartItemsFor(cart.ID) {
+		item, exists := database.DB.Items[line.ItemID]
+		if !exists || item.Stock == nil {
+			continue
+		}
+		database.DB.Reservations[cartItemKey(cart.ID, line.ItemID)] = &models.StockReservation{
+			CartID:    cart.ID,
+			ItemID:    line.ItemID,
+			Quantity:  max(line.Quantity, 1),
+			CreatedAt: Clock.Now(),
+		}
+	}
+}
+
+// releaseReservations frees all stock held by a cart.
+// Callers must hold database.DB.Mutex.
+func releaseReservations(cartID uint) {
+	for key, reservation := range database.DB.Reservations {
+		if reservation.CartID == cartID {
+			delete(database.DB.Reservations, key)
+		}
+	}
+}
+
+// commitStock takes a cart's lines out of stock at checkout and releases its
+// reservations. It changes nothing if any line is short.
+// Callers must hold database.DB.Mutex.
+func commitStock(cart *models.Cart, cartItems []models.CartItem) error {
+	for _, line := range cartItems {
+		item, exists := database.DB.Items[line.ItemID]
+		if !exists {
+			continue
+		}
+		if available, tracked := availableStock(item, cart.ID); tracked && max(line.Quantity, 1) > available {
+			return fmt.Errorf("not enough stock for %s", item.Name)
+		}
+	}
+
+	for _, line := range cartItems {
+		if item, exists := database.DB.Items[line.ItemID]; exists && item.Stock != nil {
+			*item.Stock -= max(line.Quantity, 1)
+		}
+	}
+	releaseReservations(cart.ID)
+	return nil
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreStock puts the lines of a failed or cancelled order back in stock.
+// Callers must hold database.DB.Mutex.
+func restoreStock(cartItems []models.CartItem) {
+	for _, line := range cartItems {
+		if item, exists := database.DB.Items[line.ItemID]; exists && item.Stock != nil {
+			*item.Stock += max(line.Quantity, 1)
+		}
+	}
+}
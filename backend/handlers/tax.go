@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"ecommerce-backend/tax"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TaxRuleRequest struct {
+	Country  string `json:"country" binding:"required"`
+	Region   string `json:"region"`
+	TaxClass string `json:"tax_class"`
+	Name     string `json:"name" binding:"required"`
+	Rate     int64  `json:"rate"`
+}
+
+type TaxExemptRequest struct {
+	TaxExempt bool `json:"tax_exempt"`
+}
+
+// allTaxRules lists the tax rule table. Callers must hold database.DB.Mutex.
+func allTaxRules() []*models.TaxRule {
+	rules := make([]*models.TaxRule, 0, len(database.DB.TaxRules))
+	for _, rule := range database.DB.TaxRules {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func GetTaxRules(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	rules := []models.TaxRule{}
+	for _, rule := range allTaxRules() {
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func CreateTaxRule(c *gin.Context) {
+	var req TaxRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := &models.TaxRule{
+		Country:  req.Country,
+		Region:   req.Region,
+		TaxClass: req.TaxClass,
+		Name:     req.Name,
+		Rate:     req.Rate,
+	}
+	tax.Normalize(rule)
+	if err := tax.Validate(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	rule.ID = database.DB.GetNextID()
+	rule.CreatedAt = time.Now()
+	database.DB.TaxRules[rule.ID] = rule
+
+	c.JSON(http.StatusCreated, *rule)
+}
+
+func UpdateTaxRule(c *gin.Context) {
+	var ruleID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &ruleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rule ID"})
+		return
+	}
+
+	var req TaxRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	rule, exists := database.DB.TaxRules[ruleID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rule not found"})
+		return
+	}
+
+	updated := *rule
+	updated.Country = req.Country
+	updated.Region = req.Region
+	updated.TaxClass = req.TaxClass
+	updated.Name = req.Name
+	updated.Rate = req.Rate
+	tax.Normalize(&updated)
+	if err := tax.Validate(&updated); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	*rule = updated
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteTaxRule removes a rule. Orders keep the tax lines frozen at checkout.
+func DeleteTaxRule(c *gin.Context) {
+	var ruleID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &ruleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tax rule ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	if _, exists := database.DB.TaxRules[ruleID]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tax rule not found"})
+		return
+	}
+	delete(database.DB.TaxRules, ruleID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tax rule deleted"})
+}
+
+func SetUserTaxExempt(c *gin.Context) {
+	var userID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req TaxExemptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	user, exists := database.DB.Users[userID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.TaxExempt != req.TaxExempt {
+		before := *user
+		user.TaxExempt = req.TaxExempt
+		recordAudit(c, models.AuditEntry{
+			Action:     AuditUserTaxExempt,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Changes:    auditChanges(before, *user),
+		})
+	}
+
+	responseUser := *user
+	responseUser.Password = ""
+	c.JSON(http.StatusOK, responseUser)
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"ecommerce-backend/database"
+	"ecommerce-backend/events"
+	"ecommerce-backend/models"
+	"ecommerce-backend/webhooks"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookSender posts webhook deliveries. Tests point endpoints at an
+// httptest server.
+var WebhookSender = webhooks.Sender{Client: &http.Client{Timeout: 10 * time.Second}}
+
+// WebhookRetries spaces out the attempts of failing deliveries.
+var WebhookRetries = webhooks.DefaultRetryPolicy
+
+var errUnknownEventType = errors.New("unknown event type")
+
+type WebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required,min=1"`
+	Active      *bool    `json:"active"` // defaults to true
+}
+
+// checkEventTypes rejects subscriptions to unknown event types.
+func checkEventTypes(types []string) error {
+	for _, eventType := range types {
+		if !webhooks.ValidEventType(eventType) {
+			return fmt.Errorf("%w %q", errUnknownEventType, eventType)
+		}
+	}
+	return nil
+}
+
+// subscribed reports whether an endpoint wants an event type.
+func subscribed(endpoint *models.WebhookEndpoint, eventType string) bool {
+	for _, event := range endpoint.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEvents maps the domain events sent to webhooks to the event
+// types endpoints subscribe to.
+var webhookEvents = map[events.Type]string{
+	events.OrderPlaced:        webhooks.EventOrderCreated,
+	events.OrderStatusChanged: webhooks.EventOrderStatusChanged,
+	events.ItemUpdated:        webhooks.EventItemUpdated,
+	events.CartAbandoned:      webhooks.EventCartAbandoned,
+}
+
+// QueueWebhooks queues a domain event for every active endpoint subscribed
+// to it. It is subscribed to Events; deliveries go out on the next
+// DeliverWebhooks run.
+func QueueWebhooks(event events.Event) {
+	eventType, exists := webhookEvents[event.Type]
+	if !exists {
+		return
+	}
+	payload, err := json.Marshal(models.WebhookEvent{ID: event.ID, Type: eventType, CreatedAt: event.OccurredAt, Data: event.Payload})
+	if err != nil {
+		log.Printf("Webhook event %s not queued: %v", event.ID, err)
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	for _, endpoint := range database.DB.WebhookEndpoints {
+		if endpoint.Active && subscribed(endpoint, eventType) {
+			queueDelivery(endpoint.ID, event.ID, eventType, payload, 0)
+		}
+	}
+}
+
+// queueDelivery stores a delivery due at once.
+// Callers must hold database.DB.Mutex.
+func queueDelivery(endpointID uint, eventID, eventType string, payload []byte, redeliveryOf uint) *models.WebhookDelivery {
+	now := Clock.Now()
+	delivery := &models.WebhookDelivery{
+		ID:            database.DB.GetNextID(),
+		EndpointID:    endpointID,
+		EventID:       eventID,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        models.WebhookDeliveryPending,
+		Attempts:      []models.WebhookAttempt{},
+		NextAttemptAt: &now,
+		RedeliveryOf:  redeliveryOf,
+		CreatedAt:     now,
+	}
+	database.DB.WebhookDeliveries[delivery.ID] = delivery
+	return delivery
+}
+
+// claimDelivery marks a pending delivery as in flight and returns the
+// request to send, so no other run attempts it at the same time. Deliveries
+// for a deleted endpoint fail; those for a paused one wait.
+// Callers must hold database.DB.Mutex.
+func claimDelivery(delivery *models.WebhookDelivery, now time.Time) (webhooks.Request, bool) {
+	endpoint, exists := database.DB.WebhookEndpoints[delivery.EndpointID]
+	if !exists {
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.CompletedAt = &now
+		return webhooks.Request{}, false
+	}
+	if !endpoint.Active {
+		return webhooks.Request{}, false
+	}
+
+	delivery.Status = models.WebhookDeliverySending
+	return webhooks.Request{
+		URL:        endpoint.URL,
+		Secret:     endpoint.Secret,
+		DeliveryID: strconv.FormatUint(uint64(delivery.ID), 10),
+		Event:      delivery.EventType,
+		Payload:    delivery.Payload,
+		Timestamp:  now,
+	}, true
+}
+
+// recordAttempt logs an attempt and schedules the next one or closes the
+// delivery. Callers must hold database.DB.Mutex.
+func recordAttempt(delivery *models.WebhookDelivery, now time.Time, resp webhooks.Response, err error) {
+	attempt := models.WebhookAttempt{
+		At:           now,
+		StatusCode:   resp.StatusCode,
+		ResponseBody: resp.Body,
+		DurationMS:   resp.Duration.Milliseconds(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case err == nil:
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.NextAttemptAt = nil
+		delivery.CompletedAt = &now
+	case WebhookRetries.Exhausted(len(delivery.Attempts)):
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+		delivery.CompletedAt = &now
+	default:
+		next := now.Add(WebhookRetries.Delay(len(delivery.Attempts)))
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.NextAttemptAt = &next
+	}
+}
+
+// attemptDelivery sends one claimed delivery without holding the database
+// lock and records the outcome.
+func attemptDelivery(ctx context.Context, delivery *models.WebhookDelivery, req webhooks.Request) {
+	resp, err := WebhookSender.Send(ctx, req)
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+	recordAttempt(delivery, req.Timestamp, resp, err)
+}
+
+// DeliverWebhooks attempts every delivery that is due as of now, oldest
+// first, and returns how many it attempted. The scheduler runs it every few
+// seconds.
+func DeliverWebhooks(ctx context.Context, now time.Time) int {
+	type claimed struct {
+		delivery *models.WebhookDelivery
+		req      webhooks.Request
+	}
+
+	database.DB.Mutex.Lock()
+	due := []*models.WebhookDelivery{}
+	for _, delivery := range database.DB.WebhookDeliveries {
+		if delivery.Status == models.WebhookDeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	batch := []claimed{}
+	for _, delivery := range due {
+		if req, ok := claimDelivery(delivery, now); ok {
+			batch = append(batch, claimed{delivery, req})
+		}
+	}
+	database.DB.Mutex.Unlock()
+
+	for _, c := range batch {
+		attemptDelivery(ctx, c.delivery, c.req)
+	}
+	return len(batch)
+}
+
+// webhookEndpointParam looks up the endpoint named in the path.
+// Callers must hold database.DB.Mutex.
+func webhookEndpointParam(c *gin.Context) (*models.WebhookEndpoint, bool) {
+	var endpointID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &endpointID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endpoint ID"})
+		return nil, false
+	}
+	endpoint, exists := database.DB.WebhookEndpoints[endpointID]
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook endpoint not found"})
+		return nil, false
+	}
+	return endpoint, true
+}
+
+// CreateWebhookEndpoint subscribes a URL to events. The response carries
+// the secret deliveries are signed with.
+func CreateWebhookEndpoint(c *gin.Context) {
+	var req WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkEventTypes(req.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	now := Clock.Now()
+	endpoint := &models.WebhookEndpoint{
+		ID:          database.DB.GetNextID(),
+		URL:         req.URL,
+		Description: req.Description,
+		Secret:      secret,
+		Events:      req.Events,
+		Active:      req.Active == nil || *req.Active,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	database.DB.WebhookEndpoints[endpoint.ID] = endpoint
+
+	c.JSON(http.StatusCreated, *endpoint)
+}
+
+// GetWebhookEndpoints lists every endpoint.
+func GetWebhookEndpoints(c *gin.Context) {
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	endpoints := []models.WebhookEndpoint{}
+	for _, endpoint := range database.DB.WebhookEndpoints {
+		endpoints = append(endpoints, *endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].ID < endpoints[j].ID })
+	c.JSON(http.StatusOK, endpoints)
+}
+
+// UpdateWebhookEndpoint changes an endpoint's URL, events or description,
+// or pauses it. Deliveries for a paused endpoint wait until it is active
+// again.
+func UpdateWebhookEndpoint(c *gin.Context) {
+	var req WebhookEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkEventTypes(req.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	endpoint, ok := webhookEndpointParam(c)
+	if !ok {
+		return
+	}
+
+	endpoint.URL = req.URL
+	endpoint.Description = req.Description
+	endpoint.Events = req.Events
+	if req.Active != nil {
+		endpoint.Active = *req.Active
+	}
+	endpoint.UpdatedAt = Clock.Now()
+
+	c.JSON(http.StatusOK, *endpoint)
+}
+
+// DeleteWebhookEndpoint removes an endpoint. Its delivery log is kept and
+// its undelivered events are given up.
+func DeleteWebhookEndpoint(c *gin.Context) {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	endpoint, ok := webhookEndpointParam(c)
+	if !ok {
+		return
+	}
+	delete(database.DB.WebhookEndpoints, endpoint.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook endpoint deleted"})
+}
+
+// GetWebhookDeliveries lists deliveries newest first, optionally for one
+// ?endpoint_id= or with one ?status=.
+func GetWebhookDeliveries(c *gin.Context) {
+	var endpointID uint
+	if id := c.Query("endpoint_id"); id != "" {
+		if _, err := fmt.Sscanf(id, "%d", &endpointID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endpoint ID"})
+			return
+		}
+	}
+	status := c.Query("status")
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+
+	deliveries := []models.WebhookDelivery{}
+	for _, delivery := range database.DB.WebhookDeliveries {
+		if (endpointID == 0 || delivery.EndpointID == endpointID) && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverWebhook sends a delivery's payload again as a new delivery,
+// attempting it straight away. Failed redeliveries are retried like any
+// other.
+func RedeliverWebhook(c *gin.Context) {
+	var deliveryID uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &deliveryID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	database.DB.Mutex.Lock()
+	original, exists := database.DB.WebhookDeliveries[deliveryID]
+	if !exists {
+		database.DB.Mutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	endpoint, exists := database.DB.WebhookEndpoints[original.EndpointID]
+	if !exists || !endpoint.Active {
+		database.DB.Mutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Webhook endpoint is deleted or paused"})
+		return
+	}
+	delivery := queueDelivery(original.EndpointID, original.EventID, original.EventType, original.Payload, original.ID)
+	req, _ := claimDelivery(delivery, Clock.Now())
+	database.DB.Mutex.Unlock()
+
+	attemptDelivery(c.Request.Context(), delivery, req)
+
+	database.DB.Mutex.RLock()
+	defer database.DB.Mutex.RUnlock()
+	c.JSON(http.StatusCreated, *delivery)
+}
@@ -0,0 +1,255 @@
+package handlers_test
+
+import (
+	"context"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/events"
+	"ecommerce-backend/handlers"
+	"ecommerce-backend/middleware"
+	"ecommerce-backend/models"
+	"ecommerce-backend/payments"
+	"ecommerce-backend/webhooks"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhooks", func() {
+	type received struct {
+		header http.Header
+		body   []byte
+		event  models.WebhookEvent
+	}
+
+	var (
+		fakeTime *clock.Fake
+		receiver *httptest.Server
+		endpoint models.WebhookEndpoint
+
+		mu      sync.Mutex
+		status  int
+		inbox   []received
+		retries webhooks.RetryPolicy
+	)
+
+	subscribe := func(events ...string) models.WebhookEndpoint {
+		w := request("POST", "/webhooks", map[string]interface{}{"url": receiver.URL, "events": events}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated), w.Body.String())
+		var created models.WebhookEndpoint
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created
+	}
+
+	deliver := func() int {
+		return handlers.DeliverWebhooks(context.Background(), fakeTime.Now())
+	}
+
+	// take returns and clears what the receiver got
+	take := func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		got := inbox
+		inbox = nil
+		return got
+	}
+
+	deliveries := func(query string) []models.WebhookDelivery {
+		var list []models.WebhookDelivery
+		json.Unmarshal(request("GET", "/webhooks/deliveries"+query, nil, asAdmin()).Body.Bytes(), &list)
+		return list
+	}
+
+	BeforeEach(func() {
+		handlers.Payments = payments.NewFake(payments.FakeConfig{})
+		fakeTime = clock.NewFake(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+		handlers.Clock = fakeTime
+		retries = handlers.WebhookRetries
+		handlers.WebhookRetries = webhooks.RetryPolicy{Base: 30 * time.Second, Max: time.Hour, MaxAttempts: 3}
+
+		status = http.StatusOK
+		inbox = nil
+		receiver = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var event models.WebhookEvent
+			json.Unmarshal(body, &event)
+			mu.Lock()
+			inbox = append(inbox, received{header: r.Header, body: body, event: event})
+			code := status
+			mu.Unlock()
+			w.WriteHeader(code)
+		}))
+
+		handlers.Events = events.NewBus()
+		handlers.Events.SubscribeAll(handlers.QueueWebhooks)
+
+		newTestRouter()
+		router.Use(handlers.OutboxRelay())
+		auth := router.Group("/")
+		auth.Use(middleware.AuthMiddleware())
+		auth.POST("/carts", handlers.AddToCart)
+		auth.POST("/orders", handlers.CreateOrder)
+		auth.POST("/orders/:id/cancel", handlers.CancelOrder)
+		staff := auth.Group("/")
+		staff.Use(middleware.AdminMiddleware())
+		staff.PUT("/items/:id", handlers.UpdateItem)
+		staff.GET("/webhooks", handlers.GetWebhookEndpoints)
+		staff.POST("/webhooks", handlers.CreateWebhookEndpoint)
+		staff.PUT("/webhooks/:id", handlers.UpdateWebhookEndpoint)
+		staff.DELETE("/webhooks/:id", handlers.DeleteWebhookEndpoint)
+		staff.GET("/webhooks/deliveries", handlers.GetWebhookDeliveries)
+		staff.POST("/webhooks/deliveries/:id/redeliver", handlers.RedeliverWebhook)
+
+		endpoint = subscribe(webhooks.EventOrderCreated, webhooks.EventOrderStatusChanged)
+	})
+
+	AfterEach(func() {
+		receiver.Close()
+		handlers.Clock = clock.Real{}
+		handlers.WebhookRetries = retries
+		handlers.Events = events.NewBus()
+	})
+
+	It("validates subscriptions", func() {
+		Expect(endpoint.Secret).To(HavePrefix("whsec_"))
+		Expect(endpoint.Active).To(BeTrue())
+
+		w := request("POST", "/webhooks", map[string]interface{}{"url": receiver.URL, "events": []string{"order.shipped"}}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		w = request("POST", "/webhooks", map[string]interface{}{"url": "not a url", "events": []string{webhooks.EventOrderCreated}}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		w = request("POST", "/webhooks", map[string]interface{}{"url": receiver.URL, "events": []string{}}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("sends signed order events", func() {
+		w, order := checkout(payments.FakeCardApproved)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(deliver()).To(Equal(1))
+
+		got := take()
+		Expect(got).To(HaveLen(1))
+		Expect(got[0].header.Get(webhooks.EventHeader)).To(Equal(webhooks.EventOrderCreated))
+		Expect(webhooks.Verify(endpoint.Secret, got[0].body, got[0].header.Get(webhooks.SignatureHeader), fakeTime.Now(), 5*time.Minute)).To(Succeed())
+		Expect(got[0].event.Type).To(Equal(webhooks.EventOrderCreated))
+		Expect(got[0].event.Data.(map[string]interface{})["id"]).To(BeNumerically("==", order.ID))
+
+		// Cancelling refunds the payment in full and then cancels
+		request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Changed my mind"}, asAdmin())
+		Expect(deliver()).To(Equal(2))
+		changes := [][2]interface{}{}
+		for _, r := range take() {
+			Expect(r.event.Type).To(Equal(webhooks.EventOrderStatusChanged))
+			change := r.event.Data.(map[string]interface{})
+			changes = append(changes, [2]interface{}{change["previous_status"], change["status"]})
+		}
+		Expect(changes).To(Equal([][2]interface{}{
+			{models.OrderStatusConfirmed, models.OrderStatusRefunded},
+			{models.OrderStatusRefunded, models.OrderStatusCancelled},
+		}))
+
+		Expect(deliveries("?status=" + models.WebhookDeliverySucceeded)).To(HaveLen(3))
+	})
+
+	It("only sends the events an endpoint subscribed to", func() {
+		items := subscribe(webhooks.EventItemUpdated)
+		w := request("PUT", "/items/1", map[string]interface{}{"name": "Laptop Pro", "price": 129999}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		Expect(deliver()).To(Equal(1))
+		Expect(deliveries("?endpoint_id=" + itoa(items.ID))).To(HaveLen(1))
+		Expect(deliveries("?endpoint_id=" + itoa(endpoint.ID))).To(BeEmpty())
+		Expect(take()[0].event.Data.(map[string]interface{})["name"]).To(Equal("Laptop Pro"))
+
+		carts := subscribe(webhooks.EventCartAbandoned)
+		request("POST", "/carts", map[string]interface{}{"item_id": 2, "quantity": 1}, asAdmin())
+		fakeTime.Advance(handlers.Sweeper.UserTTL)
+		Expect(handlers.Sweeper.Sweep(fakeTime.Now()).Expired).To(Equal(1))
+		handlers.RelayOutbox()
+		Expect(deliver()).To(Equal(1))
+		Expect(deliveries("?endpoint_id=" + itoa(carts.ID))[0].EventType).To(Equal(webhooks.EventCartAbandoned))
+	})
+
+	It("retries with backoff and gives up after the last attempt", func() {
+		status = http.StatusServiceUnavailable
+		checkout(payments.FakeCardApproved)
+
+		Expect(deliver()).To(Equal(1))
+		delivery := deliveries("")[0]
+		Expect(delivery.Status).To(Equal(models.WebhookDeliveryPending))
+		Expect(delivery.Attempts).To(HaveLen(1))
+		Expect(delivery.Attempts[0].StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(*delivery.NextAttemptAt).To(Equal(fakeTime.Now().Add(30 * time.Second)))
+
+		Expect(deliver()).To(Equal(0))
+		fakeTime.Advance(30 * time.Second)
+		Expect(deliver()).To(Equal(1))
+		Expect(*deliveries("")[0].NextAttemptAt).To(Equal(fakeTime.Now().Add(time.Minute)))
+
+		fakeTime.Advance(time.Minute)
+		Expect(deliver()).To(Equal(1))
+		delivery = deliveries("")[0]
+		Expect(delivery.Status).To(Equal(models.WebhookDeliveryFailed))
+		Expect(delivery.Attempts).To(HaveLen(3))
+		Expect(delivery.NextAttemptAt).To(BeNil())
+
+		fakeTime.Advance(time.Hour)
+		Expect(deliver()).To(Equal(0))
+	})
+
+	It("succeeds on a later attempt once the endpoint recovers", func() {
+		status = http.StatusInternalServerError
+		checkout(payments.FakeCardApproved)
+		deliver()
+
+		status = http.StatusOK
+		fakeTime.Advance(30 * time.Second)
+		deliver()
+		Expect(deliveries("")[0].Status).To(Equal(models.WebhookDeliverySucceeded))
+		Expect(take()).To(HaveLen(2))
+	})
+
+	It("redelivers on request", func() {
+		checkout(payments.FakeCardApproved)
+		deliver()
+		original := deliveries("")[0]
+		take()
+
+		w := request("POST", "/webhooks/deliveries/"+itoa(original.ID)+"/redeliver", nil, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var redelivery models.WebhookDelivery
+		json.Unmarshal(w.Body.Bytes(), &redelivery)
+		Expect(redelivery.RedeliveryOf).To(Equal(original.ID))
+		Expect(redelivery.EventID).To(Equal(original.EventID))
+		Expect(redelivery.Status).To(Equal(models.WebhookDeliverySucceeded))
+
+		got := take()
+		Expect(got).To(HaveLen(1))
+		Expect(got[0].body).To(MatchJSON(original.Payload))
+		Expect(got[0].header.Get(webhooks.DeliveryHeader)).To(Equal(itoa(redelivery.ID)))
+	})
+
+	It("holds deliveries for a paused endpoint and drops them once it is deleted", func() {
+		w := request("PUT", "/webhooks/"+itoa(endpoint.ID), map[string]interface{}{"url": receiver.URL, "events": endpoint.Events, "active": false}, asAdmin())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		w, order := checkout(payments.FakeCardApproved)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		Expect(deliver()).To(Equal(0))
+
+		// Pausing stops new events too
+		request("POST", "/orders/"+itoa(order.ID)+"/cancel", map[string]string{"reason": "Changed my mind"}, asAdmin())
+		Expect(deliveries("")).To(BeEmpty())
+
+		request("PUT", "/webhooks/"+itoa(endpoint.ID), map[string]interface{}{"url": receiver.URL, "events": endpoint.Events, "active": true}, asAdmin())
+		checkout(payments.FakeCardApproved)
+		Expect(request("DELETE", "/webhooks/"+itoa(endpoint.ID), nil, asAdmin()).Code).To(Equal(http.StatusOK))
+		Expect(deliver()).To(Equal(0))
+		Expect(deliveries("")[0].Status).To(Equal(models.WebhookDeliveryFailed))
+		Expect(take()).To(BeEmpty())
+	})
+})
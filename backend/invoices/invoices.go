@@ -0,0 +1,176 @@
+// Package invoices builds invoices and credit notes from orders and refunds,
+// numbers them from gapless sequences and renders them as HTML and PDF.
+package invoices
+
+import (
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidSequence = errors.New("invalid document sequence")
+
+// FromOrder builds the invoice for a paid order. The caller numbers it.
+func FromOrder(order *models.Order, seller, buyer models.InvoiceParty) *models.Invoice {
+	t := order.Totals
+	invoice := &models.Invoice{
+		Kind:     models.InvoiceKindInvoice,
+		OrderID:  order.ID,
+		UserID:   order.UserID,
+		Seller:   seller,
+		Buyer:    buyer,
+		Currency: t.Currency,
+		Lines:    make([]models.InvoiceLine, 0, len(t.Lines)),
+		Shipping: t.Shipping - t.ShippingDiscount,
+		TaxLines: append([]models.TaxLine{}, t.TaxLines...),
+		Tax:      t.Tax,
+		Total:    t.Total,
+	}
+	for _, line := range t.Lines {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			ItemID:      line.ItemID,
+			Description: line.Name,
+			Quantity:    line.Quantity,
+			UnitPrice:   line.UnitPrice,
+			Discount:    line.Discount,
+			Amount:      line.Total,
+		})
+	}
+	invoice.Subtotal = t.Merchandise() + invoice.Shipping
+	return invoice
+}
+
+// CreditNote builds the credit note for a refund against an invoiced order.
+// Refund amounts include tax; the credit note splits them back into net
+// amounts and tax in the same proportion as the order.
+func CreditNote(order *models.Order, refund *models.Refund, invoice *models.Invoice) *models.Invoice {
+	t := order.Totals
+	note := &models.Invoice{
+		Kind:     models.InvoiceKindCreditNote,
+		OrderID:  order.ID,
+		UserID:   order.UserID,
+		RefundID: refund.ID,
+		Credits:  invoice.Number,
+		Reason:   refund.Reason,
+		Seller:   invoice.Seller,
+		Buyer:    invoice.Buyer,
+		Currency: invoice.Currency,
+		Lines:    make([]models.InvoiceLine, 0, len(refund.Lines)),
+		TaxLines: []models.TaxLine{},
+		Total:    refund.Amount,
+	}
+
+	var tax int64
+	if t.Tax > 0 && t.Total > 0 {
+		tax = scale(refund.Amount, t.Tax, t.Total)
+	}
+
+	// Take the tax back out of each refunded amount
+	weights := make([]int64, 0, len(refund.Lines)+1)
+	for _, line := range refund.Lines {
+		weights = append(weights, line.Amount)
+	}
+	weights = append(weights, refund.Shipping)
+	taxes := pricing.Allocate(tax, weights)
+
+	for i, line := range refund.Lines {
+		credited := models.InvoiceLine{ItemID: line.ItemID, Quantity: line.Quantity, Amount: line.Amount - taxes[i]}
+		for _, ordered := range t.Lines {
+			if ordered.ItemID == line.ItemID {
+				credited.Description = ordered.Name
+				credited.UnitPrice = ordered.UnitPrice
+			}
+		}
+		note.Lines = append(note.Lines, credited)
+	}
+	note.Shipping = refund.Shipping - taxes[len(refund.Lines)]
+
+	// Split the tax across the order's rates
+	exclusive := []models.TaxLine{}
+	rateWeights := []int64{}
+	for _, line := range t.TaxLines {
+		if !line.Inclusive && line.Amount > 0 {
+			exclusive = append(exclusive, line)
+			rateWeights = append(rateWeights, line.Amount)
+		}
+	}
+	for i, amount := range pricing.Allocate(tax, rateWeights) {
+		line := exclusive[i]
+		note.TaxLines = append(note.TaxLines, models.TaxLine{
+			Label:   line.Label,
+			Rate:    line.Rate,
+			Taxable: scale(line.Taxable, amount, line.Amount),
+			Amount:  amount,
+		})
+	}
+
+	note.Tax = tax
+	note.Subtotal = refund.Amount - tax
+	return note
+}
+
+// Next returns the number for the next document in a sequence and moves
+// the sequence on. Numbers are only taken when a document is issued, so
+// there are no gaps.
+func Next(sequence *models.DocumentSequence) string {
+	number := Format(sequence, sequence.Next)
+	sequence.Next++
+	return number
+}
+
+// Format renders a sequence number.
+func Format(sequence *models.DocumentSequence, n int) string {
+	return fmt.Sprintf("%s%0*d", sequence.Prefix, sequence.Padding, n)
+}
+
+// ValidateSequence checks a sequence before it is stored. The next number
+// can never move back to one that may already have been issued.
+func ValidateSequence(sequence, current *models.DocumentSequence) error {
+	if strings.TrimSpace(sequence.Prefix) == "" {
+		return fmt.Errorf("%w: prefix is required", ErrInvalidSequence)
+	}
+	if sequence.Padding < 0 || sequence.Padding > 12 {
+		return fmt.Errorf("%w: padding must be between 0 and 12", ErrInvalidSequence)
+	}
+	if sequence.Next < 1 {
+		return fmt.Errorf("%w: next must be at least 1", ErrInvalidSequence)
+	}
+	if current != nil && sequence.Next < current.Next {
+		return fmt.Errorf("%w: next must not be lower than %d", ErrInvalidSequence, current.Next)
+	}
+	return nil
+}
+
+// Money formats an amount in cents, e.g. "1,234.50 USD".
+func Money(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	units := fmt.Sprintf("%d", amount/100)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+	return fmt.Sprintf("%s%s.%02d %s", sign, units, amount%100, currency)
+}
+
+// scale returns amount * num / den rounded half away from zero.
+func scale(amount, num, den int64) int64 {
+	if den == 0 {
+		return 0
+	}
+	n := amount * num
+	if (n < 0) != (den < 0) {
+		return -((abs(n) + abs(den)/2) / abs(den))
+	}
+	return (abs(n) + abs(den)/2) / abs(den)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,146 @@
+package invoices_test
+
+import (
+	"time"
+
+	"ecommerce-backend/invoices"
+	"ecommerce-backend/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Invoices", func() {
+	var (
+		order  *models.Order
+		seller models.InvoiceParty
+		buyer  models.InvoiceParty
+	)
+
+	BeforeEach(func() {
+		seller = models.InvoiceParty{Name: "Shop Inc", Line1: "1 Commerce Way", City: "Austin", Region: "TX", Country: "US", TaxID: "US-123"}
+		buyer = models.InvoiceParty{Name: "Ada (Home)", Line1: "5 Elm St", Country: "US"}
+		order = &models.Order{
+			ID:     7,
+			UserID: 3,
+			Totals: models.Totals{
+				Currency: "USD",
+				Lines: []models.LineTotal{
+					{Line: models.Line{ItemID: 1, Name: "Laptop", UnitPrice: 100000, Quantity: 2}, Subtotal: 200000, Discount: 10000, Total: 190000},
+					{Line: models.Line{ItemID: 5, Name: "Mouse", UnitPrice: 2500, Quantity: 1}, Subtotal: 2500, Total: 2500},
+				},
+				Subtotal:      202500,
+				DiscountTotal: 10000,
+				Shipping:      1500,
+				TaxLines: []models.TaxLine{
+					{Label: "State tax", Rate: 625, Taxable: 194000, Amount: 12125},
+					{Label: "City tax", Rate: 200, Taxable: 194000, Amount: 3880},
+				},
+				Tax:   16005,
+				Total: 210005,
+			},
+		}
+	})
+
+	Describe("FromOrder", func() {
+		It("copies lines, shipping and the tax breakdown", func() {
+			invoice := invoices.FromOrder(order, seller, buyer)
+			Expect(invoice.Kind).To(Equal(models.InvoiceKindInvoice))
+			Expect(invoice.Lines).To(HaveLen(2))
+			Expect(invoice.Lines[0]).To(Equal(models.InvoiceLine{ItemID: 1, Description: "Laptop", Quantity: 2, UnitPrice: 100000, Discount: 10000, Amount: 190000}))
+			Expect(invoice.Shipping).To(Equal(int64(1500)))
+			Expect(invoice.Subtotal).To(Equal(int64(194000)))
+			Expect(invoice.TaxLines).To(HaveLen(2))
+			Expect(invoice.Subtotal + invoice.Tax).To(Equal(invoice.Total))
+		})
+	})
+
+	Describe("CreditNote", func() {
+		It("splits a refund back into net amounts and tax", func() {
+			invoice := invoices.FromOrder(order, seller, buyer)
+			invoice.Number = "INV-000001"
+			refund := &models.Refund{ID: 9, Reason: "Damaged", Amount: 105003, Lines: []models.RefundLine{{ItemID: 1, Quantity: 1, Amount: 105003}}}
+
+			note := invoices.CreditNote(order, refund, invoice)
+			Expect(note.Kind).To(Equal(models.InvoiceKindCreditNote))
+			Expect(note.Credits).To(Equal("INV-000001"))
+			Expect(note.Reason).To(Equal("Damaged"))
+			Expect(note.Total).To(Equal(int64(105003)))
+			Expect(note.Lines[0].Description).To(Equal("Laptop"))
+			Expect(note.Lines[0].Amount + note.Tax).To(Equal(int64(105003)))
+			Expect(note.Subtotal + note.Tax).To(Equal(note.Total))
+
+			var taxed int64
+			for _, line := range note.TaxLines {
+				taxed += line.Amount
+			}
+			Expect(taxed).To(Equal(note.Tax))
+			Expect(note.TaxLines[0].Label).To(Equal("State tax"))
+		})
+
+		It("has no tax on untaxed orders", func() {
+			order.Totals.TaxLines, order.Totals.Tax, order.Totals.Total = nil, 0, 194000
+			invoice := invoices.FromOrder(order, seller, buyer)
+			note := invoices.CreditNote(order, &models.Refund{Amount: 1500, Shipping: 1500}, invoice)
+			Expect(note.Tax).To(BeZero())
+			Expect(note.TaxLines).To(BeEmpty())
+			Expect(note.Shipping).To(Equal(int64(1500)))
+		})
+	})
+
+	Describe("sequences", func() {
+		It("numbers documents without gaps", func() {
+			sequence := &models.DocumentSequence{Name: "invoice", Prefix: "INV-", Padding: 6, Next: 41}
+			Expect(invoices.Next(sequence)).To(Equal("INV-000041"))
+			Expect(invoices.Next(sequence)).To(Equal("INV-000042"))
+			Expect(sequence.Next).To(Equal(43))
+		})
+
+		It("never moves a sequence back", func() {
+			current := &models.DocumentSequence{Prefix: "INV-", Padding: 6, Next: 10}
+			Expect(invoices.ValidateSequence(&models.DocumentSequence{Prefix: "F-", Padding: 4, Next: 10}, current)).To(Succeed())
+			Expect(invoices.ValidateSequence(&models.DocumentSequence{Prefix: "F-", Padding: 4, Next: 9}, current)).To(MatchError(invoices.ErrInvalidSequence))
+			Expect(invoices.ValidateSequence(&models.DocumentSequence{Prefix: "", Next: 10}, current)).To(MatchError(invoices.ErrInvalidSequence))
+		})
+	})
+
+	Describe("rendering", func() {
+		var invoice *models.Invoice
+
+		BeforeEach(func() {
+			invoice = invoices.FromOrder(order, seller, buyer)
+			invoice.Number = "INV-000001"
+			invoice.IssuedAt = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+		})
+
+		It("formats money", func() {
+			Expect(invoices.Money(210005, "USD")).To(Equal("2,100.05 USD"))
+			Expect(invoices.Money(-5, "EUR")).To(Equal("-0.05 EUR"))
+		})
+
+		It("renders HTML with escaped details", func() {
+			html, err := invoices.RenderHTML(invoice)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(html)).To(ContainSubstring("Invoice INV-000001"))
+			Expect(string(html)).To(ContainSubstring("Tax ID: US-123"))
+			Expect(string(html)).To(ContainSubstring("State tax 6.25%"))
+			Expect(string(html)).To(ContainSubstring("2,100.05 USD"))
+		})
+
+		It("renders a well-formed PDF", func() {
+			pdf := string(invoices.RenderPDF(invoice))
+			Expect(pdf).To(HavePrefix("%PDF-1.4"))
+			Expect(pdf).To(HaveSuffix("%%EOF\n"))
+			Expect(pdf).To(ContainSubstring("INVOICE INV-000001"))
+			Expect(pdf).To(ContainSubstring(`Ada \(Home\)`))
+			Expect(pdf).To(ContainSubstring("/Count 1"))
+		})
+
+		It("spreads long documents over several pages", func() {
+			for i := 0; i < 80; i++ {
+				invoice.Lines = append(invoice.Lines, models.InvoiceLine{Description: "Cable", Quantity: 1})
+			}
+			Expect(string(invoices.RenderPDF(invoice))).To(ContainSubstring("/Count 2"))
+		})
+	})
+})
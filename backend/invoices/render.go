@@ -0,0 +1,161 @@
+package invoices
+
+import (
+	"bytes"
+	"ecommerce-backend/models"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Title returns the document heading for an invoice or credit note.
+func Title(invoice *models.Invoice) string {
+	if invoice.Kind == models.InvoiceKindCreditNote {
+		return "Credit Note"
+	}
+	return "Invoice"
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"money":   Money,
+	"rate":    func(bps int64) string { return fmt.Sprintf("%d.%02d%%", bps/100, bps%100) },
+	"address": addressLines,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Invoice.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 40px; color: #222; }
+table { width: 100%; border-collapse: collapse; margin-top: 24px; }
+th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num, th.num { text-align: right; }
+.parties { display: flex; justify-content: space-between; margin-top: 24px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} {{.Invoice.Number}}</h1>
+<p>Issued {{.Invoice.IssuedAt.Format "2006-01-02"}} &middot; Order #{{.Invoice.OrderID}}</p>
+{{- if .Invoice.Credits}}
+<p>Credits invoice {{.Invoice.Credits}}{{if .Invoice.Reason}}: {{.Invoice.Reason}}{{end}}</p>
+{{- end}}
+<div class="parties">
+<div><h3>Seller</h3>{{range address .Invoice.Seller}}{{.}}<br>{{end}}</div>
+<div><h3>Bill to</h3>{{range address .Invoice.Buyer}}{{.}}<br>{{end}}</div>
+</div>
+<table>
+<thead><tr><th>Description</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Discount</th><th class="num">Amount</th></tr></thead>
+<tbody>
+{{- range .Invoice.Lines}}
+<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{money .UnitPrice $.Invoice.Currency}}</td><td class="num">{{money .Discount $.Invoice.Currency}}</td><td class="num">{{money .Amount $.Invoice.Currency}}</td></tr>
+{{- end}}
+{{- if .Invoice.Shipping}}
+<tr><td>Shipping</td><td></td><td></td><td></td><td class="num">{{money .Invoice.Shipping .Invoice.Currency}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+<table>
+<tr><td>Subtotal</td><td class="num">{{money .Invoice.Subtotal .Invoice.Currency}}</td></tr>
+{{- range .Invoice.TaxLines}}
+<tr><td>{{.Label}} {{rate .Rate}}{{if .Inclusive}} (included){{end}} on {{money .Taxable $.Invoice.Currency}}</td><td class="num">{{money .Amount $.Invoice.Currency}}</td></tr>
+{{- end}}
+<tr><th>Total</th><th class="num">{{money .Invoice.Total .Invoice.Currency}}</th></tr>
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML renders an invoice or credit note as an HTML page.
+func RenderHTML(invoice *models.Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, struct {
+		Title   string
+		Invoice *models.Invoice
+	}{Title(invoice), invoice})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderPDF renders an invoice or credit note as a PDF document.
+func RenderPDF(invoice *models.Invoice) []byte {
+	return writePDF(textLines(invoice))
+}
+
+// textLines lays an invoice out as fixed-width text for the PDF.
+func textLines(invoice *models.Invoice) []string {
+	money := func(amount int64) string { return Money(amount, invoice.Currency) }
+
+	lines := []string{
+		strings.ToUpper(Title(invoice)) + " " + invoice.Number,
+		fmt.Sprintf("Issued %s   Order #%d", invoice.IssuedAt.Format("2006-01-02"), invoice.OrderID),
+	}
+	if invoice.Credits != "" {
+		credit := "Credits invoice " + invoice.Credits
+		if invoice.Reason != "" {
+			credit += ": " + invoice.Reason
+		}
+		lines = append(lines, credit)
+	}
+
+	lines = append(lines, "", "Seller")
+	lines = append(lines, addressLines(invoice.Seller)...)
+	lines = append(lines, "", "Bill to")
+	lines = append(lines, addressLines(invoice.Buyer)...)
+
+	row := "%-32.32s %5s %16s %16s"
+	lines = append(lines, "", fmt.Sprintf(row, "Description", "Qty", "Unit price", "Amount"), strings.Repeat("-", 72))
+	for _, line := range invoice.Lines {
+		lines = append(lines, fmt.Sprintf(row, line.Description, fmt.Sprint(line.Quantity), money(line.UnitPrice), money(line.Amount)))
+		if line.Discount != 0 {
+			lines = append(lines, fmt.Sprintf(row, "  less discount", "", "", money(-line.Discount)))
+		}
+	}
+	if invoice.Shipping != 0 {
+		lines = append(lines, fmt.Sprintf(row, "Shipping", "", "", money(invoice.Shipping)))
+	}
+	lines = append(lines, strings.Repeat("-", 72))
+
+	total := "%-55.55s %16s"
+	lines = append(lines, fmt.Sprintf(total, "Subtotal", money(invoice.Subtotal)))
+	for _, tax := range invoice.TaxLines {
+		label := fmt.Sprintf("%s %d.%02d%% on %s", tax.Label, tax.Rate/100, tax.Rate%100, money(tax.Taxable))
+		if tax.Inclusive {
+			label += " (included)"
+		}
+		lines = append(lines, fmt.Sprintf(total, label, money(tax.Amount)))
+	}
+	lines = append(lines, fmt.Sprintf(total, "TOTAL", money(invoice.Total)))
+	return lines
+}
+
+func addressLines(party models.InvoiceParty) []string {
+	lines := []string{}
+	for _, line := range []string{
+		party.Name,
+		party.Line1,
+		party.Line2,
+		strings.TrimSpace(strings.Join(nonEmpty(party.City, party.Region, party.PostalCode), " ")),
+		party.Country,
+		party.Email,
+	} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if party.TaxID != "" {
+		lines = append(lines, "Tax ID: "+party.TaxID)
+	}
+	return lines
+}
+
+func nonEmpty(values ...string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
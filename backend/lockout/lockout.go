@@ -0,0 +1,96 @@
+// Package lockout slows down and then locks out repeated failed logins.
+package lockout
+
+import (
+	"time"
+
+	"ecommerce-backend/models"
+)
+
+// Policy decides how failed logins are throttled. The first FreeAttempts
+// failures cost nothing; after that each failure makes the next attempt
+// wait, starting at BaseDelay and doubling up to MaxDelay. LockAfter
+// failures lock the key out for LockFor. Failures older than Window are
+// forgotten.
+type Policy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockFor      time.Duration
+	Window       time.Duration
+}
+
+// AccountPolicy protects one account: a few tries, then waits of 1s, 2s, 4s
+// and so on, and a 15 minute lockout after 10 failures.
+var AccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	LockFor:      15 * time.Minute,
+	Window:       15 * time.Minute,
+}
+
+// AddressPolicy protects against one client guessing across many accounts.
+// It is looser than AccountPolicy since addresses can be shared.
+var AddressPolicy = Policy{
+	FreeAttempts: 20,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    100,
+	LockFor:      time.Hour,
+	Window:       time.Hour,
+}
+
+// Delay returns how long to wait before the next attempt after the given
+// number of failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Expire clears a throttle whose lockout has ended or whose last failure is
+// older than the window, and reports whether anything is left in it.
+func (p Policy) Expire(t *models.LoginThrottle, now time.Time) bool {
+	if t.LockedUntil != nil && !now.Before(*t.LockedUntil) ||
+		t.LockedUntil == nil && now.Sub(t.LastFailureAt) >= p.Window {
+		t.Failures = 0
+		t.LockedUntil = nil
+	}
+	return t.Failures > 0
+}
+
+// Check returns how long the key must wait before it may try again, zero if
+// it may try now, and whether that is because it is locked out.
+func (p Policy) Check(t *models.LoginThrottle, now time.Time) (time.Duration, bool) {
+	if !p.Expire(t, now) {
+		return 0, false
+	}
+	if t.LockedUntil != nil {
+		return t.LockedUntil.Sub(now), true
+	}
+	if wait := t.LastFailureAt.Add(p.Delay(t.Failures)).Sub(now); wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// Fail counts a failed attempt and reports whether it locked the key out.
+func (p Policy) Fail(t *models.LoginThrottle, now time.Time) bool {
+	p.Expire(t, now)
+	t.Failures++
+	t.LastFailureAt = now
+	if t.LockedUntil == nil && p.LockAfter > 0 && t.Failures >= p.LockAfter {
+		until := now.Add(p.LockFor)
+		t.LockedUntil = &until
+		return true
+	}
+	return false
+}
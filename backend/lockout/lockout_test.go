@@ -0,0 +1,85 @@
+package lockout_test
+
+import (
+	"time"
+
+	"ecommerce-backend/lockout"
+	"ecommerce-backend/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	var (
+		policy lockout.Policy
+		now    time.Time
+		t      *models.LoginThrottle
+	)
+
+	BeforeEach(func() {
+		policy = lockout.Policy{
+			FreeAttempts: 2,
+			BaseDelay:    time.Second,
+			MaxDelay:     4 * time.Second,
+			LockAfter:    6,
+			LockFor:      10 * time.Minute,
+			Window:       time.Hour,
+		}
+		now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		t = &models.LoginThrottle{Key: "user:alice"}
+	})
+
+	It("doubles the delay after the free attempts, up to the maximum", func() {
+		Expect(policy.Delay(1)).To(BeZero())
+		Expect(policy.Delay(2)).To(BeZero())
+		Expect(policy.Delay(3)).To(Equal(time.Second))
+		Expect(policy.Delay(4)).To(Equal(2 * time.Second))
+		Expect(policy.Delay(5)).To(Equal(4 * time.Second))
+		Expect(policy.Delay(9)).To(Equal(4 * time.Second))
+	})
+
+	It("makes the next attempt wait once the free attempts are used", func() {
+		policy.Fail(t, now)
+		policy.Fail(t, now)
+		wait, locked := policy.Check(t, now)
+		Expect(wait).To(BeZero())
+		Expect(locked).To(BeFalse())
+
+		policy.Fail(t, now)
+		wait, locked = policy.Check(t, now)
+		Expect(wait).To(Equal(time.Second))
+		Expect(locked).To(BeFalse())
+
+		wait, _ = policy.Check(t, now.Add(time.Second))
+		Expect(wait).To(BeZero())
+	})
+
+	It("locks out after too many failures until the lock ends", func() {
+		for i := 1; i < policy.LockAfter; i++ {
+			Expect(policy.Fail(t, now)).To(BeFalse())
+		}
+		Expect(policy.Fail(t, now)).To(BeTrue())
+
+		wait, locked := policy.Check(t, now.Add(time.Minute))
+		Expect(locked).To(BeTrue())
+		Expect(wait).To(Equal(9 * time.Minute))
+
+		wait, locked = policy.Check(t, now.Add(10*time.Minute))
+		Expect(locked).To(BeFalse())
+		Expect(wait).To(BeZero())
+		Expect(t.Failures).To(BeZero())
+	})
+
+	It("forgets failures older than the window", func() {
+		policy.Fail(t, now)
+		policy.Fail(t, now)
+		policy.Fail(t, now)
+
+		Expect(policy.Expire(t, now.Add(59*time.Minute))).To(BeTrue())
+		Expect(policy.Expire(t, now.Add(time.Hour))).To(BeFalse())
+
+		policy.Fail(t, now.Add(time.Hour))
+		Expect(t.Failures).To(Equal(1))
+	})
+})
@@ -0,0 +1,161 @@
+// Package mailer sends the store's emails through SMTP, or writes them to
+// files, the log or memory where no mail server is available.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// checkHeader refuses values that could inject extra headers.
+func checkHeader(value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return ErrInvalidAddress
+	}
+	return nil
+}
+
+// Format renders a message as RFC 5322 text with CRLF line endings.
+func Format(msg Message, date time.Time) ([]byte, error) {
+	for _, value := range []string{msg.From, msg.To, msg.Subject} {
+		if err := checkHeader(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// SMTP sends through a mail server. Auth is used when Username is set;
+// net/smtp only sends it over TLS or to localhost.
+type SMTP struct {
+	Addr     string // host:port
+	Username string
+	Password string
+}
+
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	raw, err := Format(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := strings.Cut(s.Addr, ":")
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, msg.From, []string{msg.To}, raw) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// File writes each message to its own .eml file in Dir, for inspecting
+// mail in development.
+type File struct {
+	Dir string
+}
+
+func (f File) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	raw, err := Format(msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.eml", now.UTC().Format("20060102T150405"), now.UnixNano())
+	return os.WriteFile(filepath.Join(f.Dir, name), raw, 0o600)
+}
+
+// Log writes messages to a logger, or the standard one if it is nil.
+type Log struct {
+	Logger *log.Logger
+}
+
+func (l Log) Send(ctx context.Context, msg Message) error {
+	if err := checkHeader(msg.To); err != nil {
+		return err
+	}
+	logf := log.Printf
+	if l.Logger != nil {
+		logf = l.Logger.Printf
+	}
+	logf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Memory keeps sent messages in memory, for tests.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	if err := checkHeader(msg.To); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first.
+func (m *Memory) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// To returns the messages sent to an address.
+func (m *Memory) To(address string) []Message {
+	sent := []Message{}
+	for _, msg := range m.Sent() {
+		if strings.EqualFold(msg.To, address) {
+			sent = append(sent, msg)
+		}
+	}
+	return sent
+}
@@ -0,0 +1,134 @@
+package mailer_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ecommerce-backend/mailer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// smtpServer accepts one SMTP session and returns the DATA it received.
+func smtpServer() (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	received := make(chan string, 1)
+
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		reply("220 test ready")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 queued")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 test")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+var _ = Describe("Mailer", func() {
+	msg := mailer.Message{
+		From:    "shop@example.com",
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "Hello\nFollow the link.",
+	}
+
+	It("formats messages with headers and CRLF line endings", func() {
+		raw, err := mailer.Format(msg, time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+		Expect(err).ToNot(HaveOccurred())
+		text := string(raw)
+		Expect(text).To(HavePrefix("From: shop@example.com\r\nTo: alice@example.com\r\nSubject: Reset your password\r\n"))
+		Expect(text).To(ContainSubstring("Date: Sat, 01 Jun 2024 12:00:00 +0000\r\n"))
+		Expect(text).To(HaveSuffix("\r\n\r\nHello\r\nFollow the link."))
+	})
+
+	It("refuses header injection", func() {
+		bad := msg
+		bad.To = "alice@example.com\r\nBcc: everyone@example.com"
+		_, err := mailer.Format(bad, time.Now())
+		Expect(err).To(MatchError(mailer.ErrInvalidAddress))
+		Expect((&mailer.Memory{}).Send(context.Background(), bad)).To(MatchError(mailer.ErrInvalidAddress))
+	})
+
+	It("sends through an SMTP server", func() {
+		addr, received := smtpServer()
+		Expect(mailer.SMTP{Addr: addr}.Send(context.Background(), msg)).To(Succeed())
+
+		var data string
+		Eventually(received).Should(Receive(&data))
+		Expect(data).To(ContainSubstring("Subject: Reset your password"))
+		Expect(data).To(ContainSubstring("Follow the link."))
+	})
+
+	It("writes messages to files", func() {
+		dir, err := os.MkdirTemp("", "mail")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		Expect(mailer.File{Dir: filepath.Join(dir, "out")}.Send(context.Background(), msg)).To(Succeed())
+		files, _ := filepath.Glob(filepath.Join(dir, "out", "*.eml"))
+		Expect(files).To(HaveLen(1))
+		raw, _ := os.ReadFile(files[0])
+		Expect(string(raw)).To(ContainSubstring("To: alice@example.com"))
+	})
+
+	It("writes messages to the log", func() {
+		var buf bytes.Buffer
+		Expect(mailer.Log{Logger: log.New(&buf, "", 0)}.Send(context.Background(), msg)).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring("Mail to alice@example.com: Reset your password"))
+	})
+
+	It("keeps messages in memory", func() {
+		memory := &mailer.Memory{}
+		memory.Send(context.Background(), msg)
+		other := msg
+		other.To = "bob@example.com"
+		memory.Send(context.Background(), other)
+
+		Expect(memory.Sent()).To(HaveLen(2))
+		Expect(memory.To("ALICE@example.com")).To(Equal([]mailer.Message{msg}))
+	})
+})
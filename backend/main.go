@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
+	"ecommerce-backend/carriers"
+	"ecommerce-backend/clock"
 	"ecommerce-backend/database"
 	"ecommerce-backend/handlers"
+	"ecommerce-backend/mailer"
 	"ecommerce-backend/middleware"
+	"ecommerce-backend/oidc"
+	"ecommerce-backend/payments"
+	"ecommerce-backend/scheduler"
+	"ecommerce-backend/tax"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -21,40 +33,308 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "http://192.168.29.248:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Cart-Token", "Idempotency-Key", "X-Request-ID", "X-API-Key"},
+		ExposeHeaders:    []string{"Content-Length", "X-Cart-Token", "Idempotent-Replayed", "X-Request-ID", "X-Next-Cursor", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After"},
 		AllowCredentials: true,
 	}))
 
+	// Tag every request with an ID for the logs and the audit trail
+	r.Use(middleware.RequestIDMiddleware())
+
+	// Publish the domain events each request recorded once it is done
+	r.Use(handlers.OutboxRelay())
+
+	// Payments go through the local fake gateway, which reports back by
+	// webhook a little after each change
+	handlers.Payments = payments.NewFake(payments.FakeConfig{
+		WebhookDelay: 2 * time.Second,
+		Sink: func(payload []byte, signature string) {
+			if err := handlers.ProcessPaymentWebhook(payload, signature); err != nil {
+				log.Printf("Payment webhook failed: %v", err)
+			}
+		},
+	})
+
+	// Labels come from the stub carrier, which reports tracking updates as
+	// parcels move
+	handlers.Carrier = carriers.NewStub(carriers.StubConfig{
+		Sink: func(event carriers.TrackingEvent) {
+			if err := handlers.ProcessTrackingEvent(event); err != nil {
+				log.Printf("Tracking update failed: %v", err)
+			}
+		},
+	})
+
+	// Account emails go to the SMTP server in SMTP_ADDR, or else are written
+	// to files in MAIL_DIR, or else to the log
+	switch {
+	case os.Getenv("SMTP_ADDR") != "":
+		handlers.Mailer = mailer.SMTP{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case os.Getenv("MAIL_DIR") != "":
+		handlers.Mailer = mailer.File{Dir: os.Getenv("MAIL_DIR")}
+	}
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		handlers.MailFrom = from
+	}
+	if appURL := os.Getenv("APP_URL"); appURL != "" {
+		handlers.AppURL = appURL
+	}
+
+	// Catalog prices include tax when TAX_INCLUSIVE is true. TAX_ROUNDING
+	// rounds tax per "line" or per "order", and carts without an address are
+	// taxed for TAX_DEFAULT_COUNTRY and TAX_DEFAULT_REGION.
+	if value := os.Getenv("TAX_INCLUSIVE"); value != "" {
+		inclusive, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("TAX_INCLUSIVE must be true or false, not %q", value)
+		}
+		handlers.Taxes.Inclusive = inclusive
+	}
+	switch rounding := tax.Rounding(os.Getenv("TAX_ROUNDING")); rounding {
+	case "":
+	case tax.RoundPerLine, tax.RoundPerOrder:
+		handlers.Taxes.Rounding = rounding
+	default:
+		log.Fatalf("TAX_ROUNDING must be %q or %q, not %q", tax.RoundPerLine, tax.RoundPerOrder, rounding)
+	}
+	if country := os.Getenv("TAX_DEFAULT_COUNTRY"); country != "" {
+		handlers.Taxes.DefaultCountry = strings.ToUpper(country)
+		handlers.Taxes.DefaultRegion = strings.ToUpper(os.Getenv("TAX_DEFAULT_REGION"))
+	}
+
+	// Customers can sign in with the OpenID Connect provider at OIDC_ISSUER.
+	// OIDC_REDIRECT_URL is the frontend page the provider sends them back to,
+	// which posts the code and state to /users/login/oidc/callback.
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		handlers.OIDC = oidc.NewClient(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		}, nil)
+	}
+
+	// Domain events are queued for the webhook endpoints subscribed to them
+	handlers.Events.SubscribeAll(handlers.QueueWebhooks)
+
 	// Serve static files (assets/images)
 	r.Static("/assets", "../assets")
 
 	// Public routes
-	r.POST("/users/login", handlers.LoginUser)
 	r.GET("/items", handlers.GetItems)
+	r.POST("/payments/webhook", handlers.HandlePaymentWebhook)
+
+	// POSTs sent with an Idempotency-Key are safe to retry
+	idempotency := middleware.IdempotencyMiddleware(middleware.IdempotencyConfig{})
+
+	// Routes open to guests; a guest cart is merged on login or registration
+	guest := r.Group("/")
+	guest.Use(middleware.GuestMiddleware())
+	{
+		// Sign-in responses carry tokens and secrets, so they are never stored for replay
+		guest.POST("/users/login", handlers.LoginUser)
+		guest.POST("/users/login/mfa", handlers.CompleteMFALogin)
+		guest.POST("/users/login/mfa/enroll", handlers.EnrollMFALogin)
+		guest.POST("/users/login/oidc", handlers.StartOIDCLogin)
+		guest.POST("/users/login/oidc/callback", handlers.CompleteOIDCLogin)
+		guest.POST("/users/password/reset", handlers.ResetPassword)
+	}
+	guest = guest.Group("/")
+	guest.Use(idempotency)
+	{
+		guest.POST("/users", handlers.CreateUser)
+		guest.POST("/users/email/verify", handlers.VerifyEmail)
+		guest.POST("/users/password/forgot", handlers.ForgotPassword)
+		guest.POST("/carts", handlers.AddToCart)
+		guest.GET("/carts/user", handlers.GetUserCart)
+	}
 
 	// Protected routes
 	auth := r.Group("/")
-	auth.Use(middleware.AuthMiddleware())
+	auth.Use(middleware.AuthMiddleware(), idempotency)
 	{
 		// User routes
-		auth.GET("/users", handlers.GetUsers)
+		auth.POST("/users/logout", handlers.LogoutUser)
+
+		// Session routes
+		auth.GET("/sessions", handlers.GetSessions)
+		auth.DELETE("/sessions/:id", handlers.RevokeSession)
+
+		// Account email routes
+		auth.PUT("/users/email", handlers.UpdateEmail)
+		auth.POST("/users/email/verification", handlers.ResendVerification)
+
+		// Identity provider routes
+		auth.GET("/users/identities", handlers.GetUserIdentities)
+		auth.POST("/users/identities/oidc", handlers.LinkOIDCIdentity)
+		auth.DELETE("/users/identities/:id", handlers.UnlinkUserIdentity)
+
+		// Profile routes
+		auth.GET("/profile", handlers.GetProfile)
+		auth.PUT("/profile", handlers.UpdateProfile)
+		auth.PUT("/profile/password", handlers.ChangePassword)
+		auth.GET("/profile/export", handlers.ExportUserData)
+		auth.DELETE("/profile", handlers.DeleteAccount)
+
+		// Two-factor authentication routes
+		auth.GET("/mfa", handlers.GetMFAStatus)
+		auth.POST("/mfa/enroll", handlers.EnrollMFA)
+		auth.POST("/mfa/verify", handlers.ConfirmMFA)
+		auth.POST("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
+		auth.DELETE("/mfa", handlers.DisableMFA)
 
 		// Item routes
 		auth.POST("/items", handlers.CreateItem)
 
 		// Cart routes
-		auth.POST("/carts", handlers.AddToCart)
-		auth.GET("/carts", handlers.GetCarts)
-		auth.GET("/carts/user", handlers.GetUserCart)
-		auth.GET("/carts/:id", handlers.GetCartByID)
+		auth.GET("/carts/mine", handlers.GetMyCarts)
+		auth.POST("/carts/mine", handlers.CreateCart)
+		auth.PUT("/carts/:id", handlers.RenameCart)
+		auth.DELETE("/carts/:id", handlers.DeleteCart)
+		auth.POST("/carts/:id/switch", handlers.SwitchCart)
+		auth.POST("/carts/:id/items/:item_id/move", handlers.MoveCartLine)
+		auth.POST("/carts/:id/items/:item_id/save", handlers.SaveForLater)
+		auth.GET("/carts/saved", handlers.GetSavedForLater)
+		auth.POST("/carts/coupons", handlers.ApplyCoupon)
+		auth.DELETE("/carts/coupons/:code", handlers.RemoveCoupon)
+		auth.GET("/carts/shipping/quotes", handlers.GetShippingQuotes)
+		auth.PUT("/carts/shipping", handlers.SelectShipping)
+
+		// Address book routes
+		auth.GET("/addresses", handlers.GetAddresses)
+		auth.POST("/addresses", handlers.CreateAddress)
+		auth.PUT("/addresses/:id", handlers.UpdateAddress)
+		auth.DELETE("/addresses/:id", handlers.DeleteAddress)
+
+		// Shipping catalog
+		auth.GET("/shipping/methods", handlers.GetShippingMethods)
 
 		// Order routes
 		auth.POST("/orders", handlers.CreateOrder)
-		auth.GET("/orders", handlers.GetOrders)
 		auth.GET("/orders/user", handlers.GetUserOrders)
+		auth.GET("/orders/:id", handlers.GetOrder)
+		auth.POST("/orders/:id/cancel", handlers.CancelOrder)
+		auth.POST("/orders/:id/reorder", handlers.ReorderOrder)
+		auth.GET("/orders/:id/shipments", handlers.GetOrderShipments)
+
+		// Invoice routes
+		auth.GET("/orders/:id/invoice", handlers.GetOrderInvoice)
+		auth.GET("/orders/:id/invoices", handlers.GetOrderInvoices)
+		auth.GET("/invoices/:number", handlers.GetInvoice)
+
+		// Return routes
+		auth.POST("/orders/:id/returns", handlers.CreateReturn)
+		auth.GET("/returns/mine", handlers.GetMyReturns)
+		auth.GET("/store-credit", handlers.GetStoreCredit)
+
+		// Stands in for the bank's 3DS page when using the fake gateway
+		auth.POST("/payments/fake/:intent_id/complete", handlers.CompleteFakePayment)
 	}
 
+	// Admin routes
+	admin := auth.Group("/")
+	admin.Use(middleware.AdminMiddleware())
+	{
+		// Item routes
+		admin.PUT("/items/:id", handlers.UpdateItem)
+		admin.DELETE("/items/:id", handlers.DeleteItem)
+
+		// User routes
+		admin.GET("/users", handlers.GetUsers)
+		admin.PUT("/users/:id/role", handlers.SetUserRole)
+		admin.POST("/users/:id/unlock", handlers.UnlockUser)
+		admin.GET("/users/:id/sessions", handlers.GetUserSessions)
+		admin.POST("/users/:id/logout", handlers.ForceLogoutUser)
+		admin.DELETE("/users/:id/mfa", handlers.ResetUserMFA)
+		admin.GET("/mfa/roles", handlers.GetMFARoles)
+		admin.PUT("/mfa/roles/:role", handlers.SetMFARole)
+
+		// Cart routes
+		admin.GET("/carts", handlers.GetCarts)
+		admin.GET("/carts/:id", handlers.GetCartByID)
+
+		// Audit routes
+		admin.GET("/audit", handlers.GetAuditLog)
+		admin.GET("/audit/verify", handlers.VerifyAuditLog)
+
+		// API keys
+		admin.GET("/api-keys", handlers.GetAPIKeys)
+		admin.POST("/api-keys", handlers.CreateAPIKey)
+		admin.DELETE("/api-keys/:id", handlers.RevokeAPIKey)
+
+		// Webhook routes
+		admin.GET("/webhooks", handlers.GetWebhookEndpoints)
+		admin.POST("/webhooks", handlers.CreateWebhookEndpoint)
+		admin.PUT("/webhooks/:id", handlers.UpdateWebhookEndpoint)
+		admin.DELETE("/webhooks/:id", handlers.DeleteWebhookEndpoint)
+		admin.GET("/webhooks/deliveries", handlers.GetWebhookDeliveries)
+		admin.POST("/webhooks/deliveries/:id/redeliver", handlers.RedeliverWebhook)
+
+		// Promotion routes
+		admin.POST("/promotions", handlers.CreatePromotion)
+		admin.GET("/promotions", handlers.GetPromotions)
+		admin.PUT("/promotions/:id", handlers.UpdatePromotion)
+		admin.DELETE("/promotions/:id", handlers.DeletePromotion)
+
+		// Tax routes
+		admin.GET("/tax/rules", handlers.GetTaxRules)
+		admin.POST("/tax/rules", handlers.CreateTaxRule)
+		admin.PUT("/tax/rules/:id", handlers.UpdateTaxRule)
+		admin.DELETE("/tax/rules/:id", handlers.DeleteTaxRule)
+		admin.PUT("/users/:id/tax-exempt", handlers.SetUserTaxExempt)
+
+		// Shipping routes
+		admin.POST("/shipping/methods", handlers.CreateShippingMethod)
+		admin.PUT("/shipping/methods/:id", handlers.UpdateShippingMethod)
+		admin.DELETE("/shipping/methods/:id", handlers.DeleteShippingMethod)
+		admin.GET("/shipping/zones", handlers.GetShippingZones)
+		admin.POST("/shipping/zones", handlers.CreateShippingZone)
+
+		// Order routes
+		admin.GET("/orders", handlers.GetOrders)
+		admin.POST("/orders/:id/refunds", handlers.RefundOrder)
+		admin.POST("/orders/:id/shipments", handlers.CreateShipment)
+
+		// Invoice routes
+		admin.GET("/invoice-sequences", handlers.GetDocumentSequences)
+		admin.PUT("/invoice-sequences/:name", handlers.UpdateDocumentSequence)
+
+		// Return routes
+		admin.GET("/returns", handlers.GetReturns)
+		admin.POST("/returns/:id/approve", handlers.ApproveReturn)
+		admin.POST("/returns/:id/reject", handlers.RejectReturn)
+		admin.POST("/returns/:id/receive", handlers.ReceiveReturn)
+		admin.POST("/returns/:id/inspect", handlers.InspectReturn)
+		admin.POST("/returns/:id/resolve", handlers.ResolveReturn)
+	}
+
+	// Background jobs
+	jobs := scheduler.New(clock.Real{})
+	jobs.Every("cart cleanup", 15*time.Minute, func(now time.Time) {
+		if result := handlers.Sweeper.Sweep(now); result.Expired > 0 || result.Compacted > 0 {
+			log.Printf("Cart cleanup: %d expired, %d compacted", result.Expired, result.Compacted)
+		}
+	})
+	jobs.Every("outbox relay", time.Second, func(now time.Time) {
+		handlers.RelayOutbox()
+	})
+	jobs.Every("outbox cleanup", time.Hour, handlers.PruneOutbox)
+	jobs.Every("webhook delivery", 5*time.Second, func(now time.Time) {
+		handlers.DeliverWebhooks(context.Background(), now)
+	})
+	jobs.Every("login throttle cleanup", time.Hour, handlers.PruneLoginThrottles)
+	jobs.Every("user token cleanup", time.Hour, handlers.PruneUserTokens)
+	jobs.Every("session cleanup", time.Hour, handlers.PruneSessions)
+	jobs.Every("single sign-on cleanup", time.Hour, handlers.PruneOIDCLogins)
+	jobs.Every("idempotency key cleanup", time.Hour, func(now time.Time) {
+		middleware.PruneIdempotencyKeys(now)
+	})
+	jobs.Start(context.Background())
+
 	log.Println("Server starting on http://localhost:8080")
 	r.Run(":8080")
 }
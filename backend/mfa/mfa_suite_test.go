@@ -0,0 +1,13 @@
+package mfa_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMFA(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MFA Suite")
+}
@@ -0,0 +1,108 @@
+package mfa_test
+
+import (
+	"bytes"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"ecommerce-backend/mfa"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MFA", func() {
+	// The RFC 6238 test key, "12345678901234567890", in base32
+	const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	Describe("TOTP", func() {
+		It("matches the RFC 6238 test vectors", func() {
+			for unix, code := range map[int64]string{
+				59:          "287082",
+				1111111109:  "081804",
+				1111111111:  "050471",
+				1234567890:  "005924",
+				2000000000:  "279037",
+				20000000000: "353130",
+			} {
+				Expect(mfa.Code(rfcSecret, time.Unix(unix, 0))).To(Equal(code), "at %d", unix)
+			}
+		})
+
+		It("accepts codes from the neighbouring steps only", func() {
+			now := time.Unix(1234567890, 0)
+			previous, _ := mfa.Code(rfcSecret, now.Add(-mfa.Period))
+			stale, _ := mfa.Code(rfcSecret, now.Add(-2*mfa.Period))
+
+			step, ok := mfa.Validate(rfcSecret, previous, now, 0)
+			Expect(ok).To(BeTrue())
+			Expect(step).To(Equal(mfa.Step(now) - 1))
+
+			_, ok = mfa.Validate(rfcSecret, stale, now, 0)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("refuses a code for a step already used", func() {
+			now := time.Unix(1234567890, 0)
+			code, _ := mfa.Code(rfcSecret, now)
+			step, ok := mfa.Validate(rfcSecret, code, now, 0)
+			Expect(ok).To(BeTrue())
+
+			_, ok = mfa.Validate(rfcSecret, code, now, step)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("refuses malformed codes and secrets", func() {
+			now := time.Unix(59, 0)
+			_, ok := mfa.Validate(rfcSecret, "28708", now, 0)
+			Expect(ok).To(BeFalse())
+			_, ok = mfa.Validate("not base32!", "287082", now, 0)
+			Expect(ok).To(BeFalse())
+			_, err := mfa.Code("", now)
+			Expect(err).To(MatchError(mfa.ErrInvalidSecret))
+		})
+
+		It("makes secrets apps can read", func() {
+			secret, err := mfa.NewSecret()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secret).To(HaveLen(32))
+			_, err = mfa.Code(secret, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	It("builds an otpauth URI and renders it as a QR code", func() {
+		uri := mfa.URI("Shop", "alice", rfcSecret)
+		parsed, err := url.Parse(uri)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Scheme).To(Equal("otpauth"))
+		Expect(parsed.Host).To(Equal("totp"))
+		Expect(parsed.Path).To(Equal("/Shop:alice"))
+		Expect(parsed.Query().Get("secret")).To(Equal(rfcSecret))
+		Expect(parsed.Query().Get("issuer")).To(Equal("Shop"))
+
+		image, err := mfa.QRCode(uri, 256)
+		Expect(err).ToNot(HaveOccurred())
+		decoded, err := png.Decode(bytes.NewReader(image))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded.Bounds().Dx()).To(Equal(256))
+	})
+
+	It("makes distinct recovery codes and hashes them loosely", func() {
+		codes, err := mfa.NewRecoveryCodes(10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(codes).To(HaveLen(10))
+		seen := map[string]bool{}
+		for _, code := range codes {
+			Expect(code).To(MatchRegexp(`^[a-z2-9]{5}-[a-z2-9]{5}$`))
+			seen[code] = true
+		}
+		Expect(seen).To(HaveLen(10))
+
+		Expect(mfa.HashRecoveryCode(strings.ToUpper(codes[0]))).To(Equal(mfa.HashRecoveryCode(codes[0])))
+		Expect(mfa.HashRecoveryCode(strings.Replace(codes[0], "-", " ", 1))).To(Equal(mfa.HashRecoveryCode(codes[0])))
+		Expect(mfa.HashRecoveryCode(codes[1])).ToNot(Equal(mfa.HashRecoveryCode(codes[0])))
+	})
+})
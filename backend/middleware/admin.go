@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminMiddleware only lets users with the admin role through. It must run
+// after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		database.DB.Mutex.RLock()
+		user, exists := database.DB.Users[userID.(uint)]
+		isAdmin := exists && user.Role == models.RoleAdmin
+		database.DB.Mutex.RUnlock()
+
+		if !isAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"ecommerce-backend/apikey"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries an API key. A key can also be sent as the bearer
+// token.
+const APIKeyHeader = "X-API-Key"
+
+// Clock is the time API key expiry and rate limits are checked against.
+var Clock clock.Clock = clock.Real{}
+
+// apiKeyCredential returns the API key a request was sent with, if any.
+func apiKeyCredential(c *gin.Context) (string, bool) {
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		return key, true
+	}
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); apikey.IsKey(token) {
+		return token, true
+	}
+	return "", false
+}
+
+// authenticateAPIKey checks an API key, its scope for the route and its
+// rate limit, and sets "user_id" and "username" to the key's user and
+// "api_key_id" to the key. When the request may not go on it responds,
+// aborts and returns false.
+func authenticateAPIKey(c *gin.Context, key string) bool {
+	now := Clock.Now()
+
+	database.DB.Mutex.Lock()
+	var found *models.APIKey
+	if prefix, ok := apikey.PrefixOf(key); ok {
+		for _, k := range database.DB.APIKeys {
+			if k.Prefix == prefix && apikey.Matches(k, key) {
+				found = k
+				break
+			}
+		}
+	}
+	var user *models.User
+	if found != nil && apikey.Usable(found, now) {
+		user = database.DB.Users[found.UserID]
+	}
+	if user == nil {
+		database.DB.Mutex.Unlock()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return false
+	}
+
+	scope, ok := apikey.Required(c.Request.Method, c.FullPath())
+	if !ok || !apikey.Allows(found.Scopes, scope) {
+		database.DB.Mutex.Unlock()
+		message := "API keys cannot be used here"
+		if ok {
+			message = fmt.Sprintf("API key lacks the %s scope", scope)
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": message})
+		c.Abort()
+		return false
+	}
+
+	remaining, wait, allowed := apikey.Take(found, now)
+	limit := found.RateLimit
+	found.LastUsedAt = &now
+	found.LastUsedIP = c.ClientIP()
+	keyID, userID, username := found.ID, user.ID, user.Username
+	database.DB.Mutex.Unlock()
+
+	c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
+	c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+	if !allowed {
+		c.Header("Retry-After", fmt.Sprintf("%d", (wait+time.Second-1)/time.Second))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "API key rate limit exceeded"})
+		c.Abort()
+		return false
+	}
+
+	c.Set("user_id", userID)
+	c.Set("username", username)
+	c.Set("api_key_id", keyID)
+	return true
+}
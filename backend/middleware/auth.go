@@ -7,8 +7,18 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// AuthMiddleware requires a bearer JWT from a session that is still active,
+// or an API key, and sets "user_id" and "username" to the user it was
+// issued to.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key, ok := apiKeyCredential(c); ok {
+			if authenticateAPIKey(c, key) {
+				c.Next()
+			}
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -25,6 +35,9 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		if !checkSession(c, claims) {
+			return
+		}
 
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"ecommerce-backend/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GuestMiddleware lets both signed-in users and anonymous shoppers through.
+// A bearer token or API key is checked like AuthMiddleware does and sets
+// "user_id"; otherwise a valid cart token from the header or cookie sets
+// "guest_cart_id". Requests with neither continue as a new guest.
+func GuestMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key, ok := apiKeyCredential(c); ok {
+			if !authenticateAPIKey(c, key) {
+				return
+			}
+		} else if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+
+			claims, err := utils.ValidateToken(tokenString)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			if !checkSession(c, claims) {
+				return
+			}
+
+			c.Set("user_id", claims.UserID)
+			c.Set("username", claims.Username)
+		}
+
+		tokenString := c.GetHeader(utils.CartTokenHeader)
+		if tokenString == "" {
+			tokenString, _ = c.Cookie(utils.CartTokenCookie)
+		}
+		if tokenString != "" {
+			// An expired or forged cart token just starts a new guest cart
+			if claims, err := utils.ValidateCartToken(tokenString); err == nil {
+				c.Set("guest_cart_id", claims.CartID)
+			}
+		}
+
+		c.Next()
+	}
+}
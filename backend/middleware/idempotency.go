@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/models"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader        = "Idempotency-Key"
+	IdempotentReplayedHeader    = "Idempotent-Replayed"
+	maxIdempotencyKeyLength     = 255
+	defaultIdempotencyRetention = 24 * time.Hour
+)
+
+// replayedHeaders are the response headers stored with a response and sent
+// again when it is replayed.
+var replayedHeaders = []string{"Content-Type", "Location", "X-Cart-Token"}
+
+// IdempotencyConfig configures IdempotencyMiddleware. The zero value keeps
+// responses for 24 hours on the wall clock.
+type IdempotencyConfig struct {
+	Retention time.Duration
+	Clock     clock.Clock
+}
+
+// IdempotencyMiddleware makes POST requests that carry an Idempotency-Key
+// header safe to retry. The first response for a (user, route, key) is
+// stored for the retention window and replayed for later requests with the
+// same key. A duplicate that arrives while the first request is still
+// running gets 409, and a key reused with a different path or body gets 422.
+// Server errors are not stored, so the request can be retried.
+//
+// It must run after AuthMiddleware or GuestMiddleware so the key can be
+// scoped to the user or guest cart. Routes whose responses carry tokens or
+// secrets must not use it, since the stored response would hand them out
+// again.
+func IdempotencyMiddleware(config IdempotencyConfig) gin.HandlerFunc {
+	if config.Retention <= 0 {
+		config.Retention = defaultIdempotencyRetention
+	}
+	if config.Clock == nil {
+		config.Clock = clock.Real{}
+	}
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		route := c.Request.Method + " " + c.FullPath()
+		recordKey := idempotencyScope(c) + "|" + route + "|" + key
+		hash := requestHash(c.Request.URL.Path, c.Request.URL.RawQuery, body)
+		now := config.Clock.Now()
+
+		database.DB.Mutex.Lock()
+		record, exists := database.DB.IdempotencyKeys[recordKey]
+		if exists && !now.Before(record.ExpiresAt) {
+			delete(database.DB.IdempotencyKeys, recordKey)
+			exists = false
+		}
+		if !exists {
+			database.DB.IdempotencyKeys[recordKey] = &models.IdempotencyRecord{
+				Scope:       idempotencyScope(c),
+				Key:         key,
+				Route:       route,
+				RequestHash: hash,
+				CreatedAt:   now,
+				ExpiresAt:   now.Add(config.Retention),
+			}
+		}
+		var stored models.IdempotencyRecord
+		if exists {
+			stored = *record
+		}
+		database.DB.Mutex.Unlock()
+
+		if exists {
+			switch {
+			case stored.RequestHash != hash:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+			case !stored.Completed:
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			default:
+				for name, value := range stored.Headers {
+					c.Header(name, value)
+				}
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Status(stored.StatusCode)
+				c.Writer.Write(stored.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		completed := false
+		defer func() {
+			// Forget the key if the handler panicked or failed, so it can be retried
+			database.DB.Mutex.Lock()
+			defer database.DB.Mutex.Unlock()
+
+			if !completed {
+				delete(database.DB.IdempotencyKeys, recordKey)
+				return
+			}
+			record, exists := database.DB.IdempotencyKeys[recordKey]
+			if !exists {
+				return
+			}
+			record.Completed = true
+			record.StatusCode = writer.Status()
+			record.Body = writer.body.Bytes()
+			record.Headers = map[string]string{}
+			for _, name := range replayedHeaders {
+				if value := writer.Header().Get(name); value != "" {
+					record.Headers[name] = value
+				}
+			}
+		}()
+
+		c.Next()
+		completed = writer.Status() < http.StatusInternalServerError
+	}
+}
+
+// PruneIdempotencyKeys deletes records whose retention window has passed and
+// returns how many it removed.
+func PruneIdempotencyKeys(now time.Time) int {
+	database.DB.Mutex.Lock()
+	defer database.DB.Mutex.Unlock()
+
+	pruned := 0
+	for key, record := range database.DB.IdempotencyKeys {
+		if !now.Before(record.ExpiresAt) {
+			delete(database.DB.IdempotencyKeys, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// idempotencyScope names who a key belongs to: the signed-in user, else the
+// guest cart, else the client address.
+func idempotencyScope(c *gin.Context) string {
+	if userID, exists := c.Get("user_id"); exists {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	if cartID, exists := c.Get("guest_cart_id"); exists {
+		return fmt.Sprintf("guest:%d", cartID)
+	}
+	return "client:" + c.ClientIP()
+}
+
+// requestHash fingerprints a request. The path is included because records
+// are kept per route, and a key reused for another order must not replay
+// the first one's response.
+func requestHash(path, query string, body []byte) string {
+	sum := sha256.New()
+	sum.Write([]byte(path))
+	sum.Write([]byte{0})
+	sum.Write([]byte(query))
+	sum.Write([]byte{0})
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
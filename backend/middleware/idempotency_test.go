@@ -0,0 +1,164 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ecommerce-backend/clock"
+	"ecommerce-backend/database"
+	"ecommerce-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IdempotencyMiddleware", func() {
+	var (
+		router  *gin.Engine
+		fake    *clock.Fake
+		calls   int32
+		release chan struct{}
+	)
+
+	send := func(path, key, body string, userID uint) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		req.Header.Set("X-User", strconv.FormatUint(uint64(userID), 10))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		database.Connect()
+		fake = clock.NewFake(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+		atomic.StoreInt32(&calls, 0)
+		release = make(chan struct{})
+
+		router = gin.New()
+		router.Use(func(c *gin.Context) {
+			userID, _ := strconv.ParseUint(c.GetHeader("X-User"), 10, 64)
+			c.Set("user_id", uint(userID))
+		})
+		router.Use(middleware.IdempotencyMiddleware(middleware.IdempotencyConfig{Retention: time.Hour, Clock: fake}))
+		router.POST("/orders", func(c *gin.Context) {
+			n := atomic.AddInt32(&calls, 1)
+			c.Header("Location", "/orders/1")
+			c.JSON(http.StatusCreated, gin.H{"order": n})
+		})
+		router.POST("/carts", func(c *gin.Context) {
+			atomic.AddInt32(&calls, 1)
+			c.JSON(http.StatusCreated, gin.H{"message": "added"})
+		})
+		router.POST("/orders/:id/cancel", func(c *gin.Context) {
+			atomic.AddInt32(&calls, 1)
+			c.JSON(http.StatusOK, gin.H{"cancelled": c.Param("id")})
+		})
+		router.POST("/slow", func(c *gin.Context) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			c.JSON(http.StatusCreated, gin.H{"message": "done"})
+		})
+		router.POST("/broken", func(c *gin.Context) {
+			atomic.AddInt32(&calls, 1)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "boom"})
+		})
+	})
+
+	It("replays the first response for a retried key", func() {
+		first := send("/orders", "abc", `{"cart_id":1}`, 1)
+		Expect(first.Code).To(Equal(http.StatusCreated))
+
+		second := send("/orders", "abc", `{"cart_id":1}`, 1)
+		Expect(second.Code).To(Equal(http.StatusCreated))
+		Expect(second.Body.String()).To(Equal(first.Body.String()))
+		Expect(second.Header().Get("Location")).To(Equal("/orders/1"))
+		Expect(second.Header().Get(middleware.IdempotentReplayedHeader)).To(Equal("true"))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("passes requests without a key straight through", func() {
+		send("/orders", "", `{}`, 1)
+		send("/orders", "", `{}`, 1)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+		Expect(database.DB.IdempotencyKeys).To(BeEmpty())
+	})
+
+	It("scopes keys to the user and the route", func() {
+		send("/orders", "abc", `{}`, 1)
+		send("/orders", "abc", `{}`, 2)
+		send("/carts", "abc", `{}`, 1)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(3)))
+	})
+
+	It("rejects a key reused with a different body", func() {
+		send("/orders", "abc", `{"cart_id":1}`, 1)
+		w := send("/orders", "abc", `{"cart_id":2}`, 1)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("rejects a key reused for another resource on the same route", func() {
+		Expect(send("/orders/5/cancel", "abc", `{}`, 1).Code).To(Equal(http.StatusOK))
+		w := send("/orders/6/cancel", "abc", `{}`, 1)
+		Expect(w.Code).To(Equal(http.StatusUnprocessableEntity))
+		Expect(w.Body.String()).ToNot(ContainSubstring(`"cancelled":"5"`))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+
+		w = send("/orders/5/cancel", "abc", `{}`, 1)
+		Expect(w.Header().Get(middleware.IdempotentReplayedHeader)).To(Equal("true"))
+	})
+
+	It("rejects a duplicate while the first request is still running", func() {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- send("/slow", "abc", `{}`, 1) }()
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(1)))
+
+		Expect(send("/slow", "abc", `{}`, 1).Code).To(Equal(http.StatusConflict))
+
+		close(release)
+		Expect((<-done).Code).To(Equal(http.StatusCreated))
+		Expect(send("/slow", "abc", `{}`, 1).Code).To(Equal(http.StatusCreated))
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("does not keep server errors so the request can be retried", func() {
+		Expect(send("/broken", "abc", `{}`, 1).Code).To(Equal(http.StatusInternalServerError))
+		send("/broken", "abc", `{}`, 1)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+		Expect(database.DB.IdempotencyKeys).To(BeEmpty())
+	})
+
+	It("forgets keys after the retention window", func() {
+		send("/orders", "abc", `{}`, 1)
+
+		fake.Advance(59 * time.Minute)
+		send("/orders", "abc", `{}`, 1)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+
+		fake.Advance(time.Minute)
+		send("/orders", "abc", `{}`, 1)
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+	})
+
+	It("prunes expired records", func() {
+		send("/orders", "abc", `{}`, 1)
+		send("/orders", "def", `{}`, 1)
+
+		Expect(middleware.PruneIdempotencyKeys(fake.Now())).To(BeZero())
+		Expect(middleware.PruneIdempotencyKeys(fake.Now().Add(time.Hour))).To(Equal(2))
+		Expect(database.DB.IdempotencyKeys).To(BeEmpty())
+	})
+
+	It("rejects overlong keys", func() {
+		Expect(send("/orders", strings.Repeat("k", 256), `{}`, 1).Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -0,0 +1,58 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"ecommerce-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestIDMiddleware", func() {
+	var router *gin.Engine
+
+	send := func(id string) (*httptest.ResponseRecorder, string) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if id != "" {
+			req.Header.Set(middleware.RequestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w, w.Body.String()
+	}
+
+	BeforeEach(func() {
+		router = gin.New()
+		router.Use(middleware.RequestIDMiddleware())
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetString("request_id"))
+		})
+	})
+
+	It("generates an ID and echoes it", func() {
+		w, seen := send("")
+		Expect(seen).ToNot(BeEmpty())
+		Expect(w.Header().Get(middleware.RequestIDHeader)).To(Equal(seen))
+
+		_, again := send("")
+		Expect(again).ToNot(Equal(seen))
+	})
+
+	It("keeps a sane incoming ID", func() {
+		w, seen := send("edge-7f3a")
+		Expect(seen).To(Equal("edge-7f3a"))
+		Expect(w.Header().Get(middleware.RequestIDHeader)).To(Equal("edge-7f3a"))
+	})
+
+	It("replaces an ID that is too long or not printable", func() {
+		_, seen := send(strings.Repeat("a", 200))
+		Expect(seen).To(HaveLen(36))
+
+		_, seen = send("two words")
+		Expect(seen).ToNot(Equal("two words"))
+	})
+})
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"ecommerce-backend/database"
+	"ecommerce-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionTouchInterval is how often a session's last-seen time is updated;
+// requests in between only read it.
+const sessionTouchInterval = time.Minute
+
+// checkSession refuses a token whose user no longer exists or that has no
+// session or an ended one, and notes when and where the session was last
+// seen. It sets "session_id". When the token may not be used it responds,
+// aborts and returns false.
+func checkSession(c *gin.Context, claims *utils.Claims) bool {
+	now := Clock.Now()
+	ip := c.ClientIP()
+
+	database.DB.Mutex.RLock()
+	user := database.DB.Users[claims.UserID]
+	valid := user != nil
+	touch := false
+	switch {
+	case user == nil:
+		// Deleted users' tokens end with them
+	default:
+		session := database.DB.Sessions[claims.SessionID]
+		// The token expires with its session, so only revocation is checked
+		valid = session != nil && session.UserID == claims.UserID && session.RevokedAt == nil
+		touch = valid && (now.Sub(session.LastSeenAt) >= sessionTouchInterval || session.IP != ip)
+	}
+	database.DB.Mutex.RUnlock()
+
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has ended, please log in again"})
+		c.Abort()
+		return false
+	}
+
+	if touch {
+		database.DB.Mutex.Lock()
+		if session := database.DB.Sessions[claims.SessionID]; session != nil {
+			session.LastSeenAt = now
+			session.IP = ip
+		}
+		database.DB.Mutex.Unlock()
+	}
+	c.Set("session_id", claims.SessionID)
+	return true
+}
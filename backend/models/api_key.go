@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// APIKey lets a service call the API as the user it belongs to, limited to
+// its scopes. Only a hash of the key is kept; Prefix is its public start,
+// shown so keys can be told apart.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix" gorm:"uniqueIndex"`
+	Hash       string     `json:"-"`
+	UserID     uint       `json:"user_id"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"` // "<resource>:read" or "<resource>:write"
+	RateLimit  int        `json:"rate_limit"`                    // requests per minute
+	CreatedBy  uint       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// Token bucket for the rate limit
+	Tokens     float64   `json:"-" gorm:"-"`
+	RefilledAt time.Time `json:"-" gorm:"-"`
+}
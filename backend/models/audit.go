@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit outcomes.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditChange is one field changed by an audited action. Values are the
+// field's JSON before and after; sensitive fields are redacted.
+type AuditChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditEntry records one audited action. Entries are only ever appended.
+// Each one carries the hash of the entry before it, so editing, removing or
+// reordering entries breaks the chain.
+type AuditEntry struct {
+	ID         uint              `json:"id" gorm:"primaryKey"`
+	Action     string            `json:"action"`
+	Outcome    string            `json:"outcome"`
+	ActorID    uint              `json:"actor_id,omitempty"` // zero for anonymous requests and the system
+	ActorName  string            `json:"actor_name,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	TargetType string            `json:"target_type,omitempty"`
+	TargetID   uint              `json:"target_id,omitempty"`
+	Changes    []AuditChange     `json:"changes,omitempty" gorm:"serializer:json"`
+	Metadata   map[string]string `json:"metadata,omitempty" gorm:"serializer:json"`
+	CreatedAt  time.Time         `json:"created_at"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
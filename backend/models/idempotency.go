@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key header so that retries get the same response. It is
+// stored before the request runs and completed once the response is known.
+type IdempotencyRecord struct {
+	Scope       string            `json:"scope"` // the user, guest cart or client the key belongs to
+	Key         string            `json:"key"`
+	Route       string            `json:"route"`
+	RequestHash string            `json:"request_hash"`
+	Completed   bool              `json:"completed"`
+	StatusCode  int               `json:"status_code"`
+	Headers     map[string]string `json:"headers" gorm:"serializer:json"`
+	Body        []byte            `json:"body"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+}
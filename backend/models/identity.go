@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to their account at an external identity
+// provider, which is known by its issuer and subject.
+type UserIdentity struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"index"`
+	Issuer      string     `json:"issuer" gorm:"uniqueIndex:idx_identity"`
+	Subject     string     `json:"subject" gorm:"uniqueIndex:idx_identity"`
+	Email       string     `json:"email,omitempty"` // as the provider last reported it
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// OIDCLogin is a sign-in sent to the identity provider and waiting for
+// the user to come back with a code. UserID is set when a signed-in user
+// is linking an identity instead.
+type OIDCLogin struct {
+	State     string    `json:"-" gorm:"primaryKey"`
+	Nonce     string    `json:"-"`
+	Verifier  string    `json:"-"` // PKCE code verifier
+	UserID    uint      `json:"user_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
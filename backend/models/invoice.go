@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+)
+
+// Invoice kinds. A credit note reverses part or all of an invoice.
+const (
+	InvoiceKindInvoice    = "invoice"
+	InvoiceKindCreditNote = "credit_note"
+)
+
+// InvoiceParty is the seller or buyer named on an invoice.
+type InvoiceParty struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1,omitempty"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city,omitempty"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
+	TaxID      string `json:"tax_id,omitempty"`
+	Email      string `json:"email,omitempty"`
+}
+
+// InvoiceLine is one line of an invoice. Amount is after discounts and
+// before tax.
+type InvoiceLine struct {
+	ItemID      uint   `json:"item_id,omitempty"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	Discount    int64  `json:"discount"`
+	Amount      int64  `json:"amount"`
+}
+
+// Invoice is an issued invoice or credit note. Once issued it is never
+// changed; amounts on a credit note are positive and credit the invoice
+// named in Credits.
+type Invoice struct {
+	ID       uint          `json:"id" gorm:"primaryKey"`
+	Number   string        `json:"number" gorm:"unique"`
+	Kind     string        `json:"kind"`
+	OrderID  uint          `json:"order_id" gorm:"not null"`
+	UserID   uint          `json:"user_id" gorm:"not null"`
+	RefundID uint          `json:"refund_id,omitempty"`
+	Credits  string        `json:"credits,omitempty"` // invoice number a credit note reverses
+	Reason   string        `json:"reason,omitempty"`
+	Seller   InvoiceParty  `json:"seller" gorm:"serializer:json"`
+	Buyer    InvoiceParty  `json:"buyer" gorm:"serializer:json"`
+	Currency string        `json:"currency"`
+	Lines    []InvoiceLine `json:"lines" gorm:"serializer:json"`
+	Shipping int64         `json:"shipping"` // after shipping discounts, before tax
+	Subtotal int64         `json:"subtotal"` // lines and shipping, before tax
+	TaxLines []TaxLine     `json:"tax_lines" gorm:"serializer:json"`
+	Tax      int64         `json:"tax"` // tax added on top; inclusive tax is only listed
+	Total    int64         `json:"total"`
+	IssuedAt time.Time     `json:"issued_at"`
+}
+
+// DocumentSequence numbers invoices or credit notes without gaps. The next
+// document is numbered Prefix followed by Next, zero-padded to Padding
+// digits.
+type DocumentSequence struct {
+	Name    string `json:"name" gorm:"primaryKey"`
+	Prefix  string `json:"prefix"`
+	Padding int    `json:"padding"`
+	Next    int    `json:"next"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginThrottle counts recent failed logins against one account or from one
+// client address. Failures are forgotten once enough time has passed since
+// the last one, or when a lockout ends.
+type LoginThrottle struct {
+	Key           string     `json:"key" gorm:"primaryKey"` // "user:<username>" or "ip:<address>"
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
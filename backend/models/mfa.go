@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// MFAEnrollment is a user's TOTP two-factor setup. It stays unconfirmed,
+// and is not asked for at login, until the user enters a code from their
+// app. Only hashes of the unused recovery codes are kept.
+type MFAEnrollment struct {
+	UserID        uint       `json:"user_id" gorm:"primaryKey"`
+	Secret        string     `json:"-"`
+	Confirmed     bool       `json:"confirmed"`
+	RecoveryCodes []string   `json:"-" gorm:"serializer:json"`
+	LastUsedStep  int64      `json:"-"` // time step of the last code accepted, so it cannot be replayed
+	CreatedAt     time.Time  `json:"created_at"`
+	ConfirmedAt   *time.Time `json:"confirmed_at,omitempty"`
+}
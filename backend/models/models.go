@@ -4,45 +4,91 @@ import (
 	"time"
 )
 
+// User roles
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Username  string    `json:"username" gorm:"unique;not null"`
-	Password  string    `json:"password" gorm:"not null"`
-	Token     string    `json:"token"`
-	CartID    uint      `json:"cart_id"`
-	CreatedAt time.Time `json:"created_at"`
-	Cart      Cart      `json:"cart" gorm:"foreignKey:UserID"`
-	Orders    []Order   `json:"orders" gorm:"foreignKey:UserID"`
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Username      string    `json:"username" gorm:"unique;not null"`
+	DisplayName   string    `json:"display_name,omitempty"`
+	Email         string    `json:"email,omitempty" gorm:"uniqueIndex"`
+	EmailVerified bool      `json:"email_verified"`
+	Password      string    `json:"password" gorm:"not null"`
+	Role          string    `json:"role" gorm:"default:customer"`
+	TaxExempt     bool      `json:"tax_exempt"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+	CartID        uint      `json:"cart_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Cart          Cart      `json:"cart" gorm:"foreignKey:UserID"`
+	Orders        []Order   `json:"orders" gorm:"foreignKey:UserID"`
 }
 
 type Item struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	Name      string    `json:"name" gorm:"not null"`
+	Category  string    `json:"category"`
+	TaxClass  string    `json:"tax_class" gorm:"default:standard"`
 	Status    string    `json:"status" gorm:"default:active"`
 	Image     string    `json:"image"`
+	Price     int64     `json:"price"`  // minor units (cents)
+	Weight    int       `json:"weight"` // grams
+	Stock     *int      `json:"stock"`  // nil when stock is not tracked
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Cart statuses. A user can have several active carts; the saved-for-later
+// list is a cart of its own that is never checked out. A cart is in checkout
+// while its payment is being taken.
+const (
+	CartStatusActive   = "active" // guest carts are active carts with no user
+	CartStatusSaved    = "saved"
+	CartStatusCheckout = "checkout"
+	CartStatusOrdered  = "ordered"
+)
+
 type Cart struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	UserID    uint       `json:"user_id" gorm:"not null"`
-	Name      string     `json:"name"`
-	Status    string     `json:"status" gorm:"default:active"`
-	CreatedAt time.Time  `json:"created_at"`
-	CartItems []CartItem `json:"cart_items" gorm:"foreignKey:CartID"`
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	UserID            uint       `json:"user_id" gorm:"not null"`
+	Name              string     `json:"name"`
+	Status            string     `json:"status" gorm:"default:active"`
+	CouponCodes       []string   `json:"coupon_codes" gorm:"serializer:json"`
+	ShippingAddressID uint       `json:"shipping_address_id"`
+	BillingAddressID  uint       `json:"billing_address_id"`
+	ShippingMethod    string     `json:"shipping_method"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"` // last activity, used to expire idle carts
+	CartItems         []CartItem `json:"cart_items" gorm:"foreignKey:CartID"`
 }
 
 type CartItem struct {
-	CartID uint `json:"cart_id" gorm:"primaryKey"`
-	ItemID uint `json:"item_id" gorm:"primaryKey"`
-	Cart   Cart `json:"cart" gorm:"foreignKey:CartID"`
-	Item   Item `json:"item" gorm:"foreignKey:ItemID"`
+	CartID   uint `json:"cart_id" gorm:"primaryKey"`
+	ItemID   uint `json:"item_id" gorm:"primaryKey"`
+	Quantity int  `json:"quantity" gorm:"default:1"`
+	Cart     Cart `json:"cart" gorm:"foreignKey:CartID"`
+	Item     Item `json:"item" gorm:"foreignKey:ItemID"`
 }
 
+// Order is a placed cart. Addresses and totals are copied at checkout and
+// never recomputed.
 type Order struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	CartID    uint      `json:"cart_id" gorm:"not null"`
-	UserID    uint      `json:"user_id" gorm:"not null"`
-	CreatedAt time.Time `json:"created_at"`
-	Cart      Cart      `json:"cart" gorm:"foreignKey:CartID"`
+	ID              uint                `json:"id" gorm:"primaryKey"`
+	CartID          uint                `json:"cart_id" gorm:"not null"`
+	UserID          uint                `json:"user_id" gorm:"not null"`
+	CreatedAt       time.Time           `json:"created_at"`
+	Cart            Cart                `json:"cart" gorm:"foreignKey:CartID"`
+	ShippingAddress *Address            `json:"shipping_address" gorm:"serializer:json"`
+	BillingAddress  *Address            `json:"billing_address" gorm:"serializer:json"`
+	ShippingMethod  string              `json:"shipping_method"`
+	Totals          Totals              `json:"totals" gorm:"serializer:json"`
+	Redemptions     []Redemption        `json:"redemptions" gorm:"foreignKey:OrderID"`
+	Status          string              `json:"status" gorm:"default:confirmed"`
+	Payment         *Payment            `json:"payment,omitempty" gorm:"foreignKey:OrderID"`
+	Refunds         []Refund            `json:"refunds" gorm:"foreignKey:OrderID"`
+	Balance         OrderBalance        `json:"balance" gorm:"embedded;embeddedPrefix:balance_"`
+	CancelReason    string              `json:"cancel_reason,omitempty"`
+	CancelledAt     *time.Time          `json:"cancelled_at,omitempty"`
+	History         []OrderHistoryEntry `json:"history" gorm:"serializer:json"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEvent is a domain event stored with the write that caused it and
+// published once the write is done. PublishedAt is nil until then.
+type OutboxEvent struct {
+	ID          uint            `json:"id" gorm:"primaryKey"`
+	EventID     string          `json:"event_id" gorm:"unique"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload" gorm:"type:text"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	PublishedAt *time.Time      `json:"published_at,omitempty"`
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+)
+
+// Order statuses. An order waits in pending_payment while the provider
+// needs the customer to complete a challenge. A confirmed order that has
+// had some lines refunded stays confirmed; refunded means nothing is left.
+const (
+	OrderStatusPendingPayment = "pending_payment"
+	OrderStatusConfirmed      = "confirmed"
+	OrderStatusPaymentFailed  = "payment_failed"
+	OrderStatusCancelled      = "cancelled"
+	OrderStatusRefunded       = "refunded"
+)
+
+// Payment is the charge for an order at a payment provider. Amounts are in
+// cents; Status mirrors the provider's intent.
+type Payment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	OrderID     uint      `json:"order_id" gorm:"not null"`
+	UserID      uint      `json:"user_id" gorm:"not null"`
+	Provider    string    `json:"provider"`
+	IntentID    string    `json:"intent_id" gorm:"unique"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Captured    int64     `json:"captured"`
+	Refunded    int64     `json:"refunded"`
+	Status      string    `json:"status"`
+	FailureCode string    `json:"failure_code,omitempty"`
+	NextAction  string    `json:"next_action,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Capturing is set while a webhook captures the payment.
+	Capturing bool `json:"-"`
+}
+
+// RefundLine is the part of a refund for one order line. Amount includes
+// the line's share of tax.
+type RefundLine struct {
+	ItemID   uint  `json:"item_id"`
+	Quantity int   `json:"quantity"`
+	Amount   int64 `json:"amount"`
+}
+
+// Refund methods: back to the original payment, or as store credit.
+const (
+	RefundMethodPayment     = "payment"
+	RefundMethodStoreCredit = "store_credit"
+)
+
+// Refund statuses. A refund is pending while the payment provider is asked
+// to return the money.
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+)
+
+// Refund is money returned on an order, by an admin, because the customer
+// cancelled or for a return. Amount is the sum of the lines and Shipping.
+type Refund struct {
+	ID        uint         `json:"id" gorm:"primaryKey"`
+	OrderID   uint         `json:"order_id" gorm:"not null"`
+	PaymentID uint         `json:"payment_id"` // zero when nothing was charged
+	ReturnID  uint         `json:"return_id,omitempty"`
+	Method    string       `json:"method" gorm:"default:payment"`
+	Status    string       `json:"status"`
+	CreatedBy uint         `json:"created_by"`
+	Reason    string       `json:"reason"`
+	Lines     []RefundLine `json:"lines" gorm:"serializer:json"`
+	Shipping  int64        `json:"shipping"`
+	Amount    int64        `json:"amount"`
+	Restocked bool         `json:"restocked"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// OrderBalance is where an order stands with the customer's money.
+// Refunded went back to the payment and StoreCredit to the customer's
+// store credit. Outstanding is what is still to be collected.
+type OrderBalance struct {
+	Paid        int64 `json:"paid"`
+	Refunded    int64 `json:"refunded"`
+	StoreCredit int64 `json:"store_credit"`
+	Outstanding int64 `json:"outstanding"`
+}
+
+// OrderHistoryEntry records something that happened to an order: a status
+// change, a refund or a step in a return. Status is the order's status
+// after the event.
+type OrderHistoryEntry struct {
+	Event     string    `json:"event"`
+	Status    string    `json:"status"`
+	Note      string    `json:"note,omitempty"`
+	ReturnID  uint      `json:"return_id,omitempty"`
+	ActorID   uint      `json:"actor_id,omitempty"` // zero for the system
+	CreatedAt time.Time `json:"created_at"`
+}
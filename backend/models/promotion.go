@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// Promotion types
+const (
+	PromotionPercentOff   = "percent_off"
+	PromotionAmountOff    = "amount_off"
+	PromotionBuyXGetY     = "buy_x_get_y"
+	PromotionFreeShipping = "free_shipping"
+)
+
+// Promotion is a discount rule. Promotions without a Code apply
+// automatically; the rest need the code applied to the cart.
+type Promotion struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Code         string     `json:"code" gorm:"uniqueIndex"`
+	Name         string     `json:"name" gorm:"not null"`
+	Type         string     `json:"type" gorm:"not null"`
+	Value        int64      `json:"value"` // basis points for percent_off and buy_x_get_y, cents for amount_off
+	BuyQuantity  int        `json:"buy_quantity"`
+	GetQuantity  int        `json:"get_quantity"`
+	MinSubtotal  int64      `json:"min_subtotal"`
+	Categories   []string   `json:"categories" gorm:"serializer:json"`
+	StartsAt     *time.Time `json:"starts_at"`
+	EndsAt       *time.Time `json:"ends_at"`
+	UsageLimit   int        `json:"usage_limit"`    // 0 means unlimited
+	PerUserLimit int        `json:"per_user_limit"` // 0 means unlimited
+	Stackable    bool       `json:"stackable"`
+	Priority     int        `json:"priority"`
+	Active       bool       `json:"active" gorm:"default:true"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Redemption records a promotion used on an order.
+type Redemption struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	PromotionID uint      `json:"promotion_id" gorm:"not null"`
+	OrderID     uint      `json:"order_id" gorm:"not null"`
+	UserID      uint      `json:"user_id" gorm:"not null"`
+	Code        string    `json:"code"`
+	Amount      int64     `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
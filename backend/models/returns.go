@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// Return statuses. A request is approved or rejected by staff; approved
+// returns are received, inspected and then resolved with a refund or store
+// credit.
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+	ReturnStatusInspected = "inspected"
+	ReturnStatusRefunded  = "refunded"
+	ReturnStatusCredited  = "credited"
+)
+
+// ReturnLine is a quantity of one order line being sent back. Restock is
+// decided at inspection.
+type ReturnLine struct {
+	ItemID   uint `json:"item_id"`
+	Quantity int  `json:"quantity"`
+	Restock  bool `json:"restock"`
+}
+
+// Return is a customer's request to send back part of an order.
+type Return struct {
+	ID         uint         `json:"id" gorm:"primaryKey"`
+	OrderID    uint         `json:"order_id" gorm:"not null"`
+	UserID     uint         `json:"user_id" gorm:"not null"`
+	Status     string       `json:"status"`
+	Reason     string       `json:"reason"`
+	Lines      []ReturnLine `json:"lines" gorm:"serializer:json"`
+	StaffNote  string       `json:"staff_note,omitempty"`
+	RefundID   uint         `json:"refund_id,omitempty"` // set once resolved
+	Amount     int64        `json:"amount"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+	ResolvedAt *time.Time   `json:"resolved_at,omitempty"`
+}
+
+// StoreCredit is an entry in a customer's store credit ledger. A user's
+// balance is the sum of their entries.
+type StoreCredit struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null"`
+	Amount    int64     `json:"amount"`
+	OrderID   uint      `json:"order_id,omitempty"`
+	ReturnID  uint      `json:"return_id,omitempty"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is one login on one device. Its token is only accepted while the
+// session is neither revoked nor expired.
+type Session struct {
+	ID         uint       `json:"id" gorm:"primaryKey"` // the token's "sid" claim
+	UserID     uint       `json:"user_id" gorm:"index"`
+	Device     string     `json:"device"` // described from the user agent, e.g. "Firefox on Windows"
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"` // the last address it was used from
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Current    bool       `json:"current" gorm:"-"` // set in listings on the session making the request
+}
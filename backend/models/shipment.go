@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// Order statuses once fulfillment has started. They are set from the
+// order's shipments and never by hand.
+const (
+	OrderStatusPartiallyShipped = "partially_shipped"
+	OrderStatusShipped          = "shipped"
+	OrderStatusDelivered        = "delivered"
+)
+
+// Shipment statuses follow the carrier's tracking.
+const (
+	ShipmentStatusLabelCreated   = "label_created"
+	ShipmentStatusInTransit      = "in_transit"
+	ShipmentStatusOutForDelivery = "out_for_delivery"
+	ShipmentStatusDelivered      = "delivered"
+	ShipmentStatusException      = "exception"
+)
+
+// ShipmentLine is a quantity of one order line in a parcel.
+type ShipmentLine struct {
+	ItemID   uint `json:"item_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// ShipmentEvent is a tracking update for a shipment.
+type ShipmentEvent struct {
+	Status      string    `json:"status"`
+	Description string    `json:"description"`
+	Location    string    `json:"location,omitempty"`
+	OccurredAt  time.Time `json:"occurred_at"`
+}
+
+// Shipment is one parcel sent for an order.
+type Shipment struct {
+	ID             uint            `json:"id" gorm:"primaryKey"`
+	OrderID        uint            `json:"order_id" gorm:"not null"`
+	Carrier        string          `json:"carrier"`
+	TrackingNumber string          `json:"tracking_number" gorm:"index"`
+	Status         string          `json:"status"`
+	Lines          []ShipmentLine  `json:"lines" gorm:"serializer:json"`
+	Events         []ShipmentEvent `json:"events" gorm:"serializer:json"`
+	CreatedBy      uint            `json:"created_by"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
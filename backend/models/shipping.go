@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+)
+
+// Address is an entry in a user's address book. Orders keep their own copy.
+type Address struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"not null"`
+	Label      string    `json:"label"`
+	Name       string    `json:"name" gorm:"not null"`
+	Line1      string    `json:"line1" gorm:"not null"`
+	Line2      string    `json:"line2"`
+	City       string    `json:"city" gorm:"not null"`
+	Region     string    `json:"region"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country" gorm:"not null"` // ISO 3166-1 alpha-2
+	Phone      string    `json:"phone"`
+	IsDefault  bool      `json:"is_default"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ShippingZone groups destinations that share shipping rates. Regions are
+// "COUNTRY-REGION" codes and take precedence over Countries; a "*" country
+// matches everywhere else.
+type ShippingZone struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Code      string    `json:"code" gorm:"uniqueIndex"`
+	Name      string    `json:"name"`
+	Countries []string  `json:"countries" gorm:"serializer:json"`
+	Regions   []string  `json:"regions" gorm:"serializer:json"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShippingMethod is an entry in the shipping catalog. The rate is BaseRate
+// plus PerKgRate for every started kilogram, and free once the discounted
+// cart value reaches FreeOver.
+type ShippingMethod struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Code      string    `json:"code" gorm:"uniqueIndex"`
+	Name      string    `json:"name" gorm:"not null"`
+	Zones     []string  `json:"zones" gorm:"serializer:json"`
+	BaseRate  int64     `json:"base_rate"`
+	PerKgRate int64     `json:"per_kg_rate"`
+	FreeOver  int64     `json:"free_over"`  // 0 never ships free
+	MaxWeight int       `json:"max_weight"` // grams, 0 means no limit
+	MinDays   int       `json:"min_days"`
+	MaxDays   int       `json:"max_days"`
+	Active    bool      `json:"active" gorm:"default:true"`
+	CreatedAt time.Time `json:"created_at"`
+}
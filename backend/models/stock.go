@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// StockReservation holds stock for a line in an active cart until the cart
+// is checked out, emptied or expires.
+type StockReservation struct {
+	CartID    uint      `json:"cart_id" gorm:"primaryKey"`
+	ItemID    uint      `json:"item_id" gorm:"primaryKey"`
+	Quantity  int       `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// TaxClassStandard is the tax class of items and rules that do not name one.
+const TaxClassStandard = "standard"
+
+// TaxRule is one row of the tax rule table. Every rule matching the
+// destination and an item's tax class applies, so country and region rules
+// stack.
+type TaxRule struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Country   string    `json:"country" gorm:"not null"` // ISO 3166-1 alpha-2
+	Region    string    `json:"region"`                  // empty applies country-wide
+	TaxClass  string    `json:"tax_class"`               // empty means standard
+	Name      string    `json:"name" gorm:"not null"`
+	Rate      int64     `json:"rate"` // basis points, 725 = 7.25%
+	CreatedAt time.Time `json:"created_at"`
+}
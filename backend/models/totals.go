@@ -0,0 +1,61 @@
+package models
+
+// Line is a cart line to be priced.
+type Line struct {
+	ItemID    uint   `json:"item_id"`
+	Name      string `json:"name"`
+	Category  string `json:"category,omitempty"`
+	TaxClass  string `json:"tax_class,omitempty"`
+	UnitPrice int64  `json:"unit_price"`
+	Quantity  int    `json:"quantity"`
+	Weight    int    `json:"weight,omitempty"` // grams per unit
+}
+
+// LineTotal is a priced Line.
+type LineTotal struct {
+	Line
+	Subtotal int64 `json:"subtotal"` // UnitPrice * Quantity
+	Discount int64 `json:"discount"` // share of merchandise discounts
+	Total    int64 `json:"total"`    // Subtotal - Discount
+}
+
+// Adjustment describes a discount that was applied to the totals.
+type Adjustment struct {
+	RuleID   uint   `json:"rule_id,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Label    string `json:"label"`
+	Amount   int64  `json:"amount"`
+	Shipping bool   `json:"shipping,omitempty"`
+}
+
+// TaxLine is one entry in the tax breakdown.
+type TaxLine struct {
+	Label     string `json:"label"`
+	Rate      int64  `json:"rate"` // basis points, 825 = 8.25%
+	Taxable   int64  `json:"taxable"`
+	Amount    int64  `json:"amount"`
+	Inclusive bool   `json:"inclusive,omitempty"`
+}
+
+// Totals is a priced cart, as the pricing engine returns it and as an order
+// keeps it.
+type Totals struct {
+	Currency         string       `json:"currency"`
+	Lines            []LineTotal  `json:"lines"`
+	ItemCount        int          `json:"item_count"`
+	Subtotal         int64        `json:"subtotal"`
+	Discounts        []Adjustment `json:"discounts"`
+	DiscountTotal    int64        `json:"discount_total"`
+	Shipping         int64        `json:"shipping"`
+	ShippingDiscount int64        `json:"shipping_discount"`
+	TaxLines         []TaxLine    `json:"tax_lines"`
+	Tax              int64        `json:"tax"`
+	TaxInclusive     int64        `json:"tax_inclusive"` // tax already contained in prices
+	Total            int64        `json:"total"`
+}
+
+// Merchandise returns the discounted merchandise amount, the base that tax
+// and free-shipping thresholds are usually computed from.
+func (t *Totals) Merchandise() int64 {
+	return t.Subtotal - t.DiscountTotal
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// What a user token is for.
+const (
+	TokenPasswordReset     = "password_reset"
+	TokenEmailVerification = "email_verification"
+)
+
+// UserToken is a single-use secret sent to a user by email. Only its hash
+// is kept, so the tokens cannot be read back out of the database.
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	Hash      string     `json:"-" gorm:"uniqueIndex"`
+	Email     string     `json:"email"` // the address it was sent to
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
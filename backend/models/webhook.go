@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook delivery statuses. A delivery is sending while an attempt is in
+// flight and pending while it waits for its next attempt.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySending   = "sending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEndpoint is a URL that receives the events it subscribes to.
+type WebhookEndpoint struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	URL         string    `json:"url" gorm:"not null"`
+	Description string    `json:"description"`
+	Secret      string    `json:"secret"` // signs every delivery
+	Events      []string  `json:"events" gorm:"serializer:json"`
+	Active      bool      `json:"active" gorm:"default:true"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookEvent is the body posted to endpoints.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookAttempt is one try at posting a delivery.
+type WebhookAttempt struct {
+	At           time.Time `json:"at"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMS   int64     `json:"duration_ms"`
+}
+
+// WebhookDelivery is one event queued for one endpoint, with the log of
+// its attempts. Redeliveries are new deliveries of the same payload.
+type WebhookDelivery struct {
+	ID            uint             `json:"id" gorm:"primaryKey"`
+	EndpointID    uint             `json:"endpoint_id" gorm:"not null"`
+	EventID       string           `json:"event_id"`
+	EventType     string           `json:"event_type"`
+	Payload       json.RawMessage  `json:"payload" gorm:"type:text"`
+	Status        string           `json:"status"`
+	Attempts      []WebhookAttempt `json:"attempts" gorm:"serializer:json"`
+	NextAttemptAt *time.Time       `json:"next_attempt_at,omitempty"`
+	RedeliveryOf  uint             `json:"redelivery_of,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
+}
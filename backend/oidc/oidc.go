@@ -0,0 +1,288 @@
+// Package oidc signs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Leeway allows for clock differences with the provider when checking an
+// ID token's times.
+const Leeway = time.Minute
+
+// SigningMethods are the ID token signature algorithms accepted.
+var SigningMethods = []string{"RS256", "ES256"}
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrExchange       = errors.New("authorization code exchange failed")
+)
+
+// Config identifies this application to the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // asked for besides "openid"; defaults to email and profile
+}
+
+// Metadata is the part of a provider's discovery document used here.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Token is a token endpoint response.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+// Claims are the ID token claims used to find or create the user.
+type Claims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// Client talks to one provider. Its discovery document is fetched once and
+// its signing keys whenever an ID token is signed with a key not seen yet.
+type Client struct {
+	Config Config
+	HTTP   *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *KeySet
+}
+
+// NewClient returns a client for the provider in config. Nothing is
+// fetched until it is first used.
+func NewClient(config Config, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.Scopes == nil {
+		config.Scopes = []string{"email", "profile"}
+	}
+	return &Client{Config: config, HTTP: httpClient}
+}
+
+// NewVerifier returns a random PKCE code verifier. It also serves for the
+// state and nonce, which need to be just as unguessable.
+func NewVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge for a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getJSON fetches a JSON document into v.
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Discover returns the provider's metadata, fetching it the first time.
+func (c *Client) Discover(ctx context.Context) (*Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		return c.metadata, nil
+	}
+
+	var metadata Metadata
+	wellKnown := strings.TrimSuffix(c.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, wellKnown, &metadata); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if metadata.Issuer != c.Config.Issuer {
+		return nil, fmt.Errorf("discovery: issuer is %q, expected %q", metadata.Issuer, c.Config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery: endpoints missing")
+	}
+	c.metadata = &metadata
+	return c.metadata, nil
+}
+
+// AuthCodeURL returns the provider URL to send the user to. The provider
+// sends them back to the redirect URL with a code and the state.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.Config.ClientID},
+		"redirect_uri":          {c.Config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, c.Config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code and its PKCE verifier for tokens.
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	metadata, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.Config.RedirectURL},
+		"client_id":     {c.Config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.Config.ClientID), url.QueryEscape(c.Config.ClientSecret))
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &failure)
+		return nil, fmt.Errorf("%w: %s %s %s", ErrExchange, resp.Status, failure.Error, failure.Description)
+	}
+
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in response", ErrExchange)
+	}
+	return &token, nil
+}
+
+// signingKey returns the provider key with the given ID, fetching the key
+// set again if it is not known, as happens after the provider rotates keys.
+func (c *Client) signingKey(ctx context.Context, kid string) (interface{}, error) {
+	metadata, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys != nil {
+		if key, ok := c.keys.Key(kid); ok {
+			return key, nil
+		}
+	}
+	var keys KeySet
+	if err := c.getJSON(ctx, metadata.JWKSURI, &keys); err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+	c.keys = &keys
+	if key, ok := c.keys.Key(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no provider key %q", kid)
+}
+
+// Verify checks an ID token's signature against the provider's keys, and
+// that it was issued by the provider to this client for the login with
+// this nonce and is current at now.
+func (c *Client) Verify(ctx context.Context, rawIDToken, nonce string, now time.Time) (*Claims, error) {
+	metadata, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	parser := jwt.NewParser(jwt.WithValidMethods(SigningMethods), jwt.WithoutClaimsValidation())
+	_, err = parser.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.signingKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != metadata.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.VerifyAudience(c.Config.ClientID, true):
+		return nil, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != c.Config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party is %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.Add(Leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.IssuedAt != nil && claims.IssuedAt.After(now.Add(Leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,159 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"ecommerce-backend/oidc"
+	"ecommerce-backend/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PKCE", func() {
+	It("derives the S256 challenge", func() {
+		// RFC 7636 appendix B
+		Expect(oidc.Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")).To(Equal("E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"))
+	})
+
+	It("makes unguessable verifiers", func() {
+		verifier, err := oidc.NewVerifier()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verifier).To(HaveLen(43))
+		other, _ := oidc.NewVerifier()
+		Expect(other).ToNot(Equal(verifier))
+	})
+})
+
+var _ = Describe("Client", func() {
+	var (
+		provider *oidctest.Provider
+		client   *oidc.Client
+		ctx      context.Context
+		now      time.Time
+	)
+
+	BeforeEach(func() {
+		provider = oidctest.NewProvider("shop", "s3cret")
+		provider.SignIn(oidctest.Identity{Subject: "user-1", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+		client = oidc.NewClient(oidc.Config{
+			Issuer:       provider.Issuer,
+			ClientID:     "shop",
+			ClientSecret: "s3cret",
+			RedirectURL:  "http://localhost:3000/login/callback",
+		}, nil)
+		ctx = context.Background()
+		now = time.Now()
+	})
+
+	AfterEach(func() {
+		provider.Close()
+	})
+
+	// login runs the browser part of the flow and returns the code
+	login := func(nonce, verifier string) string {
+		authURL, err := client.AuthCodeURL(ctx, "state-1", nonce, verifier)
+		Expect(err).ToNot(HaveOccurred())
+		code, state, err := provider.Authorize(authURL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal("state-1"))
+		return code
+	}
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":   provider.Issuer,
+			"sub":   "user-1",
+			"aud":   "shop",
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+			"nonce": "n-1",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	It("signs a user in with a code and verifier", func() {
+		code := login("n-1", "verifier-verifier-verifier-verifier-verifier")
+
+		token, err := client.Exchange(ctx, code, "verifier-verifier-verifier-verifier-verifier")
+		Expect(err).ToNot(HaveOccurred())
+
+		verified, err := client.Verify(ctx, token.IDToken, "n-1", now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified.Subject).To(Equal("user-1"))
+		Expect(verified.Email).To(Equal("ada@example.com"))
+		Expect(verified.EmailVerified).To(BeTrue())
+		Expect(verified.Name).To(Equal("Ada"))
+	})
+
+	It("asks for a code with PKCE and the openid scope", func() {
+		authURL, err := client.AuthCodeURL(ctx, "s", "n", "v")
+		Expect(err).ToNot(HaveOccurred())
+		parsed, _ := url.Parse(authURL)
+		Expect(strings.HasPrefix(authURL, provider.Issuer+"/authorize?")).To(BeTrue())
+		Expect(parsed.Query().Get("code_challenge")).To(Equal(oidc.Challenge("v")))
+		Expect(parsed.Query().Get("code_challenge_method")).To(Equal("S256"))
+		Expect(parsed.Query().Get("scope")).To(Equal("openid email profile"))
+	})
+
+	It("cannot redeem a code without its verifier, or twice", func() {
+		code := login("n-1", "right")
+		_, err := client.Exchange(ctx, code, "wrong")
+		Expect(errors.Is(err, oidc.ErrExchange)).To(BeTrue())
+
+		code = login("n-1", "right")
+		_, err = client.Exchange(ctx, code, "right")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = client.Exchange(ctx, code, "right")
+		Expect(err).To(MatchError(ContainSubstring("invalid_grant")))
+	})
+
+	It("refuses a provider that names another issuer", func() {
+		client.Config.Issuer = provider.Issuer + "/"
+		_, err := client.Discover(ctx)
+		Expect(err).To(MatchError(ContainSubstring("issuer")))
+	})
+
+	DescribeTable("rejects ID tokens",
+		func(overrides jwt.MapClaims, nonce string) {
+			_, err := client.Verify(ctx, provider.IDToken(claims(overrides)), nonce, now)
+			Expect(errors.Is(err, oidc.ErrInvalidIDToken)).To(BeTrue(), "%v", err)
+		},
+		Entry("from another issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, "n-1"),
+		Entry("for another client", jwt.MapClaims{"aud": "other"}, "n-1"),
+		Entry("for several clients without us as authorized party", jwt.MapClaims{"aud": []string{"shop", "other"}, "azp": "other"}, "n-1"),
+		Entry("that have expired", jwt.MapClaims{"exp": time.Now().Add(-2 * time.Minute).Unix()}, "n-1"),
+		Entry("issued in the future", jwt.MapClaims{"iat": time.Now().Add(time.Hour).Unix()}, "n-1"),
+		Entry("with another login's nonce", jwt.MapClaims{}, "n-2"),
+		Entry("without a subject", jwt.MapClaims{"sub": ""}, "n-1"),
+	)
+
+	It("rejects tokens that were tampered with or not signed", func() {
+		raw := provider.IDToken(claims(nil))
+		parts := strings.Split(raw, ".")
+		forged := jwt.NewWithClaims(jwt.SigningMethodNone, claims(jwt.MapClaims{"sub": "admin"}))
+		unsigned, _ := forged.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		for _, token := range []string{parts[0] + "." + strings.Split(unsigned, ".")[1] + "." + parts[2], unsigned} {
+			_, err := client.Verify(ctx, token, "n-1", now)
+			Expect(errors.Is(err, oidc.ErrInvalidIDToken)).To(BeTrue())
+		}
+	})
+
+	It("fetches the keys again after the provider rotates them", func() {
+		_, err := client.Verify(ctx, provider.IDToken(claims(nil)), "n-1", now)
+		Expect(err).ToNot(HaveOccurred())
+
+		provider.RotateKey()
+		_, err = client.Verify(ctx, provider.IDToken(claims(nil)), "n-1", now)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
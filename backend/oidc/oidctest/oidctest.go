@@ -0,0 +1,259 @@
+// Package oidctest runs a mock OpenID Connect provider for tests. It
+// approves every authorization request as the identity last signed in, and
+// checks the client credentials, redirect URI and PKCE verifier when the
+// code is exchanged, as a real provider would.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ecommerce-backend/oidc"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Identity is an account at the provider.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// grant is an authorization code waiting to be exchanged.
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    Identity
+}
+
+// Provider is a mock provider served over HTTP.
+type Provider struct {
+	Server       *httptest.Server
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// Now dates ID tokens; it defaults to the wall clock.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	kid      int
+	identity Identity
+	grants   map[string]grant
+}
+
+// NewProvider starts a provider that knows one client. Close it when done.
+func NewProvider(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Now:          time.Now,
+		grants:       make(map[string]grant),
+	}
+	p.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	p.Issuer = p.Server.URL
+	return p
+}
+
+// Close stops the provider.
+func (p *Provider) Close() { p.Server.Close() }
+
+// SignIn makes identity the one the next authorizations are approved for.
+func (p *Provider) SignIn(identity Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.identity = identity
+}
+
+// RotateKey replaces the signing key with a new one under a new key ID.
+func (p *Provider) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.kid++
+}
+
+// Authorize follows an authorization URL as the user's browser would and
+// returns the code and state the provider redirects back with.
+func (p *Provider) Authorize(authURL string) (code, state string, err error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorize: %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	if e := location.Query().Get("error"); e != "" {
+		return "", "", errors.New(e)
+	}
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+// IDToken signs claims with the provider's current key, for tests that
+// need a token the provider would not issue.
+func (p *Provider) IDToken(claims jwt.MapClaims) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = fmt.Sprintf("key-%d", p.kid)
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(oidc.Metadata{
+		Issuer:                p.Issuer,
+		AuthorizationEndpoint: p.Issuer + "/authorize",
+		TokenEndpoint:         p.Issuer + "/token",
+		JWKSURI:               p.Issuer + "/jwks",
+		CodeChallengeMethods:  []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	json.NewEncoder(w).Encode(oidc.KeySet{Keys: []oidc.JSONWebKey{
+		oidc.RSAKey(fmt.Sprintf("key-%d", p.kid), &p.key.PublicKey),
+	}})
+}
+
+// authorize approves the request as the signed-in identity and redirects
+// back with a code, or with an error for a request it cannot accept.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") != p.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	back, _ := url.Parse(redirectURI)
+	values := url.Values{"state": {query.Get("state")}}
+
+	p.mu.Lock()
+	switch {
+	case query.Get("response_type") != "code":
+		values.Set("error", "unsupported_response_type")
+	case !strings.Contains(" "+query.Get("scope")+" ", " openid "):
+		values.Set("error", "invalid_scope")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		values.Set("error", "invalid_request")
+	case p.identity.Subject == "":
+		values.Set("error", "login_required")
+	default:
+		code := randomString()
+		p.grants[code] = grant{
+			redirectURI: redirectURI,
+			challenge:   query.Get("code_challenge"),
+			nonce:       query.Get("nonce"),
+			identity:    p.identity,
+		}
+		values.Set("code", code)
+	}
+	p.mu.Unlock()
+
+	back.RawQuery = values.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+// token exchanges a code, once, for an ID token.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if r.Method != http.MethodPost || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		fail(http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	code := r.PostFormValue("code")
+	g, exists := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+	if !exists || g.redirectURI != r.PostFormValue("redirect_uri") ||
+		oidc.Challenge(r.PostFormValue("code_verifier")) != g.challenge {
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := p.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.Issuer,
+		"sub":   g.identity.Subject,
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": g.nonce,
+	}
+	if g.identity.Email != "" {
+		claims["email"] = g.identity.Email
+		claims["email_verified"] = g.identity.EmailVerified
+	}
+	if g.identity.Name != "" {
+		claims["name"] = g.identity.Name
+	}
+	if g.identity.PreferredUsername != "" {
+		claims["preferred_username"] = g.identity.PreferredUsername
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oidc.Token{
+		AccessToken: randomString(),
+		TokenType:   "Bearer",
+		ExpiresIn:   3600,
+		IDToken:     p.IDToken(claims),
+	})
+}
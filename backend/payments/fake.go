@@ -0,0 +1,227 @@
+package payments
+
+import (
+	"context"
+	"ecommerce-backend/clock"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payment method tokens understood by the fake provider. Any other token
+// is approved.
+const (
+	FakeCardApproved          = "pm_card_visa"
+	FakeCardDeclined          = "pm_card_declined"
+	FakeCardInsufficientFunds = "pm_card_insufficient_funds"
+	FakeCard3DS               = "pm_card_3ds"
+)
+
+const fakeWebhookSecret = "fake-webhook-secret"
+
+// FakeConfig configures a fake provider. Webhooks are signed with
+// WebhookSecret and handed to Sink WebhookDelay after the change they
+// report, on Clock. Without a Sink they are dropped.
+type FakeConfig struct {
+	Clock         clock.Clock
+	WebhookSecret string
+	WebhookDelay  time.Duration
+	Sink          func(payload []byte, signature string)
+}
+
+// Fake is an in-memory Provider for local development and tests. It
+// declines and challenges cards based on their token and delivers webhooks
+// asynchronously.
+type Fake struct {
+	config FakeConfig
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	nextID  int
+}
+
+// NewFake returns a fake provider.
+func NewFake(config FakeConfig) *Fake {
+	if config.Clock == nil {
+		config.Clock = clock.Real{}
+	}
+	if config.WebhookSecret == "" {
+		config.WebhookSecret = fakeWebhookSecret
+	}
+	return &Fake{config: config, intents: map[string]*Intent{}}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (Intent, error) {
+	if req.Amount <= 0 {
+		return Intent{}, ErrInvalidAmount
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	intent := &Intent{
+		ID:       fmt.Sprintf("pi_fake_%d", f.nextID),
+		Amount:   req.Amount,
+		Currency: req.Currency,
+	}
+	f.intents[intent.ID] = intent
+
+	switch req.PaymentMethod {
+	case FakeCardDeclined:
+		intent.Status, intent.DeclineCode = StatusFailed, "card_declined"
+	case FakeCardInsufficientFunds:
+		intent.Status, intent.DeclineCode = StatusFailed, "insufficient_funds"
+	case FakeCard3DS:
+		intent.Status = StatusRequiresAction
+		intent.NextAction = "/payments/fake/" + intent.ID + "/complete"
+		return *intent, nil
+	default:
+		intent.Status = StatusAuthorized
+	}
+
+	if intent.Status == StatusFailed {
+		f.emit(EventFailed, intent)
+		return *intent, fmt.Errorf("%w: %s", ErrDeclined, intent.DeclineCode)
+	}
+	f.emit(EventAuthorized, intent)
+	return *intent, nil
+}
+
+// Complete finishes the customer action on a pending intent, as if the
+// customer passed or failed a 3DS challenge. The result arrives by webhook.
+func (f *Fake) Complete(intentID string, approve bool) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, err := f.find(intentID, StatusRequiresAction)
+	if err != nil {
+		return Intent{}, err
+	}
+	intent.NextAction = ""
+	if approve {
+		intent.Status = StatusAuthorized
+		f.emit(EventAuthorized, intent)
+	} else {
+		intent.Status, intent.DeclineCode = StatusFailed, "authentication_failed"
+		f.emit(EventFailed, intent)
+	}
+	return *intent, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, intentID string, amount int64) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, err := f.find(intentID, StatusAuthorized)
+	if err != nil {
+		return Intent{}, err
+	}
+	if amount <= 0 || amount > intent.Amount {
+		return Intent{}, ErrInvalidAmount
+	}
+	intent.Status, intent.Captured = StatusCaptured, amount
+	f.emit(EventCaptured, intent)
+	return *intent, nil
+}
+
+func (f *Fake) Void(ctx context.Context, intentID string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, err := f.find(intentID, StatusAuthorized, StatusRequiresAction)
+	if err != nil {
+		return Intent{}, err
+	}
+	intent.Status, intent.NextAction = StatusVoided, ""
+	f.emit(EventVoided, intent)
+	return *intent, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, intentID string, amount int64) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, err := f.find(intentID, StatusCaptured, StatusPartiallyRefunded)
+	if err != nil {
+		return Intent{}, err
+	}
+	if amount <= 0 || intent.Refunded+amount > intent.Captured {
+		return Intent{}, ErrInvalidAmount
+	}
+	intent.Refunded += amount
+	intent.Status = StatusPartiallyRefunded
+	if intent.Refunded == intent.Captured {
+		intent.Status = StatusRefunded
+	}
+	f.emit(EventRefunded, intent)
+	return *intent, nil
+}
+
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	return VerifySignature(f.config.WebhookSecret, payload, signature)
+}
+
+// Intent returns the provider's current view of an intent.
+func (f *Fake) Intent(intentID string) (Intent, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, exists := f.intents[intentID]
+	if !exists {
+		return Intent{}, false
+	}
+	return *intent, true
+}
+
+// find returns an intent that is in one of the given states.
+// Callers must hold f.mu.
+func (f *Fake) find(intentID string, states ...Status) (*Intent, error) {
+	intent, exists := f.intents[intentID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	for _, state := range states {
+		if intent.Status == state {
+			return intent, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: intent is %s", ErrInvalidState, intent.Status)
+}
+
+// emit schedules a webhook for the intent's current state. Delivery always
+// happens on another goroutine so a Sink may call back into the caller.
+// Callers must hold f.mu.
+func (f *Fake) emit(eventType EventType, intent *Intent) {
+	if f.config.Sink == nil {
+		return
+	}
+	f.nextID++
+	event := Event{
+		ID:        fmt.Sprintf("evt_fake_%d", f.nextID),
+		Type:      eventType,
+		Intent:    *intent,
+		CreatedAt: f.config.Clock.Now(),
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	signature := Sign(f.config.WebhookSecret, payload)
+
+	delay := f.config.WebhookDelay
+	go func() {
+		<-f.config.Clock.After(delay)
+		f.config.Sink(payload, signature)
+	}()
+}
+
+// IsFakeAction reports whether a next action URL belongs to the fake
+// provider.
+func IsFakeAction(nextAction string) bool {
+	return strings.HasPrefix(nextAction, "/payments/fake/")
+}
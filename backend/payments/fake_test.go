@@ -0,0 +1,183 @@
+package payments_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ecommerce-backend/clock"
+	"ecommerce-backend/payments"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fake", func() {
+	var (
+		ctx      context.Context
+		fakeTime *clock.Fake
+		provider *payments.Fake
+
+		mu        sync.Mutex
+		delivered []payments.Event
+	)
+
+	authorize := func(method string) (payments.Intent, error) {
+		return provider.Authorize(ctx, payments.AuthorizeRequest{Amount: 2500, Currency: "USD", PaymentMethod: method, Reference: "order-1"})
+	}
+
+	events := func() []payments.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]payments.Event(nil), delivered...)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fakeTime = clock.NewFake(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+		delivered = nil
+		provider = payments.NewFake(payments.FakeConfig{
+			Clock:         fakeTime,
+			WebhookSecret: "whsec_test",
+			WebhookDelay:  time.Minute,
+			Sink: func(payload []byte, signature string) {
+				defer GinkgoRecover()
+				event, err := provider.VerifyWebhook(payload, signature)
+				Expect(err).ToNot(HaveOccurred())
+				mu.Lock()
+				delivered = append(delivered, event)
+				mu.Unlock()
+			},
+		})
+	})
+
+	Describe("Authorize", func() {
+		It("approves the default card", func() {
+			intent, err := authorize(payments.FakeCardApproved)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(intent.Status).To(Equal(payments.StatusAuthorized))
+			Expect(intent.Amount).To(Equal(int64(2500)))
+		})
+
+		It("declines with a decline code", func() {
+			intent, err := authorize(payments.FakeCardInsufficientFunds)
+			Expect(err).To(MatchError(payments.ErrDeclined))
+			Expect(intent.Status).To(Equal(payments.StatusFailed))
+			Expect(intent.DeclineCode).To(Equal("insufficient_funds"))
+		})
+
+		It("rejects non-positive amounts", func() {
+			_, err := provider.Authorize(ctx, payments.AuthorizeRequest{Amount: 0})
+			Expect(err).To(MatchError(payments.ErrInvalidAmount))
+		})
+
+		It("holds 3DS cards until the customer acts", func() {
+			intent, err := authorize(payments.FakeCard3DS)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(intent.Status).To(Equal(payments.StatusRequiresAction))
+			Expect(payments.IsFakeAction(intent.NextAction)).To(BeTrue())
+
+			_, err = provider.Capture(ctx, intent.ID, 2500)
+			Expect(err).To(MatchError(payments.ErrInvalidState))
+
+			intent, err = provider.Complete(intent.ID, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(intent.Status).To(Equal(payments.StatusFailed))
+			Expect(intent.DeclineCode).To(Equal("authentication_failed"))
+		})
+	})
+
+	Describe("Capture, Void and Refund", func() {
+		var intent payments.Intent
+
+		BeforeEach(func() {
+			var err error
+			intent, err = authorize(payments.FakeCardApproved)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("captures up to the authorized amount", func() {
+			_, err := provider.Capture(ctx, intent.ID, 2501)
+			Expect(err).To(MatchError(payments.ErrInvalidAmount))
+
+			captured, err := provider.Capture(ctx, intent.ID, 2500)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(captured.Status).To(Equal(payments.StatusCaptured))
+			Expect(captured.Captured).To(Equal(int64(2500)))
+		})
+
+		It("voids an authorization but not a capture", func() {
+			voided, err := provider.Void(ctx, intent.ID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(voided.Status).To(Equal(payments.StatusVoided))
+
+			second, _ := authorize(payments.FakeCardApproved)
+			provider.Capture(ctx, second.ID, 2500)
+			_, err = provider.Void(ctx, second.ID)
+			Expect(err).To(MatchError(payments.ErrInvalidState))
+		})
+
+		It("refunds in parts up to the captured amount", func() {
+			provider.Capture(ctx, intent.ID, 2500)
+
+			refunded, err := provider.Refund(ctx, intent.ID, 1000)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refunded.Status).To(Equal(payments.StatusPartiallyRefunded))
+
+			_, err = provider.Refund(ctx, intent.ID, 1501)
+			Expect(err).To(MatchError(payments.ErrInvalidAmount))
+
+			refunded, err = provider.Refund(ctx, intent.ID, 1500)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(refunded.Status).To(Equal(payments.StatusRefunded))
+			Expect(refunded.Refunded).To(Equal(int64(2500)))
+		})
+
+		It("reports unknown intents", func() {
+			_, err := provider.Capture(ctx, "pi_missing", 100)
+			Expect(err).To(MatchError(payments.ErrNotFound))
+		})
+	})
+
+	Describe("webhooks", func() {
+		It("delivers signed events once the delay has passed", func() {
+			intent, _ := authorize(payments.FakeCard3DS)
+			provider.Complete(intent.ID, true)
+
+			Eventually(fakeTime.Waiters).Should(Equal(1))
+			Consistently(events).Should(BeEmpty())
+
+			fakeTime.Advance(time.Minute)
+			Eventually(events).Should(HaveLen(1))
+			event := events()[0]
+			Expect(event.Type).To(Equal(payments.EventAuthorized))
+			Expect(event.Intent.ID).To(Equal(intent.ID))
+			Expect(event.Intent.Status).To(Equal(payments.StatusAuthorized))
+		})
+
+		It("rejects payloads with a bad signature", func() {
+			payload := []byte(`{"id":"evt_1","type":"payment.captured"}`)
+			_, err := provider.VerifyWebhook(payload, payments.Sign("wrong", payload))
+			Expect(err).To(MatchError(payments.ErrInvalidSignature))
+
+			event, err := provider.VerifyWebhook(payload, payments.Sign("whsec_test", payload))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(event.Type).To(Equal(payments.EventCaptured))
+		})
+	})
+
+	Describe("Intent.Supersedes", func() {
+		It("orders intent states so stale webhooks can be ignored", func() {
+			authorized := payments.Intent{Status: payments.StatusAuthorized}
+			captured := payments.Intent{Status: payments.StatusCaptured}
+			partial := payments.Intent{Status: payments.StatusPartiallyRefunded, Refunded: 100}
+			more := payments.Intent{Status: payments.StatusPartiallyRefunded, Refunded: 200}
+
+			Expect(captured.Supersedes(authorized)).To(BeTrue())
+			Expect(authorized.Supersedes(captured)).To(BeFalse())
+			Expect(more.Supersedes(partial)).To(BeTrue())
+			Expect(partial.Supersedes(more)).To(BeFalse())
+			Expect(captured.Supersedes(captured)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,129 @@
+// Package payments defines the interface checkout uses to take payment and
+// a local fake provider that needs no network access.
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Status is the state of a payment intent at the provider.
+type Status string
+
+const (
+	StatusRequiresAction    Status = "requires_action" // waiting on the customer, e.g. a 3DS challenge
+	StatusAuthorized        Status = "authorized"
+	StatusCaptured          Status = "captured"
+	StatusPartiallyRefunded Status = "partially_refunded"
+	StatusRefunded          Status = "refunded"
+	StatusVoided            Status = "voided"
+	StatusFailed            Status = "failed"
+)
+
+// EventType names a webhook event.
+type EventType string
+
+const (
+	EventAuthorized EventType = "payment.authorized"
+	EventCaptured   EventType = "payment.captured"
+	EventRefunded   EventType = "payment.refunded"
+	EventVoided     EventType = "payment.voided"
+	EventFailed     EventType = "payment.failed"
+)
+
+var (
+	ErrDeclined         = errors.New("payment declined")
+	ErrNotFound         = errors.New("payment intent not found")
+	ErrInvalidState     = errors.New("payment intent is not in a state that allows this")
+	ErrInvalidAmount    = errors.New("invalid payment amount")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// AuthorizeRequest asks the provider to hold funds. PaymentMethod is the
+// provider's token for the customer's card; Reference identifies the order.
+type AuthorizeRequest struct {
+	Amount        int64
+	Currency      string
+	PaymentMethod string
+	Reference     string
+}
+
+// Intent is the provider's view of one payment. Amounts are in cents.
+type Intent struct {
+	ID          string `json:"id"`
+	Status      Status `json:"status"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Captured    int64  `json:"captured"`
+	Refunded    int64  `json:"refunded"`
+	NextAction  string `json:"next_action,omitempty"` // where the customer completes a pending intent
+	DeclineCode string `json:"decline_code,omitempty"`
+}
+
+// Event is a verified webhook notification.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      EventType `json:"type"`
+	Intent    Intent    `json:"intent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Provider is a payment gateway. Authorize returns an intent that is either
+// authorized, waiting on the customer (StatusRequiresAction) or, together
+// with an error wrapping ErrDeclined, failed. Pending intents are settled
+// later by a webhook.
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (Intent, error)
+	Capture(ctx context.Context, intentID string, amount int64) (Intent, error)
+	Void(ctx context.Context, intentID string) (Intent, error)
+	Refund(ctx context.Context, intentID string, amount int64) (Intent, error)
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// Sign returns the hex HMAC-SHA256 of a webhook payload.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a webhook signature made by Sign and decodes the
+// event.
+func VerifySignature(secret string, payload []byte, signature string) (Event, error) {
+	expected := Sign(secret, payload)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return Event{}, ErrInvalidSignature
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+// progress orders statuses so late or repeated webhooks can be recognised.
+var progress = map[Status]int{
+	StatusRequiresAction:    0,
+	StatusAuthorized:        1,
+	StatusCaptured:          2,
+	StatusVoided:            2,
+	StatusFailed:            2,
+	StatusPartiallyRefunded: 3,
+	StatusRefunded:          4,
+}
+
+// Supersedes reports whether the intent is at least as far along as other.
+// Webhooks may arrive late or more than once; a snapshot that does not
+// supersede the stored one is stale.
+func (i Intent) Supersedes(other Intent) bool {
+	if progress[i.Status] != progress[other.Status] {
+		return progress[i.Status] > progress[other.Status]
+	}
+	return i.Refunded >= other.Refunded
+}
@@ -0,0 +1,213 @@
+// Package pricing turns a list of cart lines into priced totals.
+//
+// All amounts are integer minor units (cents) so that the storefront and
+// checkout always agree to the cent. The pipeline runs in a fixed order:
+// line totals, merchandise discounts, shipping, shipping discounts, tax and
+// finally the grand total.
+package pricing
+
+import (
+	"ecommerce-backend/models"
+	"errors"
+	"fmt"
+)
+
+// DefaultCurrency is used when an Input does not specify one.
+const DefaultCurrency = "USD"
+
+// Input is everything the engine needs to price a cart.
+type Input struct {
+	UserID    uint
+	Currency  string
+	Codes     []string // coupon codes entered on the cart
+	Country   string   // destination, used for tax and shipping
+	Region    string
+	TaxExempt bool
+	// ShippingMethod is the shipping method code chosen on the cart.
+	ShippingMethod string
+	Lines          []models.Line
+}
+
+// Discount is a discount proposed by a Discounter. Amount is spread across
+// the eligible lines in proportion to their value; a nil Lines slice means
+// every line is eligible. Allocation, when set, gives the exact amount for
+// each entry in Lines instead. Shipping discounts reduce the shipping charge
+// instead of the merchandise. RuleID identifies the rule that produced it.
+type Discount struct {
+	RuleID     uint
+	Code       string
+	Label      string
+	Amount     int64
+	Lines      []int
+	Allocation []int64
+	Shipping   bool
+}
+
+// Discounter proposes discounts for a cart. It sees the totals as they
+// stand after line totals and any earlier discounters have run.
+type Discounter interface {
+	Discounts(in *Input, t *models.Totals) ([]Discount, error)
+}
+
+// ShippingCalculator returns the shipping charge for a cart.
+type ShippingCalculator interface {
+	Shipping(in *Input, t *models.Totals) (int64, error)
+}
+
+// TaxCalculator returns the tax breakdown for a cart. Exclusive tax lines
+// are added to the total; inclusive ones are reported but not added.
+type TaxCalculator interface {
+	Tax(in *Input, t *models.Totals) ([]models.TaxLine, error)
+}
+
+var (
+	ErrInvalidQuantity = errors.New("quantity must be positive")
+	ErrInvalidPrice    = errors.New("unit price must not be negative")
+)
+
+// Engine runs the pricing pipeline. A zero Engine prices lines only.
+type Engine struct {
+	Discounters []Discounter
+	Shipping    ShippingCalculator
+	Tax         TaxCalculator
+}
+
+// NewEngine returns an Engine with the given stages. Any of them may be nil.
+func NewEngine(shipping ShippingCalculator, tax TaxCalculator, discounters ...Discounter) *Engine {
+	return &Engine{
+		Discounters: discounters,
+		Shipping:    shipping,
+		Tax:         tax,
+	}
+}
+
+// Price computes the totals for in.
+func (e *Engine) Price(in Input) (models.Totals, error) {
+	if in.Currency == "" {
+		in.Currency = DefaultCurrency
+	}
+
+	t := models.Totals{
+		Currency:  in.Currency,
+		Lines:     make([]models.LineTotal, 0, len(in.Lines)),
+		Discounts: []models.Adjustment{},
+		TaxLines:  []models.TaxLine{},
+	}
+
+	for _, line := range in.Lines {
+		if line.Quantity <= 0 {
+			return models.Totals{}, fmt.Errorf("item %d: %w", line.ItemID, ErrInvalidQuantity)
+		}
+		if line.UnitPrice < 0 {
+			return models.Totals{}, fmt.Errorf("item %d: %w", line.ItemID, ErrInvalidPrice)
+		}
+		subtotal := line.UnitPrice * int64(line.Quantity)
+		t.Lines = append(t.Lines, models.LineTotal{Line: line, Subtotal: subtotal, Total: subtotal})
+		t.Subtotal += subtotal
+		t.ItemCount += line.Quantity
+	}
+
+	var shippingDiscounts []Discount
+	for _, d := range e.Discounters {
+		proposed, err := d.Discounts(&in, &t)
+		if err != nil {
+			return models.Totals{}, err
+		}
+		for _, discount := range proposed {
+			if discount.Shipping {
+				shippingDiscounts = append(shippingDiscounts, discount)
+				continue
+			}
+			applyDiscount(&t, discount)
+		}
+	}
+
+	if e.Shipping != nil {
+		shipping, err := e.Shipping.Shipping(&in, &t)
+		if err != nil {
+			return models.Totals{}, err
+		}
+		t.Shipping = shipping
+	}
+	for _, discount := range shippingDiscounts {
+		amount := min(discount.Amount, t.Shipping-t.ShippingDiscount)
+		if amount <= 0 {
+			continue
+		}
+		t.ShippingDiscount += amount
+		t.Discounts = append(t.Discounts, models.Adjustment{
+			RuleID:   discount.RuleID,
+			Code:     discount.Code,
+			Label:    discount.Label,
+			Amount:   amount,
+			Shipping: true,
+		})
+	}
+
+	if e.Tax != nil {
+		lines, err := e.Tax.Tax(&in, &t)
+		if err != nil {
+			return models.Totals{}, err
+		}
+		for _, tl := range lines {
+			if tl.Inclusive {
+				t.TaxInclusive += tl.Amount
+			} else {
+				t.Tax += tl.Amount
+			}
+		}
+		t.TaxLines = append(t.TaxLines, lines...)
+	}
+
+	t.Total = t.Subtotal - t.DiscountTotal + t.Shipping - t.ShippingDiscount + t.Tax
+	return t, nil
+}
+
+// applyDiscount caps a merchandise discount at what is left on the eligible
+// lines and spreads it across them.
+func applyDiscount(t *models.Totals, d Discount) {
+	eligible := d.Lines
+	if eligible == nil {
+		eligible = make([]int, len(t.Lines))
+		for i := range t.Lines {
+			eligible[i] = i
+		}
+	}
+
+	weights := make([]int64, len(eligible))
+	var remaining int64
+	for i, idx := range eligible {
+		if idx < 0 || idx >= len(t.Lines) {
+			continue
+		}
+		weights[i] = t.Lines[idx].Total
+		remaining += weights[i]
+	}
+
+	var shares []int64
+	if d.Allocation != nil && len(d.Allocation) == len(eligible) {
+		shares = make([]int64, len(eligible))
+		for i, share := range d.Allocation {
+			shares[i] = max(0, min(share, weights[i]))
+		}
+	} else {
+		shares = Allocate(min(d.Amount, remaining), weights)
+	}
+
+	var amount int64
+	for i, share := range shares {
+		if share == 0 {
+			continue
+		}
+		line := &t.Lines[eligible[i]]
+		line.Discount += share
+		line.Total -= share
+		amount += share
+	}
+	if amount <= 0 {
+		return
+	}
+
+	t.DiscountTotal += amount
+	t.Discounts = append(t.Discounts, models.Adjustment{RuleID: d.RuleID, Code: d.Code, Label: d.Label, Amount: amount})
+}
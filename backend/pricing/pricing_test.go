@@ -0,0 +1,224 @@
+package pricing_test
+
+import (
+	"errors"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type failingStage struct{}
+
+func (failingStage) Discounts(in *pricing.Input, t *models.Totals) ([]pricing.Discount, error) {
+	return nil, errors.New("boom")
+}
+
+type fixedDiscounts []pricing.Discount
+
+func (f fixedDiscounts) Discounts(in *pricing.Input, t *models.Totals) ([]pricing.Discount, error) {
+	return f, nil
+}
+
+var _ = Describe("Engine", func() {
+	var (
+		engine *pricing.Engine
+		input  pricing.Input
+	)
+
+	BeforeEach(func() {
+		engine = &pricing.Engine{}
+		input = pricing.Input{
+			Lines: []models.Line{
+				{ItemID: 1, Name: "Laptop", UnitPrice: 99999, Quantity: 1},
+				{ItemID: 5, Name: "Mouse", UnitPrice: 2499, Quantity: 3},
+			},
+		}
+	})
+
+	Describe("line totals", func() {
+		It("multiplies unit price by quantity and sums the subtotal", func() {
+			totals, err := engine.Price(input)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(totals.Currency).To(Equal("USD"))
+			Expect(totals.Lines).To(HaveLen(2))
+			Expect(totals.Lines[1].Subtotal).To(Equal(int64(7497)))
+			Expect(totals.Subtotal).To(Equal(int64(107496)))
+			Expect(totals.ItemCount).To(Equal(4))
+			Expect(totals.Total).To(Equal(int64(107496)))
+		})
+
+		It("prices an empty cart at zero with empty breakdowns", func() {
+			totals, err := engine.Price(pricing.Input{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(totals.Total).To(BeZero())
+			Expect(totals.Lines).ToNot(BeNil())
+			Expect(totals.Discounts).ToNot(BeNil())
+			Expect(totals.TaxLines).ToNot(BeNil())
+		})
+
+		It("rejects non-positive quantities", func() {
+			input.Lines[0].Quantity = 0
+			_, err := engine.Price(input)
+			Expect(errors.Is(err, pricing.ErrInvalidQuantity)).To(BeTrue())
+		})
+
+		It("rejects negative prices", func() {
+			input.Lines[0].UnitPrice = -1
+			_, err := engine.Price(input)
+			Expect(errors.Is(err, pricing.ErrInvalidPrice)).To(BeTrue())
+		})
+
+		It("keeps the requested currency", func() {
+			input.Currency = "EUR"
+			totals, _ := engine.Price(input)
+			Expect(totals.Currency).To(Equal("EUR"))
+		})
+	})
+
+	Describe("discounts", func() {
+		It("spreads a percentage discount across lines without losing cents", func() {
+			engine.Discounters = []pricing.Discounter{pricing.PercentOff{Code: "TEN", Label: "10% off", Rate: 1000}}
+
+			totals, err := engine.Price(input)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(totals.DiscountTotal).To(Equal(int64(10750)))
+			Expect(totals.Lines[0].Discount + totals.Lines[1].Discount).To(Equal(totals.DiscountTotal))
+			Expect(totals.Lines[0].Total).To(Equal(totals.Lines[0].Subtotal - totals.Lines[0].Discount))
+			Expect(totals.Discounts).To(ConsistOf(models.Adjustment{Code: "TEN", Label: "10% off", Amount: 10750}))
+			Expect(totals.Total).To(Equal(int64(96746)))
+		})
+
+		It("limits a discount to its eligible lines", func() {
+			engine.Discounters = []pricing.Discounter{fixedDiscounts{{Label: "mouse deal", Amount: 500, Lines: []int{1}}}}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.Lines[0].Discount).To(BeZero())
+			Expect(totals.Lines[1].Discount).To(Equal(int64(500)))
+		})
+
+		It("uses an explicit allocation when one is given", func() {
+			engine.Discounters = []pricing.Discounter{fixedDiscounts{{RuleID: 3, Label: "exact", Lines: []int{0, 1}, Allocation: []int64{100, 250}}}}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.Lines[0].Discount).To(Equal(int64(100)))
+			Expect(totals.Lines[1].Discount).To(Equal(int64(250)))
+			Expect(totals.Discounts).To(ConsistOf(models.Adjustment{RuleID: 3, Label: "exact", Amount: 350}))
+		})
+
+		It("caps discounts so no line goes negative", func() {
+			engine.Discounters = []pricing.Discounter{fixedDiscounts{{Label: "too much", Amount: 10000, Lines: []int{1}}}}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.Lines[1].Total).To(BeZero())
+			Expect(totals.DiscountTotal).To(Equal(int64(7497)))
+		})
+
+		It("applies later discounters to what earlier ones left", func() {
+			engine.Discounters = []pricing.Discounter{
+				pricing.AmountOff{Label: "5 off", Amount: 7496},
+				pricing.PercentOff{Label: "half", Rate: 5000},
+			}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.DiscountTotal).To(Equal(int64(7496 + 50000)))
+			Expect(totals.Total).To(Equal(int64(50000)))
+		})
+
+		It("ignores an amount-off below its minimum subtotal", func() {
+			engine.Discounters = []pricing.Discounter{pricing.AmountOff{Label: "big spender", Amount: 1000, MinSubtotal: 200000}}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.DiscountTotal).To(BeZero())
+			Expect(totals.Discounts).To(BeEmpty())
+		})
+
+		It("surfaces discounter errors", func() {
+			engine.Discounters = []pricing.Discounter{failingStage{}}
+			_, err := engine.Price(input)
+			Expect(err).To(MatchError("boom"))
+		})
+	})
+
+	Describe("shipping", func() {
+		It("charges flat shipping below the free threshold", func() {
+			engine.Shipping = pricing.FlatShipping{Amount: 599, FreeOver: 200000}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.Shipping).To(Equal(int64(599)))
+			Expect(totals.Total).To(Equal(int64(107496 + 599)))
+		})
+
+		It("ships free once discounted merchandise reaches the threshold", func() {
+			engine.Shipping = pricing.FlatShipping{Amount: 599, FreeOver: 100000}
+			totals, _ := engine.Price(input)
+			Expect(totals.Shipping).To(BeZero())
+
+			engine.Discounters = []pricing.Discounter{pricing.AmountOff{Label: "promo", Amount: 10000}}
+			totals, _ = engine.Price(input)
+			Expect(totals.Shipping).To(Equal(int64(599)))
+		})
+
+		It("does not charge shipping on an empty cart", func() {
+			engine.Shipping = pricing.FlatShipping{Amount: 599}
+			totals, _ := engine.Price(pricing.Input{})
+			Expect(totals.Shipping).To(BeZero())
+		})
+
+		It("applies shipping discounts only up to the shipping charge", func() {
+			engine.Shipping = pricing.FlatShipping{Amount: 599}
+			engine.Discounters = []pricing.Discounter{fixedDiscounts{{Code: "SHIP", Label: "Free shipping", Amount: 1000, Shipping: true}}}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.ShippingDiscount).To(Equal(int64(599)))
+			Expect(totals.DiscountTotal).To(BeZero())
+			Expect(totals.Discounts).To(ConsistOf(models.Adjustment{Code: "SHIP", Label: "Free shipping", Amount: 599, Shipping: true}))
+			Expect(totals.Total).To(Equal(int64(107496)))
+		})
+	})
+
+	Describe("tax", func() {
+		It("taxes each discounted line and rounds per line", func() {
+			input.Lines = []models.Line{
+				{ItemID: 1, UnitPrice: 105, Quantity: 1},
+				{ItemID: 2, UnitPrice: 105, Quantity: 1},
+			}
+			engine.Tax = pricing.FlatTax{Label: "Sales tax", Rate: 500}
+
+			totals, _ := engine.Price(input)
+			// 5.25 cents per line rounds to 5 each, not 10.5 -> 11 on the order.
+			Expect(totals.Tax).To(Equal(int64(10)))
+			Expect(totals.TaxLines).To(ConsistOf(models.TaxLine{Label: "Sales tax", Rate: 500, Taxable: 210, Amount: 10}))
+			Expect(totals.Total).To(Equal(int64(220)))
+		})
+
+		It("computes tax after discounts", func() {
+			engine.Discounters = []pricing.Discounter{pricing.PercentOff{Label: "half", Rate: 5000}}
+			engine.Tax = pricing.FlatTax{Label: "Sales tax", Rate: 1000}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.TaxLines[0].Taxable).To(Equal(totals.Merchandise()))
+			Expect(totals.Tax).To(Equal(int64(5000 + 375)))
+		})
+
+		It("taxes net shipping when asked", func() {
+			engine.Shipping = pricing.FlatShipping{Amount: 1000}
+			engine.Tax = pricing.FlatTax{Label: "VAT", Rate: 2000, IncludeShipping: true}
+
+			totals, _ := engine.Price(input)
+			Expect(totals.TaxLines[0].Taxable).To(Equal(int64(107496 + 1000)))
+			Expect(totals.Total).To(Equal(totals.Subtotal + totals.Shipping + totals.Tax))
+		})
+	})
+
+	It("builds an engine from its stages", func() {
+		e := pricing.NewEngine(pricing.FlatShipping{Amount: 500}, pricing.FlatTax{Rate: 1000}, pricing.PercentOff{Rate: 1000})
+		totals, err := e.Price(pricing.Input{Lines: []models.Line{{ItemID: 1, UnitPrice: 1000, Quantity: 1}}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(totals.Total).To(Equal(int64(1000 - 100 + 500 + 90)))
+	})
+})
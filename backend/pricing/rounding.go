@@ -0,0 +1,80 @@
+package pricing
+
+// BasisPoints is the denominator for rates: 10000 basis points is 100%.
+const BasisPoints = 10000
+
+// Percent returns amount * bps / 10000 rounded half away from zero.
+func Percent(amount, bps int64) int64 {
+	return divRound(amount*bps, BasisPoints)
+}
+
+// InclusivePart returns the portion of a tax-inclusive gross amount that is
+// tax at the given rate, rounded half away from zero.
+func InclusivePart(gross, bps int64) int64 {
+	return InclusiveShare(gross, bps, bps)
+}
+
+// InclusiveShare returns the part of a gross amount that belongs to one
+// rate when several rates totalling combined are included in the price.
+func InclusiveShare(gross, bps, combined int64) int64 {
+	return divRound(gross*bps, BasisPoints+combined)
+}
+
+// Allocate splits total across weights in proportion to each weight using
+// the largest remainder method, so the parts always sum to total exactly.
+// Non-positive weights receive nothing. Ties go to the earlier index.
+func Allocate(total int64, weights []int64) []int64 {
+	parts := make([]int64, len(weights))
+
+	var sum int64
+	for _, w := range weights {
+		if w > 0 {
+			sum += w
+		}
+	}
+	if sum == 0 || total == 0 {
+		return parts
+	}
+
+	remainders := make([]int64, len(weights))
+	var allocated int64
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		parts[i] = total * w / sum
+		remainders[i] = total * w % sum
+		allocated += parts[i]
+	}
+
+	for left := total - allocated; left > 0; left-- {
+		best := -1
+		for i, r := range remainders {
+			if weights[i] > 0 && (best < 0 || r > remainders[best]) {
+				best = i
+			}
+		}
+		parts[best]++
+		remainders[best] = -1
+	}
+
+	return parts
+}
+
+// Share returns what n of count units of amount are worth, rounded half
+// away from zero. Cumulative shares never lose a cent: the shares
+// Share(a, k+n, c) - Share(a, k, c) taken unit by unit sum to a.
+func Share(amount int64, n, count int) int64 {
+	if count <= 0 {
+		return 0
+	}
+	return divRound(amount*int64(n), int64(count))
+}
+
+// divRound divides n by d (d > 0) rounding half away from zero.
+func divRound(n, d int64) int64 {
+	if n < 0 {
+		return -divRound(-n, d)
+	}
+	return (n + d/2) / d
+}
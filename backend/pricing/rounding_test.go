@@ -0,0 +1,82 @@
+package pricing_test
+
+import (
+	"ecommerce-backend/pricing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rounding", func() {
+	DescribeTable("Percent rounds half away from zero",
+		func(amount, bps, expected int64) {
+			Expect(pricing.Percent(amount, bps)).To(Equal(expected))
+		},
+		Entry("exact", int64(10000), int64(1000), int64(1000)),
+		Entry("rounds down below half", int64(1004), int64(1000), int64(100)),
+		Entry("rounds up at half", int64(1005), int64(1000), int64(101)),
+		Entry("fractional rate", int64(1999), int64(825), int64(165)),
+		Entry("negative at half", int64(-1005), int64(1000), int64(-101)),
+		Entry("zero amount", int64(0), int64(825), int64(0)),
+	)
+
+	DescribeTable("InclusivePart extracts tax from gross prices",
+		func(gross, bps, expected int64) {
+			Expect(pricing.InclusivePart(gross, bps)).To(Equal(expected))
+		},
+		Entry("20% VAT on 12.00", int64(1200), int64(2000), int64(200)),
+		Entry("20% VAT on 9.99", int64(999), int64(2000), int64(167)),
+		Entry("zero rate", int64(999), int64(0), int64(0)),
+	)
+
+	Describe("Allocate", func() {
+		It("splits proportionally and always sums to the total", func() {
+			parts := pricing.Allocate(100, []int64{1, 1, 1})
+			Expect(parts).To(Equal([]int64{34, 33, 33}))
+		})
+
+		It("gives the leftover cents to the largest remainders", func() {
+			parts := pricing.Allocate(1000, []int64{3333, 3333, 3334})
+			Expect(parts).To(Equal([]int64{333, 333, 334}))
+		})
+
+		It("skips non-positive weights", func() {
+			parts := pricing.Allocate(50, []int64{0, 100, -5, 100})
+			Expect(parts).To(Equal([]int64{0, 25, 0, 25}))
+		})
+
+		It("returns zeros when there is nothing to weigh", func() {
+			Expect(pricing.Allocate(50, []int64{0, 0})).To(Equal([]int64{0, 0}))
+			Expect(pricing.Allocate(50, nil)).To(BeEmpty())
+		})
+
+		It("never loses a cent across awkward splits", func() {
+			for total := int64(0); total < 200; total++ {
+				parts := pricing.Allocate(total, []int64{7, 13, 29, 51})
+				var sum int64
+				for _, p := range parts {
+					sum += p
+				}
+				Expect(sum).To(Equal(total))
+			}
+		})
+	})
+
+	Describe("Share", func() {
+		It("prices part of a quantity", func() {
+			Expect(pricing.Share(1000, 1, 3)).To(Equal(int64(333)))
+			Expect(pricing.Share(1000, 2, 3)).To(Equal(int64(667)))
+			Expect(pricing.Share(1000, 3, 3)).To(Equal(int64(1000)))
+			Expect(pricing.Share(1000, 1, 0)).To(BeZero())
+		})
+
+		It("adds up to the amount when taken cumulatively", func() {
+			var sum int64
+			for k := 0; k < 7; k++ {
+				sum += pricing.Share(1001, k+1, 7) - pricing.Share(1001, k, 7)
+			}
+			Expect(sum).To(Equal(int64(1001)))
+		})
+	})
+})
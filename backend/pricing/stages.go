@@ -0,0 +1,76 @@
+package pricing
+
+import "ecommerce-backend/models"
+
+// PercentOff discounts every line by a fixed percentage.
+type PercentOff struct {
+	Code  string
+	Label string
+	Rate  int64 // basis points
+}
+
+func (p PercentOff) Discounts(in *Input, t *models.Totals) ([]Discount, error) {
+	amount := Percent(t.Merchandise(), p.Rate)
+	if amount <= 0 {
+		return nil, nil
+	}
+	return []Discount{{Code: p.Code, Label: p.Label, Amount: amount}}, nil
+}
+
+// AmountOff takes a fixed amount off the order once it reaches MinSubtotal.
+type AmountOff struct {
+	Code        string
+	Label       string
+	Amount      int64
+	MinSubtotal int64
+}
+
+func (a AmountOff) Discounts(in *Input, t *models.Totals) ([]Discount, error) {
+	if a.Amount <= 0 || t.Subtotal < a.MinSubtotal {
+		return nil, nil
+	}
+	return []Discount{{Code: a.Code, Label: a.Label, Amount: a.Amount}}, nil
+}
+
+// FlatShipping charges Amount per order, or nothing once the discounted
+// merchandise reaches FreeOver (when FreeOver is positive). Empty carts
+// never pay shipping.
+type FlatShipping struct {
+	Amount   int64
+	FreeOver int64
+}
+
+func (f FlatShipping) Shipping(in *Input, t *models.Totals) (int64, error) {
+	if len(t.Lines) == 0 {
+		return 0, nil
+	}
+	if f.FreeOver > 0 && t.Merchandise() >= f.FreeOver {
+		return 0, nil
+	}
+	return f.Amount, nil
+}
+
+// FlatTax applies one exclusive rate to every line, rounding per line, and
+// optionally to shipping.
+type FlatTax struct {
+	Label           string
+	Rate            int64 // basis points
+	IncludeShipping bool
+}
+
+func (f FlatTax) Tax(in *Input, t *models.Totals) ([]models.TaxLine, error) {
+	var taxable, amount int64
+	for _, line := range t.Lines {
+		taxable += line.Total
+		amount += Percent(line.Total, f.Rate)
+	}
+	if f.IncludeShipping {
+		shipping := t.Shipping - t.ShippingDiscount
+		taxable += shipping
+		amount += Percent(shipping, f.Rate)
+	}
+	if taxable == 0 {
+		return nil, nil
+	}
+	return []models.TaxLine{{Label: f.Label, Rate: f.Rate, Taxable: taxable, Amount: amount}}, nil
+}
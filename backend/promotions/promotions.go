@@ -0,0 +1,256 @@
+// Package promotions evaluates promotion rules against a priced cart. Its
+// Discounter plugs into the pricing engine, so storefront and checkout see
+// the same discounts.
+package promotions
+
+import (
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInactive   = errors.New("promotion is not active")
+	ErrNotStarted = errors.New("promotion has not started yet")
+	ErrExpired    = errors.New("promotion has expired")
+	ErrUsageLimit = errors.New("promotion usage limit reached")
+	ErrUserLimit  = errors.New("promotion already used the maximum number of times")
+	ErrInvalid    = errors.New("invalid promotion")
+)
+
+// Usage reports how often promotions have been redeemed.
+type Usage interface {
+	Redemptions(promotionID uint) int
+	UserRedemptions(promotionID, userID uint) int
+}
+
+// NormalizeCode returns the canonical form of a coupon code.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Check reports whether userID may redeem p at now.
+func Check(p *models.Promotion, userID uint, now time.Time, usage Usage) error {
+	if !p.Active {
+		return ErrInactive
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return ErrNotStarted
+	}
+	if p.EndsAt != nil && !now.Before(*p.EndsAt) {
+		return ErrExpired
+	}
+	if usage == nil {
+		return nil
+	}
+	if p.UsageLimit > 0 && usage.Redemptions(p.ID) >= p.UsageLimit {
+		return ErrUsageLimit
+	}
+	if p.PerUserLimit > 0 && userID != 0 && usage.UserRedemptions(p.ID, userID) >= p.PerUserLimit {
+		return ErrUserLimit
+	}
+	return nil
+}
+
+// Validate checks that a promotion definition is well formed.
+func Validate(p *models.Promotion) error {
+	invalid := func(reason string) error { return fmt.Errorf("%w: %s", ErrInvalid, reason) }
+
+	if strings.TrimSpace(p.Name) == "" {
+		return invalid("name is required")
+	}
+	switch p.Type {
+	case models.PromotionPercentOff:
+		if p.Value <= 0 || p.Value > pricing.BasisPoints {
+			return invalid("percent_off value must be between 1 and 10000 basis points")
+		}
+	case models.PromotionAmountOff:
+		if p.Value <= 0 {
+			return invalid("amount_off value must be positive")
+		}
+	case models.PromotionBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return invalid("buy_x_get_y needs positive buy_quantity and get_quantity")
+		}
+		if p.Value < 0 || p.Value > pricing.BasisPoints {
+			return invalid("buy_x_get_y value must be between 0 and 10000 basis points")
+		}
+	case models.PromotionFreeShipping:
+	default:
+		return invalid("unknown type " + p.Type)
+	}
+	if p.MinSubtotal < 0 || p.UsageLimit < 0 || p.PerUserLimit < 0 {
+		return invalid("limits must not be negative")
+	}
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return invalid("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// Discounter applies every eligible promotion to a cart. Automatic
+// promotions (no code) always apply; coded ones only when their code is in
+// the pricing input.
+//
+// Candidates are considered by descending Priority, then ID. A promotion
+// that is not Stackable only applies when nothing else has, and stops any
+// further promotions from applying. Stacked promotions are each computed
+// against the same undiscounted base.
+type Discounter struct {
+	Promotions func() []*models.Promotion
+	Usage      Usage
+	Now        func() time.Time
+}
+
+func (d *Discounter) Discounts(in *pricing.Input, t *models.Totals) ([]pricing.Discount, error) {
+	if d.Promotions == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	if d.Now != nil {
+		now = d.Now()
+	}
+
+	codes := make(map[string]bool, len(in.Codes))
+	for _, code := range in.Codes {
+		codes[NormalizeCode(code)] = true
+	}
+
+	var candidates []*models.Promotion
+	for _, p := range d.Promotions() {
+		if p.Code != "" && !codes[NormalizeCode(p.Code)] {
+			continue
+		}
+		if Check(p, in.UserID, now, d.Usage) != nil {
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority > candidates[j].Priority
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	var discounts []pricing.Discount
+	for _, p := range candidates {
+		if !p.Stackable && len(discounts) > 0 {
+			continue
+		}
+		discount, ok := Evaluate(p, t)
+		if !ok {
+			continue
+		}
+		discounts = append(discounts, discount)
+		if !p.Stackable {
+			break
+		}
+	}
+	return discounts, nil
+}
+
+// Evaluate computes the discount p gives on t, ignoring dates and limits.
+// It reports false when the promotion does not apply to the cart.
+func Evaluate(p *models.Promotion, t *models.Totals) (pricing.Discount, bool) {
+	eligible := eligibleLines(p, t)
+	if len(eligible) == 0 {
+		return pricing.Discount{}, false
+	}
+
+	var base int64
+	for _, idx := range eligible {
+		base += t.Lines[idx].Total
+	}
+	if base < p.MinSubtotal {
+		return pricing.Discount{}, false
+	}
+
+	discount := pricing.Discount{RuleID: p.ID, Code: p.Code, Label: p.Name, Lines: eligible}
+	switch p.Type {
+	case models.PromotionPercentOff:
+		discount.Amount = pricing.Percent(base, p.Value)
+	case models.PromotionAmountOff:
+		discount.Amount = min(p.Value, base)
+	case models.PromotionBuyXGetY:
+		discount.Allocation = buyXGetY(p, t, eligible)
+		for _, share := range discount.Allocation {
+			discount.Amount += share
+		}
+	case models.PromotionFreeShipping:
+		discount.Lines = nil
+		discount.Shipping = true
+		discount.Amount = math.MaxInt64
+		return discount, true
+	default:
+		return pricing.Discount{}, false
+	}
+
+	if discount.Amount <= 0 {
+		return pricing.Discount{}, false
+	}
+	return discount, true
+}
+
+// eligibleLines returns the indexes of lines the promotion covers.
+func eligibleLines(p *models.Promotion, t *models.Totals) []int {
+	eligible := []int{}
+	for i, line := range t.Lines {
+		if len(p.Categories) == 0 || inCategories(line.Category, p.Categories) {
+			eligible = append(eligible, i)
+		}
+	}
+	return eligible
+}
+
+func inCategories(category string, categories []string) bool {
+	for _, c := range categories {
+		if strings.EqualFold(c, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// buyXGetY lays out every eligible unit from most to least expensive and,
+// in each run of BuyQuantity+GetQuantity units, discounts the last
+// GetQuantity. The result holds the discount for each eligible line.
+func buyXGetY(p *models.Promotion, t *models.Totals, eligible []int) []int64 {
+	type unit struct {
+		pos   int // position in eligible
+		price int64
+	}
+
+	var units []unit
+	for pos, idx := range eligible {
+		line := t.Lines[idx]
+		for n := 0; n < line.Quantity; n++ {
+			units = append(units, unit{pos: pos, price: line.UnitPrice})
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool { return units[i].price > units[j].price })
+
+	rate := p.Value
+	if rate == 0 {
+		rate = pricing.BasisPoints
+	}
+
+	free := make([]int64, len(eligible))
+	group := p.BuyQuantity + p.GetQuantity
+	for i, u := range units {
+		if i%group >= p.BuyQuantity && (i/group+1)*group <= len(units) {
+			free[u.pos] += u.price
+		}
+	}
+
+	allocation := make([]int64, len(eligible))
+	for pos, amount := range free {
+		allocation[pos] = pricing.Percent(amount, rate)
+	}
+	return allocation
+}
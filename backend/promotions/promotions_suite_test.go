@@ -0,0 +1,13 @@
+package promotions_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPromotions(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Promotions Suite")
+}
@@ -0,0 +1,228 @@
+package promotions_test
+
+import (
+	"errors"
+	"time"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"ecommerce-backend/promotions"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeUsage struct {
+	total  map[uint]int
+	byUser map[uint]int
+}
+
+func (f fakeUsage) Redemptions(promotionID uint) int { return f.total[promotionID] }
+
+func (f fakeUsage) UserRedemptions(promotionID, userID uint) int { return f.byUser[promotionID] }
+
+var _ = Describe("Promotions", func() {
+	var (
+		now    time.Time
+		promos []*models.Promotion
+		usage  fakeUsage
+		engine *pricing.Engine
+		input  pricing.Input
+	)
+
+	price := func() models.Totals {
+		totals, err := engine.Price(input)
+		Expect(err).ToNot(HaveOccurred())
+		return totals
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+		promos = nil
+		usage = fakeUsage{total: map[uint]int{}, byUser: map[uint]int{}}
+		discounter := &promotions.Discounter{
+			Promotions: func() []*models.Promotion { return promos },
+			Usage:      usage,
+			Now:        func() time.Time { return now },
+		}
+		engine = pricing.NewEngine(pricing.FlatShipping{Amount: 599}, nil, discounter)
+		input = pricing.Input{
+			UserID: 7,
+			Lines: []models.Line{
+				{ItemID: 1, Name: "Laptop", Category: "computers", UnitPrice: 100000, Quantity: 1},
+				{ItemID: 5, Name: "Mouse", Category: "accessories", UnitPrice: 2500, Quantity: 4},
+			},
+		}
+	})
+
+	Describe("automatic promotions", func() {
+		It("applies a percent-off sale without a code", func() {
+			promos = []*models.Promotion{{ID: 1, Name: "Summer sale", Type: models.PromotionPercentOff, Value: 1000, Active: true}}
+
+			totals := price()
+			Expect(totals.DiscountTotal).To(Equal(int64(11000)))
+			Expect(totals.Discounts[0].RuleID).To(Equal(uint(1)))
+		})
+
+		It("ignores inactive, future and expired promotions", func() {
+			future := now.Add(time.Hour)
+			past := now.Add(-time.Hour)
+			promos = []*models.Promotion{
+				{ID: 1, Name: "off", Type: models.PromotionPercentOff, Value: 1000},
+				{ID: 2, Name: "soon", Type: models.PromotionPercentOff, Value: 1000, Active: true, StartsAt: &future},
+				{ID: 3, Name: "over", Type: models.PromotionPercentOff, Value: 1000, Active: true, EndsAt: &past},
+			}
+
+			Expect(price().DiscountTotal).To(BeZero())
+		})
+
+		It("limits category discounts to matching lines", func() {
+			promos = []*models.Promotion{{ID: 1, Name: "Accessories 50%", Type: models.PromotionPercentOff, Value: 5000, Categories: []string{"Accessories"}, Active: true}}
+
+			totals := price()
+			Expect(totals.Lines[0].Discount).To(BeZero())
+			Expect(totals.Lines[1].Discount).To(Equal(int64(5000)))
+		})
+
+		It("skips promotions below their minimum subtotal", func() {
+			promos = []*models.Promotion{{ID: 1, Name: "10 off 200", Type: models.PromotionAmountOff, Value: 1000, MinSubtotal: 200000, Active: true}}
+			Expect(price().DiscountTotal).To(BeZero())
+
+			promos[0].MinSubtotal = 110000
+			Expect(price().DiscountTotal).To(Equal(int64(1000)))
+		})
+
+		It("gives free shipping over a threshold", func() {
+			promos = []*models.Promotion{{ID: 1, Name: "Free shipping", Type: models.PromotionFreeShipping, MinSubtotal: 50000, Active: true}}
+
+			totals := price()
+			Expect(totals.Shipping).To(Equal(int64(599)))
+			Expect(totals.ShippingDiscount).To(Equal(int64(599)))
+			Expect(totals.Total).To(Equal(totals.Subtotal))
+		})
+	})
+
+	Describe("buy X get Y", func() {
+		It("makes the cheapest unit in each full group free", func() {
+			promos = []*models.Promotion{{ID: 1, Name: "Buy 2 get 1", Type: models.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Active: true}}
+
+			// Units: 100000, 2500, 2500, 2500, 2500 -> one full group, third unit free.
+			totals := price()
+			Expect(totals.Lines[0].Discount).To(BeZero())
+			Expect(totals.Lines[1].Discount).To(Equal(int64(2500)))
+		})
+
+		It("discounts the get units by Value basis points", func() {
+			input.Lines = []models.Line{{ItemID: 5, UnitPrice: 2500, Quantity: 6}}
+			promos = []*models.Promotion{{ID: 1, Name: "Buy 1 get 1 half off", Type: models.PromotionBuyXGetY, BuyQuantity: 1, GetQuantity: 1, Value: 5000, Active: true}}
+
+			Expect(price().DiscountTotal).To(Equal(int64(3 * 1250)))
+		})
+
+		It("does nothing without a full group", func() {
+			input.Lines = []models.Line{{ItemID: 5, UnitPrice: 2500, Quantity: 2}}
+			promos = []*models.Promotion{{ID: 1, Name: "Buy 2 get 1", Type: models.PromotionBuyXGetY, BuyQuantity: 2, GetQuantity: 1, Active: true}}
+
+			Expect(price().DiscountTotal).To(BeZero())
+		})
+	})
+
+	Describe("coupon codes", func() {
+		BeforeEach(func() {
+			promos = []*models.Promotion{{ID: 9, Code: "SAVE10", Name: "10 off", Type: models.PromotionAmountOff, Value: 1000, Active: true, Stackable: true}}
+		})
+
+		It("only applies when the code is on the cart", func() {
+			Expect(price().DiscountTotal).To(BeZero())
+
+			input.Codes = []string{" save10 "}
+			totals := price()
+			Expect(totals.DiscountTotal).To(Equal(int64(1000)))
+			Expect(totals.Discounts[0].Code).To(Equal("SAVE10"))
+		})
+
+		It("stops applying once the usage limit is reached", func() {
+			input.Codes = []string{"SAVE10"}
+			promos[0].UsageLimit = 2
+			usage.total[9] = 2
+
+			Expect(price().DiscountTotal).To(BeZero())
+		})
+
+		It("stops applying once the per-user limit is reached", func() {
+			input.Codes = []string{"SAVE10"}
+			promos[0].PerUserLimit = 1
+			usage.byUser[9] = 1
+
+			Expect(price().DiscountTotal).To(BeZero())
+		})
+	})
+
+	Describe("stacking", func() {
+		It("combines stackable promotions", func() {
+			promos = []*models.Promotion{
+				{ID: 1, Name: "5 off", Type: models.PromotionAmountOff, Value: 500, Stackable: true, Active: true},
+				{ID: 2, Name: "10 off", Type: models.PromotionAmountOff, Value: 1000, Stackable: true, Active: true},
+			}
+			Expect(price().DiscountTotal).To(Equal(int64(1500)))
+		})
+
+		It("lets a higher-priority exclusive promotion block the rest", func() {
+			promos = []*models.Promotion{
+				{ID: 1, Name: "5 off", Type: models.PromotionAmountOff, Value: 500, Stackable: true, Active: true},
+				{ID: 2, Name: "VIP 20%", Type: models.PromotionPercentOff, Value: 2000, Priority: 10, Active: true},
+			}
+			totals := price()
+			Expect(totals.Discounts).To(HaveLen(1))
+			Expect(totals.Discounts[0].Label).To(Equal("VIP 20%"))
+		})
+
+		It("skips an exclusive promotion once another has applied", func() {
+			promos = []*models.Promotion{
+				{ID: 1, Name: "5 off", Type: models.PromotionAmountOff, Value: 500, Stackable: true, Priority: 5, Active: true},
+				{ID: 2, Name: "VIP 20%", Type: models.PromotionPercentOff, Value: 2000, Active: true},
+			}
+			totals := price()
+			Expect(totals.Discounts).To(HaveLen(1))
+			Expect(totals.Discounts[0].Label).To(Equal("5 off"))
+		})
+	})
+
+	Describe("Check", func() {
+		It("reports why a promotion cannot be redeemed", func() {
+			p := &models.Promotion{ID: 1, Active: true, UsageLimit: 1}
+			Expect(promotions.Check(p, 7, now, usage)).To(Succeed())
+
+			usage.total[1] = 1
+			Expect(promotions.Check(p, 7, now, usage)).To(MatchError(promotions.ErrUsageLimit))
+
+			p.EndsAt = &now
+			Expect(promotions.Check(p, 7, now, usage)).To(MatchError(promotions.ErrExpired))
+
+			p.Active = false
+			Expect(promotions.Check(p, 7, now, usage)).To(MatchError(promotions.ErrInactive))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts well formed promotions", func() {
+			Expect(promotions.Validate(&models.Promotion{Name: "Sale", Type: models.PromotionPercentOff, Value: 2500})).To(Succeed())
+			Expect(promotions.Validate(&models.Promotion{Name: "Ship", Type: models.PromotionFreeShipping})).To(Succeed())
+		})
+
+		It("rejects broken definitions", func() {
+			later := now.Add(time.Hour)
+			for _, p := range []*models.Promotion{
+				{Type: models.PromotionPercentOff, Value: 100},
+				{Name: "x", Type: "mystery"},
+				{Name: "x", Type: models.PromotionPercentOff, Value: 20000},
+				{Name: "x", Type: models.PromotionAmountOff},
+				{Name: "x", Type: models.PromotionBuyXGetY, BuyQuantity: 2},
+				{Name: "x", Type: models.PromotionFreeShipping, UsageLimit: -1},
+				{Name: "x", Type: models.PromotionFreeShipping, StartsAt: &later, EndsAt: &now},
+			} {
+				Expect(errors.Is(promotions.Validate(p), promotions.ErrInvalid)).To(BeTrue(), p.Name+" "+p.Type)
+			}
+		})
+	})
+})
@@ -0,0 +1,73 @@
+// Package scheduler runs background jobs at fixed intervals on a Clock.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"ecommerce-backend/clock"
+)
+
+// Job is one run of a background job. It receives the clock's time.
+type Job func(now time.Time)
+
+type entry struct {
+	name     string
+	interval time.Duration
+	run      Job
+}
+
+// Scheduler runs every registered job in its own goroutine. A job that
+// panics is logged and runs again at its next interval.
+type Scheduler struct {
+	clock clock.Clock
+	jobs  []entry
+	wg    sync.WaitGroup
+}
+
+// New returns a scheduler driven by c.
+func New(c clock.Clock) *Scheduler {
+	return &Scheduler{clock: c}
+}
+
+// Every registers a job to run once per interval. Jobs must be registered
+// before Start.
+func (s *Scheduler) Every(name string, interval time.Duration, run Job) {
+	s.jobs = append(s.jobs, entry{name: name, interval: interval, run: run})
+}
+
+// Start runs the jobs until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Wait blocks until every job has stopped after ctx was cancelled.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job entry) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-s.clock.After(job.interval):
+			s.run(job, now)
+		}
+	}
+}
+
+func (s *Scheduler) run(job entry, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("scheduler: job %s panicked: %v", job.name, r)
+		}
+	}()
+	job.run(now)
+}
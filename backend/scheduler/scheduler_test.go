@@ -0,0 +1,100 @@
+package scheduler_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ecommerce-backend/clock"
+	"ecommerce-backend/scheduler"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduler", func() {
+	var (
+		fake   *clock.Fake
+		s      *scheduler.Scheduler
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		mu   sync.Mutex
+		runs []time.Time
+	)
+
+	recorded := func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), runs...)
+	}
+
+	BeforeEach(func() {
+		fake = clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		s = scheduler.New(fake)
+		ctx, cancel = context.WithCancel(context.Background())
+		runs = nil
+	})
+
+	AfterEach(func() {
+		cancel()
+		s.Wait()
+	})
+
+	It("runs a job once per interval", func() {
+		s.Every("record", time.Minute, func(now time.Time) {
+			mu.Lock()
+			runs = append(runs, now)
+			mu.Unlock()
+		})
+		s.Start(ctx)
+
+		Eventually(fake.Waiters).Should(Equal(1))
+		fake.Advance(30 * time.Second)
+		Consistently(recorded).Should(BeEmpty())
+
+		fake.Advance(30 * time.Second)
+		Eventually(recorded).Should(HaveLen(1))
+
+		Eventually(fake.Waiters).Should(Equal(1))
+		fake.Advance(time.Minute)
+		Eventually(recorded).Should(HaveLen(2))
+		Expect(recorded()[1].Sub(recorded()[0])).To(Equal(time.Minute))
+	})
+
+	It("keeps running a job after it panics", func() {
+		calls := 0
+		s.Every("flaky", time.Minute, func(now time.Time) {
+			mu.Lock()
+			calls++
+			n := calls
+			runs = append(runs, now)
+			mu.Unlock()
+			if n == 1 {
+				panic("boom")
+			}
+		})
+		s.Start(ctx)
+
+		Eventually(fake.Waiters).Should(Equal(1))
+		fake.Advance(time.Minute)
+		Eventually(recorded).Should(HaveLen(1))
+
+		Eventually(fake.Waiters).Should(Equal(1))
+		fake.Advance(time.Minute)
+		Eventually(recorded).Should(HaveLen(2))
+	})
+
+	It("stops when the context is cancelled", func() {
+		s.Every("idle", time.Minute, func(time.Time) {})
+		s.Start(ctx)
+		cancel()
+
+		done := make(chan struct{})
+		go func() {
+			s.Wait()
+			close(done)
+		}()
+		Eventually(done).Should(BeClosed())
+	})
+})
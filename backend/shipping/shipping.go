@@ -0,0 +1,183 @@
+// Package shipping resolves destination zones and quotes shipping rates
+// from the shipping catalog. Its Calculator plugs into the pricing engine.
+package shipping
+
+import (
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidMethod = errors.New("invalid shipping method")
+	ErrInvalidZone   = errors.New("invalid shipping zone")
+)
+
+// Quote is the price of one shipping method for a cart.
+type Quote struct {
+	Method  string `json:"method"`
+	Name    string `json:"name"`
+	Zone    string `json:"zone"`
+	Amount  int64  `json:"amount"`
+	MinDays int    `json:"min_days"`
+	MaxDays int    `json:"max_days"`
+}
+
+// ZoneFor returns the zone a destination falls in: a region match wins
+// over a country match, which wins over a "*" zone. It returns nil when no
+// zone covers the destination.
+func ZoneFor(zones []*models.ShippingZone, country, region string) *models.ShippingZone {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if country == "" {
+		return nil
+	}
+
+	var byCountry, fallback *models.ShippingZone
+	for _, zone := range sortedZones(zones) {
+		if region != "" && contains(zone.Regions, country+"-"+region) {
+			return zone
+		}
+		if byCountry == nil && contains(zone.Countries, country) {
+			byCountry = zone
+		}
+		if fallback == nil && contains(zone.Countries, "*") {
+			fallback = zone
+		}
+	}
+	if byCountry != nil {
+		return byCountry
+	}
+	return fallback
+}
+
+// Weight returns the total weight of the cart in grams.
+func Weight(t *models.Totals) int {
+	grams := 0
+	for _, line := range t.Lines {
+		grams += line.Weight * line.Quantity
+	}
+	return grams
+}
+
+// Rate prices a method for a cart in a zone. It reports false when the
+// method does not serve the zone or the cart is too heavy.
+func Rate(method *models.ShippingMethod, zone string, t *models.Totals) (int64, bool) {
+	if !method.Active || !contains(method.Zones, zone) {
+		return 0, false
+	}
+	grams := Weight(t)
+	if method.MaxWeight > 0 && grams > method.MaxWeight {
+		return 0, false
+	}
+	if method.FreeOver > 0 && t.Merchandise() >= method.FreeOver {
+		return 0, true
+	}
+	kilograms := int64((grams + 999) / 1000)
+	return method.BaseRate + method.PerKgRate*kilograms, true
+}
+
+// Quotes lists every method available for a destination, cheapest first.
+func Quotes(methods []*models.ShippingMethod, zones []*models.ShippingZone, country, region string, t *models.Totals) []Quote {
+	quotes := []Quote{}
+	zone := ZoneFor(zones, country, region)
+	if zone == nil {
+		return quotes
+	}
+	for _, method := range methods {
+		amount, ok := Rate(method, zone.Code, t)
+		if !ok {
+			continue
+		}
+		quotes = append(quotes, Quote{
+			Method:  method.Code,
+			Name:    method.Name,
+			Zone:    zone.Code,
+			Amount:  amount,
+			MinDays: method.MinDays,
+			MaxDays: method.MaxDays,
+		})
+	}
+	sort.Slice(quotes, func(i, j int) bool {
+		if quotes[i].Amount != quotes[j].Amount {
+			return quotes[i].Amount < quotes[j].Amount
+		}
+		return quotes[i].Method < quotes[j].Method
+	})
+	return quotes
+}
+
+// QuoteFor returns the quote for one method, if it is available.
+func QuoteFor(methods []*models.ShippingMethod, zones []*models.ShippingZone, country, region, code string, t *models.Totals) (Quote, bool) {
+	for _, quote := range Quotes(methods, zones, country, region, t) {
+		if quote.Method == code {
+			return quote, true
+		}
+	}
+	return Quote{}, false
+}
+
+// Calculator charges the method selected on the cart. Carts without a
+// selection, or whose selection is not available for the destination, are
+// charged nothing here; checkout must reject the latter with QuoteFor.
+type Calculator struct {
+	Methods func() []*models.ShippingMethod
+	Zones   func() []*models.ShippingZone
+}
+
+func (c *Calculator) Shipping(in *pricing.Input, t *models.Totals) (int64, error) {
+	if in.ShippingMethod == "" || c.Methods == nil || c.Zones == nil || len(t.Lines) == 0 {
+		return 0, nil
+	}
+	quote, ok := QuoteFor(c.Methods(), c.Zones(), in.Country, in.Region, in.ShippingMethod, t)
+	if !ok {
+		return 0, nil
+	}
+	return quote.Amount, nil
+}
+
+// ValidateMethod checks a shipping method before it is stored.
+func ValidateMethod(method *models.ShippingMethod) error {
+	if strings.TrimSpace(method.Code) == "" || strings.TrimSpace(method.Name) == "" {
+		return fmt.Errorf("%w: code and name are required", ErrInvalidMethod)
+	}
+	if len(method.Zones) == 0 {
+		return fmt.Errorf("%w: at least one zone is required", ErrInvalidMethod)
+	}
+	if method.BaseRate < 0 || method.PerKgRate < 0 || method.FreeOver < 0 || method.MaxWeight < 0 {
+		return fmt.Errorf("%w: rates and limits must not be negative", ErrInvalidMethod)
+	}
+	if method.MaxDays < method.MinDays {
+		return fmt.Errorf("%w: max_days must not be less than min_days", ErrInvalidMethod)
+	}
+	return nil
+}
+
+// ValidateZone checks a shipping zone before it is stored.
+func ValidateZone(zone *models.ShippingZone) error {
+	if strings.TrimSpace(zone.Code) == "" {
+		return fmt.Errorf("%w: code is required", ErrInvalidZone)
+	}
+	if len(zone.Countries) == 0 && len(zone.Regions) == 0 {
+		return fmt.Errorf("%w: at least one country or region is required", ErrInvalidZone)
+	}
+	return nil
+}
+
+func sortedZones(zones []*models.ShippingZone) []*models.ShippingZone {
+	sorted := append([]*models.ShippingZone(nil), zones...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package shipping_test
+
+import (
+	"errors"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"ecommerce-backend/shipping"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shipping", func() {
+	var (
+		zones   []*models.ShippingZone
+		methods []*models.ShippingMethod
+		totals  *models.Totals
+	)
+
+	BeforeEach(func() {
+		zones = []*models.ShippingZone{
+			{ID: 1, Code: "domestic", Countries: []string{"US"}},
+			{ID: 2, Code: "remote", Regions: []string{"US-AK", "US-HI"}},
+			{ID: 3, Code: "international", Countries: []string{"*"}},
+		}
+		methods = []*models.ShippingMethod{
+			{Code: "standard", Name: "Standard", Zones: []string{"domestic"}, BaseRate: 500, PerKgRate: 100, FreeOver: 10000, Active: true},
+			{Code: "express", Name: "Express", Zones: []string{"domestic", "remote"}, BaseRate: 1500, PerKgRate: 200, Active: true},
+			{Code: "intl", Name: "International", Zones: []string{"international"}, BaseRate: 2500, PerKgRate: 500, MaxWeight: 5000, Active: true},
+			{Code: "retired", Name: "Retired", Zones: []string{"domestic"}, BaseRate: 1},
+		}
+		totals = &models.Totals{
+			Lines: []models.LineTotal{
+				{Line: models.Line{ItemID: 1, Weight: 1200, Quantity: 1}, Total: 3000},
+				{Line: models.Line{ItemID: 2, Weight: 100, Quantity: 3}, Total: 1500},
+			},
+			Subtotal: 4500,
+		}
+	})
+
+	DescribeTable("ZoneFor",
+		func(country, region, expected string) {
+			zone := shipping.ZoneFor(zones, country, region)
+			if expected == "" {
+				Expect(zone).To(BeNil())
+			} else {
+				Expect(zone.Code).To(Equal(expected))
+			}
+		},
+		Entry("country match", "US", "CA", "domestic"),
+		Entry("region beats country", "us", "ak", "remote"),
+		Entry("wildcard for everything else", "FR", "", "international"),
+		Entry("no destination", "", "", ""),
+	)
+
+	It("returns nil when nothing covers the destination", func() {
+		Expect(shipping.ZoneFor(zones[:2], "FR", "")).To(BeNil())
+	})
+
+	It("sums weight across quantities", func() {
+		Expect(shipping.Weight(totals)).To(Equal(1500))
+	})
+
+	Describe("Rate", func() {
+		It("charges base plus every started kilogram", func() {
+			amount, ok := shipping.Rate(methods[0], "domestic", totals)
+			Expect(ok).To(BeTrue())
+			Expect(amount).To(Equal(int64(500 + 2*100)))
+		})
+
+		It("ships free over the cart value threshold", func() {
+			totals.Subtotal = 10000
+			amount, ok := shipping.Rate(methods[0], "domestic", totals)
+			Expect(ok).To(BeTrue())
+			Expect(amount).To(BeZero())
+		})
+
+		It("uses the discounted cart value for the threshold", func() {
+			totals.Subtotal = 10000
+			totals.DiscountTotal = 1
+			amount, _ := shipping.Rate(methods[0], "domestic", totals)
+			Expect(amount).To(Equal(int64(700)))
+		})
+
+		It("refuses other zones, inactive methods and heavy carts", func() {
+			_, ok := shipping.Rate(methods[0], "remote", totals)
+			Expect(ok).To(BeFalse())
+
+			_, ok = shipping.Rate(methods[3], "domestic", totals)
+			Expect(ok).To(BeFalse())
+
+			totals.Lines[0].Weight = 6000
+			_, ok = shipping.Rate(methods[2], "international", totals)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Quotes", func() {
+		It("lists available methods cheapest first", func() {
+			quotes := shipping.Quotes(methods, zones, "US", "CA", totals)
+			Expect(quotes).To(Equal([]shipping.Quote{
+				{Method: "standard", Name: "Standard", Zone: "domestic", Amount: 700},
+				{Method: "express", Name: "Express", Zone: "domestic", Amount: 1900},
+			}))
+		})
+
+		It("is empty without a zone", func() {
+			Expect(shipping.Quotes(methods, zones[:2], "FR", "", totals)).To(BeEmpty())
+		})
+
+		It("finds a single method", func() {
+			quote, ok := shipping.QuoteFor(methods, zones, "US", "HI", "express", totals)
+			Expect(ok).To(BeTrue())
+			Expect(quote.Zone).To(Equal("remote"))
+
+			_, ok = shipping.QuoteFor(methods, zones, "US", "HI", "standard", totals)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Calculator", func() {
+		var engine *pricing.Engine
+
+		BeforeEach(func() {
+			engine = pricing.NewEngine(&shipping.Calculator{
+				Methods: func() []*models.ShippingMethod { return methods },
+				Zones:   func() []*models.ShippingZone { return zones },
+			}, nil)
+		})
+
+		It("charges the selected method", func() {
+			result, err := engine.Price(pricing.Input{
+				Country: "US", Region: "NY", ShippingMethod: "express",
+				Lines: []models.Line{{ItemID: 1, UnitPrice: 1000, Quantity: 2, Weight: 400}},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Shipping).To(Equal(int64(1700)))
+			Expect(result.Total).To(Equal(int64(2000 + 1700)))
+		})
+
+		It("charges nothing without a selection or for an unavailable one", func() {
+			in := pricing.Input{Country: "FR", Lines: []models.Line{{ItemID: 1, UnitPrice: 1000, Quantity: 1}}}
+			result, _ := engine.Price(in)
+			Expect(result.Shipping).To(BeZero())
+
+			in.ShippingMethod = "standard"
+			result, _ = engine.Price(in)
+			Expect(result.Shipping).To(BeZero())
+		})
+	})
+
+	Describe("validation", func() {
+		It("checks methods", func() {
+			Expect(shipping.ValidateMethod(methods[0])).To(Succeed())
+			Expect(errors.Is(shipping.ValidateMethod(&models.ShippingMethod{Code: "x", Name: "x"}), shipping.ErrInvalidMethod)).To(BeTrue())
+			Expect(errors.Is(shipping.ValidateMethod(&models.ShippingMethod{Code: "x", Name: "x", Zones: []string{"a"}, BaseRate: -1}), shipping.ErrInvalidMethod)).To(BeTrue())
+			Expect(errors.Is(shipping.ValidateMethod(&models.ShippingMethod{Code: "x", Name: "x", Zones: []string{"a"}, MinDays: 3, MaxDays: 1}), shipping.ErrInvalidMethod)).To(BeTrue())
+		})
+
+		It("checks zones", func() {
+			Expect(shipping.ValidateZone(zones[0])).To(Succeed())
+			Expect(errors.Is(shipping.ValidateZone(&models.ShippingZone{Code: "empty"}), shipping.ErrInvalidZone)).To(BeTrue())
+		})
+	})
+})
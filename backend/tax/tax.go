@@ -0,0 +1,173 @@
+// Package tax computes tax lines from a table of rules keyed by destination
+// and product tax class. Its Calculator plugs into the pricing engine.
+package tax
+
+import (
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rounding decides where tax amounts are rounded to the cent.
+type Rounding string
+
+const (
+	// RoundPerLine rounds the tax on every cart line, then sums.
+	RoundPerLine Rounding = "line"
+	// RoundPerOrder sums the unrounded tax and rounds once per rule.
+	RoundPerOrder Rounding = "order"
+)
+
+var ErrInvalidRule = errors.New("invalid tax rule")
+
+// Calculator applies the rule table to a cart.
+//
+// With Inclusive set, catalog prices already contain tax: the tax lines
+// report the embedded amount and Taxable is the gross amount. Tax-exempt
+// customers pay no tax; under inclusive pricing they get the embedded tax
+// taken off as a negative exclusive line.
+type Calculator struct {
+	Rules            func() []*models.TaxRule
+	Inclusive        bool
+	Rounding         Rounding
+	DefaultCountry   string // used when the input has no destination
+	DefaultRegion    string
+	ShippingTaxClass string // empty leaves shipping untaxed
+}
+
+// taxable is an amount of a single tax class.
+type taxable struct {
+	class  string
+	amount int64
+}
+
+func (c *Calculator) Tax(in *pricing.Input, t *models.Totals) ([]models.TaxLine, error) {
+	if c.Rules == nil {
+		return nil, nil
+	}
+
+	country, region := in.Country, in.Region
+	if country == "" {
+		country, region = c.DefaultCountry, c.DefaultRegion
+	}
+
+	amounts := make([]taxable, 0, len(t.Lines)+1)
+	for _, line := range t.Lines {
+		amounts = append(amounts, taxable{class: normalizeClass(line.TaxClass), amount: line.Total})
+	}
+	if c.ShippingTaxClass != "" {
+		amounts = append(amounts, taxable{class: normalizeClass(c.ShippingTaxClass), amount: t.Shipping - t.ShippingDiscount})
+	}
+
+	rules := Match(c.Rules(), country, region)
+
+	// Under inclusive pricing each amount carries the combined rate of its class.
+	combined := map[string]int64{}
+	for _, rule := range rules {
+		combined[normalizeClass(rule.TaxClass)] += rule.Rate
+	}
+
+	lines := []models.TaxLine{}
+	var embedded int64
+	for _, rule := range rules {
+		class := normalizeClass(rule.TaxClass)
+		var base, amount int64
+		for _, a := range amounts {
+			if a.class != class || a.amount == 0 {
+				continue
+			}
+			base += a.amount
+			if c.Rounding != RoundPerOrder {
+				amount += c.compute(a.amount, rule.Rate, combined[class])
+			}
+		}
+		if base == 0 {
+			continue
+		}
+		if c.Rounding == RoundPerOrder {
+			amount = c.compute(base, rule.Rate, combined[class])
+		}
+
+		lines = append(lines, models.TaxLine{
+			Label:     rule.Name,
+			Rate:      rule.Rate,
+			Taxable:   base,
+			Amount:    amount,
+			Inclusive: c.Inclusive,
+		})
+		embedded += amount
+	}
+
+	if in.TaxExempt {
+		if !c.Inclusive || embedded == 0 {
+			return nil, nil
+		}
+		return []models.TaxLine{{Label: "Tax exemption", Amount: -embedded}}, nil
+	}
+	return lines, nil
+}
+
+func (c *Calculator) compute(amount, rate, combined int64) int64 {
+	if c.Inclusive {
+		return pricing.InclusiveShare(amount, rate, combined)
+	}
+	return pricing.Percent(amount, rate)
+}
+
+// Match returns the rules that apply to a destination, country-wide rules
+// first, in a stable order.
+func Match(rules []*models.TaxRule, country, region string) []*models.TaxRule {
+	matched := []*models.TaxRule{}
+	for _, rule := range rules {
+		if !strings.EqualFold(rule.Country, country) {
+			continue
+		}
+		if rule.Region != "" && !strings.EqualFold(rule.Region, region) {
+			continue
+		}
+		matched = append(matched, rule)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if (a.Region == "") != (b.Region == "") {
+			return a.Region == ""
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	return matched
+}
+
+// Validate checks a rule before it is stored.
+func Validate(rule *models.TaxRule) error {
+	if len(strings.TrimSpace(rule.Country)) != 2 {
+		return fmt.Errorf("%w: country must be a two-letter code", ErrInvalidRule)
+	}
+	if strings.TrimSpace(rule.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRule)
+	}
+	if rule.Rate < 0 || rule.Rate > pricing.BasisPoints {
+		return fmt.Errorf("%w: rate must be between 0 and 10000 basis points", ErrInvalidRule)
+	}
+	return nil
+}
+
+// Normalize puts a rule's codes in canonical form.
+func Normalize(rule *models.TaxRule) {
+	rule.Country = strings.ToUpper(strings.TrimSpace(rule.Country))
+	rule.Region = strings.ToUpper(strings.TrimSpace(rule.Region))
+	rule.TaxClass = normalizeClass(rule.TaxClass)
+}
+
+func normalizeClass(class string) string {
+	class = strings.ToLower(strings.TrimSpace(class))
+	if class == "" {
+		return models.TaxClassStandard
+	}
+	return class
+}
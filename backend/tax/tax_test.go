@@ -0,0 +1,169 @@
+package tax_test
+
+import (
+	"errors"
+
+	"ecommerce-backend/models"
+	"ecommerce-backend/pricing"
+	"ecommerce-backend/tax"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var rules = []*models.TaxRule{
+	{ID: 1, Country: "US", Region: "CA", Name: "California", Rate: 725},
+	{ID: 2, Country: "US", Region: "NY", Name: "New York", Rate: 400},
+	{ID: 3, Country: "CA", Name: "GST", Rate: 500},
+	{ID: 4, Country: "CA", Region: "BC", Name: "PST", Rate: 700},
+	{ID: 5, Country: "GB", Name: "VAT", Rate: 2000},
+	{ID: 6, Country: "GB", TaxClass: "reduced", Name: "VAT (reduced)", Rate: 500},
+	{ID: 7, Country: "GB", TaxClass: "zero", Name: "VAT (zero)", Rate: 0},
+}
+
+func lines(prices ...int64) []models.Line {
+	result := make([]models.Line, len(prices))
+	for i, p := range prices {
+		result[i] = models.Line{ItemID: uint(i + 1), UnitPrice: p, Quantity: 1}
+	}
+	return result
+}
+
+var _ = Describe("Calculator", func() {
+	price := func(calc *tax.Calculator, in pricing.Input) models.Totals {
+		totals, err := pricing.NewEngine(nil, calc).Price(in)
+		Expect(err).ToNot(HaveOccurred())
+		return totals
+	}
+
+	newCalculator := func(rounding tax.Rounding, inclusive bool) *tax.Calculator {
+		return &tax.Calculator{
+			Rules:     func() []*models.TaxRule { return rules },
+			Rounding:  rounding,
+			Inclusive: inclusive,
+		}
+	}
+
+	DescribeTable("rounding per line vs per order",
+		func(rounding tax.Rounding, inclusive bool, country, region string, prices []int64, expectedTax int64) {
+			totals := price(newCalculator(rounding, inclusive), pricing.Input{Country: country, Region: region, Lines: lines(prices...)})
+
+			var taxed int64
+			for _, tl := range totals.TaxLines {
+				taxed += tl.Amount
+			}
+			Expect(taxed).To(Equal(expectedTax))
+			if inclusive {
+				Expect(totals.TaxInclusive).To(Equal(expectedTax))
+				Expect(totals.Total).To(Equal(totals.Subtotal))
+			} else {
+				Expect(totals.Tax).To(Equal(expectedTax))
+				Expect(totals.Total).To(Equal(totals.Subtotal + expectedTax))
+			}
+		},
+		// 3 x 1.50 at 7.25%: 10.875c per line -> 11 each = 33; on the order 32.625 -> 33.
+		Entry("exclusive, per line, halves agree", tax.RoundPerLine, false, "US", "CA", []int64{150, 150, 150}, int64(33)),
+		Entry("exclusive, per order, halves agree", tax.RoundPerOrder, false, "US", "CA", []int64{150, 150, 150}, int64(33)),
+		// 3 x 0.10 at 7.25%: 0.725c per line -> 1 each = 3; on the order 2.175 -> 2.
+		Entry("exclusive, per line, rounds each line up", tax.RoundPerLine, false, "US", "CA", []int64{10, 10, 10}, int64(3)),
+		Entry("exclusive, per order, rounds once", tax.RoundPerOrder, false, "US", "CA", []int64{10, 10, 10}, int64(2)),
+		// 2 x 0.30 at 4%: 1.2c per line -> 1 each = 2; on the order 2.4c -> 2.
+		Entry("exclusive, per line, rounds each line down", tax.RoundPerLine, false, "US", "NY", []int64{30, 30}, int64(2)),
+		Entry("exclusive, per order, same result", tax.RoundPerOrder, false, "US", "NY", []int64{30, 30}, int64(2)),
+		// 3 x 0.99 at 4%: 3.96c -> 4 each = 12; 11.88c -> 12 on the order.
+		Entry("exclusive, per line", tax.RoundPerLine, false, "US", "NY", []int64{99, 99, 99}, int64(12)),
+		// Inclusive 20%: 9.99 embeds 166.5c -> 167 per line = 501; 29.97 embeds 499.5c -> 500 on the order.
+		Entry("inclusive, per line", tax.RoundPerLine, true, "GB", "", []int64{999, 999, 999}, int64(501)),
+		Entry("inclusive, per order", tax.RoundPerOrder, true, "GB", "", []int64{999, 999, 999}, int64(500)),
+		Entry("no matching rules", tax.RoundPerLine, false, "FR", "", []int64{999}, int64(0)),
+		Entry("country without a region rule", tax.RoundPerLine, false, "US", "", []int64{999}, int64(0)),
+	)
+
+	It("stacks country and region rules into separate tax lines", func() {
+		totals := price(newCalculator(tax.RoundPerLine, false), pricing.Input{Country: "ca", Region: "bc", Lines: lines(10000)})
+
+		Expect(totals.TaxLines).To(Equal([]models.TaxLine{
+			{Label: "GST", Rate: 500, Taxable: 10000, Amount: 500},
+			{Label: "PST", Rate: 700, Taxable: 10000, Amount: 700},
+		}))
+		Expect(totals.Tax).To(Equal(int64(1200)))
+	})
+
+	It("splits combined inclusive rates between rules", func() {
+		totals := price(newCalculator(tax.RoundPerLine, true), pricing.Input{Country: "CA", Region: "BC", Lines: lines(11200)})
+
+		Expect(totals.TaxLines[0].Amount).To(Equal(int64(500)))
+		Expect(totals.TaxLines[1].Amount).To(Equal(int64(700)))
+		Expect(totals.Total).To(Equal(int64(11200)))
+	})
+
+	It("taxes each product tax class at its own rate", func() {
+		in := pricing.Input{Country: "GB", Lines: []models.Line{
+			{ItemID: 1, UnitPrice: 1000, Quantity: 1},
+			{ItemID: 2, UnitPrice: 1000, Quantity: 1, TaxClass: "reduced"},
+			{ItemID: 3, UnitPrice: 1000, Quantity: 1, TaxClass: "Zero"},
+		}}
+		totals := price(newCalculator(tax.RoundPerLine, false), in)
+
+		Expect(totals.TaxLines).To(ConsistOf(
+			models.TaxLine{Label: "VAT", Rate: 2000, Taxable: 1000, Amount: 200},
+			models.TaxLine{Label: "VAT (reduced)", Rate: 500, Taxable: 1000, Amount: 50},
+			models.TaxLine{Label: "VAT (zero)", Rate: 0, Taxable: 1000, Amount: 0},
+		))
+		Expect(totals.Tax).To(Equal(int64(250)))
+	})
+
+	It("taxes discounted amounts and, when configured, shipping", func() {
+		calc := newCalculator(tax.RoundPerLine, false)
+		calc.ShippingTaxClass = models.TaxClassStandard
+		engine := pricing.NewEngine(pricing.FlatShipping{Amount: 1000}, calc, pricing.PercentOff{Label: "half", Rate: 5000})
+
+		totals, _ := engine.Price(pricing.Input{Country: "GB", Lines: lines(10000)})
+		Expect(totals.TaxLines[0].Taxable).To(Equal(int64(5000 + 1000)))
+		Expect(totals.Tax).To(Equal(int64(1200)))
+	})
+
+	It("falls back to the default destination", func() {
+		calc := newCalculator(tax.RoundPerLine, false)
+		calc.DefaultCountry, calc.DefaultRegion = "US", "NY"
+
+		Expect(price(calc, pricing.Input{Lines: lines(1000)}).Tax).To(Equal(int64(40)))
+	})
+
+	Describe("tax-exempt customers", func() {
+		It("pay no exclusive tax", func() {
+			totals := price(newCalculator(tax.RoundPerLine, false), pricing.Input{Country: "US", Region: "CA", TaxExempt: true, Lines: lines(1000)})
+			Expect(totals.TaxLines).To(BeEmpty())
+			Expect(totals.Total).To(Equal(int64(1000)))
+		})
+
+		It("get embedded tax removed under inclusive pricing", func() {
+			totals := price(newCalculator(tax.RoundPerLine, true), pricing.Input{Country: "GB", TaxExempt: true, Lines: lines(1200)})
+			Expect(totals.TaxLines).To(Equal([]models.TaxLine{{Label: "Tax exemption", Amount: -200}}))
+			Expect(totals.Total).To(Equal(int64(1000)))
+		})
+	})
+
+	Describe("Validate and Normalize", func() {
+		It("normalizes codes and classes", func() {
+			rule := &models.TaxRule{Country: " us", Region: "ca ", Name: "California", Rate: 725}
+			tax.Normalize(rule)
+			Expect(rule.Country).To(Equal("US"))
+			Expect(rule.Region).To(Equal("CA"))
+			Expect(rule.TaxClass).To(Equal(models.TaxClassStandard))
+			Expect(tax.Validate(rule)).To(Succeed())
+		})
+
+		It("rejects bad rules", func() {
+			for _, rule := range []*models.TaxRule{
+				{Country: "USA", Name: "x", Rate: 1},
+				{Country: "US", Rate: 1},
+				{Country: "US", Name: "x", Rate: -1},
+				{Country: "US", Name: "x", Rate: 10001},
+			} {
+				Expect(errors.Is(tax.Validate(rule), tax.ErrInvalidRule)).To(BeTrue())
+			}
+		})
+	})
+})
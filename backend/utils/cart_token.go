@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// CartTokenTTL is how long a guest cart token stays valid.
+const CartTokenTTL = 30 * 24 * time.Hour
+
+// CartTokenHeader and CartTokenCookie carry a guest cart token.
+const (
+	CartTokenHeader = "X-Cart-Token"
+	CartTokenCookie = "cart_token"
+)
+
+const cartTokenSubject = "guest-cart"
+
+var ErrInvalidCartToken = errors.New("invalid cart token")
+
+// CartClaims identify an anonymous shopper's cart.
+type CartClaims struct {
+	CartID uint `json:"cart_id"`
+	jwt.RegisteredClaims
+}
+
+// GenerateCartToken signs a token for a guest cart.
+func GenerateCartToken(cartID uint) (string, error) {
+	claims := &CartClaims{
+		CartID: cartID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   cartTokenSubject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(CartTokenTTL)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// ValidateCartToken returns the claims of a guest cart token. User tokens
+// are signed with the same key, so the subject tells the two apart.
+func ValidateCartToken(tokenString string) (*CartClaims, error) {
+	claims := &CartClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidCartToken
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidCartToken
+	}
+	if claims.Subject != cartTokenSubject || claims.CartID == 0 {
+		return nil, ErrInvalidCartToken
+	}
+
+	return claims, nil
+}
@@ -7,19 +7,26 @@ import (
 
 var jwtSecret = []byte("your-secret-key")
 
+// TokenTTL is how long a login token is valid.
+const TokenTTL = 24 * time.Hour
+
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
+	UserID    uint   `json:"user_id"`
+	Username  string `json:"username"`
+	SessionID uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uint, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateSessionToken signs a token for a login session. It is only
+// accepted while the session is active.
+func GenerateSessionToken(userID uint, username string, sessionID uint, expiresAt time.Time) (string, error) {
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:    userID,
+		Username:  username,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
@@ -37,5 +44,10 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, err
 	}
 
+	// Guest cart tokens share the signing key but carry no user
+	if claims.UserID == 0 {
+		return nil, ErrInvalidCartToken
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// MFATokenTTL is how long a user has to enter their second factor after
+// their password.
+const MFATokenTTL = 5 * time.Minute
+
+const mfaTokenSubject = "mfa-challenge"
+
+var ErrInvalidMFAToken = errors.New("invalid MFA token")
+
+// MFAClaims identify a user who has passed the password step of a login.
+// The user is not under "user_id", so ValidateToken refuses these tokens.
+type MFAClaims struct {
+	UserID uint `json:"mfa_user_id"`
+	jwt.RegisteredClaims
+}
+
+// GenerateMFAToken signs a challenge token for the second step of a login.
+func GenerateMFAToken(userID uint) (string, error) {
+	claims := &MFAClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   mfaTokenSubject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(MFATokenTTL)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtSecret)
+}
+
+// ValidateMFAToken returns the claims of a challenge token.
+func ValidateMFAToken(tokenString string) (*MFAClaims, error) {
+	claims := &MFAClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidMFAToken
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidMFAToken
+	}
+	if claims.Subject != mfaTokenSubject || claims.UserID == 0 {
+		return nil, ErrInvalidMFAToken
+	}
+
+	return claims, nil
+}
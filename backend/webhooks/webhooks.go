@@ -0,0 +1,189 @@
+// Package webhooks signs and sends outgoing webhook deliveries and decides
+// when failed ones are retried.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types an endpoint can subscribe to.
+const (
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventItemUpdated        = "item.updated"
+	EventCartAbandoned      = "cart.abandoned"
+)
+
+// EventTypes lists every event type, in the order they are documented.
+var EventTypes = []string{EventOrderCreated, EventOrderStatusChanged, EventItemUpdated, EventCartAbandoned}
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// maxResponseBody is how much of a response body is kept for the log.
+const maxResponseBody = 1024
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredSignature = errors.New("webhook signature has expired")
+)
+
+// ValidEventType reports whether t is a known event type.
+func ValidEventType(t string) bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random signing secret for an endpoint.
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header for a payload sent at timestamp:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<payload>">". Signing the
+// timestamp lets receivers reject replayed deliveries.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + mac(secret, t, payload)
+}
+
+// Verify checks a signature header made by Sign. Signatures older than
+// tolerance as of now are rejected; a zero tolerance accepts any age.
+func Verify(secret string, payload []byte, header string, now time.Time, tolerance time.Duration) error {
+	var t, v1 string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			v1 = value
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || v1 == "" {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(mac(secret, t, payload)), []byte(v1)) {
+		return ErrInvalidSignature
+	}
+	if tolerance > 0 && now.Sub(time.Unix(unix, 0)) > tolerance {
+		return ErrExpiredSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, payload []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp + "."))
+	m.Write(payload)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// RetryPolicy spaces out the attempts of a failing delivery. The wait after
+// the nth failed attempt is Base doubled n-1 times, capped at Max. A
+// delivery is given up after MaxAttempts attempts.
+type RetryPolicy struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// DefaultRetryPolicy retries for about a day: after 30s, 1m, 2m and so on
+// up to 6h between attempts.
+var DefaultRetryPolicy = RetryPolicy{Base: 30 * time.Second, Max: 6 * time.Hour, MaxAttempts: 12}
+
+// Delay returns how long to wait after the given number of failed attempts.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.Base
+	for i := 1; i < attempts && delay < p.Max; i++ {
+		delay *= 2
+	}
+	return min(delay, p.Max)
+}
+
+// Exhausted reports whether a delivery that failed attempts times should be
+// given up.
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// Request is one delivery attempt.
+type Request struct {
+	URL        string
+	Secret     string
+	DeliveryID string
+	Event      string
+	Payload    []byte
+	Timestamp  time.Time
+}
+
+// Response is what the endpoint answered. Body is cut to the first
+// kilobyte.
+type Response struct {
+	StatusCode int
+	Body       string
+	Duration   time.Duration
+}
+
+// Sender posts deliveries over HTTP.
+type Sender struct {
+	Client *http.Client
+}
+
+// Send posts a signed delivery. Any answer other than a 2xx status is an
+// error; the response is returned with it when there was one.
+func (s Sender) Send(ctx context.Context, req Request) (Response, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Payload))
+	if err != nil {
+		return Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "ecommerce-backend-webhooks/1")
+	httpReq.Header.Set(EventHeader, req.Event)
+	httpReq.Header.Set(DeliveryHeader, req.DeliveryID)
+	httpReq.Header.Set(SignatureHeader, Sign(req.Secret, req.Timestamp, req.Payload))
+
+	start := time.Now()
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return Response{Duration: time.Since(start)}, err
+	}
+	defer httpResp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseBody))
+
+	resp := Response{StatusCode: httpResp.StatusCode, Body: string(body), Duration: time.Since(start)}
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		return resp, fmt.Errorf("endpoint answered %d", httpResp.StatusCode)
+	}
+	return resp, nil
+}
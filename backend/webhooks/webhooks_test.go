@@ -0,0 +1,124 @@
+package webhooks_test
+
+import (
+	"context"
+	"ecommerce-backend/webhooks"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhooks", func() {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	payload := []byte(`{"type":"order.created"}`)
+
+	Describe("signatures", func() {
+		It("verifies its own signatures", func() {
+			header := webhooks.Sign("whsec_test", now, payload)
+			Expect(header).To(HavePrefix("t=1717243200,v1="))
+			Expect(webhooks.Verify("whsec_test", payload, header, now, 5*time.Minute)).To(Succeed())
+		})
+
+		It("rejects a wrong secret, a changed payload or a changed timestamp", func() {
+			header := webhooks.Sign("whsec_test", now, payload)
+			Expect(webhooks.Verify("whsec_other", payload, header, now, 0)).To(MatchError(webhooks.ErrInvalidSignature))
+			Expect(webhooks.Verify("whsec_test", []byte(`{}`), header, now, 0)).To(MatchError(webhooks.ErrInvalidSignature))
+
+			replayed := "t=1717243300" + header[len("t=1717243200"):]
+			Expect(webhooks.Verify("whsec_test", payload, replayed, now, 0)).To(MatchError(webhooks.ErrInvalidSignature))
+			Expect(webhooks.Verify("whsec_test", payload, "garbage", now, 0)).To(MatchError(webhooks.ErrInvalidSignature))
+		})
+
+		It("rejects old signatures", func() {
+			header := webhooks.Sign("whsec_test", now, payload)
+			Expect(webhooks.Verify("whsec_test", payload, header, now.Add(10*time.Minute), 5*time.Minute)).To(MatchError(webhooks.ErrExpiredSignature))
+		})
+
+		It("makes distinct secrets", func() {
+			a, _ := webhooks.NewSecret()
+			b, _ := webhooks.NewSecret()
+			Expect(a).To(HavePrefix("whsec_"))
+			Expect(a).ToNot(Equal(b))
+		})
+	})
+
+	Describe("retries", func() {
+		policy := webhooks.RetryPolicy{Base: 30 * time.Second, Max: 5 * time.Minute, MaxAttempts: 4}
+
+		It("backs off exponentially up to the cap", func() {
+			Expect(policy.Delay(1)).To(Equal(30 * time.Second))
+			Expect(policy.Delay(2)).To(Equal(time.Minute))
+			Expect(policy.Delay(3)).To(Equal(2 * time.Minute))
+			Expect(policy.Delay(5)).To(Equal(5 * time.Minute))
+			Expect(policy.Delay(50)).To(Equal(5 * time.Minute))
+		})
+
+		It("gives up after the last attempt", func() {
+			Expect(policy.Exhausted(3)).To(BeFalse())
+			Expect(policy.Exhausted(4)).To(BeTrue())
+		})
+	})
+
+	Describe("sending", func() {
+		var (
+			server   *httptest.Server
+			status   int
+			received *http.Request
+			body     []byte
+		)
+
+		BeforeEach(func() {
+			status = http.StatusOK
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(status)
+				w.Write([]byte("thanks"))
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		send := func() (webhooks.Response, error) {
+			return webhooks.Sender{}.Send(context.Background(), webhooks.Request{
+				URL:        server.URL,
+				Secret:     "whsec_test",
+				DeliveryID: "42",
+				Event:      webhooks.EventOrderCreated,
+				Payload:    payload,
+				Timestamp:  now,
+			})
+		}
+
+		It("posts a signed payload", func() {
+			resp, err := send()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Body).To(Equal("thanks"))
+
+			Expect(body).To(Equal(payload))
+			Expect(received.Header.Get(webhooks.EventHeader)).To(Equal(webhooks.EventOrderCreated))
+			Expect(received.Header.Get(webhooks.DeliveryHeader)).To(Equal("42"))
+			Expect(webhooks.Verify("whsec_test", body, received.Header.Get(webhooks.SignatureHeader), now, 0)).To(Succeed())
+		})
+
+		It("fails on any status other than 2xx", func() {
+			status = http.StatusServiceUnavailable
+			resp, err := send()
+			Expect(err).To(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("fails when the endpoint cannot be reached", func() {
+			server.Close()
+			_, err := send()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})